@@ -7,13 +7,20 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
 	"syscall"
 	"time"
 
 	"github.com/wpinrui/dovora2/backend/internal/api"
+	"github.com/wpinrui/dovora2/backend/internal/auth"
 	"github.com/wpinrui/dovora2/backend/internal/db"
 	"github.com/wpinrui/dovora2/backend/internal/invidious"
 	"github.com/wpinrui/dovora2/backend/internal/lyrics"
+	"github.com/wpinrui/dovora2/backend/internal/media"
+	"github.com/wpinrui/dovora2/backend/internal/musicbrainz"
+	"github.com/wpinrui/dovora2/backend/internal/playback"
+	"github.com/wpinrui/dovora2/backend/internal/scrobble"
 	"github.com/wpinrui/dovora2/backend/internal/ytdlp"
 )
 
@@ -33,11 +40,47 @@ func main() {
 		log.Fatal("JWT_SECRET environment variable is required")
 	}
 
+	// Rotating MEDIA_URL_SECRET revokes all outstanding signed media URLs
+	mediaURLSecret := os.Getenv("MEDIA_URL_SECRET")
+	if mediaURLSecret == "" {
+		mediaURLSecret = auth.DeriveURLSigningKey(jwtSecret)
+	}
+	urlSigner := auth.NewURLSigner(mediaURLSecret)
+
+	// Forwarding headers are only believed from these, e.g. for binding
+	// signed URLs to the client's IP behind a reverse proxy
+	trustedProxies, err := api.ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatalf("TRUSTED_PROXIES must be a comma-separated list of IP addresses or CIDR ranges: %v", err)
+	}
+
 	invidiousURL := os.Getenv("INVIDIOUS_URL")
 	if invidiousURL == "" {
 		invidiousURL = "https://inv.perditum.com"
 	}
 
+	musicBrainzURL := os.Getenv("MUSICBRAINZ_URL")
+	if musicBrainzURL == "" {
+		musicBrainzURL = "https://musicbrainz.org"
+	}
+
+	// Scrobbling endpoints can point at a local fake for testing
+	listenBrainzURL := os.Getenv("LISTENBRAINZ_URL")
+	if listenBrainzURL == "" {
+		listenBrainzURL = "https://api.listenbrainz.org"
+	}
+
+	lastFMURL := os.Getenv("LASTFM_URL")
+	if lastFMURL == "" {
+		lastFMURL = "https://ws.audioscrobbler.com"
+	}
+
+	lastFMAPIKey := os.Getenv("LASTFM_API_KEY")
+	lastFMAPISecret := os.Getenv("LASTFM_API_SECRET")
+	if lastFMAPIKey == "" || lastFMAPISecret == "" {
+		log.Println("Warning: LASTFM_API_KEY or LASTFM_API_SECRET not set, Last.fm scrobbling will not work")
+	}
+
 	geniusAPIKey := os.Getenv("GENIUS_API_KEY")
 	if geniusAPIKey == "" {
 		log.Println("Warning: GENIUS_API_KEY not set, lyrics endpoint will not work")
@@ -62,6 +105,12 @@ func main() {
 
 	invidiousClient := invidious.NewClient(invidiousURL)
 	lyricsClient := lyrics.NewClient(geniusAPIKey)
+	musicBrainzClient := musicbrainz.NewClient(musicBrainzURL)
+
+	var lastFM scrobble.Service
+	if lastFMAPIKey != "" && lastFMAPISecret != "" {
+		lastFM = scrobble.NewLastFM(lastFMURL, lastFMAPIKey, lastFMAPISecret)
+	}
 
 	// Initialize yt-dlp downloader
 	downloadsDir := os.Getenv("DOWNLOADS_DIR")
@@ -74,21 +123,70 @@ func main() {
 	}
 	log.Printf("Downloads directory: %s", downloadsDir)
 
+	var processorOpts []media.Option
+	if v := os.Getenv("TRANSCODE_CACHE_MAX_MB"); v != "" {
+		maxMB, err := strconv.Atoi(v)
+		if err != nil || maxMB <= 0 {
+			log.Fatalf("TRANSCODE_CACHE_MAX_MB must be a positive integer, got %q", v)
+		}
+		processorOpts = append(processorOpts, media.WithTranscodeCacheSize(int64(maxMB)<<20))
+	}
+
+	coversDir := filepath.Join(downloadsDir, "covers")
+	if err := os.MkdirAll(coversDir, 0755); err != nil {
+		log.Fatalf("Failed to create covers directory: %v", err)
+	}
+
+	trashRetention := 30 * 24 * time.Hour
+	if v := os.Getenv("TRASH_RETENTION_DAYS"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil || days <= 0 {
+			log.Fatalf("TRASH_RETENTION_DAYS must be a positive integer, got %q", v)
+		}
+		trashRetention = time.Duration(days) * 24 * time.Hour
+	}
+
+	processor, err := media.New(filepath.Join(downloadsDir, "cache"), processorOpts...)
+	if err != nil {
+		log.Fatalf("Failed to initialize media processor: %v", err)
+	}
+
 	authHandler := api.NewAuthHandler(database, jwtSecret)
 	inviteHandler := api.NewInviteHandler(database)
 	searchHandler := api.NewSearchHandler(invidiousClient)
-	downloadHandler := api.NewDownloadHandler(database, downloader)
-	fileHandler := api.NewFileHandler(database)
+	downloadHandler := api.NewDownloadHandler(database, downloader, processor)
+	fileHandler := api.NewFileHandler(database, processor, urlSigner, trustedProxies)
 	libraryHandler := api.NewLibraryHandler(database)
 	lyricsHandler := api.NewLyricsHandler(lyricsClient)
 	playlistHandler := api.NewPlaylistHandler(database)
 	adminHandler := api.NewAdminHandler(database)
-	middleware := api.NewMiddleware(jwtSecret, database)
+	metadataHandler := api.NewMetadataHandler(database, musicBrainzClient)
+	duplicatesHandler := api.NewDuplicatesHandler(database, processor)
+	waveformHandler := api.NewWaveformHandler(database, processor)
+	streamHandler := api.NewStreamHandler(database, processor)
+	archiveHandler := api.NewArchiveHandler(database)
+	playlistTransferHandler := api.NewPlaylistTransferHandler(database, downloadHandler)
+	importHandler := api.NewImportHandler(database, invidiousClient, downloadHandler)
+	takeoutHandler := api.NewTakeoutHandler(database, downloadHandler, downloadsDir)
+	catalogHandler := api.NewCatalogHandler(database)
+	tagHandler := api.NewTagHandler(database)
+	preferenceHandler := api.NewPreferenceHandler(database)
+	scrobbleHandler := api.NewScrobbleHandler(database, scrobble.NewListenBrainz(listenBrainzURL), lastFM)
+	playHandler := api.NewPlayHandler(database, scrobbleHandler)
+	syncHandler := api.NewSyncHandler(database)
+	trashHandler := api.NewTrashHandler(database, processor, trashRetention)
+	coverHandler := api.NewCoverHandler(database, coversDir)
+	positionHandler := api.NewPositionHandler(database)
+	playbackHub := playback.NewHub()
+	playbackHandler := api.NewPlaybackHandler(playbackHub)
+	recommendationHandler := api.NewRecommendationHandler(database, invidiousClient)
+	middleware := api.NewMiddleware(jwtSecret, database, urlSigner, trustedProxies)
 
 	// Rate limiters: (requests per second, burst)
 	authLimiter := api.NewRateLimiter(0.17, 5)     // ~10 req/min, burst of 5
 	downloadLimiter := api.NewRateLimiter(0.08, 3) // ~5 req/min, burst of 3
 	apiLimiter := api.NewRateLimiter(1.0, 10)      // 60 req/min, burst of 10
+	streamLimiter := api.NewRateLimiter(5.0, 50)   // players fetch many segments while buffering
 
 	http.HandleFunc("/health", healthHandler(database))
 	http.HandleFunc("/auth/register", authLimiter.RateLimit(authHandler.Register))
@@ -98,14 +196,65 @@ func main() {
 	http.HandleFunc("/invites/list", apiLimiter.RateLimit(middleware.RequireAuth(inviteHandler.List)))
 	http.HandleFunc("/search", apiLimiter.RateLimit(middleware.RequireAuth(searchHandler.Search)))
 	http.HandleFunc("/download", middleware.RequireAuth(downloadLimiter.RateLimitByUser(downloadHandler.Download)))
+	http.HandleFunc("/downloads", apiLimiter.RateLimit(middleware.RequireAuth(downloadHandler.ListJobs)))
+	http.HandleFunc("/recommendations", apiLimiter.RateLimit(middleware.RequireAuth(recommendationHandler.GetRecommendations)))
 	http.HandleFunc("/lyrics", apiLimiter.RateLimit(middleware.RequireAuth(lyricsHandler.GetLyrics)))
-	http.HandleFunc("/files/", apiLimiter.RateLimit(middleware.RequireAuth(fileHandler.ServeFile)))
+	http.HandleFunc("/files/", apiLimiter.RateLimit(middleware.RequireFileAuth(fileHandler.ServeFile)))
+	http.HandleFunc("/files/{id}/signed-url", apiLimiter.RateLimit(middleware.RequireAuth(fileHandler.CreateSignedURL)))
+	http.HandleFunc("/sync", apiLimiter.RateLimit(middleware.RequireAuth(syncHandler.Sync)))
 	http.HandleFunc("/library/music", apiLimiter.RateLimit(middleware.RequireAuth(libraryHandler.GetMusic)))
 	http.HandleFunc("/library/videos", apiLimiter.RateLimit(middleware.RequireAuth(libraryHandler.GetVideos)))
+	http.HandleFunc("/library/search", apiLimiter.RateLimit(middleware.RequireAuth(libraryHandler.Search)))
+	http.HandleFunc("/library/duplicates", apiLimiter.RateLimit(middleware.RequireAuth(duplicatesHandler.List)))
+	http.HandleFunc("/library/duplicates/merge", apiLimiter.RateLimit(middleware.RequireAuth(duplicatesHandler.Merge)))
+	http.HandleFunc("/library/artists", apiLimiter.RateLimit(middleware.RequireAuth(catalogHandler.ListArtists)))
+	http.HandleFunc("/library/artists/merge", apiLimiter.RateLimit(middleware.RequireAuth(catalogHandler.MergeArtists)))
+	http.HandleFunc("/library/artists/{id}", apiLimiter.RateLimit(middleware.RequireAuth(catalogHandler.GetArtist)))
+	http.HandleFunc("/library/albums/{id}", apiLimiter.RateLimit(middleware.RequireAuth(catalogHandler.GetAlbum)))
+	http.HandleFunc("/library/batch", apiLimiter.RateLimit(middleware.RequireAuth(libraryHandler.Batch)))
+	http.HandleFunc("/library/archive", apiLimiter.RateLimit(middleware.RequireAuth(archiveHandler.ExportLibrary)))
 	http.HandleFunc("/library/", apiLimiter.RateLimit(middleware.RequireAuth(libraryHandler.DeleteItem)))
 	http.HandleFunc("/tracks/", apiLimiter.RateLimit(middleware.RequireAuth(libraryHandler.UpdateTrack)))
+	http.HandleFunc("/tracks/{id}/matches", apiLimiter.RateLimit(middleware.RequireAuth(metadataHandler.HandleMatches)))
+	http.HandleFunc("/tracks/{id}/waveform", apiLimiter.RateLimit(middleware.RequireAuth(waveformHandler.GetWaveform)))
+	http.HandleFunc("/tracks/{id}/favorite", apiLimiter.RateLimit(middleware.RequireAuth(preferenceHandler.TrackFavorite)))
+	http.HandleFunc("/tracks/{id}/rating", apiLimiter.RateLimit(middleware.RequireAuth(preferenceHandler.TrackRating)))
+	http.HandleFunc("/tracks/{id}/cover", apiLimiter.RateLimit(middleware.RequireAuth(coverHandler.TrackCover)))
+	http.HandleFunc("/videos/{id}", apiLimiter.RateLimit(middleware.RequireAuth(libraryHandler.UpdateVideo)))
+	http.HandleFunc("/videos/{id}/favorite", apiLimiter.RateLimit(middleware.RequireAuth(preferenceHandler.VideoFavorite)))
+	http.HandleFunc("/videos/{id}/rating", apiLimiter.RateLimit(middleware.RequireAuth(preferenceHandler.VideoRating)))
+	http.HandleFunc("/videos/{id}/cover", apiLimiter.RateLimit(middleware.RequireAuth(coverHandler.VideoCover)))
+	http.HandleFunc("/stream/{id}/master.m3u8", streamLimiter.RateLimit(middleware.RequireAuth(streamHandler.ServeMaster)))
+	http.HandleFunc("/stream/{id}/{rendition}/{file}", streamLimiter.RateLimit(middleware.RequireAuth(streamHandler.ServeSegment)))
 	http.HandleFunc("/playlists", apiLimiter.RateLimit(middleware.RequireAuth(playlistHandler.HandlePlaylists)))
 	http.HandleFunc("/playlists/", apiLimiter.RateLimit(middleware.RequireAuth(playlistHandler.HandlePlaylist)))
+	http.HandleFunc("/playlists/{id}/archive", apiLimiter.RateLimit(middleware.RequireAuth(archiveHandler.ExportPlaylist)))
+	http.HandleFunc("/playlists/{id}/export", apiLimiter.RateLimit(middleware.RequireAuth(playlistTransferHandler.Export)))
+	http.HandleFunc("/playlists/import", apiLimiter.RateLimit(middleware.RequireAuth(playlistTransferHandler.Import)))
+	http.HandleFunc("/plays", apiLimiter.RateLimit(middleware.RequireAuth(playHandler.RecordPlays)))
+	http.HandleFunc("/plays/now-playing", apiLimiter.RateLimit(middleware.RequireAuth(scrobbleHandler.NowPlaying)))
+	http.HandleFunc("/scrobbling", apiLimiter.RateLimit(middleware.RequireAuth(scrobbleHandler.ListAccounts)))
+	http.HandleFunc("/scrobbling/{service}", apiLimiter.RateLimit(middleware.RequireAuth(scrobbleHandler.HandleAccount)))
+	http.HandleFunc("/positions", apiLimiter.RateLimit(middleware.RequireAuth(positionHandler.ListPositions)))
+	http.HandleFunc("/positions/{id}", apiLimiter.RateLimit(middleware.RequireAuth(positionHandler.HandlePosition)))
+	http.HandleFunc("/continue-watching", apiLimiter.RateLimit(middleware.RequireAuth(positionHandler.ContinueWatching)))
+	http.HandleFunc("/playback/connect", apiLimiter.RateLimit(middleware.RequireAuth(playbackHandler.Connect)))
+	http.HandleFunc("/stats/summary", apiLimiter.RateLimit(middleware.RequireAuth(playHandler.Summary)))
+	http.HandleFunc("/stats/top-tracks", apiLimiter.RateLimit(middleware.RequireAuth(playHandler.TopTracks)))
+	http.HandleFunc("/stats/top-artists", apiLimiter.RateLimit(middleware.RequireAuth(playHandler.TopArtists)))
+	http.HandleFunc("/stats/heatmap", apiLimiter.RateLimit(middleware.RequireAuth(playHandler.Heatmap)))
+	http.HandleFunc("/tags", apiLimiter.RateLimit(middleware.RequireAuth(tagHandler.HandleTags)))
+	http.HandleFunc("/tags/bulk", apiLimiter.RateLimit(middleware.RequireAuth(tagHandler.Bulk)))
+	http.HandleFunc("/tags/{id}", apiLimiter.RateLimit(middleware.RequireAuth(tagHandler.HandleTag)))
+	http.HandleFunc("/account/export", apiLimiter.RateLimit(middleware.RequireAuth(takeoutHandler.Export)))
+	http.HandleFunc("/account/import", apiLimiter.RateLimit(middleware.RequireAuth(takeoutHandler.Import)))
+	http.HandleFunc("/imports", apiLimiter.RateLimit(middleware.RequireAuth(importHandler.HandleImports)))
+	http.HandleFunc("/imports/{id}", apiLimiter.RateLimit(middleware.RequireAuth(importHandler.GetImport)))
+	http.HandleFunc("/imports/{id}/rows/{position}", apiLimiter.RateLimit(middleware.RequireAuth(importHandler.ResolveRow)))
+
+	http.HandleFunc("/trash", apiLimiter.RateLimit(middleware.RequireAuth(trashHandler.HandleTrash)))
+	http.HandleFunc("/trash/{id}", apiLimiter.RateLimit(middleware.RequireAuth(trashHandler.DeleteItem)))
+	http.HandleFunc("/trash/{id}/restore", apiLimiter.RateLimit(middleware.RequireAuth(trashHandler.Restore)))
 
 	// Admin endpoints
 	http.HandleFunc("/admin/users", apiLimiter.RateLimit(middleware.RequireAuth(middleware.RequireAdmin(adminHandler.HandleUsers))))
@@ -113,6 +262,27 @@ func main() {
 	http.HandleFunc("/admin/invites", apiLimiter.RateLimit(middleware.RequireAuth(middleware.RequireAdmin(adminHandler.HandleInvites))))
 	http.HandleFunc("/admin/invites/", apiLimiter.RateLimit(middleware.RequireAuth(middleware.RequireAdmin(adminHandler.HandleInvites))))
 
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	// Fingerprint tracks downloaded before fingerprinting was added
+	go downloadHandler.BackfillFingerprints(backgroundCtx)
+
+	// Link tracks downloaded before artists and albums were catalogued
+	go catalogHandler.BackfillCatalog(backgroundCtx)
+
+	// Process downloads queued by playlist imports
+	go downloadHandler.RunQueue(backgroundCtx)
+
+	// Match imported Spotify and Apple Music playlists against YouTube
+	go importHandler.RunImports(backgroundCtx)
+
+	// Forward plays to linked ListenBrainz and Last.fm accounts
+	go scrobbleHandler.RunOutbox(backgroundCtx)
+
+	// Permanently delete items left in the trash past the retention period
+	go trashHandler.RunPurge(backgroundCtx)
+
 	server := &http.Server{
 		Addr:         ":" + port,
 		ReadTimeout:  15 * time.Second,
@@ -132,6 +302,11 @@ func main() {
 	<-quit
 
 	log.Println("Shutting down server...")
+	stopBackground()
+
+	// Shutdown doesn't wait for hijacked WebSocket connections, so close
+	// them directly
+	playbackHub.Close()
 
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer shutdownCancel()
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wpinrui/dovora2/backend/internal/db"
+	"github.com/wpinrui/dovora2/backend/internal/ziparchive"
+)
+
+// maxNameTemplateLength bounds the ?template= query parameter
+const maxNameTemplateLength = 200
+
+type ArchiveHandler struct {
+	db *db.DB
+}
+
+func NewArchiveHandler(database *db.DB) *ArchiveHandler {
+	return &ArchiveHandler{db: database}
+}
+
+// ExportPlaylist handles GET /playlists/{id}/archive, returning a ZIP of the
+// playlist's tracks in order along with an M3U8 playlist file
+func (h *ArchiveHandler) ExportPlaylist(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	template, ok := nameTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	playlist, err := h.db.GetPlaylistWithTracks(r.Context(), r.PathValue("id"), userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "playlist not found")
+			return
+		}
+		log.Printf("Failed to get playlist: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to get playlist")
+		return
+	}
+
+	h.serveArchive(w, r, playlist.Name, playlist.UpdatedAt, playlist.Tracks, template, true)
+}
+
+// ExportLibrary handles GET /library/archive, returning a ZIP of the user's
+// whole music library along with an M3U8 playlist file
+func (h *ArchiveHandler) ExportLibrary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	template, ok := nameTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	tracks, err := h.db.GetTracksByUserID(r.Context(), userID)
+	if err != nil {
+		log.Printf("Failed to get tracks: %v", err)
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+
+	var updatedAt time.Time
+	for _, track := range tracks {
+		if track.UpdatedAt.After(updatedAt) {
+			updatedAt = track.UpdatedAt
+		}
+	}
+
+	h.serveArchive(w, r, "Library", updatedAt, tracks, template, false)
+}
+
+// nameTemplate reads the ?template= parameter naming files in the archive
+func nameTemplate(w http.ResponseWriter, r *http.Request) (string, bool) {
+	template := r.URL.Query().Get("template")
+	if template == "" {
+		return ziparchive.DefaultNameTemplate, true
+	}
+
+	if len(template) > maxNameTemplateLength || !strings.Contains(template, "{title}") {
+		writeError(w, http.StatusBadRequest, "template must include {title} and be at most 200 characters")
+		return "", false
+	}
+
+	return template, true
+}
+
+// serveArchive names the tracks' files from the template and serves them as
+// a ZIP alongside an M3U8 playlist
+func (h *ArchiveHandler) serveArchive(w http.ResponseWriter, r *http.Request, name string, updatedAt time.Time, tracks []db.Track, template string, numbered bool) {
+	files := make([]ziparchive.File, 0, len(tracks)+1)
+	used := make(map[string]bool)
+
+	var m3u strings.Builder
+	m3u.WriteString("#EXTM3U\n")
+	fmt.Fprintf(&m3u, "#PLAYLIST:%s\n", name)
+
+	width := max(2, len(strconv.Itoa(len(tracks))))
+	for i, track := range tracks {
+		if _, err := os.Stat(track.FilePath); err != nil {
+			log.Printf("Skipping track %s in archive: %v", track.ID, err)
+			continue
+		}
+
+		fileName := ziparchive.RenderName(template, map[string]string{
+			"artist": track.Artist,
+			"title":  track.Title,
+			"album":  track.Album,
+			"year":   releaseYear(track.ReleaseDate),
+			"ext":    strings.TrimPrefix(filepath.Ext(track.FilePath), "."),
+		})
+		if numbered {
+			fileName = fmt.Sprintf("%0*d - %s", width, i+1, fileName)
+		}
+		fileName = ziparchive.UniqueName(fileName, used)
+
+		files = append(files, ziparchive.File{Name: fileName, Path: track.FilePath})
+
+		display := track.Title
+		if track.Artist != "" {
+			display = track.Artist + " - " + track.Title
+		}
+		fmt.Fprintf(&m3u, "#EXTINF:%d,%s\n%s\n", track.DurationSeconds, display, fileName)
+	}
+
+	playlistName := ziparchive.UniqueName(ziparchive.RenderName("{name}.m3u8", map[string]string{"name": name}), used)
+	files = append(files, ziparchive.File{
+		Name:    playlistName,
+		Data:    []byte(m3u.String()),
+		ModTime: updatedAt,
+	})
+
+	serveZip(w, r, name+".zip", files)
+}
+
+// serveZip serves files as a ZIP with http.ServeContent, which handles Range
+// and If-Range so interrupted downloads can resume
+func serveZip(w http.ResponseWriter, r *http.Request, name string, files []ziparchive.File) {
+	archive, err := ziparchive.New(files)
+	if err != nil {
+		log.Printf("Failed to build archive: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to build archive")
+		return
+	}
+	defer archive.Close()
+
+	// Large archives take longer than the server's write timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Failed to clear write deadline: %v", err)
+	}
+
+	archiveName := sanitizeFilename(name)
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+archiveName+"\"")
+	// The ETag identifies this exact archive, so If-Range only resumes when
+	// nothing has changed. Last-Modified is left out since reordering a
+	// playlist changes the archive without changing any file times.
+	w.Header().Set("ETag", archive.ETag())
+
+	http.ServeContent(w, r, archiveName, time.Time{}, io.NewSectionReader(archive, 0, archive.Size()))
+}
+
+// releaseYear returns the year of a YYYY, YYYY-MM or YYYY-MM-DD date
+func releaseYear(date string) string {
+	if len(date) < 4 {
+		return ""
+	}
+	return date[:4]
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/wpinrui/dovora2/backend/internal/db"
+)
+
+// maxBatchOperations bounds the operations in one batch
+const maxBatchOperations = 1000
+
+// Batch operation statuses
+const (
+	batchStatusOK         = "ok"
+	batchStatusFailed     = "failed"
+	batchStatusRolledBack = "rolled_back"
+	batchStatusSkipped    = "skipped"
+)
+
+type batchOperationRequest struct {
+	Op         string   `json:"op"`
+	ID         string   `json:"id"`
+	Title      string   `json:"title"`
+	Artist     string   `json:"artist"`
+	PlaylistID string   `json:"playlist_id"`
+	Add        []string `json:"add"`
+	Remove     []string `json:"remove"`
+}
+
+type batchRequest struct {
+	// Atomic rolls back every operation if any fails
+	Atomic     bool                    `json:"atomic"`
+	Operations []batchOperationRequest `json:"operations"`
+}
+
+type batchResultResponse struct {
+	Op     string         `json:"op"`
+	ID     string         `json:"id"`
+	Status string         `json:"status"`
+	Error  string         `json:"error,omitempty"`
+	Track  *trackResponse `json:"track,omitempty"`
+}
+
+type batchResponse struct {
+	Committed bool                  `json:"committed"`
+	Results   []batchResultResponse `json:"results"`
+}
+
+// Batch handles POST /library/batch, applying many deletes, track updates,
+// playlist additions and tag changes in one transaction. Each operation
+// gets its own result; with atomic set, a failure rolls back the rest.
+// Deleted items go to the trash.
+func (h *LibraryHandler) Batch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	var req batchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if len(req.Operations) == 0 {
+		writeError(w, http.StatusBadRequest, "operations is required")
+		return
+	}
+	if len(req.Operations) > maxBatchOperations {
+		writeError(w, http.StatusBadRequest, "at most 1000 operations can be run at once")
+		return
+	}
+
+	ops := make([]db.BatchOperation, 0, len(req.Operations))
+	for i, o := range req.Operations {
+		op, msg := newBatchOperation(&o)
+		if msg != "" {
+			writeError(w, http.StatusBadRequest, "operation "+strconv.Itoa(i)+": "+msg)
+			return
+		}
+		ops = append(ops, op)
+	}
+
+	outcome, err := h.db.RunLibraryBatch(r.Context(), userID, ops, req.Atomic)
+	if err != nil {
+		log.Printf("Failed to run library batch: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to run batch")
+		return
+	}
+
+	response := batchResponse{Committed: outcome.Committed, Results: make([]batchResultResponse, 0, len(ops))}
+	failed := false
+	for i, result := range outcome.Results {
+		item := batchResultResponse{Op: ops[i].Op, ID: ops[i].ItemID, Status: batchStatusOK}
+		switch {
+		case result.Err != nil:
+			item.Status = batchStatusFailed
+			item.Error = batchErrorMessage(result.Err)
+			failed = true
+		case !outcome.Committed && failed:
+			item.Status = batchStatusSkipped
+		case !outcome.Committed:
+			item.Status = batchStatusRolledBack
+		case result.Track != nil:
+			linkCatalog(r.Context(), h.db, result.Track)
+			track := newTrackResponse(result.Track)
+			item.Track = &track
+		}
+		response.Results = append(response.Results, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// newBatchOperation checks a requested operation, returning why it's
+// invalid if it is
+func newBatchOperation(o *batchOperationRequest) (db.BatchOperation, string) {
+	op := db.BatchOperation{Op: o.Op, ItemID: o.ID}
+	if o.ID == "" {
+		return op, "id is required"
+	}
+
+	switch o.Op {
+	case db.BatchDelete:
+	case db.BatchUpdate:
+		op.Title, op.Artist = o.Title, o.Artist
+		if op.Title == "" && op.Artist == "" {
+			return op, "title or artist is required"
+		}
+	case db.BatchAddToPlaylist:
+		op.PlaylistID = o.PlaylistID
+		if op.PlaylistID == "" {
+			return op, "playlist_id is required"
+		}
+	case db.BatchTag:
+		if len(o.Add) == 0 && len(o.Remove) == 0 {
+			return op, "add or remove is required"
+		}
+		for _, names := range []struct {
+			src []string
+			dst *[]string
+		}{
+			{o.Add, &op.AddTags},
+			{o.Remove, &op.RemoveTags},
+		} {
+			for _, name := range names.src {
+				name = strings.TrimSpace(name)
+				if !validTagName(name) {
+					return op, "tag names must be 1 to 100 characters without commas"
+				}
+				*names.dst = append(*names.dst, name)
+			}
+		}
+	default:
+		return op, "op must be 'delete', 'update', 'add_to_playlist' or 'tag'"
+	}
+
+	return op, ""
+}
+
+func batchErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		return "not found"
+	case errors.Is(err, db.ErrReadOnlyPlaylist):
+		return "playlist is read-only"
+	}
+	log.Printf("Library batch operation failed: %v", err)
+	return "operation failed"
+}
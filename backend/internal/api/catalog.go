@@ -0,0 +1,282 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wpinrui/dovora2/backend/internal/db"
+	"github.com/wpinrui/dovora2/backend/internal/textmatch"
+)
+
+// CatalogHandler serves the artist and album views of a user's music
+type CatalogHandler struct {
+	db *db.DB
+}
+
+func NewCatalogHandler(database *db.DB) *CatalogHandler {
+	return &CatalogHandler{db: database}
+}
+
+type artistResponse struct {
+	ID                   string `json:"id"`
+	Name                 string `json:"name"`
+	TrackCount           int    `json:"track_count"`
+	AlbumCount           int    `json:"album_count"`
+	TotalDurationSeconds int    `json:"total_duration_seconds"`
+	ArtworkURL           string `json:"artwork_url"`
+}
+
+func newArtistResponse(artist *db.Artist) artistResponse {
+	return artistResponse{
+		ID:                   artist.ID,
+		Name:                 artist.Name,
+		TrackCount:           artist.TrackCount,
+		AlbumCount:           artist.AlbumCount,
+		TotalDurationSeconds: artist.TotalDurationSeconds,
+		ArtworkURL:           artist.ArtworkURL,
+	}
+}
+
+type artistsResponse struct {
+	Artists []artistResponse `json:"artists"`
+}
+
+type artistDetailResponse struct {
+	artistResponse
+	Albums []albumResponse `json:"albums"`
+	Tracks []trackResponse `json:"tracks"`
+}
+
+type albumResponse struct {
+	ID                   string `json:"id"`
+	Title                string `json:"title"`
+	ArtistID             string `json:"artist_id"`
+	Artist               string `json:"artist"`
+	ReleaseDate          string `json:"release_date,omitempty"`
+	TrackCount           int    `json:"track_count"`
+	TotalDurationSeconds int    `json:"total_duration_seconds"`
+	ArtworkURL           string `json:"artwork_url"`
+}
+
+func newAlbumResponse(album *db.Album) albumResponse {
+	return albumResponse{
+		ID:                   album.ID,
+		Title:                album.Title,
+		ArtistID:             album.ArtistID,
+		Artist:               album.ArtistName,
+		ReleaseDate:          album.ReleaseDate,
+		TrackCount:           album.TrackCount,
+		TotalDurationSeconds: album.TotalDurationSeconds,
+		ArtworkURL:           album.ArtworkURL,
+	}
+}
+
+type albumDetailResponse struct {
+	albumResponse
+	Tracks []trackResponse `json:"tracks"`
+}
+
+type mergeArtistsRequest struct {
+	KeepID   string   `json:"keep_id"`
+	MergeIDs []string `json:"merge_ids"`
+}
+
+// ListArtists handles GET /library/artists
+func (h *CatalogHandler) ListArtists(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	artists, err := h.db.ListArtists(r.Context(), userID)
+	if err != nil {
+		log.Printf("Failed to list artists: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to list artists")
+		return
+	}
+
+	response := artistsResponse{Artists: make([]artistResponse, 0, len(artists))}
+	for i := range artists {
+		response.Artists = append(response.Artists, newArtistResponse(&artists[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetArtist handles GET /library/artists/{id}
+func (h *CatalogHandler) GetArtist(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	artist, err := h.db.GetArtistWithTracks(r.Context(), r.PathValue("id"), userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "artist not found")
+			return
+		}
+		log.Printf("Failed to get artist: %v", err)
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+
+	response := artistDetailResponse{
+		artistResponse: newArtistResponse(&artist.Artist),
+		Albums:         make([]albumResponse, 0, len(artist.Albums)),
+		Tracks:         make([]trackResponse, 0, len(artist.Tracks)),
+	}
+	for i := range artist.Albums {
+		response.Albums = append(response.Albums, newAlbumResponse(&artist.Albums[i]))
+	}
+	for i := range artist.Tracks {
+		response.Tracks = append(response.Tracks, newTrackResponse(&artist.Tracks[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetAlbum handles GET /library/albums/{id}
+func (h *CatalogHandler) GetAlbum(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	album, err := h.db.GetAlbumWithTracks(r.Context(), r.PathValue("id"), userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "album not found")
+			return
+		}
+		log.Printf("Failed to get album: %v", err)
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+
+	response := albumDetailResponse{
+		albumResponse: newAlbumResponse(&album.Album),
+		Tracks:        make([]trackResponse, 0, len(album.Tracks)),
+	}
+	for i := range album.Tracks {
+		response.Tracks = append(response.Tracks, newTrackResponse(&album.Tracks[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// MergeArtists handles POST /library/artists/merge, folding artists that are
+// spellings of the same name into one
+func (h *CatalogHandler) MergeArtists(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	var req mergeArtistsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if req.KeepID == "" {
+		writeError(w, http.StatusBadRequest, "keep_id is required")
+		return
+	}
+
+	slices.Sort(req.MergeIDs)
+	mergeIDs := slices.Compact(req.MergeIDs)
+	if len(mergeIDs) == 0 {
+		writeError(w, http.StatusBadRequest, "merge_ids is required")
+		return
+	}
+	if slices.Contains(mergeIDs, req.KeepID) {
+		writeError(w, http.StatusBadRequest, "merge_ids must not contain keep_id")
+		return
+	}
+
+	if err := h.db.MergeArtists(r.Context(), userID, req.KeepID, mergeIDs); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "artist not found")
+			return
+		}
+		log.Printf("Failed to merge artists: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to merge artists")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// BackfillCatalog links tracks downloaded before the catalog existed
+func (h *CatalogHandler) BackfillCatalog(ctx context.Context) {
+	tracks, err := h.db.GetUncataloguedTracks(ctx)
+	if err != nil {
+		log.Printf("Failed to get tracks for catalog backfill: %v", err)
+		return
+	}
+
+	for i := range tracks {
+		linkCatalog(ctx, h.db, &tracks[i])
+
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// linkCatalog links a track to the artist and album its metadata names and
+// records the links on the track. Failures are logged since the track itself
+// is already saved.
+func linkCatalog(ctx context.Context, database *db.DB, track *db.Track) {
+	artist := textmatch.ArtistName(track.Artist)
+	album := strings.TrimSpace(track.Album)
+
+	artistID, albumID, err := database.LinkTrackCatalog(ctx, track.ID, track.UserID, &db.CatalogLink{
+		ArtistName:  artist,
+		ArtistKey:   textmatch.ArtistKey(track.Artist),
+		AlbumTitle:  album,
+		AlbumKey:    textmatch.Normalize(album),
+		ReleaseDate: track.ReleaseDate,
+	})
+	if err != nil {
+		log.Printf("Failed to link track %s to catalog: %v", track.ID, err)
+		return
+	}
+
+	track.ArtistID = artistID
+	track.AlbumID = albumID
+}
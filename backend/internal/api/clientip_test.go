@@ -0,0 +1,113 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := ParseTrustedProxies(" 10.0.0.1, 172.16.0.0/12,,::1 ")
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies() error = %v", err)
+	}
+	if len(proxies) != 3 {
+		t.Fatalf("got %d proxies, want 3", len(proxies))
+	}
+
+	if _, err := ParseTrustedProxies("10.0.0.1, proxy.local"); err == nil {
+		t.Error("ParseTrustedProxies() accepted a hostname")
+	}
+
+	if proxies, err := ParseTrustedProxies(""); err != nil || len(proxies) != 0 {
+		t.Errorf("ParseTrustedProxies(\"\") = %v, %v, want none", proxies, err)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.1, 172.16.0.0/12")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		proxies    TrustedProxies
+		remoteAddr string
+		xff        []string
+		realIP     string
+		want       string
+	}{
+		{
+			name:       "direct client",
+			proxies:    proxies,
+			remoteAddr: "203.0.113.5:40000",
+			want:       "203.0.113.5",
+		},
+		{
+			name:       "headers ignored from untrusted peers",
+			proxies:    proxies,
+			remoteAddr: "203.0.113.5:40000",
+			xff:        []string{"198.51.100.7"},
+			realIP:     "198.51.100.8",
+			want:       "203.0.113.5",
+		},
+		{
+			name:       "headers ignored with no trusted proxies",
+			remoteAddr: "10.0.0.1:40000",
+			xff:        []string{"198.51.100.7"},
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "forwarded by trusted proxy",
+			proxies:    proxies,
+			remoteAddr: "10.0.0.1:40000",
+			xff:        []string{"198.51.100.7"},
+			want:       "198.51.100.7",
+		},
+		{
+			name:       "spoofed entries left of the real client skipped",
+			proxies:    proxies,
+			remoteAddr: "10.0.0.1:40000",
+			xff:        []string{"192.0.2.1, 198.51.100.7, 172.16.3.4"},
+			want:       "198.51.100.7",
+		},
+		{
+			name:       "repeated headers combined",
+			proxies:    proxies,
+			remoteAddr: "10.0.0.1:40000",
+			xff:        []string{"192.0.2.1", "198.51.100.7"},
+			want:       "198.51.100.7",
+		},
+		{
+			name:       "X-Real-IP from trusted proxy",
+			proxies:    proxies,
+			remoteAddr: "10.0.0.1:40000",
+			realIP:     "198.51.100.8",
+			want:       "198.51.100.8",
+		},
+		{
+			name:       "IPv4-mapped IPv6 peer",
+			proxies:    proxies,
+			remoteAddr: "[::ffff:10.0.0.1]:40000",
+			xff:        []string{"198.51.100.7"},
+			want:       "198.51.100.7",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/files/1", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			if got := tt.proxies.ClientIP(r); got != tt.want {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
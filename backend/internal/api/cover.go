@@ -0,0 +1,212 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wpinrui/dovora2/backend/internal/coverart"
+	"github.com/wpinrui/dovora2/backend/internal/db"
+)
+
+// maxCoverUploadBytes bounds an uploaded cover art image
+const maxCoverUploadBytes = 10 << 20
+
+// CoverHandler stores uploaded cover art for tracks and videos and serves it
+type CoverHandler struct {
+	db  *db.DB
+	dir string
+}
+
+// NewCoverHandler creates a cover handler that keeps cover art in dir
+func NewCoverHandler(database *db.DB, dir string) *CoverHandler {
+	return &CoverHandler{db: database, dir: dir}
+}
+
+// TrackCover handles GET and PUT /tracks/{id}/cover
+func (h *CoverHandler) TrackCover(w http.ResponseWriter, r *http.Request) {
+	h.cover(w, r, false)
+}
+
+// VideoCover handles GET and PUT /videos/{id}/cover
+func (h *CoverHandler) VideoCover(w http.ResponseWriter, r *http.Request) {
+	h.cover(w, r, true)
+}
+
+func (h *CoverHandler) cover(w http.ResponseWriter, r *http.Request, video bool) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		h.serve(w, r, userID, video)
+	case http.MethodPut:
+		h.upload(w, r, userID, video)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// serve sends an item's uploaded cover art
+func (h *CoverHandler) serve(w http.ResponseWriter, r *http.Request, userID string, video bool) {
+	var coverPath *string
+	var err error
+	if video {
+		var v *db.Video
+		if v, err = h.db.GetVideoByID(r.Context(), r.PathValue("id"), userID); err == nil {
+			coverPath = v.CoverPath
+		}
+	} else {
+		var t *db.Track
+		if t, err = h.db.GetTrackByID(r.Context(), r.PathValue("id"), userID); err == nil {
+			coverPath = t.CoverPath
+		}
+	}
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "item not found")
+			return
+		}
+		log.Printf("Failed to get item: %v", err)
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	if coverPath == nil {
+		writeError(w, http.StatusNotFound, "item has no uploaded cover art")
+		return
+	}
+
+	f, err := os.Open(*coverPath)
+	if err != nil {
+		log.Printf("Failed to open cover art %s: %v", *coverPath, err)
+		writeError(w, http.StatusNotFound, "cover art not found")
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		log.Printf("Failed to stat cover art %s: %v", *coverPath, err)
+		writeError(w, http.StatusInternalServerError, "failed to read cover art")
+		return
+	}
+
+	// Thumbnail URLs carry a version that changes with each upload
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.Header().Set("Content-Type", "image/jpeg")
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
+// upload replaces an item's thumbnail with the image in the request body,
+// after validating it and scaling it down to at most 1200 pixels a side
+func (h *CoverHandler) upload(w http.ResponseWriter, r *http.Request, userID string, video bool) {
+	id := r.PathValue("id")
+
+	// Check the item exists before anything is written for it
+	var err error
+	if video {
+		_, err = h.db.GetVideoByID(r.Context(), id, userID)
+	} else {
+		_, err = h.db.GetTrackByID(r.Context(), id, userID)
+	}
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "item not found")
+			return
+		}
+		log.Printf("Failed to get item: %v", err)
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCoverUploadBytes))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "cover art too large or unreadable")
+		return
+	}
+
+	cover, err := coverart.Process(data)
+	switch {
+	case errors.Is(err, coverart.ErrUnsupportedFormat):
+		writeError(w, http.StatusUnsupportedMediaType, err.Error())
+		return
+	case errors.Is(err, coverart.ErrTooSmall):
+		writeError(w, http.StatusBadRequest, "cover art must be at least 64 pixels a side")
+		return
+	case errors.Is(err, coverart.ErrTooLarge):
+		writeError(w, http.StatusBadRequest, "cover art must be at most 25 megapixels")
+		return
+	case err != nil:
+		log.Printf("Failed to process cover art: %v", err)
+		writeError(w, http.StatusBadRequest, "invalid image")
+		return
+	}
+
+	// Covers are named by item, so a new upload replaces the old file
+	coverPath := filepath.Join(h.dir, id+".jpg")
+	if err := writeCoverFile(coverPath, cover); err != nil {
+		log.Printf("Failed to save cover art: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to save cover art")
+		return
+	}
+
+	sum := sha256.Sum256(cover)
+	version := hex.EncodeToString(sum[:6])
+
+	var response interface{}
+	if video {
+		var v *db.Video
+		v, err = h.db.SetVideoCover(r.Context(), id, userID, coverPath, "/videos/"+id+"/cover?v="+version)
+		if err == nil {
+			response = newVideoResponse(v)
+		}
+	} else {
+		var t *db.Track
+		t, err = h.db.SetTrackCover(r.Context(), id, userID, coverPath, "/tracks/"+id+"/cover?v="+version)
+		if err == nil {
+			response = newTrackResponse(t)
+		}
+	}
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "item not found")
+			return
+		}
+		log.Printf("Failed to set cover art: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to update item")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// writeCoverFile writes cover art through a temporary file so a request
+// serving the old cover never sees a partial one
+func writeCoverFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".cover-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
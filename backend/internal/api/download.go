@@ -1,24 +1,55 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/wpinrui/dovora2/backend/internal/db"
+	"github.com/wpinrui/dovora2/backend/internal/media"
 	"github.com/wpinrui/dovora2/backend/internal/ytdlp"
 )
 
-const defaultVideoQuality = "best"
+const (
+	defaultVideoQuality = "best"
+
+	// processingTimeout bounds the background work done on each downloaded file
+	processingTimeout = 5 * time.Minute
+)
 
 type DownloadHandler struct {
 	db         *db.DB
 	downloader *ytdlp.Downloader
+	processor  *media.Processor
+
+	// queueWake signals the queue worker that a job was enqueued
+	queueWake chan struct{}
+
+	// processQueue holds new tracks waiting for processTrack; a single
+	// worker, running while the queue has tracks, takes them in turn
+	processMu      sync.Mutex
+	processQueue   []trackFile
+	processRunning bool
+}
+
+// trackFile is a track and the file holding its audio
+type trackFile struct {
+	id       string
+	filePath string
 }
 
-func NewDownloadHandler(database *db.DB, downloader *ytdlp.Downloader) *DownloadHandler {
-	return &DownloadHandler{db: database, downloader: downloader}
+func NewDownloadHandler(database *db.DB, downloader *ytdlp.Downloader, processor *media.Processor) *DownloadHandler {
+	return &DownloadHandler{
+		db:         database,
+		downloader: downloader,
+		processor:  processor,
+		queueWake:  make(chan struct{}, 1),
+	}
 }
 
 type downloadRequest struct {
@@ -66,33 +97,61 @@ func (h *DownloadHandler) Download(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	response, err := h.download(r.Context(), userID, req.VideoID, req.Type)
+	if err != nil {
+		message := "download failed"
+		var dlErr *downloadError
+		if errors.As(err, &dlErr) {
+			message = dlErr.message
+		}
+		log.Printf("Download failed for %s: %v", req.VideoID, err)
+		writeError(w, http.StatusInternalServerError, message)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+// downloadError pairs a download failure with the message shown to clients
+type downloadError struct {
+	message string
+	err     error
+}
+
+func (e *downloadError) Error() string {
+	return e.message + ": " + e.err.Error()
+}
+
+func (e *downloadError) Unwrap() error {
+	return e.err
+}
+
+// download fetches a YouTube video as audio or video and adds it to the
+// user's library, then starts deriving data from it in the background
+func (h *DownloadHandler) download(ctx context.Context, userID, videoID, mediaType string) (*downloadResponse, error) {
 	var result *ytdlp.DownloadResult
 	var err error
 
-	if req.Type == "audio" {
-		result, err = h.downloader.DownloadAudio(r.Context(), req.VideoID)
+	if mediaType == "audio" {
+		result, err = h.downloader.DownloadAudio(ctx, videoID)
 	} else {
-		result, err = h.downloader.DownloadVideo(r.Context(), req.VideoID)
+		result, err = h.downloader.DownloadVideo(ctx, videoID)
 	}
 
 	if err != nil {
-		log.Printf("Download failed for %s: %v", req.VideoID, err)
-		writeError(w, http.StatusInternalServerError, "download failed")
-		return
+		return nil, &downloadError{message: "download failed", err: err}
 	}
 
 	// Get file size
 	fileInfo, err := os.Stat(result.FilePath)
 	if err != nil {
-		log.Printf("Failed to stat file %s: %v", result.FilePath, err)
-		writeError(w, http.StatusInternalServerError, "failed to get file info")
-		return
+		return nil, &downloadError{message: "failed to get file info", err: err}
 	}
 	fileSize := fileInfo.Size()
 
-	var response downloadResponse
-
-	if req.Type == "audio" {
+	if mediaType == "audio" {
 		track := &db.Track{
 			UserID:          userID,
 			YoutubeID:       result.Metadata.ID,
@@ -102,6 +161,7 @@ func (h *DownloadHandler) Download(w http.ResponseWriter, r *http.Request) {
 			ThumbnailURL:    result.Metadata.Thumbnail,
 			FilePath:        result.FilePath,
 			FileSizeBytes:   fileSize,
+			Description:     result.Metadata.Description,
 		}
 
 		// Use channel as fallback for artist
@@ -109,14 +169,16 @@ func (h *DownloadHandler) Download(w http.ResponseWriter, r *http.Request) {
 			track.Artist = result.Metadata.Channel
 		}
 
-		track, err = h.db.CreateTrack(r.Context(), track)
+		track, err = h.db.CreateTrack(ctx, track)
 		if err != nil {
-			log.Printf("Failed to save track: %v", err)
-			writeError(w, http.StatusInternalServerError, "failed to save track")
-			return
+			return nil, &downloadError{message: "failed to save track", err: err}
 		}
 
-		response = downloadResponse{
+		linkCatalog(ctx, h.db, track)
+
+		h.queueProcessing(track.ID, track.FilePath)
+
+		return &downloadResponse{
 			ID:              track.ID,
 			YoutubeID:       track.YoutubeID,
 			Title:           track.Title,
@@ -125,40 +187,112 @@ func (h *DownloadHandler) Download(w http.ResponseWriter, r *http.Request) {
 			ThumbnailURL:    track.ThumbnailURL,
 			FileSizeBytes:   track.FileSizeBytes,
 			Type:            "audio",
-		}
-	} else {
-		video := &db.Video{
-			UserID:          userID,
-			YoutubeID:       result.Metadata.ID,
-			Title:           result.Metadata.Title,
-			Channel:         result.Metadata.Channel,
-			DurationSeconds: result.Metadata.Duration,
-			ThumbnailURL:    result.Metadata.Thumbnail,
-			FilePath:        result.FilePath,
-			FileSizeBytes:   fileSize,
-			Quality:         defaultVideoQuality,
-		}
+		}, nil
+	}
 
-		video, err = h.db.CreateVideo(r.Context(), video)
-		if err != nil {
-			log.Printf("Failed to save video: %v", err)
-			writeError(w, http.StatusInternalServerError, "failed to save video")
+	video := &db.Video{
+		UserID:          userID,
+		YoutubeID:       result.Metadata.ID,
+		Title:           result.Metadata.Title,
+		Channel:         result.Metadata.Channel,
+		DurationSeconds: result.Metadata.Duration,
+		ThumbnailURL:    result.Metadata.Thumbnail,
+		FilePath:        result.FilePath,
+		FileSizeBytes:   fileSize,
+		Quality:         defaultVideoQuality,
+		Description:     result.Metadata.Description,
+	}
+
+	video, err = h.db.CreateVideo(ctx, video)
+	if err != nil {
+		return nil, &downloadError{message: "failed to save video", err: err}
+	}
+
+	// Prepare adaptive streams ahead of the first playback
+	h.processor.EnsureHLS(video.FilePath)
+
+	return &downloadResponse{
+		ID:              video.ID,
+		YoutubeID:       video.YoutubeID,
+		Title:           video.Title,
+		Channel:         video.Channel,
+		DurationSeconds: video.DurationSeconds,
+		ThumbnailURL:    video.ThumbnailURL,
+		FileSizeBytes:   video.FileSizeBytes,
+		Type:            "video",
+	}, nil
+}
+
+// queueProcessing queues a new track for processTrack. Tracks are processed
+// one at a time, so a large import doesn't decode its whole library at once.
+func (h *DownloadHandler) queueProcessing(trackID, filePath string) {
+	h.processMu.Lock()
+	defer h.processMu.Unlock()
+
+	h.processQueue = append(h.processQueue, trackFile{id: trackID, filePath: filePath})
+	if !h.processRunning {
+		h.processRunning = true
+		go h.runProcessing()
+	}
+}
+
+// runProcessing processes queued tracks until the queue is empty
+func (h *DownloadHandler) runProcessing() {
+	for {
+		h.processMu.Lock()
+		if len(h.processQueue) == 0 {
+			h.processRunning = false
+			h.processMu.Unlock()
 			return
 		}
+		track := h.processQueue[0]
+		h.processQueue = h.processQueue[1:]
+		h.processMu.Unlock()
 
-		response = downloadResponse{
-			ID:              video.ID,
-			YoutubeID:       video.YoutubeID,
-			Title:           video.Title,
-			Channel:         video.Channel,
-			DurationSeconds: video.DurationSeconds,
-			ThumbnailURL:    video.ThumbnailURL,
-			FileSizeBytes:   video.FileSizeBytes,
-			Type:            "video",
-		}
+		h.processTrack(track.id, track.filePath)
 	}
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(response)
+// processTrack derives data from a newly downloaded track's audio. It runs in
+// the background, so failures are logged rather than returned.
+func (h *DownloadHandler) processTrack(trackID, filePath string) {
+	ctx, cancel := context.WithTimeout(context.Background(), processingTimeout)
+	defer cancel()
+
+	h.fingerprintTrack(ctx, trackID, filePath)
+
+	if _, err := h.processor.Waveform(ctx, filePath); err != nil {
+		log.Printf("Failed to generate waveform for track %s: %v", trackID, err)
+	}
+}
+
+func (h *DownloadHandler) fingerprintTrack(ctx context.Context, trackID, filePath string) {
+	fp, err := h.processor.Fingerprint(ctx, filePath)
+	if err != nil {
+		log.Printf("Failed to fingerprint track %s: %v", trackID, err)
+		return
+	}
+
+	if err := h.db.SetTrackFingerprint(ctx, trackID, fp.Bytes()); err != nil {
+		log.Printf("Failed to save fingerprint for track %s: %v", trackID, err)
+	}
+}
+
+// BackfillFingerprints fingerprints tracks downloaded before fingerprinting existed
+func (h *DownloadHandler) BackfillFingerprints(ctx context.Context) {
+	tracks, err := h.db.GetUnfingerprintedTracks(ctx)
+	if err != nil {
+		log.Printf("Failed to get tracks for fingerprint backfill: %v", err)
+		return
+	}
+
+	for _, track := range tracks {
+		trackCtx, cancel := context.WithTimeout(ctx, processingTimeout)
+		h.fingerprintTrack(trackCtx, track.ID, track.FilePath)
+		cancel()
+
+		if ctx.Err() != nil {
+			return
+		}
+	}
 }
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wpinrui/dovora2/backend/internal/db"
+)
+
+const (
+	// downloadQueuePollInterval is how often the worker checks for jobs
+	// whose retry delay has passed
+	downloadQueuePollInterval = 30 * time.Second
+
+	maxDownloadAttempts = 3
+	downloadJobTimeout  = 15 * time.Minute
+)
+
+type downloadJobResponse struct {
+	ID               string  `json:"id"`
+	YoutubeID        string  `json:"youtube_id"`
+	Type             string  `json:"type"`
+	PlaylistID       *string `json:"playlist_id,omitempty"`
+	PlaylistPosition *int    `json:"playlist_position,omitempty"`
+	Status           string  `json:"status"`
+	Attempts         int     `json:"attempts"`
+	Error            string  `json:"error,omitempty"`
+	ItemID           *string `json:"item_id,omitempty"`
+	CreatedAt        string  `json:"created_at"`
+	UpdatedAt        string  `json:"updated_at"`
+}
+
+type downloadJobsResponse struct {
+	Jobs []downloadJobResponse `json:"jobs"`
+}
+
+func newDownloadJobResponse(job *db.DownloadJob) downloadJobResponse {
+	return downloadJobResponse{
+		ID:               job.ID,
+		YoutubeID:        job.YoutubeID,
+		Type:             job.Type,
+		PlaylistID:       job.PlaylistID,
+		PlaylistPosition: job.PlaylistPosition,
+		Status:           job.Status,
+		Attempts:         job.Attempts,
+		Error:            job.Error,
+		ItemID:           job.ItemID,
+		CreatedAt:        job.CreatedAt.Format(timeFormatISO8601),
+		UpdatedAt:        job.UpdatedAt.Format(timeFormatISO8601),
+	}
+}
+
+// ListJobs handles GET /downloads, returning the user's queued downloads
+func (h *DownloadHandler) ListJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	jobs, err := h.db.ListDownloadJobs(r.Context(), userID)
+	if err != nil {
+		log.Printf("Failed to list download jobs: %v", err)
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+
+	response := downloadJobsResponse{Jobs: make([]downloadJobResponse, 0, len(jobs))}
+	for i := range jobs {
+		response.Jobs = append(response.Jobs, newDownloadJobResponse(&jobs[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// QueueDownload adds a download to the queue processed by RunQueue
+func (h *DownloadHandler) QueueDownload(ctx context.Context, job *db.DownloadJob) (*db.DownloadJob, error) {
+	queued, err := h.db.EnqueueDownload(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case h.queueWake <- struct{}{}:
+	default:
+	}
+
+	return queued, nil
+}
+
+// RunQueue processes queued downloads one at a time until ctx is cancelled
+func (h *DownloadHandler) RunQueue(ctx context.Context) {
+	if err := h.db.ResetRunningDownloadJobs(ctx); err != nil {
+		log.Printf("Failed to requeue interrupted downloads: %v", err)
+	}
+
+	ticker := time.NewTicker(downloadQueuePollInterval)
+	defer ticker.Stop()
+
+	for {
+		job, err := h.db.ClaimDownloadJob(ctx)
+		if err == nil {
+			h.runDownloadJob(ctx, job)
+			continue
+		}
+		if !errors.Is(err, db.ErrNotFound) && ctx.Err() == nil {
+			log.Printf("Failed to claim download job: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.queueWake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *DownloadHandler) runDownloadJob(ctx context.Context, job *db.DownloadJob) {
+	jobCtx, cancel := context.WithTimeout(ctx, downloadJobTimeout)
+	defer cancel()
+
+	itemID, err := h.existingItem(jobCtx, job)
+	if err == nil && itemID == "" {
+		var response *downloadResponse
+		response, err = h.download(jobCtx, job.UserID, job.YoutubeID, job.Type)
+		if err == nil {
+			itemID = response.ID
+		}
+	}
+
+	if err != nil {
+		log.Printf("Queued download %s of %s failed: %v", job.ID, job.YoutubeID, err)
+
+		var retryAt *time.Time
+		if job.Attempts < maxDownloadAttempts && ctx.Err() == nil {
+			at := time.Now().Add(time.Duration(job.Attempts) * time.Minute)
+			retryAt = &at
+		}
+		// Use a fresh context so the failure is recorded even during shutdown
+		if err := h.db.FailDownloadJob(context.Background(), job.ID, err.Error(), retryAt); err != nil {
+			log.Printf("Failed to record download job failure: %v", err)
+		}
+		return
+	}
+
+	if job.PlaylistID != nil && job.Type == "audio" {
+		position := 0
+		if job.PlaylistPosition != nil {
+			position = *job.PlaylistPosition
+		}
+		if err := h.db.InsertTrackIntoPlaylist(jobCtx, *job.PlaylistID, itemID, position); err != nil {
+			log.Printf("Failed to add downloaded track %s to playlist %s: %v", itemID, *job.PlaylistID, err)
+		}
+	}
+
+	if err := h.db.CompleteDownloadJob(jobCtx, job.ID, itemID); err != nil {
+		log.Printf("Failed to complete download job %s: %v", job.ID, err)
+	}
+}
+
+// existingItem returns the library item a job would create if the user
+// already has it, so queued duplicates don't download twice
+func (h *DownloadHandler) existingItem(ctx context.Context, job *db.DownloadJob) (string, error) {
+	if job.Type == "audio" {
+		track, err := h.db.GetTrackByYoutubeID(ctx, job.UserID, job.YoutubeID)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return "", nil
+			}
+			return "", err
+		}
+		return track.ID, nil
+	}
+
+	video, err := h.db.GetVideoByYoutubeID(ctx, job.UserID, job.YoutubeID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+	return video.ID, nil
+}
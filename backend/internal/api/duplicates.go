@@ -0,0 +1,164 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/wpinrui/dovora2/backend/internal/db"
+	"github.com/wpinrui/dovora2/backend/internal/media"
+)
+
+// duplicateThreshold is the fingerprint similarity at which two tracks are
+// treated as the same recording
+const duplicateThreshold = 0.85
+
+type DuplicatesHandler struct {
+	db        *db.DB
+	processor *media.Processor
+}
+
+func NewDuplicatesHandler(database *db.DB, processor *media.Processor) *DuplicatesHandler {
+	return &DuplicatesHandler{db: database, processor: processor}
+}
+
+type duplicateGroupResponse struct {
+	Tracks []trackResponse `json:"tracks"`
+}
+
+type duplicatesResponse struct {
+	Groups []duplicateGroupResponse `json:"groups"`
+}
+
+type mergeDuplicatesRequest struct {
+	KeepID   string   `json:"keep_id"`
+	MergeIDs []string `json:"merge_ids"`
+}
+
+// List handles GET /library/duplicates, grouping tracks whose audio fingerprints match
+func (h *DuplicatesHandler) List(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	stored, err := h.db.GetTrackFingerprints(r.Context(), userID)
+	if err != nil {
+		log.Printf("Failed to get fingerprints for user %s: %v", userID, err)
+		writeError(w, http.StatusInternalServerError, "failed to get duplicates")
+		return
+	}
+
+	fingerprints := make([]media.Fingerprint, 0, len(stored))
+	trackIDs := make([]string, 0, len(stored))
+	for _, s := range stored {
+		fp, err := media.FingerprintFromBytes(s.Fingerprint)
+		if err != nil {
+			log.Printf("Skipping corrupt fingerprint for track %s: %v", s.TrackID, err)
+			continue
+		}
+		fingerprints = append(fingerprints, fp)
+		trackIDs = append(trackIDs, s.TrackID)
+	}
+
+	groups := media.DuplicateGroups(fingerprints, duplicateThreshold)
+
+	var duplicateIDs []string
+	for _, group := range groups {
+		for _, i := range group {
+			duplicateIDs = append(duplicateIDs, trackIDs[i])
+		}
+	}
+
+	tracksByID := make(map[string]*db.Track, len(duplicateIDs))
+	if len(duplicateIDs) > 0 {
+		tracks, err := h.db.GetTracksByIDs(r.Context(), userID, duplicateIDs)
+		if err != nil {
+			log.Printf("Failed to get duplicate tracks for user %s: %v", userID, err)
+			writeError(w, http.StatusInternalServerError, "failed to get duplicates")
+			return
+		}
+		for i := range tracks {
+			tracksByID[tracks[i].ID] = &tracks[i]
+		}
+	}
+
+	response := duplicatesResponse{
+		Groups: make([]duplicateGroupResponse, 0, len(groups)),
+	}
+	for _, group := range groups {
+		tracks := make([]trackResponse, 0, len(group))
+		for _, i := range group {
+			if track, ok := tracksByID[trackIDs[i]]; ok {
+				tracks = append(tracks, newTrackResponse(track))
+			}
+		}
+		if len(tracks) > 1 {
+			response.Groups = append(response.Groups, duplicateGroupResponse{Tracks: tracks})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Merge handles POST /library/duplicates/merge, keeping one track and folding the others into it
+func (h *DuplicatesHandler) Merge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	var req mergeDuplicatesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if req.KeepID == "" {
+		writeError(w, http.StatusBadRequest, "keep_id is required")
+		return
+	}
+
+	slices.Sort(req.MergeIDs)
+	mergeIDs := slices.Compact(req.MergeIDs)
+	if len(mergeIDs) == 0 {
+		writeError(w, http.StatusBadRequest, "merge_ids is required")
+		return
+	}
+	if slices.Contains(mergeIDs, req.KeepID) {
+		writeError(w, http.StatusBadRequest, "merge_ids must not contain keep_id")
+		return
+	}
+
+	filePaths, err := h.db.MergeTracks(r.Context(), userID, req.KeepID, mergeIDs)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "track not found")
+			return
+		}
+		log.Printf("Failed to merge tracks: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to merge tracks")
+		return
+	}
+
+	for _, filePath := range filePaths {
+		removeUnreferencedFile(r.Context(), h.db, h.processor, filePath)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
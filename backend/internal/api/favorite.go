@@ -0,0 +1,128 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wpinrui/dovora2/backend/internal/db"
+)
+
+// maxRating is the highest star rating; 0 means unrated
+const maxRating = 5
+
+// PreferenceHandler stores favorites and star ratings on tracks and videos
+type PreferenceHandler struct {
+	db *db.DB
+}
+
+func NewPreferenceHandler(database *db.DB) *PreferenceHandler {
+	return &PreferenceHandler{db: database}
+}
+
+type ratingRequest struct {
+	Rating *int `json:"rating"`
+}
+
+// TrackFavorite handles PUT and DELETE /tracks/{id}/favorite
+func (h *PreferenceHandler) TrackFavorite(w http.ResponseWriter, r *http.Request) {
+	h.favorite(w, r, false)
+}
+
+// VideoFavorite handles PUT and DELETE /videos/{id}/favorite
+func (h *PreferenceHandler) VideoFavorite(w http.ResponseWriter, r *http.Request) {
+	h.favorite(w, r, true)
+}
+
+// TrackRating handles PUT /tracks/{id}/rating
+func (h *PreferenceHandler) TrackRating(w http.ResponseWriter, r *http.Request) {
+	h.rate(w, r, false)
+}
+
+// VideoRating handles PUT /videos/{id}/rating
+func (h *PreferenceHandler) VideoRating(w http.ResponseWriter, r *http.Request) {
+	h.rate(w, r, true)
+}
+
+func (h *PreferenceHandler) favorite(w http.ResponseWriter, r *http.Request, video bool) {
+	var favorite bool
+	switch r.Method {
+	case http.MethodPut:
+		favorite = true
+	case http.MethodDelete:
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	h.set(w, r, video, &db.Preferences{Favorite: &favorite})
+}
+
+func (h *PreferenceHandler) rate(w http.ResponseWriter, r *http.Request, video bool) {
+	if r.Method != http.MethodPut {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req ratingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if req.Rating == nil || *req.Rating < 0 || *req.Rating > maxRating {
+		writeError(w, http.StatusBadRequest, "rating must be between 0 and 5")
+		return
+	}
+
+	h.set(w, r, video, &db.Preferences{Rating: req.Rating})
+}
+
+// set applies preferences to the track or video in the path and responds
+// with the updated item
+func (h *PreferenceHandler) set(w http.ResponseWriter, r *http.Request, video bool, prefs *db.Preferences) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	var response interface{}
+	var err error
+	if video {
+		var v *db.Video
+		if v, err = h.db.SetVideoPreferences(r.Context(), r.PathValue("id"), userID, prefs); err == nil {
+			response = newVideoResponse(v)
+		}
+	} else {
+		var t *db.Track
+		if t, err = h.db.SetTrackPreferences(r.Context(), r.PathValue("id"), userID, prefs); err == nil {
+			response = newTrackResponse(t)
+		}
+	}
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "item not found")
+			return
+		}
+		log.Printf("Failed to set preferences: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to update item")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// formatOptionalTime formats a time that may be unset for a response field
+// that is omitted when empty
+func formatOptionalTime(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	formatted := t.Format(timeFormatISO8601)
+	return &formatted
+}
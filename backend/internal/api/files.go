@@ -1,23 +1,111 @@
 package api
 
 import (
+	"encoding/json"
 	"errors"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/wpinrui/dovora2/backend/internal/auth"
 	"github.com/wpinrui/dovora2/backend/internal/db"
+	"github.com/wpinrui/dovora2/backend/internal/media"
 )
 
+// defaultTranscodeFormat is used when only max_bitrate is requested
+const defaultTranscodeFormat = "aac"
+
 type FileHandler struct {
-	db *db.DB
+	db        *db.DB
+	processor *media.Processor
+	urlSigner *auth.URLSigner
+	proxies   TrustedProxies
+}
+
+func NewFileHandler(database *db.DB, processor *media.Processor, urlSigner *auth.URLSigner, proxies TrustedProxies) *FileHandler {
+	return &FileHandler{db: database, processor: processor, urlSigner: urlSigner, proxies: proxies}
+}
+
+type signedURLRequest struct {
+	TTLSeconds int  `json:"ttl_seconds"`
+	BindIP     bool `json:"bind_ip"`
+}
+
+type signedURLResponse struct {
+	URL       string `json:"url"`
+	ExpiresAt string `json:"expires_at"`
 }
 
-func NewFileHandler(database *db.DB) *FileHandler {
-	return &FileHandler{db: database}
+// CreateSignedURL handles POST /files/{id}/signed-url, minting a URL for the
+// file that works without an Authorization header until it expires. The
+// returned URL is relative to the API base URL and accepts the same query
+// parameters as GET /files/{id}.
+func (h *FileHandler) CreateSignedURL(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	// The body is optional; an empty one takes the defaults
+	var req signedURLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	ttl := auth.DefaultSignedURLDuration
+	if req.TTLSeconds < 0 {
+		writeError(w, http.StatusBadRequest, "ttl_seconds must be positive")
+		return
+	}
+	if req.TTLSeconds > 0 {
+		ttl = min(time.Duration(req.TTLSeconds)*time.Second, auth.MaxSignedURLDuration)
+	}
+
+	id := r.PathValue("id")
+	if _, err := h.db.GetTrackByID(r.Context(), id, userID); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			log.Printf("Failed to query track: %v", err)
+			writeError(w, http.StatusInternalServerError, "database error")
+			return
+		}
+
+		if _, err := h.db.GetVideoByID(r.Context(), id, userID); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				writeError(w, http.StatusNotFound, "file not found")
+				return
+			}
+			log.Printf("Failed to query video: %v", err)
+			writeError(w, http.StatusInternalServerError, "database error")
+			return
+		}
+	}
+
+	var ip string
+	if req.BindIP {
+		ip = h.proxies.ClientIP(r)
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	values := h.urlSigner.Sign(id, userID, ip, expiresAt)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(signedURLResponse{
+		URL:       "/files/" + id + "?" + values.Encode(),
+		ExpiresAt: expiresAt.UTC().Format(time.RFC3339),
+	})
 }
 
 func (h *FileHandler) ServeFile(w http.ResponseWriter, r *http.Request) {
@@ -39,9 +127,40 @@ func (h *FileHandler) ServeFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Optional transcoding: ?format=mp3|opus|aac&max_bitrate={kbps}
+	query := r.URL.Query()
+	formatName := query.Get("format")
+	maxBitrate := 0
+	if v := query.Get("max_bitrate"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed <= 0 {
+			writeError(w, http.StatusBadRequest, "max_bitrate must be a positive number of kbps")
+			return
+		}
+		maxBitrate = parsed
+		if formatName == "" {
+			formatName = defaultTranscodeFormat
+		}
+	}
+
+	var format media.TranscodeFormat
+	transcode := formatName != ""
+	if transcode {
+		var ok bool
+		format, ok = media.LookupTranscodeFormat(formatName)
+		if !ok {
+			writeError(w, http.StatusBadRequest, "format must be 'mp3', 'opus' or 'aac'")
+			return
+		}
+	}
+
 	// Try to find as track first
 	track, err := h.db.GetTrackByID(r.Context(), id, userID)
 	if err == nil {
+		if transcode {
+			h.serveTranscodedFile(w, r, track, format, format.TranscodeBitrate(maxBitrate))
+			return
+		}
 		h.serveMediaFile(w, r, track.FilePath, track.Title+".m4a", "audio/mp4")
 		return
 	}
@@ -56,6 +175,10 @@ func (h *FileHandler) ServeFile(w http.ResponseWriter, r *http.Request) {
 	// Try to find as video
 	video, err := h.db.GetVideoByID(r.Context(), id, userID)
 	if err == nil {
+		if transcode {
+			writeError(w, http.StatusBadRequest, "transcoding is only supported for tracks")
+			return
+		}
 		h.serveMediaFile(w, r, video.FilePath, video.Title+".mp4", "video/mp4")
 		return
 	}
@@ -103,6 +226,39 @@ func (h *FileHandler) serveMediaFile(w http.ResponseWriter, r *http.Request, fil
 	http.ServeContent(w, r, safeFilename, fileInfo.ModTime(), file)
 }
 
+// serveTranscodedFile serves a track transcoded to the given format. Cached
+// transcodes support range requests; otherwise the output is streamed while
+// ffmpeg produces it, so the whole response is sent without ranges.
+func (h *FileHandler) serveTranscodedFile(w http.ResponseWriter, r *http.Request, track *db.Track, format media.TranscodeFormat, bitrate int) {
+	filename := track.Title + format.Ext
+
+	if cached, ok := h.processor.CachedTranscode(track.FilePath, format, bitrate); ok {
+		h.serveMediaFile(w, r, cached, filename, format.ContentType)
+		return
+	}
+
+	if _, err := os.Stat(track.FilePath); err != nil {
+		if os.IsNotExist(err) {
+			log.Printf("File not found on disk: %s", track.FilePath)
+			writeError(w, http.StatusNotFound, "file not found on disk")
+			return
+		}
+		log.Printf("Failed to stat file: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to access file")
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType)
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+sanitizeFilename(filename)+"\"")
+	w.Header().Set("Accept-Ranges", "none")
+
+	// Headers are sent with the first chunk of output, so failures after
+	// that can only be logged
+	if err := h.processor.Transcode(r.Context(), w, track.FilePath, format, bitrate); err != nil {
+		log.Printf("Failed to transcode track %s: %v", track.ID, err)
+	}
+}
+
 func sanitizeFilename(filename string) string {
 	// Remove path separators and other problematic characters
 	filename = filepath.Base(filename)
@@ -0,0 +1,562 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wpinrui/dovora2/backend/internal/db"
+	"github.com/wpinrui/dovora2/backend/internal/importer"
+	"github.com/wpinrui/dovora2/backend/internal/invidious"
+	"github.com/wpinrui/dovora2/backend/internal/playlistfmt"
+)
+
+const (
+	// maxImportRows bounds how many tracks one import may search for
+	maxImportRows = 5000
+
+	// importSearchInterval spaces out searches so large imports don't
+	// hammer the Invidious instance
+	importSearchInterval = 500 * time.Millisecond
+
+	importSearchTimeout = 30 * time.Second
+	importPollInterval  = 30 * time.Second
+)
+
+type ImportHandler struct {
+	db        *db.DB
+	invidious *invidious.Client
+	downloads *DownloadHandler
+	wake      chan struct{}
+}
+
+func NewImportHandler(database *db.DB, invidiousClient *invidious.Client, downloads *DownloadHandler) *ImportHandler {
+	return &ImportHandler{
+		db:        database,
+		invidious: invidiousClient,
+		downloads: downloads,
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+type importCandidateResponse struct {
+	YoutubeID       string  `json:"youtube_id"`
+	Title           string  `json:"title"`
+	Channel         string  `json:"channel"`
+	DurationSeconds int     `json:"duration_seconds"`
+	Score           float64 `json:"score"`
+}
+
+type importRowResponse struct {
+	Position        int                       `json:"position"`
+	Title           string                    `json:"title"`
+	Artist          string                    `json:"artist,omitempty"`
+	Album           string                    `json:"album,omitempty"`
+	DurationSeconds int                       `json:"duration_seconds,omitempty"`
+	Status          string                    `json:"status"`
+	YoutubeID       *string                   `json:"youtube_id,omitempty"`
+	Score           *float64                  `json:"score,omitempty"`
+	Candidates      []importCandidateResponse `json:"candidates,omitempty"`
+	DownloadJobID   *string                   `json:"download_job_id,omitempty"`
+}
+
+type importJobResponse struct {
+	ID         string              `json:"id"`
+	Name       string              `json:"name"`
+	PlaylistID *string             `json:"playlist_id"`
+	Status     string              `json:"status"`
+	Total      int                 `json:"total"`
+	Pending    int                 `json:"pending"`
+	Matched    int                 `json:"matched"`
+	Review     int                 `json:"review"`
+	Skipped    int                 `json:"skipped"`
+	CreatedAt  string              `json:"created_at"`
+	UpdatedAt  string              `json:"updated_at"`
+	Rows       []importRowResponse `json:"rows,omitempty"`
+}
+
+type importJobsResponse struct {
+	Imports []importJobResponse `json:"imports"`
+}
+
+type resolveImportRowRequest struct {
+	YoutubeID string `json:"youtube_id"`
+	Skip      bool   `json:"skip"`
+}
+
+func newImportJobResponse(job *db.ImportJob) importJobResponse {
+	return importJobResponse{
+		ID:         job.ID,
+		Name:       job.Name,
+		PlaylistID: job.PlaylistID,
+		Status:     job.Status,
+		Total:      job.Total,
+		Pending:    job.Pending,
+		Matched:    job.Matched,
+		Review:     job.Review,
+		Skipped:    job.Skipped,
+		CreatedAt:  job.CreatedAt.Format(timeFormatISO8601),
+		UpdatedAt:  job.UpdatedAt.Format(timeFormatISO8601),
+	}
+}
+
+func newImportRowResponse(row *db.ImportRow) importRowResponse {
+	response := importRowResponse{
+		Position:        row.Position,
+		Title:           row.Title,
+		Artist:          row.Artist,
+		Album:           row.Album,
+		DurationSeconds: row.DurationSeconds,
+		Status:          row.Status,
+		YoutubeID:       row.YoutubeID,
+		Score:           row.Score,
+		DownloadJobID:   row.DownloadJobID,
+	}
+
+	// Candidates are only useful while the user still has to choose
+	if row.Status == db.ImportRowReview || row.Status == db.ImportRowSkipped {
+		for _, candidate := range row.Candidates {
+			response.Candidates = append(response.Candidates, importCandidateResponse{
+				YoutubeID:       candidate.VideoID,
+				Title:           candidate.Title,
+				Channel:         candidate.Channel,
+				DurationSeconds: candidate.DurationSeconds,
+				Score:           candidate.Score,
+			})
+		}
+	}
+
+	return response
+}
+
+// HandleImports handles GET /imports to list import jobs and POST /imports
+// to start one
+func (h *ImportHandler) HandleImports(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.list(w, r)
+	case http.MethodPost:
+		h.create(w, r)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// create handles POST /imports?format=csv|json&name=. The request body is a
+// Spotify or Apple Music playlist export; its format is detected when not
+// given. Rows are searched on YouTube in the background.
+func (h *ImportHandler) create(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPlaylistImportBytes))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "playlist file too large or unreadable")
+		return
+	}
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = importer.Detect(data)
+	}
+
+	parsed, err := importer.Parse(format, data)
+	if err != nil {
+		switch {
+		case errors.Is(err, importer.ErrUnsupportedFormat):
+			writeError(w, http.StatusBadRequest, "format must be 'csv' or 'json'")
+		case errors.Is(err, importer.ErrNoTitleColumn):
+			writeError(w, http.StatusBadRequest, "no track title column found")
+		default:
+			writeError(w, http.StatusBadRequest, "invalid playlist file")
+		}
+		return
+	}
+	if len(parsed) == 0 {
+		writeError(w, http.StatusBadRequest, "playlist has no tracks")
+		return
+	}
+	if len(parsed) > maxImportRows {
+		writeError(w, http.StatusBadRequest, "playlist has more than 5000 tracks")
+		return
+	}
+
+	name := query.Get("name")
+	if name == "" {
+		name = defaultImportedPlaylistName
+	}
+	if runes := []rune(name); len(runes) > maxPlaylistNameLength {
+		name = string(runes[:maxPlaylistNameLength])
+	}
+
+	rows := make([]db.ImportRow, len(parsed))
+	for i, row := range parsed {
+		rows[i] = db.ImportRow{
+			Title:           row.Title,
+			Artist:          row.Artist,
+			Album:           row.Album,
+			DurationSeconds: row.DurationSeconds,
+		}
+	}
+
+	job, err := h.db.CreateImportJob(r.Context(), userID, name, rows)
+	if err != nil {
+		log.Printf("Failed to create import job: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to create import")
+		return
+	}
+
+	select {
+	case h.wake <- struct{}{}:
+	default:
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(newImportJobResponse(job))
+}
+
+func (h *ImportHandler) list(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	jobs, err := h.db.ListImportJobs(r.Context(), userID)
+	if err != nil {
+		log.Printf("Failed to list import jobs: %v", err)
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+
+	response := importJobsResponse{Imports: make([]importJobResponse, 0, len(jobs))}
+	for i := range jobs {
+		response.Imports = append(response.Imports, newImportJobResponse(&jobs[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetImport handles GET /imports/{id}, returning the job with every row and
+// the candidates of rows awaiting review
+func (h *ImportHandler) GetImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	job, ok := h.getJob(w, r, userID)
+	if !ok {
+		return
+	}
+
+	rows, err := h.db.ListImportRows(r.Context(), job.ID)
+	if err != nil {
+		log.Printf("Failed to list import rows: %v", err)
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+
+	response := newImportJobResponse(job)
+	response.Rows = make([]importRowResponse, 0, len(rows))
+	for i := range rows {
+		response.Rows = append(response.Rows, newImportRowResponse(&rows[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// ResolveRow handles POST /imports/{id}/rows/{position}. The body either
+// picks a YouTube video for a row awaiting review, which is then downloaded
+// into the playlist, or skips the row.
+func (h *ImportHandler) ResolveRow(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	position, err := strconv.Atoi(r.PathValue("position"))
+	if err != nil || position < 0 {
+		writeError(w, http.StatusBadRequest, "invalid row position")
+		return
+	}
+
+	var req resolveImportRowRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	// Accept a pasted link as well as a bare video ID
+	videoID := req.YoutubeID
+	if !playlistfmt.IsVideoID(videoID) {
+		videoID = playlistfmt.YouTubeID(req.YoutubeID)
+	}
+	if req.Skip == (videoID == "") {
+		writeError(w, http.StatusBadRequest, "provide either a valid youtube_id or skip")
+		return
+	}
+
+	job, ok := h.getJob(w, r, userID)
+	if !ok {
+		return
+	}
+
+	row, err := h.db.GetImportRow(r.Context(), job.ID, position)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "row not found")
+			return
+		}
+		log.Printf("Failed to get import row: %v", err)
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+
+	previous := *row
+	if req.Skip {
+		row.Status = db.ImportRowSkipped
+		row.YoutubeID = nil
+	} else {
+		row.Status = db.ImportRowMatched
+		row.YoutubeID = &videoID
+		row.Score = nil
+		for _, candidate := range row.Candidates {
+			if candidate.VideoID == videoID {
+				row.Score = &candidate.Score
+			}
+		}
+	}
+
+	if err := h.db.UpdateImportRow(r.Context(), row, db.ImportRowReview, db.ImportRowSkipped); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			writeError(w, http.StatusConflict, "row has already been matched")
+			return
+		}
+		log.Printf("Failed to resolve import row: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to resolve row")
+		return
+	}
+
+	if row.Status == db.ImportRowMatched {
+		err := h.queueRowDownload(r.Context(), job, row)
+		if err == nil {
+			err = h.db.UpdateImportRow(r.Context(), row)
+		}
+		if err != nil {
+			log.Printf("Failed to queue download of %s: %v", videoID, err)
+			if err := h.db.UpdateImportRow(r.Context(), &previous); err != nil {
+				log.Printf("Failed to restore import row: %v", err)
+			}
+			writeError(w, http.StatusInternalServerError, "failed to queue download")
+			return
+		}
+	}
+
+	if err := h.db.RefreshImportJobStatus(r.Context(), job.ID); err != nil {
+		log.Printf("Failed to update import job: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newImportRowResponse(row))
+}
+
+func (h *ImportHandler) getJob(w http.ResponseWriter, r *http.Request, userID string) (*db.ImportJob, bool) {
+	job, err := h.db.GetImportJob(r.Context(), r.PathValue("id"), userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "import not found")
+			return nil, false
+		}
+		log.Printf("Failed to get import job: %v", err)
+		writeError(w, http.StatusInternalServerError, "database error")
+		return nil, false
+	}
+	return job, true
+}
+
+// queueRowDownload queues the download of a matched row and sets the row's
+// DownloadJobID. Once done, the track joins the playlist with the row's
+// position in the export as its stored position, so rows resolved in any
+// order, or skipped, leave the others in export order.
+func (h *ImportHandler) queueRowDownload(ctx context.Context, job *db.ImportJob, row *db.ImportRow) error {
+	position := row.Position
+	queued, err := h.downloads.QueueDownload(ctx, &db.DownloadJob{
+		UserID:           job.UserID,
+		YoutubeID:        *row.YoutubeID,
+		Type:             "audio",
+		PlaylistID:       job.PlaylistID,
+		PlaylistPosition: &position,
+	})
+	if err != nil {
+		return err
+	}
+
+	row.DownloadJobID = &queued.ID
+	return nil
+}
+
+// RunImports matches the rows of pending imports one at a time until ctx is
+// cancelled. Jobs interrupted by a restart resume from their first pending row.
+func (h *ImportHandler) RunImports(ctx context.Context) {
+	ticker := time.NewTicker(importPollInterval)
+	defer ticker.Stop()
+
+	for {
+		job, err := h.db.NextMatchingImportJob(ctx)
+		if err == nil {
+			h.runImportJob(ctx, job)
+			continue
+		}
+		if !errors.Is(err, db.ErrNotFound) && ctx.Err() == nil {
+			log.Printf("Failed to get import job: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *ImportHandler) runImportJob(ctx context.Context, job *db.ImportJob) {
+	for {
+		row, err := h.db.NextPendingImportRow(ctx, job.ID)
+		if err != nil {
+			if !errors.Is(err, db.ErrNotFound) {
+				if ctx.Err() == nil {
+					log.Printf("Failed to get import row: %v", err)
+				}
+				// Leave the job matching so it's picked up again later
+				h.sleep(ctx, importPollInterval)
+				return
+			}
+			break
+		}
+
+		if err := h.matchRow(ctx, job, row); err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Failed to match import row %d of %s: %v", row.Position, job.ID, err)
+				h.sleep(ctx, importPollInterval)
+			}
+			return
+		}
+
+		if !h.sleep(ctx, importSearchInterval) {
+			return
+		}
+	}
+
+	if err := h.db.RefreshImportJobStatus(ctx, job.ID); err != nil {
+		log.Printf("Failed to update import job: %v", err)
+	}
+}
+
+// matchRow searches YouTube for a row and either accepts the best candidate,
+// queueing its download, or leaves the ranked candidates for review. A failed
+// search also sends the row to review, since retrying rarely helps a query
+// Invidious can't answer.
+func (h *ImportHandler) matchRow(ctx context.Context, job *db.ImportJob, row *db.ImportRow) error {
+	want := importer.Row{
+		Title:           row.Title,
+		Artist:          row.Artist,
+		Album:           row.Album,
+		DurationSeconds: row.DurationSeconds,
+	}
+
+	searchCtx, cancel := context.WithTimeout(ctx, importSearchTimeout)
+	results, err := h.invidious.Search(searchCtx, importer.SearchQuery(want), "video")
+	cancel()
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Printf("Import search for %q failed: %v", importer.SearchQuery(want), err)
+	}
+
+	candidates := make([]importer.Candidate, 0, len(results))
+	for _, result := range results {
+		if result.LiveNow {
+			continue
+		}
+		candidates = append(candidates, importer.Candidate{
+			VideoID:         result.VideoID,
+			Title:           result.Title,
+			Channel:         result.Author,
+			DurationSeconds: result.LengthSeconds,
+		})
+	}
+	ranked := importer.Rank(want, candidates)
+
+	row.Status = db.ImportRowReview
+	row.Candidates = make([]db.ImportCandidate, 0, len(ranked))
+	for _, candidate := range ranked {
+		row.Candidates = append(row.Candidates, db.ImportCandidate{
+			VideoID:         candidate.VideoID,
+			Title:           candidate.Title,
+			Channel:         candidate.Channel,
+			DurationSeconds: candidate.DurationSeconds,
+			Score:           candidate.Score,
+		})
+	}
+
+	if len(ranked) > 0 {
+		best := ranked[0]
+		row.Score = &best.Score
+		if best.Score >= importer.AutoAcceptScore {
+			row.Status = db.ImportRowMatched
+			row.YoutubeID = &best.VideoID
+		}
+	}
+
+	// Queue before saving so a failure leaves the row pending to retry. A
+	// repeated download is harmless: the queue skips tracks already in the
+	// library and the playlist skips tracks it already holds.
+	if row.Status == db.ImportRowMatched {
+		if err := h.queueRowDownload(ctx, job, row); err != nil {
+			return err
+		}
+	}
+
+	return h.db.UpdateImportRow(ctx, row, db.ImportRowPending)
+}
+
+// sleep waits for d, returning false if ctx is cancelled first
+func (h *ImportHandler) sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
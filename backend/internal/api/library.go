@@ -1,15 +1,18 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"log"
 	"net/http"
 	"os"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/wpinrui/dovora2/backend/internal/db"
+	"github.com/wpinrui/dovora2/backend/internal/media"
 )
 
 type LibraryHandler struct {
@@ -21,36 +24,103 @@ func NewLibraryHandler(database *db.DB) *LibraryHandler {
 }
 
 type trackResponse struct {
-	ID              string `json:"id"`
-	YoutubeID       string `json:"youtube_id"`
-	Title           string `json:"title"`
-	Artist          string `json:"artist"`
-	DurationSeconds int    `json:"duration_seconds"`
-	ThumbnailURL    string `json:"thumbnail_url"`
-	FileSizeBytes   int64  `json:"file_size_bytes"`
-	CreatedAt       string `json:"created_at"`
+	ID              string   `json:"id"`
+	YoutubeID       string   `json:"youtube_id"`
+	Title           string   `json:"title"`
+	Artist          string   `json:"artist"`
+	Album           string   `json:"album,omitempty"`
+	ReleaseDate     string   `json:"release_date,omitempty"`
+	MBRecordingID   *string  `json:"mb_recording_id,omitempty"`
+	MBReleaseID     *string  `json:"mb_release_id,omitempty"`
+	MBArtistID      *string  `json:"mb_artist_id,omitempty"`
+	DurationSeconds int      `json:"duration_seconds"`
+	ThumbnailURL    string   `json:"thumbnail_url"`
+	FileSizeBytes   int64    `json:"file_size_bytes"`
+	PlayCount       int      `json:"play_count"`
+	Favorite        bool     `json:"favorite"`
+	FavoritedAt     *string  `json:"favorited_at,omitempty"`
+	Rating          int      `json:"rating"`
+	ArtistID        *string  `json:"artist_id,omitempty"`
+	AlbumID         *string  `json:"album_id,omitempty"`
+	Tags            []string `json:"tags,omitempty"`
+	CreatedAt       string   `json:"created_at"`
+}
+
+func newTrackResponse(track *db.Track) trackResponse {
+	return trackResponse{
+		ID:              track.ID,
+		YoutubeID:       track.YoutubeID,
+		Title:           track.Title,
+		Artist:          track.Artist,
+		Album:           track.Album,
+		ReleaseDate:     track.ReleaseDate,
+		MBRecordingID:   track.MBRecordingID,
+		MBReleaseID:     track.MBReleaseID,
+		MBArtistID:      track.MBArtistID,
+		DurationSeconds: track.DurationSeconds,
+		ThumbnailURL:    track.ThumbnailURL,
+		FileSizeBytes:   track.FileSizeBytes,
+		PlayCount:       track.PlayCount,
+		Favorite:        track.FavoritedAt != nil,
+		FavoritedAt:     formatOptionalTime(track.FavoritedAt),
+		Rating:          track.Rating,
+		ArtistID:        track.ArtistID,
+		AlbumID:         track.AlbumID,
+		Tags:            track.Tags,
+		CreatedAt:       track.CreatedAt.Format(timeFormatISO8601),
+	}
 }
 
 type libraryResponse struct {
-	Tracks []trackResponse `json:"tracks"`
+	Tracks     []trackResponse `json:"tracks"`
+	NextCursor string          `json:"next_cursor,omitempty"`
 }
 
 type videoResponse struct {
-	ID              string `json:"id"`
-	YoutubeID       string `json:"youtube_id"`
-	Title           string `json:"title"`
-	Channel         string `json:"channel"`
-	DurationSeconds int    `json:"duration_seconds"`
-	ThumbnailURL    string `json:"thumbnail_url"`
-	FileSizeBytes   int64  `json:"file_size_bytes"`
-	Quality         string `json:"quality"`
-	CreatedAt       string `json:"created_at"`
+	ID              string   `json:"id"`
+	YoutubeID       string   `json:"youtube_id"`
+	Title           string   `json:"title"`
+	Channel         string   `json:"channel"`
+	Description     string   `json:"description,omitempty"`
+	DurationSeconds int      `json:"duration_seconds"`
+	ThumbnailURL    string   `json:"thumbnail_url"`
+	FileSizeBytes   int64    `json:"file_size_bytes"`
+	Quality         string   `json:"quality"`
+	PlayCount       int      `json:"play_count"`
+	Favorite        bool     `json:"favorite"`
+	FavoritedAt     *string  `json:"favorited_at,omitempty"`
+	Rating          int      `json:"rating"`
+	Tags            []string `json:"tags,omitempty"`
+	CreatedAt       string   `json:"created_at"`
+}
+
+func newVideoResponse(video *db.Video) videoResponse {
+	return videoResponse{
+		ID:              video.ID,
+		YoutubeID:       video.YoutubeID,
+		Title:           video.Title,
+		Channel:         video.Channel,
+		Description:     video.Description,
+		DurationSeconds: video.DurationSeconds,
+		ThumbnailURL:    video.ThumbnailURL,
+		FileSizeBytes:   video.FileSizeBytes,
+		Quality:         video.Quality,
+		PlayCount:       video.PlayCount,
+		Favorite:        video.FavoritedAt != nil,
+		FavoritedAt:     formatOptionalTime(video.FavoritedAt),
+		Rating:          video.Rating,
+		Tags:            video.Tags,
+		CreatedAt:       video.CreatedAt.Format(timeFormatISO8601),
+	}
 }
 
 type videoLibraryResponse struct {
-	Videos []videoResponse `json:"videos"`
+	Videos     []videoResponse `json:"videos"`
+	NextCursor string          `json:"next_cursor,omitempty"`
 }
 
+// GetMusic handles GET /library/music. See parseLibraryQuery for paging,
+// sorting and filtering.
 func (h *LibraryHandler) GetMusic(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
@@ -63,8 +133,17 @@ func (h *LibraryHandler) GetMusic(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tracks, err := h.db.GetTracksByUserID(r.Context(), userID)
+	query, ok := parseLibraryQuery(w, r, false)
+	if !ok {
+		return
+	}
+
+	tracks, next, err := h.db.QueryTracks(r.Context(), userID, query)
 	if err != nil {
+		if errors.Is(err, db.ErrInvalidCursor) {
+			writeError(w, http.StatusBadRequest, "cursor does not match this sort order")
+			return
+		}
 		log.Printf("Failed to get tracks for user %s: %v", userID, err)
 		writeError(w, http.StatusInternalServerError, "failed to get library")
 		return
@@ -73,24 +152,20 @@ func (h *LibraryHandler) GetMusic(w http.ResponseWriter, r *http.Request) {
 	response := libraryResponse{
 		Tracks: make([]trackResponse, 0, len(tracks)),
 	}
+	if next != nil {
+		response.NextCursor = next.Encode()
+	}
 
-	for _, track := range tracks {
-		response.Tracks = append(response.Tracks, trackResponse{
-			ID:              track.ID,
-			YoutubeID:       track.YoutubeID,
-			Title:           track.Title,
-			Artist:          track.Artist,
-			DurationSeconds: track.DurationSeconds,
-			ThumbnailURL:    track.ThumbnailURL,
-			FileSizeBytes:   track.FileSizeBytes,
-			CreatedAt:       track.CreatedAt.Format("2006-01-02T15:04:05Z"),
-		})
+	for i := range tracks {
+		response.Tracks = append(response.Tracks, newTrackResponse(&tracks[i]))
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
+// GetVideos handles GET /library/videos, with the same query parameters as
+// GetMusic except not_in_playlist
 func (h *LibraryHandler) GetVideos(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
@@ -103,8 +178,17 @@ func (h *LibraryHandler) GetVideos(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	videos, err := h.db.GetVideosByUserID(r.Context(), userID)
+	query, ok := parseLibraryQuery(w, r, true)
+	if !ok {
+		return
+	}
+
+	videos, next, err := h.db.QueryVideos(r.Context(), userID, query)
 	if err != nil {
+		if errors.Is(err, db.ErrInvalidCursor) {
+			writeError(w, http.StatusBadRequest, "cursor does not match this sort order")
+			return
+		}
 		log.Printf("Failed to get videos for user %s: %v", userID, err)
 		writeError(w, http.StatusInternalServerError, "failed to get video library")
 		return
@@ -113,19 +197,12 @@ func (h *LibraryHandler) GetVideos(w http.ResponseWriter, r *http.Request) {
 	response := videoLibraryResponse{
 		Videos: make([]videoResponse, 0, len(videos)),
 	}
+	if next != nil {
+		response.NextCursor = next.Encode()
+	}
 
-	for _, video := range videos {
-		response.Videos = append(response.Videos, videoResponse{
-			ID:              video.ID,
-			YoutubeID:       video.YoutubeID,
-			Title:           video.Title,
-			Channel:         video.Channel,
-			DurationSeconds: video.DurationSeconds,
-			ThumbnailURL:    video.ThumbnailURL,
-			FileSizeBytes:   video.FileSizeBytes,
-			Quality:         video.Quality,
-			CreatedAt:       video.CreatedAt.Format("2006-01-02T15:04:05Z"),
-		})
+	for i := range videos {
+		response.Videos = append(response.Videos, newVideoResponse(&videos[i]))
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -196,21 +273,92 @@ func (h *LibraryHandler) UpdateTrack(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	response := trackResponse{
-		ID:              track.ID,
-		YoutubeID:       track.YoutubeID,
-		Title:           track.Title,
-		Artist:          track.Artist,
-		DurationSeconds: track.DurationSeconds,
-		ThumbnailURL:    track.ThumbnailURL,
-		FileSizeBytes:   track.FileSizeBytes,
-		CreatedAt:       track.CreatedAt.Format("2006-01-02T15:04:05Z"),
-	}
+	linkCatalog(r.Context(), h.db, track)
+
+	response := newTrackResponse(track)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
+// Limits on edited video metadata, matching the database columns
+const (
+	maxVideoTitleLength       = 500
+	maxVideoChannelLength     = 500
+	maxVideoDescriptionLength = 10000
+)
+
+type updateVideoRequest struct {
+	Title       *string `json:"title"`
+	Channel     *string `json:"channel"`
+	Description *string `json:"description"`
+}
+
+// UpdateVideo handles PATCH /videos/{id}, editing a video's title, channel
+// and description. Omitted fields are left unchanged; channel and
+// description may be set to "" to clear them.
+func (h *LibraryHandler) UpdateVideo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	var req updateVideoRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if req.Title == nil && req.Channel == nil && req.Description == nil {
+		writeError(w, http.StatusBadRequest, "title, channel or description is required")
+		return
+	}
+
+	edit := &db.VideoEdit{Channel: req.Channel, Description: req.Description}
+	if req.Title != nil {
+		title := strings.TrimSpace(*req.Title)
+		if title == "" {
+			writeError(w, http.StatusBadRequest, "title cannot be empty")
+			return
+		}
+		edit.Title = &title
+	}
+	if edit.Title != nil && utf8.RuneCountInString(*edit.Title) > maxVideoTitleLength {
+		writeError(w, http.StatusBadRequest, "title must be at most 500 characters")
+		return
+	}
+	if edit.Channel != nil && utf8.RuneCountInString(*edit.Channel) > maxVideoChannelLength {
+		writeError(w, http.StatusBadRequest, "channel must be at most 500 characters")
+		return
+	}
+	if edit.Description != nil && utf8.RuneCountInString(*edit.Description) > maxVideoDescriptionLength {
+		writeError(w, http.StatusBadRequest, "description must be at most 10000 characters")
+		return
+	}
+
+	video, err := h.db.UpdateVideo(r.Context(), r.PathValue("id"), userID, edit)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "video not found")
+			return
+		}
+		log.Printf("Failed to update video: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to update video")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newVideoResponse(video))
+}
+
+// DeleteItem handles DELETE /library/{id}, moving a track or video to the
+// trash
 func (h *LibraryHandler) DeleteItem(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
 		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
@@ -230,44 +378,36 @@ func (h *LibraryHandler) DeleteItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Try to delete as track first
-	filePath, err := h.db.DeleteTrack(r.Context(), id, userID)
-	if err == nil {
-		// Successfully deleted track, now delete file from disk
-		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
-			log.Printf("Failed to delete file %s: %v", filePath, err)
-		}
-
-		w.WriteHeader(http.StatusNoContent)
+	err := h.db.MoveToTrash(r.Context(), userID, id)
+	if errors.Is(err, db.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "item not found")
 		return
 	}
-
-	// If not found as track, check if it's a "not found" error
-	if !errors.Is(err, pgx.ErrNoRows) {
-		log.Printf("Failed to delete track: %v", err)
+	if err != nil {
+		log.Printf("Failed to move item to trash: %v", err)
 		writeError(w, http.StatusInternalServerError, "database error")
 		return
 	}
 
-	// Track not found - try to delete as video
-	filePath, err = h.db.DeleteVideo(r.Context(), id, userID)
-	if err == nil {
-		// Successfully deleted video, now delete file from disk
-		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
-			log.Printf("Failed to delete file %s: %v", filePath, err)
-		}
+	w.WriteHeader(http.StatusNoContent)
+}
 
-		w.WriteHeader(http.StatusNoContent)
+// removeUnreferencedFile deletes a media file and anything cached from it
+// unless another library item still uses it
+func removeUnreferencedFile(ctx context.Context, database *db.DB, processor *media.Processor, filePath string) {
+	referenced, err := database.IsFileReferenced(ctx, filePath)
+	if err != nil {
+		log.Printf("Failed to check references to %s: %v", filePath, err)
 		return
 	}
-
-	// If not found as video, check if it's a "not found" error
-	if !errors.Is(err, pgx.ErrNoRows) {
-		log.Printf("Failed to delete video: %v", err)
-		writeError(w, http.StatusInternalServerError, "database error")
+	if referenced {
 		return
 	}
 
-	// Item not found in tracks or videos
-	writeError(w, http.StatusNotFound, "item not found")
+	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to delete file %s: %v", filePath, err)
+	}
+	if err := processor.RemoveCached(filePath); err != nil {
+		log.Printf("Failed to delete cached data for %s: %v", filePath, err)
+	}
 }
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wpinrui/dovora2/backend/internal/db"
+)
+
+// defaultLibraryPageSize applies when a cursor is given without a limit
+const defaultLibraryPageSize = 100
+
+// parseLibraryQuery reads the paging, sorting and filtering parameters of
+// the library endpoints:
+//
+//	sort             added (default), title, artist, duration, plays or rating
+//	order            asc or desc; defaults to desc for added, plays and rating
+//	limit, cursor    page size and the next_cursor of the previous page
+//	artist           exact artist (channel for videos), ignoring case
+//	added_after      RFC 3339 time or YYYY-MM-DD date, inclusive
+//	added_before     RFC 3339 time or YYYY-MM-DD date, exclusive
+//	min_duration     seconds, inclusive
+//	max_duration     seconds, inclusive
+//	not_in_playlist  true to list only tracks in no playlist
+//	favorite         true for favorites only, false for the rest
+//	min_rating       1-5, inclusive
+//	tags             comma-separated tag names, ignoring case
+//	tag_match        any (default) or all of tags
+//
+// Without limit or cursor every match is returned, as before paging existed.
+// It writes an error response and returns false for invalid parameters.
+func parseLibraryQuery(w http.ResponseWriter, r *http.Request, video bool) (*db.LibraryQuery, bool) {
+	params := r.URL.Query()
+	query := &db.LibraryQuery{Sort: db.SortAdded}
+
+	if v := params.Get("sort"); v != "" {
+		if !db.ValidLibrarySort(v) {
+			writeError(w, http.StatusBadRequest, "sort must be 'added', 'title', 'artist', 'duration', 'plays' or 'rating'")
+			return nil, false
+		}
+		query.Sort = v
+	}
+
+	switch params.Get("order") {
+	case "":
+		query.Descending = query.Sort == db.SortAdded || query.Sort == db.SortPlays || query.Sort == db.SortRating
+	case "asc":
+	case "desc":
+		query.Descending = true
+	default:
+		writeError(w, http.StatusBadRequest, "order must be 'asc' or 'desc'")
+		return nil, false
+	}
+
+	if v := params.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > db.MaxLibraryPageSize {
+			writeError(w, http.StatusBadRequest, "limit must be between 1 and 500")
+			return nil, false
+		}
+		query.Limit = limit
+	}
+
+	if v := params.Get("cursor"); v != "" {
+		cursor, err := db.DecodeLibraryCursor(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid cursor")
+			return nil, false
+		}
+		query.Cursor = cursor
+		if query.Limit == 0 {
+			query.Limit = defaultLibraryPageSize
+		}
+	}
+
+	query.Artist = params.Get("artist")
+
+	for _, p := range []struct {
+		name string
+		dst  **time.Time
+	}{
+		{"added_after", &query.AddedAfter},
+		{"added_before", &query.AddedBefore},
+	} {
+		if v := params.Get(p.name); v != "" {
+			t, ok := parseQueryTime(v)
+			if !ok {
+				writeError(w, http.StatusBadRequest, p.name+" must be an RFC 3339 time or YYYY-MM-DD date")
+				return nil, false
+			}
+			*p.dst = &t
+		}
+	}
+
+	for _, p := range []struct {
+		name string
+		dst  **int
+	}{
+		{"min_duration", &query.MinDuration},
+		{"max_duration", &query.MaxDuration},
+	} {
+		if v := params.Get(p.name); v != "" {
+			seconds, err := strconv.Atoi(v)
+			if err != nil || seconds < 0 {
+				writeError(w, http.StatusBadRequest, p.name+" must be a non-negative number of seconds")
+				return nil, false
+			}
+			*p.dst = &seconds
+		}
+	}
+
+	if v := params.Get("not_in_playlist"); v != "" {
+		notInPlaylist, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "not_in_playlist must be true or false")
+			return nil, false
+		}
+		if notInPlaylist && video {
+			writeError(w, http.StatusBadRequest, "not_in_playlist only applies to music")
+			return nil, false
+		}
+		query.NotInPlaylist = notInPlaylist
+	}
+
+	if v := params.Get("favorite"); v != "" {
+		favorite, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "favorite must be true or false")
+			return nil, false
+		}
+		query.Favorite = &favorite
+	}
+
+	if v := params.Get("min_rating"); v != "" {
+		rating, err := strconv.Atoi(v)
+		if err != nil || rating < 1 || rating > maxRating {
+			writeError(w, http.StatusBadRequest, "min_rating must be between 1 and 5")
+			return nil, false
+		}
+		query.MinRating = &rating
+	}
+
+	if v := params.Get("tags"); v != "" {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				query.Tags = append(query.Tags, name)
+			}
+		}
+	}
+
+	switch params.Get("tag_match") {
+	case "", "any":
+	case "all":
+		query.MatchAllTags = true
+	default:
+		writeError(w, http.StatusBadRequest, "tag_match must be 'any' or 'all'")
+		return nil, false
+	}
+
+	return query, true
+}
+
+// parseQueryTime reads an RFC 3339 time or a YYYY-MM-DD date (midnight UTC)
+func parseQueryTime(v string) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, true
+	}
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
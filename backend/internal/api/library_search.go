@@ -0,0 +1,115 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultLibrarySearchLimit = 50
+	maxLibrarySearchLimit     = 200
+
+	// maxLibrarySearchLength bounds the ?q= parameter
+	maxLibrarySearchLength = 200
+)
+
+type librarySearchResult struct {
+	Type  string         `json:"type"` // "audio" or "video"
+	Score float64        `json:"score"`
+	Track *trackResponse `json:"track,omitempty"`
+	Video *videoResponse `json:"video,omitempty"`
+}
+
+type librarySearchResponse struct {
+	Results []librarySearchResult `json:"results"`
+}
+
+// Search handles GET /library/search?q=&type=audio|video&limit=, searching
+// titles, artists, channels, albums and descriptions of the user's tracks and
+// videos. Words match as prefixes and near misses match by trigram
+// similarity; results from both kinds are ranked together.
+func (h *LibraryHandler) Search(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	params := r.URL.Query()
+	text := strings.TrimSpace(params.Get("q"))
+	if text == "" {
+		writeError(w, http.StatusBadRequest, "q is required")
+		return
+	}
+	if len(text) > maxLibrarySearchLength {
+		writeError(w, http.StatusBadRequest, "q must be at most 200 characters")
+		return
+	}
+
+	mediaType := params.Get("type")
+	if mediaType != "" && mediaType != "audio" && mediaType != "video" {
+		writeError(w, http.StatusBadRequest, "type must be 'audio' or 'video'")
+		return
+	}
+
+	limit := defaultLibrarySearchLimit
+	if v := params.Get("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 1 || parsed > maxLibrarySearchLimit {
+			writeError(w, http.StatusBadRequest, "limit must be between 1 and 200")
+			return
+		}
+		limit = parsed
+	}
+
+	var results []librarySearchResult
+
+	if mediaType != "video" {
+		tracks, err := h.db.SearchTracks(r.Context(), userID, text, limit)
+		if err != nil {
+			log.Printf("Failed to search tracks: %v", err)
+			writeError(w, http.StatusInternalServerError, "search failed")
+			return
+		}
+		for i := range tracks {
+			track := newTrackResponse(&tracks[i].Track)
+			results = append(results, librarySearchResult{Type: "audio", Score: tracks[i].Score, Track: &track})
+		}
+	}
+
+	if mediaType != "audio" {
+		videos, err := h.db.SearchVideos(r.Context(), userID, text, limit)
+		if err != nil {
+			log.Printf("Failed to search videos: %v", err)
+			writeError(w, http.StatusInternalServerError, "search failed")
+			return
+		}
+		for i := range videos {
+			video := newVideoResponse(&videos[i].Video)
+			results = append(results, librarySearchResult{Type: "video", Score: videos[i].Score, Video: &video})
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	if results == nil {
+		results = []librarySearchResult{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(librarySearchResponse{Results: results})
+}
@@ -0,0 +1,215 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wpinrui/dovora2/backend/internal/db"
+	"github.com/wpinrui/dovora2/backend/internal/musicbrainz"
+	"github.com/wpinrui/dovora2/backend/internal/textmatch"
+)
+
+// matchSearchLimit is how many recordings are requested from MusicBrainz per lookup
+const matchSearchLimit = 10
+
+type MetadataHandler struct {
+	db     *db.DB
+	client *musicbrainz.Client
+}
+
+func NewMetadataHandler(database *db.DB, client *musicbrainz.Client) *MetadataHandler {
+	return &MetadataHandler{db: database, client: client}
+}
+
+type matchCandidateResponse struct {
+	RecordingID     string  `json:"recording_id"`
+	Title           string  `json:"title"`
+	Artist          string  `json:"artist"`
+	ArtistID        string  `json:"artist_id,omitempty"`
+	Album           string  `json:"album,omitempty"`
+	ReleaseID       string  `json:"release_id,omitempty"`
+	ReleaseDate     string  `json:"release_date,omitempty"`
+	DurationSeconds int     `json:"duration_seconds"`
+	Score           float64 `json:"score"`
+}
+
+type matchesResponse struct {
+	Candidates []matchCandidateResponse `json:"candidates"`
+}
+
+type acceptMatchRequest struct {
+	RecordingID string `json:"recording_id"`
+	ReleaseID   string `json:"release_id"`
+}
+
+// HandleMatches routes requests to /tracks/{id}/matches
+func (h *MetadataHandler) HandleMatches(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.listMatches(w, r)
+	case http.MethodPost:
+		h.acceptMatch(w, r)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// listMatches searches MusicBrainz for recordings matching the track and returns them scored
+func (h *MetadataHandler) listMatches(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	track := h.getTrack(w, r, r.PathValue("id"), userID)
+	if track == nil {
+		return
+	}
+
+	// Search on the bare song title; YouTube titles often carry the artist and upload noise
+	title := textmatch.Normalize(textmatch.StripArtistPrefix(track.Title, track.Artist))
+	recordings, err := h.client.SearchRecordings(r.Context(), title, track.Artist, matchSearchLimit)
+	if err == nil && len(recordings) == 0 && track.Artist != "" {
+		// Channel names like "QueenVEVO" rarely match an artist, so retry on title alone
+		recordings, err = h.client.SearchRecordings(r.Context(), title, "", matchSearchLimit)
+	}
+	if err != nil {
+		log.Printf("MusicBrainz search failed for track %s: %v", track.ID, err)
+		writeError(w, http.StatusBadGateway, "metadata service unavailable")
+		return
+	}
+
+	candidates := musicbrainz.Rank(recordings, track.Title, track.Artist, track.DurationSeconds)
+
+	response := matchesResponse{
+		Candidates: make([]matchCandidateResponse, 0, len(candidates)),
+	}
+	for _, c := range candidates {
+		candidate := matchCandidateResponse{
+			RecordingID:     c.Recording.ID,
+			Title:           c.Recording.Title,
+			Artist:          c.Recording.ArtistName(),
+			ArtistID:        c.Recording.PrimaryArtistID(),
+			DurationSeconds: c.Recording.DurationSeconds(),
+			Score:           c.Score,
+		}
+		if c.Release != nil {
+			candidate.Album = c.Release.Title
+			candidate.ReleaseID = c.Release.ID
+			candidate.ReleaseDate = c.Release.Date
+		}
+		response.Candidates = append(response.Candidates, candidate)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// acceptMatch fills in the track's canonical metadata from a chosen recording
+func (h *MetadataHandler) acceptMatch(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	var req acceptMatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if req.RecordingID == "" {
+		writeError(w, http.StatusBadRequest, "recording_id is required")
+		return
+	}
+	if !db.IsUUID(req.RecordingID) || (req.ReleaseID != "" && !db.IsUUID(req.ReleaseID)) {
+		writeError(w, http.StatusBadRequest, "recording_id and release_id must be MusicBrainz IDs")
+		return
+	}
+
+	track := h.getTrack(w, r, r.PathValue("id"), userID)
+	if track == nil {
+		return
+	}
+
+	recording, err := h.client.GetRecording(r.Context(), req.RecordingID)
+	if err != nil {
+		if errors.Is(err, musicbrainz.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "recording not found")
+			return
+		}
+		log.Printf("MusicBrainz lookup failed for recording %s: %v", req.RecordingID, err)
+		writeError(w, http.StatusBadGateway, "metadata service unavailable")
+		return
+	}
+
+	release := musicbrainz.BestRelease(recording.Releases)
+	if req.ReleaseID != "" {
+		release = nil
+		for i := range recording.Releases {
+			if recording.Releases[i].ID == req.ReleaseID {
+				release = &recording.Releases[i]
+				break
+			}
+		}
+		if release == nil {
+			writeError(w, http.StatusBadRequest, "release_id does not belong to recording")
+			return
+		}
+	}
+
+	// The IDs are stored in UUID columns, so a server answering with anything
+	// else is at fault
+	artistID := recording.PrimaryArtistID()
+	if !db.IsUUID(recording.ID) || (artistID != "" && !db.IsUUID(artistID)) || (release != nil && !db.IsUUID(release.ID)) {
+		log.Printf("MusicBrainz returned an invalid ID for recording %s", req.RecordingID)
+		writeError(w, http.StatusBadGateway, "metadata service returned an invalid response")
+		return
+	}
+
+	meta := &db.TrackMetadata{
+		Title:         recording.Title,
+		Artist:        recording.ArtistName(),
+		MBRecordingID: &recording.ID,
+	}
+	if artistID != "" {
+		meta.MBArtistID = &artistID
+	}
+	if release != nil {
+		meta.Album = release.Title
+		meta.ReleaseDate = release.Date
+		meta.MBReleaseID = &release.ID
+	}
+
+	updated, err := h.db.ApplyTrackMetadata(r.Context(), track.ID, userID, meta)
+	if err != nil {
+		log.Printf("Failed to apply metadata to track %s: %v", track.ID, err)
+		writeError(w, http.StatusInternalServerError, "failed to update track")
+		return
+	}
+
+	linkCatalog(r.Context(), h.db, updated)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newTrackResponse(updated))
+}
+
+// getTrack loads a track owned by the user, or writes an error response and returns nil
+func (h *MetadataHandler) getTrack(w http.ResponseWriter, r *http.Request, trackID, userID string) *db.Track {
+	track, err := h.db.GetTrackByID(r.Context(), trackID, userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "track not found")
+			return nil
+		}
+		log.Printf("Failed to get track: %v", err)
+		writeError(w, http.StatusInternalServerError, "database error")
+		return nil
+	}
+	return track
+}
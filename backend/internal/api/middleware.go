@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/wpinrui/dovora2/backend/internal/auth"
 	"github.com/wpinrui/dovora2/backend/internal/db"
@@ -16,10 +17,12 @@ const UserIDKey contextKey = "userID"
 type Middleware struct {
 	jwtSecret string
 	db        *db.DB
+	urlSigner *auth.URLSigner
+	proxies   TrustedProxies
 }
 
-func NewMiddleware(jwtSecret string, database *db.DB) *Middleware {
-	return &Middleware{jwtSecret: jwtSecret, db: database}
+func NewMiddleware(jwtSecret string, database *db.DB, urlSigner *auth.URLSigner, proxies TrustedProxies) *Middleware {
+	return &Middleware{jwtSecret: jwtSecret, db: database, urlSigner: urlSigner, proxies: proxies}
 }
 
 func (m *Middleware) RequireAuth(next http.HandlerFunc) http.HandlerFunc {
@@ -48,6 +51,31 @@ func (m *Middleware) RequireAuth(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// RequireFileAuth accepts a signed URL for the requested file in place of a
+// bearer token, for players that can't send an Authorization header
+func (m *Middleware) RequireFileAuth(next http.HandlerFunc) http.HandlerFunc {
+	requireAuth := m.RequireAuth(next)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if !query.Has(auth.SignedURLSignatureParam) {
+			requireAuth(w, r)
+			return
+		}
+
+		// Extract ID from URL path: /files/{id}
+		fileID := strings.TrimPrefix(r.URL.Path, "/files/")
+		userID, err := m.urlSigner.Verify(fileID, query, m.proxies.ClientIP(r), time.Now())
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, "invalid or expired signed url")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), UserIDKey, userID)
+		next(w, r.WithContext(ctx))
+	}
+}
+
 func GetUserID(ctx context.Context) (string, bool) {
 	userID, ok := ctx.Value(UserIDKey).(string)
 	return userID, ok
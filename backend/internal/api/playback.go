@@ -0,0 +1,159 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/wpinrui/dovora2/backend/internal/playback"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// playbackReadTimeout is how long a device can stay silent before it's
+	// disconnected. Devices ping more often than this when otherwise idle.
+	playbackReadTimeout = 90 * time.Second
+
+	playbackWriteTimeout = 10 * time.Second
+
+	// maxPlaybackMessageBytes bounds a message from a device, enough for a
+	// full queue
+	maxPlaybackMessageBytes = 1 << 20
+
+	maxDeviceIDLength   = 64
+	maxDeviceNameLength = 100
+)
+
+// Kinds of message a device sends
+const (
+	playbackMessageState   = "state"
+	playbackMessageCommand = "command"
+	playbackMessagePing    = "ping"
+)
+
+// PlaybackHandler connects devices to the playback hub over WebSocket
+type PlaybackHandler struct {
+	hub *playback.Hub
+}
+
+func NewPlaybackHandler(hub *playback.Hub) *PlaybackHandler {
+	return &PlaybackHandler{hub: hub}
+}
+
+// playbackMessage is a message from a device: its own playback state, a
+// command for the device Target, or a ping
+type playbackMessage struct {
+	Type    string            `json:"type"`
+	State   *playback.State   `json:"state"`
+	Target  string            `json:"target"`
+	Command *playback.Command `json:"command"`
+}
+
+// Connect handles GET /playback/connect?device_id=&name=, upgrading to a
+// WebSocket over which the device reports its playback state and sends and
+// receives remote commands
+func (h *PlaybackHandler) Connect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	deviceID := r.URL.Query().Get("device_id")
+	if deviceID == "" || len(deviceID) > maxDeviceIDLength {
+		writeError(w, http.StatusBadRequest, "device_id is required and must be at most 64 characters")
+		return
+	}
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = deviceID
+	}
+	if utf8.RuneCountInString(name) > maxDeviceNameLength {
+		writeError(w, http.StatusBadRequest, "name must be at most 100 characters")
+		return
+	}
+
+	// Devices authenticate with a bearer token rather than cookies, so
+	// there's no cross-site risk for an Origin check to guard against
+	server := websocket.Server{Handler: func(ws *websocket.Conn) {
+		h.serve(ws, userID, deviceID, name)
+	}}
+	server.ServeHTTP(w, r)
+}
+
+func (h *PlaybackHandler) serve(ws *websocket.Conn, userID, deviceID, name string) {
+	ws.MaxPayloadBytes = maxPlaybackMessageBytes
+
+	device := h.hub.Connect(userID, deviceID, name)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer ws.Close()
+
+		for event := range device.Events() {
+			ws.SetWriteDeadline(time.Now().Add(playbackWriteTimeout))
+			if err := websocket.JSON.Send(ws, event); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		ws.SetReadDeadline(time.Now().Add(playbackReadTimeout))
+
+		var data []byte
+		if err := websocket.Message.Receive(ws, &data); err != nil {
+			if errors.Is(err, websocket.ErrFrameTooLarge) {
+				device.Send(playback.Event{Type: playback.EventError, Error: "message too large"})
+			}
+			break
+		}
+
+		var msg playbackMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			device.Send(playback.Event{Type: playback.EventError, Error: "invalid message"})
+			continue
+		}
+		if err := h.handleMessage(userID, device, &msg); err != nil {
+			device.Send(playback.Event{Type: playback.EventError, Error: err.Error()})
+		}
+	}
+
+	h.hub.Disconnect(userID, device)
+	<-done
+}
+
+func (h *PlaybackHandler) handleMessage(userID string, device *playback.Device, msg *playbackMessage) error {
+	switch msg.Type {
+	case playbackMessageState:
+		if msg.State == nil {
+			return errors.New("state message needs a state")
+		}
+		return h.hub.UpdateState(userID, device, *msg.State)
+
+	case playbackMessageCommand:
+		if msg.Command == nil || msg.Target == "" {
+			return errors.New("command message needs a target and command")
+		}
+		err := h.hub.SendCommand(userID, device, msg.Target, *msg.Command)
+		if errors.Is(err, playback.ErrDeviceNotConnected) {
+			return errors.New("target device not connected")
+		}
+		return err
+
+	case playbackMessagePing:
+		device.Send(playback.Event{Type: playback.EventPong})
+		return nil
+
+	default:
+		return errors.New("unknown message type")
+	}
+}
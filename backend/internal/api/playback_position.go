@@ -0,0 +1,234 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/wpinrui/dovora2/backend/internal/db"
+)
+
+const (
+	defaultContinueLimit = 20
+	maxContinueLimit     = 100
+)
+
+// PositionHandler keeps where users are in their tracks and videos so
+// playback can resume on another device
+type PositionHandler struct {
+	db *db.DB
+}
+
+func NewPositionHandler(database *db.DB) *PositionHandler {
+	return &PositionHandler{db: database}
+}
+
+type savePositionRequest struct {
+	PositionSeconds *int `json:"position_seconds"`
+	Finished        bool `json:"finished"`
+	// UpdatedAt is when the device reached the position; defaults to now
+	UpdatedAt string `json:"updated_at"`
+}
+
+type positionResponse struct {
+	ItemID          string `json:"item_id"`
+	Type            string `json:"type"` // "audio" or "video"
+	PositionSeconds int    `json:"position_seconds"`
+	DurationSeconds int    `json:"duration_seconds"`
+	Finished        bool   `json:"finished"`
+	UpdatedAt       string `json:"updated_at"`
+	ModifiedAt      string `json:"modified_at"` // when the server stored it, for ?since=
+}
+
+type savePositionResponse struct {
+	positionResponse
+	// Applied is false when a later position from another device was kept
+	Applied bool `json:"applied"`
+}
+
+type resumeItemResponse struct {
+	Type     string           `json:"type"` // "audio" or "video"
+	Track    *trackResponse   `json:"track,omitempty"`
+	Video    *videoResponse   `json:"video,omitempty"`
+	Position positionResponse `json:"position"`
+}
+
+func newPositionResponse(pos *db.PlaybackPosition) positionResponse {
+	itemType := "audio"
+	if pos.Kind == db.SyncVideo {
+		itemType = "video"
+	}
+	return positionResponse{
+		ItemID:          pos.ItemID,
+		Type:            itemType,
+		PositionSeconds: pos.PositionSeconds,
+		DurationSeconds: pos.DurationSeconds,
+		Finished:        pos.Finished,
+		UpdatedAt:       pos.UpdatedAt.Format(timeFormatISO8601),
+		ModifiedAt:      pos.ModifiedAt.Format(timeFormatISO8601),
+	}
+}
+
+// ListPositions handles GET /positions?since=, returning the user's
+// positions stored after since, or all of them, most recently stored first.
+// Clients pass the latest modified_at they've seen.
+func (h *PositionHandler) ListPositions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	var since *time.Time
+	if v := r.URL.Query().Get("since"); v != "" {
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "since must be an RFC 3339 time")
+			return
+		}
+		since = &parsed
+	}
+
+	positions, err := h.db.ListPlaybackPositions(r.Context(), userID, since)
+	if err != nil {
+		log.Printf("Failed to list playback positions: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to get positions")
+		return
+	}
+
+	response := make([]positionResponse, 0, len(positions))
+	for i := range positions {
+		response = append(response, newPositionResponse(&positions[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// HandlePosition handles GET and PUT /positions/{id} for a track or video.
+// A PUT whose updated_at is older than the stored position's is ignored,
+// and the stored position is returned with applied false.
+func (h *PositionHandler) HandlePosition(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		pos, err := h.db.GetPlaybackPosition(r.Context(), userID, r.PathValue("id"))
+		if errors.Is(err, db.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "no position for item")
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to get playback position: %v", err)
+			writeError(w, http.StatusInternalServerError, "failed to get position")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newPositionResponse(pos))
+
+	case http.MethodPut:
+		h.savePosition(w, r, userID)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (h *PositionHandler) savePosition(w http.ResponseWriter, r *http.Request, userID string) {
+	var req savePositionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if req.PositionSeconds == nil || *req.PositionSeconds < 0 || *req.PositionSeconds > maxPlayDuration {
+		writeError(w, http.StatusBadRequest, "position_seconds must be between 0 and 86400")
+		return
+	}
+
+	updatedAt := time.Now()
+	if req.UpdatedAt != "" {
+		parsed, err := time.Parse(time.RFC3339, req.UpdatedAt)
+		if err != nil || parsed.After(updatedAt.Add(playClockSkew)) {
+			writeError(w, http.StatusBadRequest, "updated_at must be a past RFC 3339 time")
+			return
+		}
+		updatedAt = parsed
+	}
+
+	pos, applied, err := h.db.SavePlaybackPosition(r.Context(), userID, r.PathValue("id"), *req.PositionSeconds, req.Finished, updatedAt)
+	if errors.Is(err, db.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "item not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to save playback position: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to save position")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(savePositionResponse{positionResponse: newPositionResponse(pos), Applied: applied})
+}
+
+// ContinueWatching handles GET /continue-watching?limit=, listing videos
+// and long tracks the user is partway through, most recently played first
+func (h *PositionHandler) ContinueWatching(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	limit := defaultContinueLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 1 || parsed > maxContinueLimit {
+			writeError(w, http.StatusBadRequest, "limit must be between 1 and 100")
+			return
+		}
+		limit = parsed
+	}
+
+	items, err := h.db.GetContinueWatching(r.Context(), userID, limit)
+	if err != nil {
+		log.Printf("Failed to get continue watching: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to get continue watching")
+		return
+	}
+
+	response := make([]resumeItemResponse, 0, len(items))
+	for i := range items {
+		item := &items[i]
+		entry := resumeItemResponse{Position: newPositionResponse(&item.Position)}
+		if item.Track != nil {
+			track := newTrackResponse(item.Track)
+			entry.Type, entry.Track = "audio", &track
+		} else {
+			video := newVideoResponse(item.Video)
+			entry.Type, entry.Video = "video", &video
+		}
+		response = append(response, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -23,18 +23,19 @@ func NewPlaylistHandler(database *db.DB) *PlaylistHandler {
 }
 
 type playlistResponse struct {
-	ID        string `json:"id"`
-	Name      string `json:"name"`
-	CreatedAt string `json:"created_at"`
-	UpdatedAt string `json:"updated_at"`
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Virtual  bool   `json:"virtual,omitempty"`
+	ReadOnly bool   `json:"read_only,omitempty"`
+	// Rules are set on smart playlists
+	Rules     *db.SmartRules `json:"rules,omitempty"`
+	CreatedAt string         `json:"created_at"`
+	UpdatedAt string         `json:"updated_at"`
 }
 
 type playlistWithTracksResponse struct {
-	ID        string          `json:"id"`
-	Name      string          `json:"name"`
-	CreatedAt string          `json:"created_at"`
-	UpdatedAt string          `json:"updated_at"`
-	Tracks    []trackResponse `json:"tracks"`
+	playlistResponse
+	Tracks []trackResponse `json:"tracks"`
 }
 
 type playlistsResponse struct {
@@ -43,10 +44,13 @@ type playlistsResponse struct {
 
 type createPlaylistRequest struct {
 	Name string `json:"name"`
+	// Rules makes a smart playlist
+	Rules *db.SmartRules `json:"rules"`
 }
 
 type updatePlaylistRequest struct {
-	Name string `json:"name"`
+	Name  string         `json:"name"`
+	Rules *db.SmartRules `json:"rules"`
 }
 
 type addTrackRequest struct {
@@ -57,8 +61,21 @@ type reorderTracksRequest struct {
 	TrackIDs []string `json:"track_ids"`
 }
 
-// verifyPlaylistOwnership checks that a playlist exists and belongs to the user.
-// Returns the playlist if found, or writes an error response and returns nil.
+func newPlaylistResponse(p *db.Playlist) playlistResponse {
+	return playlistResponse{
+		ID:        p.ID,
+		Name:      p.Name,
+		Virtual:   p.Virtual,
+		ReadOnly:  p.ReadOnly(),
+		Rules:     p.Rules,
+		CreatedAt: p.CreatedAt.Format(timeFormatISO8601),
+		UpdatedAt: p.UpdatedAt.Format(timeFormatISO8601),
+	}
+}
+
+// verifyPlaylistOwnership checks that a playlist exists, belongs to the user
+// and has tracks that can be edited. Returns the playlist if so, or writes
+// an error response and returns nil.
 func (h *PlaylistHandler) verifyPlaylistOwnership(ctx context.Context, w http.ResponseWriter, playlistID, userID string) *db.Playlist {
 	playlist, err := h.db.GetPlaylistByID(ctx, playlistID, userID)
 	if err != nil {
@@ -70,6 +87,10 @@ func (h *PlaylistHandler) verifyPlaylistOwnership(ctx context.Context, w http.Re
 		writeError(w, http.StatusInternalServerError, "failed to verify playlist")
 		return nil
 	}
+	if playlist.ReadOnly() {
+		writeError(w, http.StatusMethodNotAllowed, "smart playlists are read-only; change their rules instead")
+		return nil
+	}
 	return playlist
 }
 
@@ -100,17 +121,21 @@ func (h *PlaylistHandler) list(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The favorites playlist is listed first
+	favorites, err := h.db.GetFavoritesPlaylist(r.Context(), userID)
+	if err != nil {
+		log.Printf("Failed to get favorites playlist for user %s: %v", userID, err)
+		writeError(w, http.StatusInternalServerError, "failed to get playlists")
+		return
+	}
+	playlists = append([]db.Playlist{*favorites}, playlists...)
+
 	response := playlistsResponse{
 		Playlists: make([]playlistResponse, 0, len(playlists)),
 	}
 
-	for _, p := range playlists {
-		response.Playlists = append(response.Playlists, playlistResponse{
-			ID:        p.ID,
-			Name:      p.Name,
-			CreatedAt: p.CreatedAt.Format(timeFormatISO8601),
-			UpdatedAt: p.UpdatedAt.Format(timeFormatISO8601),
-		})
+	for i := range playlists {
+		response.Playlists = append(response.Playlists, newPlaylistResponse(&playlists[i]))
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -136,23 +161,26 @@ func (h *PlaylistHandler) create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	playlist, err := h.db.CreatePlaylist(r.Context(), userID, req.Name)
+	var playlist *db.Playlist
+	var err error
+	if req.Rules != nil {
+		if err := req.Rules.Validate(); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		playlist, err = h.db.CreateSmartPlaylist(r.Context(), userID, req.Name, req.Rules)
+	} else {
+		playlist, err = h.db.CreatePlaylist(r.Context(), userID, req.Name)
+	}
 	if err != nil {
 		log.Printf("Failed to create playlist: %v", err)
 		writeError(w, http.StatusInternalServerError, "failed to create playlist")
 		return
 	}
 
-	response := playlistResponse{
-		ID:        playlist.ID,
-		Name:      playlist.Name,
-		CreatedAt: playlist.CreatedAt.Format(timeFormatISO8601),
-		UpdatedAt: playlist.UpdatedAt.Format(timeFormatISO8601),
-	}
-
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(newPlaylistResponse(playlist))
 }
 
 // Get returns a single playlist with its tracks
@@ -182,25 +210,13 @@ func (h *PlaylistHandler) Get(w http.ResponseWriter, r *http.Request) {
 	}
 
 	tracks := make([]trackResponse, 0, len(playlist.Tracks))
-	for _, track := range playlist.Tracks {
-		tracks = append(tracks, trackResponse{
-			ID:              track.ID,
-			YoutubeID:       track.YoutubeID,
-			Title:           track.Title,
-			Artist:          track.Artist,
-			DurationSeconds: track.DurationSeconds,
-			ThumbnailURL:    track.ThumbnailURL,
-			FileSizeBytes:   track.FileSizeBytes,
-			CreatedAt:       track.CreatedAt.Format(timeFormatISO8601),
-		})
+	for i := range playlist.Tracks {
+		tracks = append(tracks, newTrackResponse(&playlist.Tracks[i]))
 	}
 
 	response := playlistWithTracksResponse{
-		ID:        playlist.ID,
-		Name:      playlist.Name,
-		CreatedAt: playlist.CreatedAt.Format(timeFormatISO8601),
-		UpdatedAt: playlist.UpdatedAt.Format(timeFormatISO8601),
-		Tracks:    tracks,
+		playlistResponse: newPlaylistResponse(&playlist.Playlist),
+		Tracks:           tracks,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -233,7 +249,24 @@ func (h *PlaylistHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	playlist, err := h.db.UpdatePlaylist(r.Context(), id, userID, req.Name)
+	if req.Rules != nil {
+		if err := req.Rules.Validate(); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		existing, err := h.db.GetPlaylistByID(r.Context(), id, userID)
+		if err == nil && existing.Rules == nil {
+			writeError(w, http.StatusBadRequest, "rules can only be changed on smart playlists")
+			return
+		}
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			log.Printf("Failed to get playlist: %v", err)
+			writeError(w, http.StatusInternalServerError, "failed to update playlist")
+			return
+		}
+	}
+
+	playlist, err := h.db.UpdatePlaylist(r.Context(), id, userID, req.Name, req.Rules)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			writeError(w, http.StatusNotFound, "playlist not found")
@@ -244,15 +277,8 @@ func (h *PlaylistHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	response := playlistResponse{
-		ID:        playlist.ID,
-		Name:      playlist.Name,
-		CreatedAt: playlist.CreatedAt.Format(timeFormatISO8601),
-		UpdatedAt: playlist.UpdatedAt.Format(timeFormatISO8601),
-	}
-
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(newPlaylistResponse(playlist))
 }
 
 // Delete deletes a playlist
@@ -420,6 +446,13 @@ func (h *PlaylistHandler) ReorderTracks(w http.ResponseWriter, r *http.Request)
 func (h *PlaylistHandler) HandlePlaylist(w http.ResponseWriter, r *http.Request) {
 	// Check if this is a /playlists/{id}/tracks path
 	path := strings.TrimPrefix(r.URL.Path, "/playlists/")
+
+	// The favorites playlist follows the favorite flag on tracks
+	if id, _, _ := strings.Cut(path, "/"); id == db.FavoritesPlaylistID && (r.Method != http.MethodGet || path != id) {
+		writeError(w, http.StatusMethodNotAllowed, "the favorites playlist is read-only; use /tracks/{id}/favorite")
+		return
+	}
+
 	if strings.Contains(path, "/tracks") {
 		h.handlePlaylistTracks(w, r)
 		return
@@ -0,0 +1,310 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wpinrui/dovora2/backend/internal/db"
+	"github.com/wpinrui/dovora2/backend/internal/playlistfmt"
+	"github.com/wpinrui/dovora2/backend/internal/textmatch"
+)
+
+const (
+	// maxPlaylistImportBytes bounds uploaded playlist files
+	maxPlaylistImportBytes = 5 << 20
+
+	// playlistMatchThreshold is the score at which an entry without a YouTube
+	// link is matched to a library track by title, artist and duration
+	playlistMatchThreshold = 0.85
+
+	// maxPlaylistNameLength matches the playlists.name column
+	maxPlaylistNameLength = 500
+
+	defaultImportedPlaylistName = "Imported playlist"
+)
+
+// Import entry statuses
+const (
+	importEntryMatched   = "matched"
+	importEntryQueued    = "queued"
+	importEntryUnmatched = "unmatched"
+)
+
+type PlaylistTransferHandler struct {
+	db        *db.DB
+	downloads *DownloadHandler
+}
+
+func NewPlaylistTransferHandler(database *db.DB, downloads *DownloadHandler) *PlaylistTransferHandler {
+	return &PlaylistTransferHandler{db: database, downloads: downloads}
+}
+
+type importEntryResponse struct {
+	Index           int      `json:"index"`
+	Title           string   `json:"title"`
+	Artist          string   `json:"artist,omitempty"`
+	DurationSeconds int      `json:"duration_seconds,omitempty"`
+	YoutubeID       string   `json:"youtube_id,omitempty"`
+	Status          string   `json:"status"`
+	MatchedBy       string   `json:"matched_by,omitempty"` // "youtube_id" or "metadata"
+	Score           *float64 `json:"score,omitempty"`
+	TrackID         string   `json:"track_id,omitempty"`
+	JobID           string   `json:"job_id,omitempty"`
+}
+
+type importPlaylistResponse struct {
+	Playlist  playlistResponse      `json:"playlist"`
+	Matched   int                   `json:"matched"`
+	Queued    int                   `json:"queued"`
+	Unmatched int                   `json:"unmatched"`
+	Entries   []importEntryResponse `json:"entries"`
+}
+
+// Export handles GET /playlists/{id}/export?format=m3u8|xspf|jspf. Entries
+// point at each track's YouTube URL so the playlist stays usable elsewhere.
+func (h *PlaylistTransferHandler) Export(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = playlistfmt.FormatM3U8
+	}
+
+	playlist, err := h.db.GetPlaylistWithTracks(r.Context(), r.PathValue("id"), userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "playlist not found")
+			return
+		}
+		log.Printf("Failed to get playlist: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to get playlist")
+		return
+	}
+
+	out := &playlistfmt.Playlist{
+		Title:   playlist.Name,
+		Entries: make([]playlistfmt.Entry, 0, len(playlist.Tracks)),
+	}
+	for _, track := range playlist.Tracks {
+		out.Entries = append(out.Entries, playlistfmt.Entry{
+			Title:           track.Title,
+			Artist:          track.Artist,
+			Album:           track.Album,
+			DurationSeconds: track.DurationSeconds,
+			Locations:       []string{playlistfmt.YouTubeURL(track.YoutubeID)},
+		})
+	}
+
+	data, err := playlistfmt.Encode(format, out)
+	if err != nil {
+		if errors.Is(err, playlistfmt.ErrUnsupportedFormat) {
+			writeError(w, http.StatusBadRequest, "format must be 'm3u8', 'xspf' or 'jspf'")
+			return
+		}
+		log.Printf("Failed to encode playlist: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to export playlist")
+		return
+	}
+
+	filename := sanitizeFilename(playlist.Name + "." + format)
+	w.Header().Set("Content-Type", playlistfmt.ContentType(format))
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+"\"")
+	w.Write(data)
+}
+
+// Import handles POST /playlists/import?format=&name=&download_missing=true.
+// The request body is the playlist file; its format is detected when not
+// given. Entries are matched to library tracks by YouTube link, then by
+// title, artist and duration, and the matches become a new playlist. With
+// download_missing, unmatched entries that link to YouTube are queued for
+// download and join the playlist at their original position once done.
+func (h *PlaylistTransferHandler) Import(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	query := r.URL.Query()
+	downloadMissing := false
+	if v := query.Get("download_missing"); v != "" {
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "download_missing must be true or false")
+			return
+		}
+		downloadMissing = parsed
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPlaylistImportBytes))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "playlist file too large or unreadable")
+		return
+	}
+
+	format := query.Get("format")
+	if format == "" {
+		format = playlistfmt.Detect(data)
+	}
+
+	parsed, err := playlistfmt.Parse(format, data)
+	if err != nil {
+		if errors.Is(err, playlistfmt.ErrUnsupportedFormat) {
+			writeError(w, http.StatusBadRequest, "format must be 'm3u8', 'xspf' or 'jspf'")
+			return
+		}
+		writeError(w, http.StatusBadRequest, "invalid playlist file")
+		return
+	}
+	if len(parsed.Entries) == 0 {
+		writeError(w, http.StatusBadRequest, "playlist has no entries")
+		return
+	}
+
+	name := query.Get("name")
+	if name == "" {
+		name = parsed.Title
+	}
+	if name == "" {
+		name = defaultImportedPlaylistName
+	}
+	if runes := []rune(name); len(runes) > maxPlaylistNameLength {
+		name = string(runes[:maxPlaylistNameLength])
+	}
+
+	tracks, err := h.db.GetTracksByUserID(r.Context(), userID)
+	if err != nil {
+		log.Printf("Failed to get tracks: %v", err)
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+
+	response := importPlaylistResponse{Entries: make([]importEntryResponse, 0, len(parsed.Entries))}
+	// Matched tracks keep their index in the file as their position, so
+	// queued downloads can be inserted between them
+	var entries []db.PlaylistEntry
+	for i := range parsed.Entries {
+		entry := &parsed.Entries[i]
+		result := importEntryResponse{
+			Index:           i,
+			Title:           entry.Title,
+			Artist:          entry.Artist,
+			DurationSeconds: entry.DurationSeconds,
+			YoutubeID:       entry.YouTubeID(),
+			Status:          importEntryUnmatched,
+		}
+
+		if track, matchedBy, score := matchPlaylistEntry(entry, result.YoutubeID, tracks); track != nil {
+			result.Status = importEntryMatched
+			result.MatchedBy = matchedBy
+			result.TrackID = track.ID
+			if matchedBy == "metadata" {
+				result.Score = &score
+			}
+			entries = append(entries, db.PlaylistEntry{TrackID: track.ID, Position: i})
+		}
+
+		response.Entries = append(response.Entries, result)
+	}
+
+	playlist, err := h.db.CreatePlaylistWithTracks(r.Context(), userID, name, entries)
+	if err != nil {
+		log.Printf("Failed to create imported playlist: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to create playlist")
+		return
+	}
+
+	for i := range response.Entries {
+		result := &response.Entries[i]
+		if downloadMissing && result.Status == importEntryUnmatched && result.YoutubeID != "" {
+			position := result.Index
+			job, err := h.downloads.QueueDownload(r.Context(), &db.DownloadJob{
+				UserID:           userID,
+				YoutubeID:        result.YoutubeID,
+				Type:             "audio",
+				PlaylistID:       &playlist.ID,
+				PlaylistPosition: &position,
+			})
+			if err != nil {
+				log.Printf("Failed to queue download of %s: %v", result.YoutubeID, err)
+			} else {
+				result.Status = importEntryQueued
+				result.JobID = job.ID
+			}
+		}
+
+		switch result.Status {
+		case importEntryMatched:
+			response.Matched++
+		case importEntryQueued:
+			response.Queued++
+		default:
+			response.Unmatched++
+		}
+	}
+
+	response.Playlist = playlistResponse{
+		ID:        playlist.ID,
+		Name:      playlist.Name,
+		CreatedAt: playlist.CreatedAt.Format(timeFormatISO8601),
+		UpdatedAt: playlist.UpdatedAt.Format(timeFormatISO8601),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+// matchPlaylistEntry finds the library track an imported entry refers to,
+// first by YouTube ID and then by the best title, artist and duration score
+func matchPlaylistEntry(entry *playlistfmt.Entry, youtubeID string, tracks []db.Track) (*db.Track, string, float64) {
+	if youtubeID != "" {
+		for i := range tracks {
+			if tracks[i].YoutubeID == youtubeID {
+				return &tracks[i], "youtube_id", 1
+			}
+		}
+	}
+
+	if entry.Title == "" {
+		return nil, "", 0
+	}
+
+	want := textmatch.Track{Title: entry.Title, Artist: entry.Artist, DurationSeconds: entry.DurationSeconds}
+	var best *db.Track
+	bestScore := 0.0
+	for i := range tracks {
+		score := textmatch.TrackScore(textmatch.Track{
+			Title:           tracks[i].Title,
+			Artist:          tracks[i].Artist,
+			DurationSeconds: tracks[i].DurationSeconds,
+		}, want)
+		if score > bestScore {
+			best, bestScore = &tracks[i], score
+		}
+	}
+
+	if bestScore < playlistMatchThreshold {
+		return nil, "", 0
+	}
+	return best, "metadata", bestScore
+}
@@ -0,0 +1,341 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/wpinrui/dovora2/backend/internal/db"
+)
+
+const (
+	// maxPlayBatch bounds the events in one upload
+	maxPlayBatch = 500
+
+	// maxPlayDuration rejects events no single playback could produce
+	maxPlayDuration = 24 * 60 * 60
+
+	// maxClientIDLength matches the play_history.client_id column
+	maxClientIDLength = 100
+
+	// playClockSkew tolerates client clocks running ahead of the server's
+	playClockSkew = 5 * time.Minute
+
+	defaultTopLimit = 10
+	maxTopLimit     = 100
+)
+
+// statsPeriods maps the period parameter to how far back it reaches; all
+// reaches back forever
+var statsPeriods = map[string]time.Duration{
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+	"year":  365 * 24 * time.Hour,
+	"all":   0,
+}
+
+// PlayHandler records what users play and reports listening statistics
+type PlayHandler struct {
+	db        *db.DB
+	scrobbles *ScrobbleHandler
+}
+
+func NewPlayHandler(database *db.DB, scrobbles *ScrobbleHandler) *PlayHandler {
+	return &PlayHandler{db: database, scrobbles: scrobbles}
+}
+
+type playEventRequest struct {
+	TrackID         string `json:"track_id"`
+	VideoID         string `json:"video_id"`
+	ClientID        string `json:"client_id"`
+	StartedAt       string `json:"started_at"`
+	DurationSeconds int    `json:"duration_seconds"`
+	Completed       bool   `json:"completed"`
+}
+
+type recordPlaysRequest struct {
+	Events []playEventRequest `json:"events"`
+}
+
+type recordPlaysResponse struct {
+	Recorded int `json:"recorded"`
+	Skipped  int `json:"skipped"`
+}
+
+type listeningSummaryResponse struct {
+	Period       string `json:"period"`
+	TotalSeconds int64  `json:"total_seconds"`
+	Plays        int    `json:"plays"`
+	Tracks       int    `json:"tracks"`
+	Videos       int    `json:"videos"`
+}
+
+type topTrackResponse struct {
+	trackResponse
+	Plays   int   `json:"plays"`
+	Seconds int64 `json:"seconds"`
+}
+
+type topTracksResponse struct {
+	Period string             `json:"period"`
+	Tracks []topTrackResponse `json:"tracks"`
+}
+
+type topArtistResponse struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	ArtworkURL string `json:"artwork_url"`
+	Plays      int    `json:"plays"`
+	Seconds    int64  `json:"seconds"`
+}
+
+type topArtistsResponse struct {
+	Period  string              `json:"period"`
+	Artists []topArtistResponse `json:"artists"`
+}
+
+type heatmapResponse struct {
+	Period   string `json:"period"`
+	Timezone string `json:"timezone"`
+	// Seconds listened by weekday, Monday first, and hour of day
+	Seconds [7][24]int64 `json:"seconds"`
+}
+
+// RecordPlays handles POST /plays. Clients upload play events in batches,
+// possibly long after playback while offline. Events may carry a client_id
+// so a retried upload doesn't record them twice.
+func (h *PlayHandler) RecordPlays(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	var req recordPlaysRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if len(req.Events) == 0 {
+		writeError(w, http.StatusBadRequest, "events is required")
+		return
+	}
+	if len(req.Events) > maxPlayBatch {
+		writeError(w, http.StatusBadRequest, "at most 500 events can be uploaded at once")
+		return
+	}
+
+	latestStart := time.Now().Add(playClockSkew)
+	events := make([]db.PlayEvent, 0, len(req.Events))
+	for i, e := range req.Events {
+		if (e.TrackID == "") == (e.VideoID == "") {
+			writeError(w, http.StatusBadRequest, "event "+strconv.Itoa(i)+": exactly one of track_id and video_id is required")
+			return
+		}
+		startedAt, err := time.Parse(time.RFC3339, e.StartedAt)
+		if err != nil || startedAt.After(latestStart) {
+			writeError(w, http.StatusBadRequest, "event "+strconv.Itoa(i)+": started_at must be a past RFC 3339 time")
+			return
+		}
+		if e.DurationSeconds < 0 || e.DurationSeconds > maxPlayDuration {
+			writeError(w, http.StatusBadRequest, "event "+strconv.Itoa(i)+": duration_seconds must be between 0 and 86400")
+			return
+		}
+		if len(e.ClientID) > maxClientIDLength {
+			writeError(w, http.StatusBadRequest, "event "+strconv.Itoa(i)+": client_id must be at most 100 characters")
+			return
+		}
+
+		events = append(events, db.PlayEvent{
+			TrackID:         optionalString(e.TrackID),
+			VideoID:         optionalString(e.VideoID),
+			ClientID:        optionalString(e.ClientID),
+			StartedAt:       startedAt,
+			DurationSeconds: e.DurationSeconds,
+			Completed:       e.Completed,
+		})
+	}
+
+	recorded, err := h.db.RecordPlays(r.Context(), userID, events)
+	if err != nil {
+		log.Printf("Failed to record plays: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to record plays")
+		return
+	}
+	if recorded > 0 {
+		h.scrobbles.Wake()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recordPlaysResponse{Recorded: recorded, Skipped: len(events) - recorded})
+}
+
+// Summary handles GET /stats/summary?period=
+func (h *PlayHandler) Summary(w http.ResponseWriter, r *http.Request) {
+	userID, period, since, ok := h.statsRequest(w, r)
+	if !ok {
+		return
+	}
+
+	summary, err := h.db.GetListeningSummary(r.Context(), userID, since)
+	if err != nil {
+		log.Printf("Failed to get listening summary: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to get stats")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listeningSummaryResponse{
+		Period:       period,
+		TotalSeconds: summary.TotalSeconds,
+		Plays:        summary.Plays,
+		Tracks:       summary.Tracks,
+		Videos:       summary.Videos,
+	})
+}
+
+// TopTracks handles GET /stats/top-tracks?period=&limit=
+func (h *PlayHandler) TopTracks(w http.ResponseWriter, r *http.Request) {
+	userID, period, since, ok := h.statsRequest(w, r)
+	if !ok {
+		return
+	}
+	limit, ok := parseTopLimit(w, r)
+	if !ok {
+		return
+	}
+
+	tracks, err := h.db.GetTopTracks(r.Context(), userID, since, limit)
+	if err != nil {
+		log.Printf("Failed to get top tracks: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to get stats")
+		return
+	}
+
+	response := topTracksResponse{Period: period, Tracks: make([]topTrackResponse, 0, len(tracks))}
+	for i := range tracks {
+		response.Tracks = append(response.Tracks, topTrackResponse{
+			trackResponse: newTrackResponse(&tracks[i].Track),
+			Plays:         tracks[i].Plays,
+			Seconds:       tracks[i].Seconds,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// TopArtists handles GET /stats/top-artists?period=&limit=
+func (h *PlayHandler) TopArtists(w http.ResponseWriter, r *http.Request) {
+	userID, period, since, ok := h.statsRequest(w, r)
+	if !ok {
+		return
+	}
+	limit, ok := parseTopLimit(w, r)
+	if !ok {
+		return
+	}
+
+	artists, err := h.db.GetTopArtists(r.Context(), userID, since, limit)
+	if err != nil {
+		log.Printf("Failed to get top artists: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to get stats")
+		return
+	}
+
+	response := topArtistsResponse{Period: period, Artists: make([]topArtistResponse, 0, len(artists))}
+	for _, a := range artists {
+		response.Artists = append(response.Artists, topArtistResponse{
+			ID:         a.ID,
+			Name:       a.Name,
+			ArtworkURL: a.ArtworkURL,
+			Plays:      a.Plays,
+			Seconds:    a.Seconds,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Heatmap handles GET /stats/heatmap?period=&tz=, where tz is an IANA time
+// zone such as Europe/London (UTC by default)
+func (h *PlayHandler) Heatmap(w http.ResponseWriter, r *http.Request) {
+	userID, period, since, ok := h.statsRequest(w, r)
+	if !ok {
+		return
+	}
+
+	timezone := r.URL.Query().Get("tz")
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(timezone); err != nil || timezone == "Local" {
+		writeError(w, http.StatusBadRequest, "tz must be an IANA time zone")
+		return
+	}
+
+	heatmap, err := h.db.GetListeningHeatmap(r.Context(), userID, since, timezone)
+	if err != nil {
+		log.Printf("Failed to get listening heatmap: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to get stats")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(heatmapResponse{Period: period, Timezone: timezone, Seconds: *heatmap})
+}
+
+// statsRequest checks a stats request and reads its period, which defaults
+// to month. since is nil for all time. It writes an error response and
+// returns false when the request is invalid.
+func (h *PlayHandler) statsRequest(w http.ResponseWriter, r *http.Request) (userID, period string, since *time.Time, ok bool) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return "", "", nil, false
+	}
+
+	userID, ok = GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return "", "", nil, false
+	}
+
+	period = r.URL.Query().Get("period")
+	if period == "" {
+		period = "month"
+	}
+	span, known := statsPeriods[period]
+	if !known {
+		writeError(w, http.StatusBadRequest, "period must be 'day', 'week', 'month', 'year' or 'all'")
+		return "", "", nil, false
+	}
+	if span > 0 {
+		start := time.Now().Add(-span)
+		since = &start
+	}
+
+	return userID, period, since, true
+}
+
+func parseTopLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return defaultTopLimit, true
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 1 || limit > maxTopLimit {
+		writeError(w, http.StatusBadRequest, "limit must be between 1 and 100")
+		return 0, false
+	}
+	return limit, true
+}
@@ -87,7 +87,9 @@ func (rl *RateLimiter) cleanup() {
 	})
 }
 
-// getClientIP extracts the client IP from the request
+// getClientIP extracts the client IP from the request for rate limiting.
+// Forwarding headers are taken on trust, so anything that grants access uses
+// TrustedProxies.ClientIP instead.
 func getClientIP(r *http.Request) string {
 	// Check X-Forwarded-For header (for reverse proxies)
 	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
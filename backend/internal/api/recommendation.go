@@ -0,0 +1,190 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wpinrui/dovora2/backend/internal/db"
+	"github.com/wpinrui/dovora2/backend/internal/invidious"
+	"github.com/wpinrui/dovora2/backend/internal/recommend"
+)
+
+const (
+	defaultRecommendationLimit = 20
+	maxRecommendationLimit     = 50
+
+	// recentPlayWindow is how long after being played an item is left out of
+	// recommendations
+	recentPlayWindow = 24 * time.Hour
+)
+
+// RecommendationHandler suggests what to play after a track, from YouTube's
+// related videos and the playlists the track appears in
+type RecommendationHandler struct {
+	db        *db.DB
+	invidious *invidious.Client
+}
+
+func NewRecommendationHandler(database *db.DB, invidiousClient *invidious.Client) *RecommendationHandler {
+	return &RecommendationHandler{db: database, invidious: invidiousClient}
+}
+
+// recommendationResponse is a suggested YouTube video. Those already in the
+// library have InLibrary set along with the track or video.
+type recommendationResponse struct {
+	VideoID      string         `json:"video_id"`
+	Title        string         `json:"title"`
+	Author       string         `json:"author"`
+	Duration     int            `json:"duration"`
+	ThumbnailURL string         `json:"thumbnail_url"`
+	InLibrary    bool           `json:"in_library"`
+	Track        *trackResponse `json:"track,omitempty"`
+	Video        *videoResponse `json:"video,omitempty"`
+}
+
+type recommendationsResponse struct {
+	Seed    trackResponse            `json:"seed"`
+	Results []recommendationResponse `json:"results"`
+}
+
+// GetRecommendations handles GET /recommendations?seed=<track_id>&limit=.
+// YouTube's related videos for the seed are blended with tracks sharing
+// playlists with it, so items suggested by both come first. Anything played
+// in the last day is left out.
+func (h *RecommendationHandler) GetRecommendations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	seedID := r.URL.Query().Get("seed")
+	if seedID == "" {
+		writeError(w, http.StatusBadRequest, "seed parameter is required")
+		return
+	}
+
+	limit := defaultRecommendationLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 1 || parsed > maxRecommendationLimit {
+			writeError(w, http.StatusBadRequest, "limit must be between 1 and 50")
+			return
+		}
+		limit = parsed
+	}
+
+	seed, err := h.db.GetTrackByID(r.Context(), seedID, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "track not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get track: %v", err)
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+
+	coOccurring, err := h.db.GetCoOccurringTracks(r.Context(), userID, seed.ID, maxRecommendationLimit)
+	if err != nil {
+		log.Printf("Failed to get co-occurring tracks: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to get recommendations")
+		return
+	}
+
+	// Without YouTube's suggestions the playlists alone still give some
+	var related []invidious.RecommendedVideo
+	if video, err := h.invidious.GetVideo(r.Context(), seed.YoutubeID); err != nil {
+		log.Printf("Failed to get related videos for %s: %v", seed.YoutubeID, err)
+	} else {
+		for _, v := range video.RecommendedVideos {
+			// Live streams have no length and can't be downloaded
+			if v.LengthSeconds > 0 {
+				related = append(related, v)
+			}
+		}
+	}
+
+	relatedIDs := make([]string, 0, len(related))
+	relatedByID := make(map[string]*invidious.RecommendedVideo, len(related))
+	for i := range related {
+		relatedIDs = append(relatedIDs, related[i].VideoID)
+		relatedByID[related[i].VideoID] = &related[i]
+	}
+
+	tracks, videos, err := h.db.GetItemsByYoutubeIDs(r.Context(), userID, relatedIDs)
+	if err != nil {
+		log.Printf("Failed to get library items: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to get recommendations")
+		return
+	}
+
+	coOccurringIDs := make([]string, 0, len(coOccurring))
+	for i := range coOccurring {
+		coOccurringIDs = append(coOccurringIDs, coOccurring[i].YoutubeID)
+		tracks[coOccurring[i].YoutubeID] = &coOccurring[i]
+	}
+
+	exclude, err := h.db.GetRecentlyPlayedYoutubeIDs(r.Context(), userID, time.Now().Add(-recentPlayWindow))
+	if err != nil {
+		log.Printf("Failed to get recently played items: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to get recommendations")
+		return
+	}
+	exclude[seed.YoutubeID] = true
+
+	response := recommendationsResponse{
+		Seed:    newTrackResponse(seed),
+		Results: []recommendationResponse{},
+	}
+
+	for _, youtubeID := range recommend.Blend([][]string{relatedIDs, coOccurringIDs}, exclude, limit) {
+		var result recommendationResponse
+		if track := tracks[youtubeID]; track != nil {
+			t := newTrackResponse(track)
+			result = recommendationResponse{
+				VideoID:      youtubeID,
+				Title:        track.Title,
+				Author:       track.Artist,
+				Duration:     track.DurationSeconds,
+				ThumbnailURL: track.ThumbnailURL,
+				InLibrary:    true,
+				Track:        &t,
+			}
+		} else if video := videos[youtubeID]; video != nil {
+			v := newVideoResponse(video)
+			result = recommendationResponse{
+				VideoID:      youtubeID,
+				Title:        video.Title,
+				Author:       video.Channel,
+				Duration:     video.DurationSeconds,
+				ThumbnailURL: video.ThumbnailURL,
+				InLibrary:    true,
+				Video:        &v,
+			}
+		} else {
+			rv := relatedByID[youtubeID]
+			result = recommendationResponse{
+				VideoID:      youtubeID,
+				Title:        rv.Title,
+				Author:       rv.Author,
+				Duration:     rv.LengthSeconds,
+				ThumbnailURL: pickThumbnail(rv.VideoThumbnails),
+			}
+		}
+		response.Results = append(response.Results, result)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,359 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wpinrui/dovora2/backend/internal/db"
+	"github.com/wpinrui/dovora2/backend/internal/scrobble"
+)
+
+const (
+	// scrobblePollInterval is how often the outbox worker checks for
+	// submissions whose retry delay has passed
+	scrobblePollInterval = time.Minute
+
+	scrobbleSendTimeout = 30 * time.Second
+	maxScrobbleBackoff  = 6 * time.Hour
+
+	// nowPlayingGrace keeps a now playing notice for tracks of unknown
+	// length, and a little past the end of known ones
+	nowPlayingGrace = 5 * time.Minute
+)
+
+// ScrobbleHandler links ListenBrainz and Last.fm accounts and forwards plays
+// to them through the scrobble outbox
+type ScrobbleHandler struct {
+	db       *db.DB
+	services map[string]scrobble.Service
+	// outboxWake signals the outbox worker that submissions were queued
+	outboxWake chan struct{}
+}
+
+// NewScrobbleHandler creates a scrobble handler. lastFM may be nil when no
+// Last.fm API key is configured, in which case Last.fm can't be linked.
+func NewScrobbleHandler(database *db.DB, listenBrainz, lastFM scrobble.Service) *ScrobbleHandler {
+	services := map[string]scrobble.Service{scrobble.ServiceListenBrainz: listenBrainz}
+	if lastFM != nil {
+		services[scrobble.ServiceLastFM] = lastFM
+	}
+	return &ScrobbleHandler{
+		db:         database,
+		services:   services,
+		outboxWake: make(chan struct{}, 1),
+	}
+}
+
+type scrobbleAccountResponse struct {
+	Service    string  `json:"service"`
+	Username   string  `json:"username"`
+	Active     bool    `json:"active"`
+	DisabledAt *string `json:"disabled_at,omitempty"`
+	LastError  string  `json:"last_error,omitempty"`
+	Pending    int     `json:"pending"`
+	CreatedAt  string  `json:"created_at"`
+	UpdatedAt  string  `json:"updated_at"`
+}
+
+type scrobbleAccountsResponse struct {
+	Accounts []scrobbleAccountResponse `json:"accounts"`
+}
+
+type linkScrobbleAccountRequest struct {
+	Token string `json:"token"`
+}
+
+type nowPlayingRequest struct {
+	TrackID string `json:"track_id"`
+}
+
+type nowPlayingResponse struct {
+	Queued int `json:"queued"`
+}
+
+func newScrobbleAccountResponse(account *db.ScrobbleAccount) scrobbleAccountResponse {
+	return scrobbleAccountResponse{
+		Service:    account.Service,
+		Username:   account.Username,
+		Active:     account.DisabledAt == nil,
+		DisabledAt: formatOptionalTime(account.DisabledAt),
+		LastError:  account.LastError,
+		Pending:    account.Pending,
+		CreatedAt:  account.CreatedAt.Format(timeFormatISO8601),
+		UpdatedAt:  account.UpdatedAt.Format(timeFormatISO8601),
+	}
+}
+
+// ListAccounts handles GET /scrobbling
+func (h *ScrobbleHandler) ListAccounts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	accounts, err := h.db.ListScrobbleAccounts(r.Context(), userID)
+	if err != nil {
+		log.Printf("Failed to list scrobble accounts: %v", err)
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+
+	response := scrobbleAccountsResponse{Accounts: make([]scrobbleAccountResponse, 0, len(accounts))}
+	for i := range accounts {
+		response.Accounts = append(response.Accounts, newScrobbleAccountResponse(&accounts[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// HandleAccount handles PUT and DELETE /scrobbling/{service}. PUT links an
+// account with a ListenBrainz user token or a Last.fm session key, which is
+// checked with the service first.
+func (h *ScrobbleHandler) HandleAccount(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	serviceName := r.PathValue("service")
+	service, known := h.services[serviceName]
+	if !known {
+		writeError(w, http.StatusNotFound, "unknown scrobbling service")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		h.linkAccount(w, r, userID, serviceName, service)
+	case http.MethodDelete:
+		err := h.db.UnlinkScrobbleAccount(r.Context(), userID, serviceName)
+		if errors.Is(err, db.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "account not linked")
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to unlink scrobble account: %v", err)
+			writeError(w, http.StatusInternalServerError, "failed to unlink account")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (h *ScrobbleHandler) linkAccount(w http.ResponseWriter, r *http.Request, userID, serviceName string, service scrobble.Service) {
+	var req linkScrobbleAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Token == "" {
+		writeError(w, http.StatusBadRequest, "token is required")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), scrobbleSendTimeout)
+	defer cancel()
+
+	username, err := service.ValidateToken(ctx, req.Token)
+	if errors.Is(err, scrobble.ErrUnauthorized) {
+		writeError(w, http.StatusBadRequest, "token rejected by "+serviceName)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to validate %s token: %v", serviceName, err)
+		writeError(w, http.StatusBadGateway, "failed to reach "+serviceName)
+		return
+	}
+
+	if err := h.db.LinkScrobbleAccount(r.Context(), userID, serviceName, req.Token, username); err != nil {
+		log.Printf("Failed to link scrobble account: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to link account")
+		return
+	}
+	h.Wake()
+
+	accounts, err := h.db.ListScrobbleAccounts(r.Context(), userID)
+	if err != nil {
+		log.Printf("Failed to list scrobble accounts: %v", err)
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	for i := range accounts {
+		if accounts[i].Service == serviceName {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(newScrobbleAccountResponse(&accounts[i]))
+			return
+		}
+	}
+	writeError(w, http.StatusInternalServerError, "database error")
+}
+
+// NowPlaying handles POST /plays/now-playing, telling the user's linked
+// services which track just started
+func (h *ScrobbleHandler) NowPlaying(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	var req nowPlayingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.TrackID == "" {
+		writeError(w, http.StatusBadRequest, "track_id is required")
+		return
+	}
+
+	track, err := h.db.GetTrackByID(r.Context(), req.TrackID, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "track not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get track: %v", err)
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+
+	expiresAt := time.Now().Add(nowPlayingGrace + time.Duration(track.DurationSeconds)*time.Second)
+
+	queued, err := h.db.EnqueueNowPlaying(r.Context(), userID, track.ID, expiresAt)
+	if err != nil {
+		log.Printf("Failed to queue now playing: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to queue now playing")
+		return
+	}
+	if queued > 0 {
+		h.Wake()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(nowPlayingResponse{Queued: queued})
+}
+
+// Wake tells the outbox worker that submissions may be waiting
+func (h *ScrobbleHandler) Wake() {
+	select {
+	case h.outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// RunOutbox sends queued submissions one at a time until ctx is cancelled.
+// Failed submissions are retried with growing delays until the service
+// accepts them, so plays made while a service is down aren't lost.
+func (h *ScrobbleHandler) RunOutbox(ctx context.Context) {
+	ticker := time.NewTicker(scrobblePollInterval)
+	defer ticker.Stop()
+
+	for {
+		job, err := h.db.ClaimScrobble(ctx)
+		if err == nil {
+			h.sendScrobble(ctx, job)
+			continue
+		}
+		if !errors.Is(err, db.ErrNotFound) && ctx.Err() == nil {
+			log.Printf("Failed to claim scrobble: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.outboxWake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *ScrobbleHandler) sendScrobble(ctx context.Context, job *db.ScrobbleJob) {
+	// Use a fresh context so the outcome is recorded even during shutdown
+	recordCtx := context.Background()
+
+	service, known := h.services[job.Service]
+	if !known {
+		// The service was unconfigured since the account was linked; hold
+		// its submissions until it's configured again
+		h.retryScrobble(recordCtx, job, errors.New(job.Service+" is not configured"))
+		return
+	}
+
+	var listen scrobble.Listen
+	if err := json.Unmarshal(job.Listen, &listen); err != nil {
+		log.Printf("Dropping malformed scrobble %s: %v", job.ID, err)
+		h.completeScrobble(recordCtx, job)
+		return
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, scrobbleSendTimeout)
+	defer cancel()
+
+	var err error
+	if job.Kind == db.ScrobbleNowPlaying {
+		err = service.NowPlaying(sendCtx, job.Token, &listen)
+	} else {
+		err = service.Scrobble(sendCtx, job.Token, &listen)
+	}
+
+	switch {
+	case err == nil:
+		h.completeScrobble(recordCtx, job)
+	case errors.Is(err, scrobble.ErrUnauthorized):
+		log.Printf("%s rejected the token of user %s: %v", job.Service, job.UserID, err)
+		if err := h.db.DisableScrobbleAccount(recordCtx, job.UserID, job.Service, err.Error()); err != nil {
+			log.Printf("Failed to disable scrobble account: %v", err)
+		}
+		// Keep the submission for when the user links the account again
+		h.retryScrobble(recordCtx, job, err)
+	case errors.Is(err, scrobble.ErrRejected):
+		log.Printf("%s refused scrobble %s: %v", job.Service, job.ID, err)
+		h.completeScrobble(recordCtx, job)
+	case job.Kind == db.ScrobbleNowPlaying:
+		// A late now playing notice is wrong, so it's only tried once
+		log.Printf("Failed to send now playing %s to %s: %v", job.ID, job.Service, err)
+		h.completeScrobble(recordCtx, job)
+	default:
+		log.Printf("Failed to send scrobble %s to %s: %v", job.ID, job.Service, err)
+		h.retryScrobble(recordCtx, job, err)
+	}
+}
+
+func (h *ScrobbleHandler) completeScrobble(ctx context.Context, job *db.ScrobbleJob) {
+	if err := h.db.CompleteScrobble(ctx, job.ID); err != nil {
+		log.Printf("Failed to complete scrobble: %v", err)
+	}
+}
+
+// retryScrobble schedules another attempt, doubling the delay from a minute
+// with each attempt up to maxScrobbleBackoff
+func (h *ScrobbleHandler) retryScrobble(ctx context.Context, job *db.ScrobbleJob, cause error) {
+	backoff := maxScrobbleBackoff
+	if job.Attempts < 10 {
+		backoff = min(time.Duration(1<<job.Attempts)*time.Minute, maxScrobbleBackoff)
+	}
+	if err := h.db.RetryScrobble(ctx, job.ID, cause.Error(), time.Now().Add(backoff)); err != nil {
+		log.Printf("Failed to record scrobble failure: %v", err)
+	}
+}
@@ -61,20 +61,7 @@ func (h *SearchHandler) Search(w http.ResponseWriter, r *http.Request) {
 	}
 
 	for _, result := range results {
-		thumbnail := ""
-		if len(result.VideoThumbnails) > 0 {
-			// Prefer medium quality thumbnail
-			for _, t := range result.VideoThumbnails {
-				if t.Quality == "medium" {
-					thumbnail = t.URL
-					break
-				}
-			}
-			// Fallback to first available
-			if thumbnail == "" {
-				thumbnail = result.VideoThumbnails[0].URL
-			}
-		}
+		thumbnail := pickThumbnail(result.VideoThumbnails)
 
 		response.Results = append(response.Results, searchResultResponse{
 			VideoID:       result.VideoID,
@@ -90,3 +77,16 @@ func (h *SearchHandler) Search(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
+
+// pickThumbnail returns the medium quality thumbnail, or the first available
+func pickThumbnail(thumbnails []invidious.VideoThumbnail) string {
+	for _, t := range thumbnails {
+		if t.Quality == "medium" {
+			return t.URL
+		}
+	}
+	if len(thumbnails) > 0 {
+		return thumbnails[0].URL
+	}
+	return ""
+}
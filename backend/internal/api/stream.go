@@ -0,0 +1,139 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wpinrui/dovora2/backend/internal/db"
+	"github.com/wpinrui/dovora2/backend/internal/media"
+)
+
+// hlsRetryAfterSeconds is how long clients are told to wait while renditions are generated
+const hlsRetryAfterSeconds = "30"
+
+// hlsNamePattern matches the rendition directories and files ffmpeg writes,
+// which keeps request paths from escaping the video's HLS directory
+var hlsNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+(\.[A-Za-z0-9]+)?$`)
+
+var hlsContentTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".m4s":  "video/iso.segment",
+	".mp4":  "video/mp4",
+}
+
+type StreamHandler struct {
+	db        *db.DB
+	processor *media.Processor
+}
+
+func NewStreamHandler(database *db.DB, processor *media.Processor) *StreamHandler {
+	return &StreamHandler{db: database, processor: processor}
+}
+
+// ServeMaster handles GET /stream/{id}/master.m3u8. If the renditions haven't
+// been generated yet, generation starts and 503 is returned with Retry-After.
+func (h *StreamHandler) ServeMaster(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	video, ok := h.getVideo(w, r)
+	if !ok {
+		return
+	}
+
+	if !h.processor.EnsureHLS(video.FilePath) {
+		w.Header().Set("Retry-After", hlsRetryAfterSeconds)
+		writeError(w, http.StatusServiceUnavailable, "stream is being prepared")
+		return
+	}
+
+	h.serveHLSFile(w, r, filepath.Join(h.processor.HLSDir(video.FilePath), media.HLSMasterPlaylist))
+}
+
+// ServeSegment handles GET /stream/{id}/{rendition}/{file}, serving a
+// rendition's media playlist, init segment or media segments
+func (h *StreamHandler) ServeSegment(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	rendition := r.PathValue("rendition")
+	file := r.PathValue("file")
+	if !hlsNamePattern.MatchString(rendition) || !hlsNamePattern.MatchString(file) {
+		writeError(w, http.StatusBadRequest, "invalid stream path")
+		return
+	}
+
+	video, ok := h.getVideo(w, r)
+	if !ok {
+		return
+	}
+
+	if !h.processor.HLSReady(video.FilePath) {
+		writeError(w, http.StatusNotFound, "stream not available")
+		return
+	}
+
+	h.serveHLSFile(w, r, filepath.Join(h.processor.HLSDir(video.FilePath), rendition, file))
+}
+
+func (h *StreamHandler) getVideo(w http.ResponseWriter, r *http.Request) (*db.Video, bool) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return nil, false
+	}
+
+	video, err := h.db.GetVideoByID(r.Context(), r.PathValue("id"), userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "video not found")
+			return nil, false
+		}
+		log.Printf("Failed to get video: %v", err)
+		writeError(w, http.StatusInternalServerError, "database error")
+		return nil, false
+	}
+
+	return video, true
+}
+
+func (h *StreamHandler) serveHLSFile(w http.ResponseWriter, r *http.Request, path string) {
+	contentType, ok := hlsContentTypes[strings.ToLower(filepath.Ext(path))]
+	if !ok {
+		writeError(w, http.StatusNotFound, "file not found")
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			writeError(w, http.StatusNotFound, "file not found")
+			return
+		}
+		log.Printf("Failed to open HLS file: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to open file")
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		log.Printf("Failed to stat HLS file: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to access file")
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/wpinrui/dovora2/backend/internal/db"
+)
+
+// SyncHandler lets offline clients fetch only what changed in the library
+// since they last synced
+type SyncHandler struct {
+	db *db.DB
+}
+
+func NewSyncHandler(database *db.DB) *SyncHandler {
+	return &SyncHandler{db: database}
+}
+
+type syncPlaylistResponse struct {
+	playlistResponse
+	// TrackIDs lists the playlist's tracks in order; null for smart
+	// playlists, whose tracks are fetched from /playlists/{id}
+	TrackIDs []string `json:"track_ids"`
+}
+
+type syncDeletedResponse struct {
+	Tracks    []string `json:"tracks"`
+	Videos    []string `json:"videos"`
+	Playlists []string `json:"playlists"`
+}
+
+type syncResponse struct {
+	Token     string                 `json:"token"`
+	Tracks    []trackResponse        `json:"tracks"`
+	Videos    []videoResponse        `json:"videos"`
+	Playlists []syncPlaylistResponse `json:"playlists"`
+	Deleted   syncDeletedResponse    `json:"deleted"`
+}
+
+// Sync handles GET /sync?since=<token>. Without since it returns the whole
+// library. The response's token is passed as since on the next sync; items
+// may come back again even if they didn't change, so applying a response
+// must be idempotent.
+func (h *SyncHandler) Sync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	delta, err := h.db.GetSyncDelta(r.Context(), userID, r.URL.Query().Get("since"))
+	if errors.Is(err, db.ErrInvalidSyncToken) {
+		writeError(w, http.StatusBadRequest, "invalid since token")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get sync delta: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to sync")
+		return
+	}
+
+	response := syncResponse{
+		Token:     delta.Token,
+		Tracks:    make([]trackResponse, 0, len(delta.Tracks)),
+		Videos:    make([]videoResponse, 0, len(delta.Videos)),
+		Playlists: make([]syncPlaylistResponse, 0, len(delta.Playlists)),
+		Deleted: syncDeletedResponse{
+			Tracks:    emptyIfNil(delta.DeletedTracks),
+			Videos:    emptyIfNil(delta.DeletedVideos),
+			Playlists: emptyIfNil(delta.DeletedPlaylists),
+		},
+	}
+	for i := range delta.Tracks {
+		response.Tracks = append(response.Tracks, newTrackResponse(&delta.Tracks[i]))
+	}
+	for i := range delta.Videos {
+		response.Videos = append(response.Videos, newVideoResponse(&delta.Videos[i]))
+	}
+	for i := range delta.Playlists {
+		playlist := &delta.Playlists[i]
+		var trackIDs []string
+		if playlist.Rules == nil {
+			trackIDs = emptyIfNil(delta.PlaylistTrackIDs[playlist.ID])
+		}
+		response.Playlists = append(response.Playlists, syncPlaylistResponse{
+			playlistResponse: newPlaylistResponse(playlist),
+			TrackIDs:         trackIDs,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func emptyIfNil(ids []string) []string {
+	if ids == nil {
+		return []string{}
+	}
+	return ids
+}
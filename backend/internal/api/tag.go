@@ -0,0 +1,268 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wpinrui/dovora2/backend/internal/db"
+)
+
+// maxTagNameLength matches the tags.name column
+const maxTagNameLength = 100
+
+// maxTaggedItems bounds the tracks and videos one bulk tag request touches
+const maxTaggedItems = 1000
+
+type TagHandler struct {
+	db *db.DB
+}
+
+func NewTagHandler(database *db.DB) *TagHandler {
+	return &TagHandler{db: database}
+}
+
+type tagResponse struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	TrackCount int    `json:"track_count"`
+	VideoCount int    `json:"video_count"`
+	CreatedAt  string `json:"created_at"`
+	UpdatedAt  string `json:"updated_at"`
+}
+
+func newTagResponse(tag *db.Tag) tagResponse {
+	return tagResponse{
+		ID:         tag.ID,
+		Name:       tag.Name,
+		TrackCount: tag.TrackCount,
+		VideoCount: tag.VideoCount,
+		CreatedAt:  tag.CreatedAt.Format(timeFormatISO8601),
+		UpdatedAt:  tag.UpdatedAt.Format(timeFormatISO8601),
+	}
+}
+
+type tagsResponse struct {
+	Tags []tagResponse `json:"tags"`
+}
+
+type tagNameRequest struct {
+	Name string `json:"name"`
+}
+
+type bulkTagRequest struct {
+	Add      []string `json:"add"`
+	Remove   []string `json:"remove"`
+	TrackIDs []string `json:"track_ids"`
+	VideoIDs []string `json:"video_ids"`
+}
+
+// validTagName reports whether a trimmed tag name can be stored. Commas are
+// refused because the library filters take a comma-separated list of names.
+func validTagName(name string) bool {
+	return name != "" && utf8.RuneCountInString(name) <= maxTagNameLength && !strings.Contains(name, ",")
+}
+
+// HandleTags routes requests to /tags (list and create)
+func (h *TagHandler) HandleTags(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.list(w, r)
+	case http.MethodPost:
+		h.create(w, r)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// HandleTag routes requests to /tags/{id} (rename and delete)
+func (h *TagHandler) HandleTag(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPatch:
+		h.rename(w, r)
+	case http.MethodDelete:
+		h.delete(w, r)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// list returns the user's tags with how many tracks and videos carry each
+func (h *TagHandler) list(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	tags, err := h.db.ListTags(r.Context(), userID)
+	if err != nil {
+		log.Printf("Failed to list tags: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to list tags")
+		return
+	}
+
+	response := tagsResponse{Tags: make([]tagResponse, 0, len(tags))}
+	for i := range tags {
+		response.Tags = append(response.Tags, newTagResponse(&tags[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *TagHandler) create(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	var req tagNameRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if !validTagName(name) {
+		writeError(w, http.StatusBadRequest, "name must be 1 to 100 characters without commas")
+		return
+	}
+
+	tag, err := h.db.CreateTag(r.Context(), userID, name)
+	if err != nil {
+		if errors.Is(err, db.ErrTagExists) {
+			writeError(w, http.StatusConflict, "tag already exists")
+			return
+		}
+		log.Printf("Failed to create tag: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to create tag")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newTagResponse(tag))
+}
+
+func (h *TagHandler) rename(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	var req tagNameRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if !validTagName(name) {
+		writeError(w, http.StatusBadRequest, "name must be 1 to 100 characters without commas")
+		return
+	}
+
+	tag, err := h.db.RenameTag(r.Context(), r.PathValue("id"), userID, name)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			writeError(w, http.StatusNotFound, "tag not found")
+		case errors.Is(err, db.ErrTagExists):
+			writeError(w, http.StatusConflict, "tag already exists")
+		default:
+			log.Printf("Failed to rename tag: %v", err)
+			writeError(w, http.StatusInternalServerError, "failed to rename tag")
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newTagResponse(tag))
+}
+
+func (h *TagHandler) delete(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	if err := h.db.DeleteTag(r.Context(), r.PathValue("id"), userID); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "tag not found")
+			return
+		}
+		log.Printf("Failed to delete tag: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to delete tag")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Bulk handles POST /tags/bulk, adding and removing tags by name on many
+// tracks and videos at once. Added tags that don't exist yet are created.
+func (h *TagHandler) Bulk(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	var req bulkTagRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if len(req.Add) == 0 && len(req.Remove) == 0 {
+		writeError(w, http.StatusBadRequest, "add or remove is required")
+		return
+	}
+	if len(req.TrackIDs) == 0 && len(req.VideoIDs) == 0 {
+		writeError(w, http.StatusBadRequest, "track_ids or video_ids is required")
+		return
+	}
+	if len(req.TrackIDs)+len(req.VideoIDs) > maxTaggedItems {
+		writeError(w, http.StatusBadRequest, "at most 1000 items can be tagged at once")
+		return
+	}
+
+	changes := &db.TagChanges{TrackIDs: req.TrackIDs, VideoIDs: req.VideoIDs}
+	for _, names := range []struct {
+		src []string
+		dst *[]string
+	}{
+		{req.Add, &changes.Add},
+		{req.Remove, &changes.Remove},
+	} {
+		for _, name := range names.src {
+			name = strings.TrimSpace(name)
+			if !validTagName(name) {
+				writeError(w, http.StatusBadRequest, "tag names must be 1 to 100 characters without commas")
+				return
+			}
+			*names.dst = append(*names.dst, name)
+		}
+	}
+
+	if err := h.db.ApplyTagChanges(r.Context(), userID, changes); err != nil {
+		log.Printf("Failed to apply tag changes: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to update tags")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,657 @@
+package api
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wpinrui/dovora2/backend/internal/db"
+	"github.com/wpinrui/dovora2/backend/internal/playlistfmt"
+	"github.com/wpinrui/dovora2/backend/internal/takeout"
+	"github.com/wpinrui/dovora2/backend/internal/ytdlp"
+	"github.com/wpinrui/dovora2/backend/internal/ziparchive"
+)
+
+// maxTakeoutImportBytes bounds uploaded takeout archives, which may hold a
+// whole media library
+const maxTakeoutImportBytes = 50 << 30
+
+// mediaExtPattern limits the extensions restored media files may have
+var mediaExtPattern = regexp.MustCompile(`^\.[A-Za-z0-9]{1,5}$`)
+
+// Takeout item statuses
+const (
+	takeoutRestored = "restored"
+	takeoutExisting = "existing"
+	takeoutQueued   = "queued"
+	takeoutSkipped  = "skipped"
+)
+
+type TakeoutHandler struct {
+	db        *db.DB
+	downloads *DownloadHandler
+	mediaDir  string
+}
+
+// NewTakeoutHandler creates a handler that restores media files into
+// mediaDir. Restored files are kept per user, apart from the downloader's
+// shared files.
+func NewTakeoutHandler(database *db.DB, downloads *DownloadHandler, mediaDir string) *TakeoutHandler {
+	return &TakeoutHandler{db: database, downloads: downloads, mediaDir: mediaDir}
+}
+
+type takeoutCounts struct {
+	Restored int `json:"restored"`
+	Existing int `json:"existing"`
+	Queued   int `json:"queued"`
+	Skipped  int `json:"skipped"`
+}
+
+func (c *takeoutCounts) add(status string) {
+	switch status {
+	case takeoutRestored:
+		c.Restored++
+	case takeoutExisting:
+		c.Existing++
+	case takeoutQueued:
+		c.Queued++
+	default:
+		c.Skipped++
+	}
+}
+
+type takeoutImportResponse struct {
+	Tracks    takeoutCounts `json:"tracks"`
+	Videos    takeoutCounts `json:"videos"`
+	Playlists int           `json:"playlists"`
+}
+
+// Export handles GET /account/export?media=true, returning a ZIP with the
+// user's profile, library metadata, playlists and invites in account.json,
+// the schema it follows, and with media=true every media file. The archive
+// is assembled as it's read, so nothing is stored and downloads can resume.
+func (h *TakeoutHandler) Export(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	includeMedia := false
+	if v := r.URL.Query().Get("media"); v != "" {
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "media must be true or false")
+			return
+		}
+		includeMedia = parsed
+	}
+
+	account, files, updatedAt, err := h.buildExport(r.Context(), userID, includeMedia)
+	if err != nil {
+		log.Printf("Failed to build takeout: %v", err)
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	if account == nil {
+		writeError(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	data, err := takeout.Encode(account)
+	if err != nil {
+		log.Printf("Failed to encode takeout: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to build archive")
+		return
+	}
+
+	files = append([]ziparchive.File{
+		{Name: takeout.DataFile, Data: data, ModTime: updatedAt},
+		{Name: takeout.SchemaFile, Data: takeout.Schema, ModTime: updatedAt},
+	}, files...)
+
+	serveZip(w, r, "dovora-takeout.zip", files)
+}
+
+// buildExport gathers a user's account data along with the media files to
+// include, and the time it was last changed. The account is nil if the user
+// doesn't exist.
+func (h *TakeoutHandler) buildExport(ctx context.Context, userID string, includeMedia bool) (*takeout.Account, []ziparchive.File, time.Time, error) {
+	user, err := h.db.GetUserByID(ctx, userID)
+	if err != nil || user == nil {
+		return nil, nil, time.Time{}, err
+	}
+
+	tracks, err := h.db.GetTracksByUserID(ctx, userID)
+	if err != nil {
+		return nil, nil, time.Time{}, fmt.Errorf("get tracks: %w", err)
+	}
+	videos, err := h.db.GetVideosByUserID(ctx, userID)
+	if err != nil {
+		return nil, nil, time.Time{}, fmt.Errorf("get videos: %w", err)
+	}
+	playlists, err := h.db.GetPlaylistsByUserID(ctx, userID)
+	if err != nil {
+		return nil, nil, time.Time{}, fmt.Errorf("get playlists: %w", err)
+	}
+	playlistTracks, err := h.db.GetPlaylistTrackIDs(ctx, userID)
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+	invites, err := h.db.ListInvitesByCreator(ctx, userID)
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+
+	account := takeout.New()
+	account.Profile = takeout.Profile{Email: user.Email, IsAdmin: user.IsAdmin, CreatedAt: user.CreatedAt}
+	updatedAt := user.UpdatedAt
+
+	var files []ziparchive.File
+	mediaFile := func(kind, id, filePath string) string {
+		if !includeMedia {
+			return ""
+		}
+		if _, err := os.Stat(filePath); err != nil {
+			log.Printf("Leaving %s %s out of takeout: %v", kind, id, err)
+			return ""
+		}
+		name := takeout.MediaFile(kind, id, filepath.Ext(filePath))
+		files = append(files, ziparchive.File{Name: name, Path: filePath})
+		return name
+	}
+
+	for _, track := range tracks {
+		account.Tracks = append(account.Tracks, takeout.Track{
+			ID:              track.ID,
+			YoutubeID:       track.YoutubeID,
+			Title:           track.Title,
+			Artist:          track.Artist,
+			Album:           track.Album,
+			ReleaseDate:     track.ReleaseDate,
+			DurationSeconds: track.DurationSeconds,
+			ThumbnailURL:    track.ThumbnailURL,
+			Description:     track.Description,
+			MBRecordingID:   derefString(track.MBRecordingID),
+			MBReleaseID:     derefString(track.MBReleaseID),
+			MBArtistID:      derefString(track.MBArtistID),
+			FileSizeBytes:   track.FileSizeBytes,
+			MediaFile:       mediaFile(string(ytdlp.MediaTypeAudio), track.ID, track.FilePath),
+			CreatedAt:       track.CreatedAt,
+		})
+		updatedAt = latest(updatedAt, track.UpdatedAt)
+	}
+
+	for _, video := range videos {
+		account.Videos = append(account.Videos, takeout.Video{
+			ID:              video.ID,
+			YoutubeID:       video.YoutubeID,
+			Title:           video.Title,
+			Channel:         video.Channel,
+			DurationSeconds: video.DurationSeconds,
+			ThumbnailURL:    video.ThumbnailURL,
+			Description:     video.Description,
+			Quality:         video.Quality,
+			FileSizeBytes:   video.FileSizeBytes,
+			MediaFile:       mediaFile(string(ytdlp.MediaTypeVideo), video.ID, video.FilePath),
+			CreatedAt:       video.CreatedAt,
+		})
+		updatedAt = latest(updatedAt, video.UpdatedAt)
+	}
+
+	for _, playlist := range playlists {
+		// Smart playlists are left out: their rules refer to ratings, tags
+		// and play history that archives don't carry
+		if playlist.Rules != nil {
+			continue
+		}
+		trackIDs := playlistTracks[playlist.ID]
+		if trackIDs == nil {
+			trackIDs = []string{}
+		}
+		account.Playlists = append(account.Playlists, takeout.Playlist{
+			ID:        playlist.ID,
+			Name:      playlist.Name,
+			TrackIDs:  trackIDs,
+			CreatedAt: playlist.CreatedAt,
+			UpdatedAt: playlist.UpdatedAt,
+		})
+		updatedAt = latest(updatedAt, playlist.UpdatedAt)
+	}
+
+	for _, invite := range invites {
+		account.Invites = append(account.Invites, takeout.Invite{
+			Code:      invite.Code,
+			CreatedAt: invite.CreatedAt,
+			UsedAt:    invite.UsedAt,
+			ExpiresAt: invite.ExpiresAt,
+		})
+		updatedAt = latest(updatedAt, invite.CreatedAt)
+	}
+
+	return account, files, updatedAt, nil
+}
+
+// Import handles POST /account/import?download_missing=true. The request body
+// is a takeout archive from any Dovora instance; its tracks, videos and
+// playlists are added to the current user's library. Items the user already
+// has are kept, items without a media file in the archive are queued for
+// download unless download_missing=false, and invites aren't restored since
+// they only grant access to the instance that issued them.
+func (h *TakeoutHandler) Import(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	downloadMissing := true
+	if v := r.URL.Query().Get("download_missing"); v != "" {
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "download_missing must be true or false")
+			return
+		}
+		downloadMissing = parsed
+	}
+
+	// Archives with media take longer to upload and unpack than the
+	// server's timeouts allow
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(time.Time{}); err != nil {
+		log.Printf("Failed to clear read deadline: %v", err)
+	}
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Failed to clear write deadline: %v", err)
+	}
+
+	// The ZIP directory is at the end, so the upload is spooled to disk
+	tmp, err := os.CreateTemp(h.mediaDir, ".takeout-*.zip")
+	if err != nil {
+		log.Printf("Failed to create temp file: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to store upload")
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := io.Copy(tmp, http.MaxBytesReader(w, r.Body, maxTakeoutImportBytes))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "archive too large or unreadable")
+		return
+	}
+
+	archive, err := zip.NewReader(tmp, size)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid archive")
+		return
+	}
+
+	entries := make(map[string]*zip.File, len(archive.File))
+	for _, f := range archive.File {
+		entries[f.Name] = f
+	}
+
+	account, err := readTakeoutAccount(entries[takeout.DataFile])
+	if err != nil {
+		switch {
+		case errors.Is(err, takeout.ErrUnsupportedVersion):
+			writeError(w, http.StatusBadRequest, "archive was exported by a newer version of Dovora")
+		case errors.Is(err, takeout.ErrTooLarge):
+			writeError(w, http.StatusBadRequest, takeout.DataFile+" is too large")
+		default:
+			writeError(w, http.StatusBadRequest, "archive has no valid "+takeout.DataFile)
+		}
+		return
+	}
+
+	response := h.restore(r.Context(), userID, account, entries, downloadMissing)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func readTakeoutAccount(entry *zip.File) (*takeout.Account, error) {
+	if entry == nil {
+		return nil, takeout.ErrUnknownFormat
+	}
+	// Decode stops at the limit too, since the archive states the size
+	if entry.UncompressedSize64 > takeout.MaxDataFileBytes {
+		return nil, takeout.ErrTooLarge
+	}
+
+	rc, err := entry.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return takeout.Decode(rc)
+}
+
+// restore adds an account's items to a user's library. Failures of single
+// items are logged and counted as skipped so the rest still come through.
+func (h *TakeoutHandler) restore(ctx context.Context, userID string, account *takeout.Account, entries map[string]*zip.File, downloadMissing bool) takeoutImportResponse {
+	var response takeoutImportResponse
+
+	// trackIDs maps archive track IDs to library IDs. Tracks that still
+	// have to be downloaded are in missing instead.
+	trackIDs := make(map[string]string)
+	trackStatus := make(map[string]string)
+	missing := make(map[string]string)
+	for i := range account.Tracks {
+		track := &account.Tracks[i]
+		id, status, err := h.restoreTrack(ctx, userID, track, entries[track.MediaFile])
+		switch {
+		case err != nil:
+			log.Printf("Failed to restore track %s: %v", track.YoutubeID, err)
+		case id != "":
+			trackIDs[track.ID] = id
+			trackStatus[track.ID] = status
+		case playlistfmt.IsVideoID(track.YoutubeID):
+			missing[track.ID] = track.YoutubeID
+		}
+	}
+
+	queuedTracks := make(map[string]bool)
+	for _, p := range account.Playlists {
+		// Tracks keep their index in the archive's playlist as their
+		// position, leaving gaps for the downloads queued below
+		var entries []db.PlaylistEntry
+		for position, id := range p.TrackIDs {
+			if libraryID, ok := trackIDs[id]; ok {
+				entries = append(entries, db.PlaylistEntry{TrackID: libraryID, Position: position})
+			}
+		}
+
+		name := p.Name
+		if runes := []rune(name); len(runes) > maxPlaylistNameLength {
+			name = string(runes[:maxPlaylistNameLength])
+		}
+		playlist, err := h.db.CreatePlaylistWithTracks(ctx, userID, name, entries)
+		if err != nil {
+			log.Printf("Failed to restore playlist %q: %v", p.Name, err)
+			continue
+		}
+		response.Playlists++
+
+		if !downloadMissing {
+			continue
+		}
+		// A track in several playlists gets a job per playlist; the queue
+		// downloads it once and adds it to each
+		for position, id := range p.TrackIDs {
+			youtubeID, ok := missing[id]
+			if !ok {
+				continue
+			}
+			position := position
+			if h.queueTakeoutDownload(ctx, &db.DownloadJob{
+				UserID:           userID,
+				YoutubeID:        youtubeID,
+				Type:             string(ytdlp.MediaTypeAudio),
+				PlaylistID:       &playlist.ID,
+				PlaylistPosition: &position,
+			}) {
+				queuedTracks[id] = true
+			}
+		}
+	}
+
+	for i := range account.Tracks {
+		track := &account.Tracks[i]
+		switch {
+		case trackStatus[track.ID] != "":
+			response.Tracks.add(trackStatus[track.ID])
+		case queuedTracks[track.ID]:
+			response.Tracks.add(takeoutQueued)
+		case missing[track.ID] != "" && downloadMissing &&
+			h.queueTakeoutDownload(ctx, &db.DownloadJob{UserID: userID, YoutubeID: track.YoutubeID, Type: string(ytdlp.MediaTypeAudio)}):
+			response.Tracks.add(takeoutQueued)
+		default:
+			response.Tracks.add(takeoutSkipped)
+		}
+	}
+
+	for i := range account.Videos {
+		video := &account.Videos[i]
+		status, err := h.restoreVideo(ctx, userID, video, entries[video.MediaFile])
+		if err != nil {
+			log.Printf("Failed to restore video %s: %v", video.YoutubeID, err)
+			status = takeoutSkipped
+		}
+		if status == "" {
+			status = takeoutSkipped
+			if downloadMissing && playlistfmt.IsVideoID(video.YoutubeID) &&
+				h.queueTakeoutDownload(ctx, &db.DownloadJob{UserID: userID, YoutubeID: video.YoutubeID, Type: string(ytdlp.MediaTypeVideo)}) {
+				status = takeoutQueued
+			}
+		}
+		response.Videos.add(status)
+	}
+
+	return response
+}
+
+// restoreTrack adds an archived track to the library, returning its library
+// ID and whether it was restored or already there. The ID is empty when the
+// archive has no media for a track the user doesn't have.
+func (h *TakeoutHandler) restoreTrack(ctx context.Context, userID string, t *takeout.Track, media *zip.File) (string, string, error) {
+	if !playlistfmt.IsVideoID(t.YoutubeID) {
+		return "", "", fmt.Errorf("invalid youtube id %q", t.YoutubeID)
+	}
+
+	existing, err := h.db.GetTrackByYoutubeID(ctx, userID, t.YoutubeID)
+	if err == nil {
+		return existing.ID, takeoutExisting, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return "", "", err
+	}
+
+	if media == nil {
+		return "", "", nil
+	}
+
+	filePath, size, err := h.extractMedia(ctx, media, userID, ytdlp.MediaTypeAudio, t.YoutubeID)
+	if err != nil {
+		return "", "", err
+	}
+
+	track, err := h.db.CreateTrack(ctx, &db.Track{
+		UserID:          userID,
+		YoutubeID:       t.YoutubeID,
+		Title:           t.Title,
+		Artist:          t.Artist,
+		DurationSeconds: t.DurationSeconds,
+		ThumbnailURL:    t.ThumbnailURL,
+		FilePath:        filePath,
+		FileSizeBytes:   size,
+		Description:     t.Description,
+	})
+	if err != nil {
+		h.discardMedia(filePath)
+		return "", "", fmt.Errorf("create track: %w", err)
+	}
+
+	if t.Album != "" || t.ReleaseDate != "" || t.MBRecordingID != "" {
+		updated, err := h.db.ApplyTrackMetadata(ctx, track.ID, userID, &db.TrackMetadata{
+			Title:         t.Title,
+			Artist:        t.Artist,
+			Album:         t.Album,
+			ReleaseDate:   t.ReleaseDate,
+			MBRecordingID: optionalString(t.MBRecordingID),
+			MBReleaseID:   optionalString(t.MBReleaseID),
+			MBArtistID:    optionalString(t.MBArtistID),
+		})
+		if err != nil {
+			log.Printf("Failed to restore metadata of track %s: %v", track.ID, err)
+		} else {
+			track = updated
+		}
+	}
+
+	linkCatalog(ctx, h.db, track)
+
+	h.downloads.queueProcessing(track.ID, track.FilePath)
+
+	return track.ID, takeoutRestored, nil
+}
+
+// restoreVideo adds an archived video to the library. The status is empty
+// when the archive has no media for a video the user doesn't have.
+func (h *TakeoutHandler) restoreVideo(ctx context.Context, userID string, v *takeout.Video, media *zip.File) (string, error) {
+	if !playlistfmt.IsVideoID(v.YoutubeID) {
+		return "", fmt.Errorf("invalid youtube id %q", v.YoutubeID)
+	}
+
+	_, err := h.db.GetVideoByYoutubeID(ctx, userID, v.YoutubeID)
+	if err == nil {
+		return takeoutExisting, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return "", err
+	}
+
+	if media == nil {
+		return "", nil
+	}
+
+	filePath, size, err := h.extractMedia(ctx, media, userID, ytdlp.MediaTypeVideo, v.YoutubeID)
+	if err != nil {
+		return "", err
+	}
+
+	quality := v.Quality
+	if quality == "" {
+		quality = defaultVideoQuality
+	}
+
+	video, err := h.db.CreateVideo(ctx, &db.Video{
+		UserID:          userID,
+		YoutubeID:       v.YoutubeID,
+		Title:           v.Title,
+		Channel:         v.Channel,
+		DurationSeconds: v.DurationSeconds,
+		ThumbnailURL:    v.ThumbnailURL,
+		FilePath:        filePath,
+		FileSizeBytes:   size,
+		Quality:         quality,
+		Description:     v.Description,
+	})
+	if err != nil {
+		h.discardMedia(filePath)
+		return "", fmt.Errorf("create video: %w", err)
+	}
+
+	h.downloads.processor.EnsureHLS(video.FilePath)
+
+	return takeoutRestored, nil
+}
+
+// extractMedia writes an archived media file into the user's own restored
+// media directory, after checking with ffprobe that it really is audio or
+// video. The archive's contents are the uploader's word alone, so they never
+// go where the downloader shares files between users.
+func (h *TakeoutHandler) extractMedia(ctx context.Context, media *zip.File, userID string, mediaType ytdlp.MediaType, youtubeID string) (string, int64, error) {
+	ext := filepath.Ext(media.Name)
+	if !mediaExtPattern.MatchString(ext) {
+		return "", 0, fmt.Errorf("unexpected media file extension %q", ext)
+	}
+
+	dir := filepath.Join(h.mediaDir, "restored", userID, string(mediaType))
+	filePath := filepath.Join(dir, youtubeID+ext)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", 0, fmt.Errorf("create media directory: %w", err)
+	}
+
+	src, err := media.Open()
+	if err != nil {
+		return "", 0, fmt.Errorf("open archived media: %w", err)
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(dir, ".restore-*"+ext)
+	if err != nil {
+		return "", 0, fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	size, err := io.Copy(tmp, src)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("extract media: %w", err)
+	}
+
+	if err := h.downloads.processor.CheckMedia(ctx, tmp.Name(), mediaType == ytdlp.MediaTypeVideo); err != nil {
+		return "", 0, fmt.Errorf("check restored media: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), filePath); err != nil {
+		return "", 0, fmt.Errorf("move restored media: %w", err)
+	}
+
+	return filePath, size, nil
+}
+
+// discardMedia removes a file extracted for an item that couldn't be added,
+// unless something else uses it, such as a trashed item restored by an
+// earlier import. It uses a fresh context so the file goes even when the
+// request was cancelled.
+func (h *TakeoutHandler) discardMedia(filePath string) {
+	removeUnreferencedFile(context.Background(), h.db, h.downloads.processor, filePath)
+}
+
+// queueTakeoutDownload queues a download, reporting whether it was queued
+func (h *TakeoutHandler) queueTakeoutDownload(ctx context.Context, job *db.DownloadJob) bool {
+	if _, err := h.downloads.QueueDownload(ctx, job); err != nil {
+		log.Printf("Failed to queue download of %s: %v", job.YoutubeID, err)
+		return false
+	}
+	return true
+}
+
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func latest(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/wpinrui/dovora2/backend/internal/db"
+	"github.com/wpinrui/dovora2/backend/internal/media"
+)
+
+// trashPurgeInterval is how often items past the retention period are purged
+const trashPurgeInterval = time.Hour
+
+// TrashHandler serves the trash that deleted library items wait in until
+// they're restored or purged
+type TrashHandler struct {
+	db        *db.DB
+	processor *media.Processor
+	retention time.Duration
+}
+
+// NewTrashHandler creates a trash handler that keeps deleted items for
+// retention before purging them
+func NewTrashHandler(database *db.DB, processor *media.Processor, retention time.Duration) *TrashHandler {
+	return &TrashHandler{db: database, processor: processor, retention: retention}
+}
+
+type trashItemResponse struct {
+	ID        string         `json:"id"`
+	Type      string         `json:"type"` // "audio" or "video"
+	Track     *trackResponse `json:"track,omitempty"`
+	Video     *videoResponse `json:"video,omitempty"`
+	DeletedAt string         `json:"deleted_at,omitempty"`
+	// PurgeAt is when the item is permanently deleted unless restored
+	PurgeAt string `json:"purge_at,omitempty"`
+}
+
+func (h *TrashHandler) newTrashItemResponse(item *db.TrashItem) trashItemResponse {
+	response := trashItemResponse{ID: item.ID}
+	if item.Track != nil {
+		track := newTrackResponse(item.Track)
+		response.Type, response.Track = "audio", &track
+	} else {
+		video := newVideoResponse(item.Video)
+		response.Type, response.Video = "video", &video
+	}
+	if !item.DeletedAt.IsZero() {
+		response.DeletedAt = item.DeletedAt.Format(timeFormatISO8601)
+		response.PurgeAt = item.DeletedAt.Add(h.retention).Format(timeFormatISO8601)
+	}
+	return response
+}
+
+// HandleTrash handles GET /trash, listing trashed items most recently
+// deleted first, and DELETE /trash, emptying the trash
+func (h *TrashHandler) HandleTrash(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		items, err := h.db.ListTrash(r.Context(), userID)
+		if err != nil {
+			log.Printf("Failed to list trash: %v", err)
+			writeError(w, http.StatusInternalServerError, "failed to list trash")
+			return
+		}
+
+		response := make([]trashItemResponse, 0, len(items))
+		for i := range items {
+			response = append(response, h.newTrashItemResponse(&items[i]))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+
+	case http.MethodDelete:
+		filePaths, err := h.db.EmptyTrash(r.Context(), userID)
+		if err != nil {
+			log.Printf("Failed to empty trash: %v", err)
+			writeError(w, http.StatusInternalServerError, "failed to empty trash")
+			return
+		}
+		h.removeFiles(r.Context(), filePaths)
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// DeleteItem handles DELETE /trash/{id}, permanently deleting a trashed item
+// and its file
+func (h *TrashHandler) DeleteItem(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	filePaths, err := h.db.DeleteFromTrash(r.Context(), userID, r.PathValue("id"))
+	if errors.Is(err, db.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "item not found in trash")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete item from trash: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to delete item")
+		return
+	}
+	h.removeFiles(r.Context(), filePaths)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Restore handles POST /trash/{id}/restore, putting a trashed item back in
+// the library with its tags and its places in playlists
+func (h *TrashHandler) Restore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	item, err := h.db.RestoreFromTrash(r.Context(), userID, r.PathValue("id"))
+	if errors.Is(err, db.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "item not found in trash")
+		return
+	}
+	if errors.Is(err, db.ErrRestoreConflict) {
+		writeError(w, http.StatusConflict, "item is already in the library")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to restore item from trash: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to restore item")
+		return
+	}
+
+	// The track's artist or album may have been merged away while it was in
+	// the trash
+	if item.Track != nil {
+		linkCatalog(r.Context(), h.db, item.Track)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.newTrashItemResponse(item))
+}
+
+// RunPurge permanently deletes trashed items once they've been in the trash
+// for the retention period, until ctx is cancelled
+func (h *TrashHandler) RunPurge(ctx context.Context) {
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		filePaths, err := h.db.PurgeTrash(ctx, time.Now().Add(-h.retention))
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Failed to purge trash: %v", err)
+		}
+		// Use a fresh context so purged files are removed even during shutdown
+		h.removeFiles(context.Background(), filePaths)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *TrashHandler) removeFiles(ctx context.Context, filePaths []string) {
+	for _, filePath := range filePaths {
+		removeUnreferencedFile(ctx, h.db, h.processor, filePath)
+	}
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wpinrui/dovora2/backend/internal/db"
+	"github.com/wpinrui/dovora2/backend/internal/media"
+)
+
+type WaveformHandler struct {
+	db        *db.DB
+	processor *media.Processor
+}
+
+func NewWaveformHandler(database *db.DB, processor *media.Processor) *WaveformHandler {
+	return &WaveformHandler{db: database, processor: processor}
+}
+
+type waveformResponse struct {
+	Buckets int   `json:"buckets"`
+	Peaks   []int `json:"peaks"`
+}
+
+// GetWaveform handles GET /tracks/{id}/waveform?format=json|binary.
+// The binary format is one unsigned byte per bucket.
+func (h *WaveformHandler) GetWaveform(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not found in context")
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "binary" {
+		writeError(w, http.StatusBadRequest, "format must be 'json' or 'binary'")
+		return
+	}
+
+	track, err := h.db.GetTrackByID(r.Context(), r.PathValue("id"), userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "track not found")
+			return
+		}
+		log.Printf("Failed to get track: %v", err)
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+
+	peaks, err := h.processor.Waveform(r.Context(), track.FilePath)
+	if err != nil {
+		log.Printf("Failed to generate waveform for track %s: %v", track.ID, err)
+		writeError(w, http.StatusInternalServerError, "failed to generate waveform")
+		return
+	}
+
+	// Peaks only change if the track is re-downloaded
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+
+	if format == "binary" {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(len(peaks)))
+		w.Write(peaks)
+		return
+	}
+
+	response := waveformResponse{
+		Buckets: len(peaks),
+		Peaks:   make([]int, len(peaks)),
+	}
+	for i, p := range peaks {
+		response.Peaks[i] = int(p)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
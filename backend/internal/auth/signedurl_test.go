@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestURLSigner(t *testing.T) {
+	signer := NewURLSigner(testSecret)
+	now := time.Now()
+	expires := now.Add(DefaultSignedURLDuration)
+
+	t.Run("valid signature", func(t *testing.T) {
+		values := signer.Sign("file-1", "user-123", "", expires)
+
+		userID, err := signer.Verify("file-1", values, "203.0.113.7", now)
+		if err != nil {
+			t.Fatalf("Verify() error = %v", err)
+		}
+		if userID != "user-123" {
+			t.Errorf("Verify() userID = %v, want user-123", userID)
+		}
+	})
+
+	tests := []struct {
+		name       string
+		resourceID string
+		mutate     func(values map[string][]string)
+		clientIP   string
+		at         time.Time
+		signer     *URLSigner
+		wantErr    error
+	}{
+		{
+			name:       "different resource",
+			resourceID: "file-2",
+			wantErr:    ErrInvalidSignature,
+		},
+		{
+			name:       "tampered user",
+			resourceID: "file-1",
+			mutate:     func(v map[string][]string) { v[SignedURLUserParam] = []string{"user-456"} },
+			wantErr:    ErrInvalidSignature,
+		},
+		{
+			name:       "extended expiry",
+			resourceID: "file-1",
+			mutate: func(v map[string][]string) {
+				v[SignedURLExpiresParam] = []string{"99999999999"}
+			},
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:       "expired",
+			resourceID: "file-1",
+			at:         expires.Add(time.Second),
+			wantErr:    ErrExpiredSignature,
+		},
+		{
+			name:       "rotated key",
+			resourceID: "file-1",
+			signer:     NewURLSigner("rotated-secret"),
+			wantErr:    ErrInvalidSignature,
+		},
+		{
+			name:       "missing signature",
+			resourceID: "file-1",
+			mutate:     func(v map[string][]string) { delete(v, SignedURLSignatureParam) },
+			wantErr:    ErrInvalidSignature,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values := signer.Sign("file-1", "user-123", "", expires)
+			if tt.mutate != nil {
+				tt.mutate(values)
+			}
+			at := now
+			if !tt.at.IsZero() {
+				at = tt.at
+			}
+			verifier := signer
+			if tt.signer != nil {
+				verifier = tt.signer
+			}
+
+			_, err := verifier.Verify(tt.resourceID, values, tt.clientIP, at)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestURLSigner_IPBinding(t *testing.T) {
+	signer := NewURLSigner(testSecret)
+	now := time.Now()
+	values := signer.Sign("file-1", "user-123", "203.0.113.7", now.Add(time.Minute))
+
+	if _, err := signer.Verify("file-1", values, "203.0.113.7", now); err != nil {
+		t.Errorf("Verify() from bound IP error = %v", err)
+	}
+	if _, err := signer.Verify("file-1", values, "198.51.100.1", now); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Verify() from other IP error = %v, want %v", err, ErrInvalidSignature)
+	}
+
+	// Dropping the binding invalidates the signature
+	values.Del(SignedURLIPParam)
+	if _, err := signer.Verify("file-1", values, "198.51.100.1", now); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Verify() without ip param error = %v, want %v", err, ErrInvalidSignature)
+	}
+}
+
+func TestDeriveURLSigningKey(t *testing.T) {
+	key := DeriveURLSigningKey(testSecret)
+	if key == testSecret || key == "" {
+		t.Errorf("DeriveURLSigningKey() = %q, want a distinct key", key)
+	}
+	if DeriveURLSigningKey(testSecret) != key {
+		t.Error("DeriveURLSigningKey() is not deterministic")
+	}
+}
@@ -0,0 +1,128 @@
+// Package coverart validates uploaded cover art and normalizes it to a
+// bounded JPEG
+package coverart
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif" // registers GIF decoding
+	"image/jpeg"
+	_ "image/png" // registers PNG decoding
+)
+
+const (
+	// MaxDimension is the longest side of processed cover art; larger
+	// images are scaled down to fit
+	MaxDimension = 1200
+
+	// MinDimension is the shortest side accepted
+	MinDimension = 64
+
+	// maxPixels bounds the decoded size so a small file can't expand into
+	// an enormous image
+	maxPixels = 25_000_000
+
+	jpegQuality = 90
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("cover art must be a JPEG, PNG or GIF image")
+	ErrTooSmall          = errors.New("cover art is too small")
+	ErrTooLarge          = errors.New("cover art has too many pixels")
+)
+
+// Process checks that data is a JPEG, PNG or GIF image of acceptable size
+// and returns it as a JPEG no larger than MaxDimension on either side.
+// Transparent areas are filled with white.
+func Process(data []byte) ([]byte, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+	if format != "jpeg" && format != "png" && format != "gif" {
+		return nil, ErrUnsupportedFormat
+	}
+	if config.Width < MinDimension || config.Height < MinDimension {
+		return nil, ErrTooSmall
+	}
+	if config.Width*config.Height > maxPixels {
+		return nil, ErrTooLarge
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decode cover art: %w", err)
+	}
+
+	// Flatten onto white, since JPEG has no transparency
+	bounds := src.Bounds()
+	flat := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), src, bounds.Min, draw.Over)
+
+	width, height := fitWithin(bounds.Dx(), bounds.Dy(), MaxDimension)
+	out := flat
+	if width != bounds.Dx() || height != bounds.Dy() {
+		out = downscale(flat, width, height)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, out, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return nil, fmt.Errorf("encode cover art: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// fitWithin scales width and height down, keeping their ratio, so neither
+// exceeds limit. Sizes already within the limit are returned unchanged.
+func fitWithin(width, height, limit int) (int, int) {
+	if width <= limit && height <= limit {
+		return width, height
+	}
+	if width >= height {
+		return limit, max(1, (height*limit+width/2)/width)
+	}
+	return max(1, (width*limit+height/2)/height), limit
+}
+
+// downscale shrinks src to width by height by averaging the source pixels
+// each destination pixel covers
+func downscale(src *image.RGBA, width, height int) *image.RGBA {
+	srcWidth, srcHeight := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0 := y * srcHeight / height
+		y1 := max(y0+1, (y+1)*srcHeight/height)
+		for x := 0; x < width; x++ {
+			x0 := x * srcWidth / width
+			x1 := max(x0+1, (x+1)*srcWidth/width)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += uint64(p[0])
+					g += uint64(p[1])
+					b += uint64(p[2])
+					a += uint64(p[3])
+					n++
+				}
+			}
+
+			d := dst.Pix[y*dst.Stride+x*4:]
+			d[0] = uint8(r / n)
+			d[1] = uint8(g / n)
+			d[2] = uint8(b / n)
+			d[3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,105 @@
+package coverart
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// encodePNG returns a PNG of the given size filled with c
+func encodePNG(t *testing.T, width, height int, c color.Color) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func decodeJPEG(t *testing.T, data []byte) image.Image {
+	t.Helper()
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("output is not a JPEG: %v", err)
+	}
+	return img
+}
+
+func TestProcess(t *testing.T) {
+	t.Run("keeps small images at their size", func(t *testing.T) {
+		out, err := Process(encodePNG(t, 300, 200, color.RGBA{200, 0, 0, 255}))
+		if err != nil {
+			t.Fatalf("Process: %v", err)
+		}
+		if size := decodeJPEG(t, out).Bounds().Size(); size != image.Pt(300, 200) {
+			t.Errorf("size = %v, want (300,200)", size)
+		}
+	})
+
+	t.Run("scales large images down keeping their ratio", func(t *testing.T) {
+		out, err := Process(encodePNG(t, 2400, 1600, color.RGBA{0, 0, 200, 255}))
+		if err != nil {
+			t.Fatalf("Process: %v", err)
+		}
+		img := decodeJPEG(t, out)
+		if size := img.Bounds().Size(); size != image.Pt(MaxDimension, 800) {
+			t.Errorf("size = %v, want (%d,800)", size, MaxDimension)
+		}
+		if _, _, b, _ := img.At(600, 400).RGBA(); b>>8 < 180 {
+			t.Errorf("center blue = %d, want about 200", b>>8)
+		}
+	})
+
+	t.Run("fills transparency with white", func(t *testing.T) {
+		out, err := Process(encodePNG(t, 100, 100, color.NRGBA{0, 0, 0, 0}))
+		if err != nil {
+			t.Fatalf("Process: %v", err)
+		}
+		if r, g, b, _ := decodeJPEG(t, out).At(50, 50).RGBA(); r>>8 < 250 || g>>8 < 250 || b>>8 < 250 {
+			t.Errorf("color = (%d,%d,%d), want white", r>>8, g>>8, b>>8)
+		}
+	})
+
+	t.Run("rejects tiny images", func(t *testing.T) {
+		_, err := Process(encodePNG(t, 32, 500, color.Black))
+		if !errors.Is(err, ErrTooSmall) {
+			t.Errorf("err = %v, want ErrTooSmall", err)
+		}
+	})
+
+	t.Run("rejects non-images", func(t *testing.T) {
+		_, err := Process([]byte("<svg xmlns=\"http://www.w3.org/2000/svg\"/>"))
+		if !errors.Is(err, ErrUnsupportedFormat) {
+			t.Errorf("err = %v, want ErrUnsupportedFormat", err)
+		}
+	})
+}
+
+func TestFitWithin(t *testing.T) {
+	tests := []struct {
+		width, height, wantWidth, wantHeight int
+	}{
+		{800, 600, 800, 600},
+		{2400, 1200, 1200, 600},
+		{1000, 3000, 400, 1200},
+		{5000, 1, 1200, 1},
+	}
+
+	for _, tt := range tests {
+		width, height := fitWithin(tt.width, tt.height, 1200)
+		if width != tt.wantWidth || height != tt.wantHeight {
+			t.Errorf("fitWithin(%d, %d) = %d, %d, want %d, %d",
+				tt.width, tt.height, width, height, tt.wantWidth, tt.wantHeight)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Library batch operations
+const (
+	BatchDelete        = "delete"
+	BatchUpdate        = "update"
+	BatchAddToPlaylist = "add_to_playlist"
+	BatchTag           = "tag"
+)
+
+// ErrReadOnlyPlaylist is returned when tracks are added to a smart playlist
+var ErrReadOnlyPlaylist = errors.New("playlist is read-only")
+
+// BatchOperation is one change in a library batch. ItemID names a track or
+// video; the other fields used depend on Op.
+type BatchOperation struct {
+	Op     string
+	ItemID string
+
+	// Update replaces a track's title and artist; empty ones are kept
+	Title  string
+	Artist string
+
+	// AddToPlaylist appends a track to the end of a playlist
+	PlaylistID string
+
+	// Tag adds and removes tags by name
+	AddTags    []string
+	RemoveTags []string
+}
+
+// BatchResult is the outcome of one batch operation. Err is ErrNotFound
+// when the item or playlist doesn't exist or belong to the user.
+type BatchResult struct {
+	Err error
+	// Track is set by a successful update
+	Track *Track
+}
+
+// BatchOutcome is the outcome of a library batch
+type BatchOutcome struct {
+	Results []BatchResult
+	// Committed is false when an all-or-nothing batch was rolled back
+	Committed bool
+}
+
+// RunLibraryBatch applies operations to a user's library in one
+// transaction. Each operation runs in its own savepoint, so a failed one
+// is undone on its own; with atomic, any failure rolls back the batch.
+func (db *DB) RunLibraryBatch(ctx context.Context, userID string, ops []BatchOperation, atomic bool) (*BatchOutcome, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	outcome := &BatchOutcome{Results: make([]BatchResult, len(ops))}
+	for i := range ops {
+		savepoint, err := tx.Begin(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("begin savepoint: %w", err)
+		}
+
+		result := runBatchOperation(ctx, savepoint, userID, &ops[i])
+		outcome.Results[i] = result
+		if result.Err != nil {
+			if err := savepoint.Rollback(ctx); err != nil {
+				return nil, fmt.Errorf("roll back savepoint: %w", err)
+			}
+			if atomic {
+				return outcome, nil
+			}
+			continue
+		}
+
+		if err := savepoint.Commit(ctx); err != nil {
+			return nil, fmt.Errorf("release savepoint: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit transaction: %w", err)
+	}
+	outcome.Committed = true
+
+	return outcome, nil
+}
+
+// runBatchOperation applies one operation. Deleted items go to the trash.
+func runBatchOperation(ctx context.Context, tx pgx.Tx, userID string, op *BatchOperation) BatchResult {
+	switch op.Op {
+	case BatchDelete:
+		return BatchResult{Err: moveToTrash(ctx, tx, userID, op.ItemID)}
+
+	case BatchUpdate:
+		track := &Track{}
+		err := scanTrack(tx.QueryRow(ctx, `
+			UPDATE tracks t
+			SET title = COALESCE(NULLIF($3, ''), t.title), artist = COALESCE(NULLIF($4, ''), t.artist), updated_at = NOW()
+			WHERE t.id = $1 AND t.user_id = $2
+			RETURNING `+trackColumns,
+			op.ItemID, userID, op.Title, op.Artist), track)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return BatchResult{Err: ErrNotFound}
+		}
+		if err != nil {
+			return BatchResult{Err: fmt.Errorf("update track: %w", err)}
+		}
+		return BatchResult{Track: track}
+
+	case BatchAddToPlaylist:
+		return BatchResult{Err: batchAddToPlaylist(ctx, tx, userID, op.ItemID, op.PlaylistID)}
+
+	case BatchTag:
+		kind, err := batchItemKind(ctx, tx, userID, op.ItemID)
+		if err != nil {
+			return BatchResult{Err: err}
+		}
+		changes := &TagChanges{Add: op.AddTags, Remove: op.RemoveTags}
+		if kind == SyncTrack {
+			changes.TrackIDs = []string{op.ItemID}
+		} else {
+			changes.VideoIDs = []string{op.ItemID}
+		}
+		return BatchResult{Err: applyTagChanges(ctx, tx, userID, changes)}
+	}
+
+	return BatchResult{Err: fmt.Errorf("unknown batch operation %q", op.Op)}
+}
+
+func batchAddToPlaylist(ctx context.Context, tx pgx.Tx, userID, trackID, playlistID string) error {
+	// Lock the playlist so concurrent additions don't take the same position
+	var smart bool
+	err := tx.QueryRow(ctx, `
+		SELECT rules IS NOT NULL FROM playlists WHERE id = $1 AND user_id = $2 FOR UPDATE
+	`, playlistID, userID).Scan(&smart)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("lock playlist: %w", err)
+	}
+	if smart {
+		return ErrReadOnlyPlaylist
+	}
+
+	var owned bool
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS(SELECT 1 FROM tracks WHERE id = $1 AND user_id = $2)
+	`, trackID, userID).Scan(&owned)
+	if err != nil {
+		return fmt.Errorf("check track: %w", err)
+	}
+	if !owned {
+		return ErrNotFound
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO playlist_tracks (playlist_id, track_id, position)
+		SELECT $1, $2, COALESCE(MAX(position) + 1, 0) FROM playlist_tracks WHERE playlist_id = $1
+		ON CONFLICT (playlist_id, track_id) DO NOTHING
+	`, playlistID, trackID)
+	if err != nil {
+		return fmt.Errorf("add playlist track: %w", err)
+	}
+
+	return nil
+}
+
+// batchItemKind reports whether an item is one of the user's tracks or
+// videos, as SyncTrack or SyncVideo
+func batchItemKind(ctx context.Context, tx pgx.Tx, userID, itemID string) (string, error) {
+	var kind string
+	err := tx.QueryRow(ctx, `
+		SELECT 'track' FROM tracks WHERE id = $1 AND user_id = $2
+		UNION ALL
+		SELECT 'video' FROM videos WHERE id = $1 AND user_id = $2
+	`, itemID, userID).Scan(&kind)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("find item: %w", err)
+	}
+
+	return kind, nil
+}
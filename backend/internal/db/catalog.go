@@ -0,0 +1,426 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// artistColumns lists the columns scanned by scanArtist. The aggregates need
+// tracks joined as t and the query grouped by ar.id.
+const artistColumns = `ar.id, ar.user_id, ar.name,
+	COUNT(t.id), COUNT(DISTINCT t.album_id), COALESCE(SUM(t.duration_seconds), 0),
+	COALESCE((ARRAY_AGG(t.thumbnail_url ORDER BY t.created_at DESC) FILTER (WHERE t.thumbnail_url <> ''))[1], ''),
+	ar.created_at, ar.updated_at`
+
+// albumColumns lists the columns scanned by scanAlbum. The aggregates need
+// the album's artist joined as ar, tracks joined as t and the query grouped
+// by al.id and ar.id.
+const albumColumns = `al.id, al.user_id, al.artist_id, ar.name, al.title, al.release_date,
+	COUNT(t.id), COALESCE(SUM(t.duration_seconds), 0),
+	COALESCE((ARRAY_AGG(t.thumbnail_url ORDER BY t.created_at) FILTER (WHERE t.thumbnail_url <> ''))[1], ''),
+	al.created_at, al.updated_at`
+
+// Artist is a catalog entry grouping the tracks credited to one artist,
+// with totals over those tracks
+type Artist struct {
+	ID                   string
+	UserID               string
+	Name                 string
+	TrackCount           int
+	AlbumCount           int
+	TotalDurationSeconds int
+	ArtworkURL           string
+	CreatedAt            time.Time
+	UpdatedAt            time.Time
+}
+
+// Album is a catalog entry grouping an artist's tracks from one release,
+// with totals over those tracks
+type Album struct {
+	ID                   string
+	UserID               string
+	ArtistID             string
+	ArtistName           string
+	Title                string
+	ReleaseDate          string
+	TrackCount           int
+	TotalDurationSeconds int
+	ArtworkURL           string
+	CreatedAt            time.Time
+	UpdatedAt            time.Time
+}
+
+// ArtistWithTracks is an artist with its albums and all its tracks
+type ArtistWithTracks struct {
+	Artist
+	Albums []Album
+	Tracks []Track
+}
+
+// AlbumWithTracks is an album with its tracks
+type AlbumWithTracks struct {
+	Album
+	Tracks []Track
+}
+
+// CatalogLink names the artist and album a track belongs to. The keys are
+// the normalized names entries are matched on; an empty artist key leaves
+// the track unlinked and an empty album key links the artist only.
+type CatalogLink struct {
+	ArtistName  string
+	ArtistKey   string
+	AlbumTitle  string
+	AlbumKey    string
+	ReleaseDate string
+}
+
+func scanArtist(row pgx.Row, artist *Artist) error {
+	return row.Scan(
+		&artist.ID,
+		&artist.UserID,
+		&artist.Name,
+		&artist.TrackCount,
+		&artist.AlbumCount,
+		&artist.TotalDurationSeconds,
+		&artist.ArtworkURL,
+		&artist.CreatedAt,
+		&artist.UpdatedAt,
+	)
+}
+
+func scanAlbum(row pgx.Row, album *Album) error {
+	return row.Scan(
+		&album.ID,
+		&album.UserID,
+		&album.ArtistID,
+		&album.ArtistName,
+		&album.Title,
+		&album.ReleaseDate,
+		&album.TrackCount,
+		&album.TotalDurationSeconds,
+		&album.ArtworkURL,
+		&album.CreatedAt,
+		&album.UpdatedAt,
+	)
+}
+
+// LinkTrackCatalog links a track to its artist and album, creating the
+// entries the first time a name is seen. Artists are found through their
+// aliases, so a track credited to a merged artist links to the survivor.
+func (db *DB) LinkTrackCatalog(ctx context.Context, trackID, userID string, link *CatalogLink) (artistID, albumID *string, err error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if link.ArtistKey != "" {
+		// Lock the user so concurrent downloads don't create the same artist twice
+		if _, err := tx.Exec(ctx, `SELECT id FROM users WHERE id = $1 FOR UPDATE`, userID); err != nil {
+			return nil, nil, fmt.Errorf("lock user: %w", err)
+		}
+
+		var id string
+		err = tx.QueryRow(ctx, `
+			SELECT artist_id FROM artist_aliases WHERE user_id = $1 AND normalized_name = $2
+		`, userID, link.ArtistKey).Scan(&id)
+		if errors.Is(err, pgx.ErrNoRows) {
+			err = tx.QueryRow(ctx, `
+				INSERT INTO artists (user_id, name) VALUES ($1, $2) RETURNING id
+			`, userID, link.ArtistName).Scan(&id)
+			if err != nil {
+				return nil, nil, fmt.Errorf("create artist: %w", err)
+			}
+			_, err = tx.Exec(ctx, `
+				INSERT INTO artist_aliases (user_id, normalized_name, artist_id) VALUES ($1, $2, $3)
+			`, userID, link.ArtistKey, id)
+			if err != nil {
+				return nil, nil, fmt.Errorf("create artist alias: %w", err)
+			}
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("get artist alias: %w", err)
+		}
+		artistID = &id
+	}
+
+	if artistID != nil && link.AlbumKey != "" {
+		// The first release date seen for an album sticks
+		var id string
+		err = tx.QueryRow(ctx, `
+			INSERT INTO albums (user_id, artist_id, title, normalized_title, release_date)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (user_id, artist_id, normalized_title) DO UPDATE SET
+				release_date = CASE WHEN albums.release_date = '' THEN EXCLUDED.release_date ELSE albums.release_date END
+			RETURNING id
+		`, userID, *artistID, link.AlbumTitle, link.AlbumKey, link.ReleaseDate).Scan(&id)
+		if err != nil {
+			return nil, nil, fmt.Errorf("upsert album: %w", err)
+		}
+		albumID = &id
+	}
+
+	result, err := tx.Exec(ctx, `
+		UPDATE tracks SET artist_id = $3, album_id = $4
+		WHERE id = $1 AND user_id = $2
+	`, trackID, userID, artistID, albumID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("link track: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return nil, nil, ErrNotFound
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, nil, fmt.Errorf("commit transaction: %w", err)
+	}
+
+	return artistID, albumID, nil
+}
+
+// GetUncataloguedTracks returns credited tracks not yet linked to an artist,
+// such as those downloaded before the catalog existed
+func (db *DB) GetUncataloguedTracks(ctx context.Context) ([]Track, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT `+trackColumns+`
+		FROM tracks t
+		WHERE t.artist_id IS NULL AND t.artist <> ''
+		ORDER BY t.created_at
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("get uncatalogued tracks: %w", err)
+	}
+	defer rows.Close()
+
+	var tracks []Track
+	for rows.Next() {
+		var track Track
+		if err := scanTrack(rows, &track); err != nil {
+			return nil, fmt.Errorf("scan track: %w", err)
+		}
+		tracks = append(tracks, track)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate tracks: %w", err)
+	}
+
+	return tracks, nil
+}
+
+// ListArtists returns a user's artists that have tracks, ordered by name
+func (db *DB) ListArtists(ctx context.Context, userID string) ([]Artist, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT `+artistColumns+`
+		FROM artists ar
+		INNER JOIN tracks t ON t.artist_id = ar.id
+		WHERE ar.user_id = $1
+		GROUP BY ar.id
+		ORDER BY lower(ar.name), ar.id
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list artists: %w", err)
+	}
+	defer rows.Close()
+
+	var artists []Artist
+	for rows.Next() {
+		var artist Artist
+		if err := scanArtist(rows, &artist); err != nil {
+			return nil, fmt.Errorf("scan artist: %w", err)
+		}
+		artists = append(artists, artist)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate artists: %w", err)
+	}
+
+	return artists, nil
+}
+
+// GetArtistWithTracks returns an artist with its albums, oldest release
+// first, and its tracks grouped by album
+func (db *DB) GetArtistWithTracks(ctx context.Context, artistID, userID string) (*ArtistWithTracks, error) {
+	artist := &ArtistWithTracks{}
+	err := scanArtist(db.Pool.QueryRow(ctx, `
+		SELECT `+artistColumns+`
+		FROM artists ar
+		LEFT JOIN tracks t ON t.artist_id = ar.id
+		WHERE ar.id = $1 AND ar.user_id = $2
+		GROUP BY ar.id
+	`, artistID, userID), &artist.Artist)
+	if err != nil {
+		return nil, fmt.Errorf("get artist: %w", err)
+	}
+
+	albumRows, err := db.Pool.Query(ctx, `
+		SELECT `+albumColumns+`
+		FROM albums al
+		INNER JOIN artists ar ON ar.id = al.artist_id
+		INNER JOIN tracks t ON t.album_id = al.id
+		WHERE al.artist_id = $1
+		GROUP BY al.id, ar.id
+		ORDER BY al.release_date = '', al.release_date, lower(al.title)
+	`, artistID)
+	if err != nil {
+		return nil, fmt.Errorf("get artist albums: %w", err)
+	}
+	defer albumRows.Close()
+
+	for albumRows.Next() {
+		var album Album
+		if err := scanAlbum(albumRows, &album); err != nil {
+			return nil, fmt.Errorf("scan album: %w", err)
+		}
+		artist.Albums = append(artist.Albums, album)
+	}
+	if err := albumRows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate albums: %w", err)
+	}
+
+	// Tracks without an album come last
+	artist.Tracks, err = db.queryCatalogTracks(ctx, `
+		SELECT `+trackColumns+`
+		FROM tracks t
+		LEFT JOIN albums al ON al.id = t.album_id
+		WHERE t.artist_id = $1
+		ORDER BY al.id IS NULL, al.release_date = '', al.release_date, lower(al.title), t.created_at
+	`, artistID)
+	if err != nil {
+		return nil, err
+	}
+
+	return artist, nil
+}
+
+// GetAlbumWithTracks returns an album with its tracks in the order they
+// were added
+func (db *DB) GetAlbumWithTracks(ctx context.Context, albumID, userID string) (*AlbumWithTracks, error) {
+	album := &AlbumWithTracks{}
+	err := scanAlbum(db.Pool.QueryRow(ctx, `
+		SELECT `+albumColumns+`
+		FROM albums al
+		INNER JOIN artists ar ON ar.id = al.artist_id
+		LEFT JOIN tracks t ON t.album_id = al.id
+		WHERE al.id = $1 AND al.user_id = $2
+		GROUP BY al.id, ar.id
+	`, albumID, userID), &album.Album)
+	if err != nil {
+		return nil, fmt.Errorf("get album: %w", err)
+	}
+
+	album.Tracks, err = db.queryCatalogTracks(ctx, `
+		SELECT `+trackColumns+`
+		FROM tracks t
+		WHERE t.album_id = $1
+		ORDER BY t.created_at
+	`, albumID)
+	if err != nil {
+		return nil, err
+	}
+
+	return album, nil
+}
+
+func (db *DB) queryCatalogTracks(ctx context.Context, query string, args ...interface{}) ([]Track, error) {
+	rows, err := db.Pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("get tracks: %w", err)
+	}
+	defer rows.Close()
+
+	var tracks []Track
+	for rows.Next() {
+		var track Track
+		if err := scanTrack(rows, &track); err != nil {
+			return nil, fmt.Errorf("scan track: %w", err)
+		}
+		tracks = append(tracks, track)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate tracks: %w", err)
+	}
+
+	return tracks, nil
+}
+
+// MergeArtists folds the merged artists into the kept one. Their tracks,
+// albums and aliases move to the kept artist; albums it already has a
+// same-titled entry for are combined with that entry.
+func (db *DB) MergeArtists(ctx context.Context, userID, keepID string, mergeIDs []string) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `SELECT id FROM users WHERE id = $1 FOR UPDATE`, userID); err != nil {
+		return fmt.Errorf("lock user: %w", err)
+	}
+
+	// Every artist involved must belong to the user
+	var owned int
+	err = tx.QueryRow(ctx, `
+		SELECT COUNT(*) FROM artists
+		WHERE user_id = $1 AND (id = $2 OR id = ANY($3))
+	`, userID, keepID, mergeIDs).Scan(&owned)
+	if err != nil {
+		return fmt.Errorf("verify artist ownership: %w", err)
+	}
+	if owned != len(mergeIDs)+1 {
+		return ErrNotFound
+	}
+
+	// Artists are merged one at a time so two of them holding the same album
+	// can't both be moved onto the kept artist
+	for _, mergeID := range mergeIDs {
+		_, err = tx.Exec(ctx, `
+			UPDATE tracks t SET album_id = keep.id
+			FROM albums merged, albums keep
+			WHERE t.album_id = merged.id AND merged.artist_id = $2
+			  AND keep.artist_id = $1 AND keep.normalized_title = merged.normalized_title
+		`, keepID, mergeID)
+		if err != nil {
+			return fmt.Errorf("combine albums: %w", err)
+		}
+
+		_, err = tx.Exec(ctx, `
+			UPDATE albums SET artist_id = $1, updated_at = NOW()
+			WHERE artist_id = $2 AND normalized_title NOT IN (
+				SELECT normalized_title FROM albums WHERE artist_id = $1
+			)
+		`, keepID, mergeID)
+		if err != nil {
+			return fmt.Errorf("move albums: %w", err)
+		}
+
+		if _, err := tx.Exec(ctx, `UPDATE tracks SET artist_id = $1 WHERE artist_id = $2`, keepID, mergeID); err != nil {
+			return fmt.Errorf("move tracks: %w", err)
+		}
+
+		if _, err := tx.Exec(ctx, `UPDATE artist_aliases SET artist_id = $1 WHERE artist_id = $2`, keepID, mergeID); err != nil {
+			return fmt.Errorf("move aliases: %w", err)
+		}
+
+		// Albums left behind were combined above and are now empty
+		if _, err := tx.Exec(ctx, `DELETE FROM artists WHERE id = $1`, mergeID); err != nil {
+			return fmt.Errorf("delete merged artist: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE artists SET updated_at = NOW() WHERE id = $1`, keepID)
+	if err != nil {
+		return fmt.Errorf("touch artist: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"regexp"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -12,6 +13,14 @@ import (
 // ErrNotFound is returned when a requested resource doesn't exist
 var ErrNotFound = errors.New("not found")
 
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// IsUUID reports whether s is a UUID in its usual hyphenated form, as
+// stored in UUID columns
+func IsUUID(s string) bool {
+	return uuidPattern.MatchString(s)
+}
+
 type DB struct {
 	Pool *pgxpool.Pool
 }
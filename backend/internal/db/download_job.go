@@ -0,0 +1,177 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Download job statuses
+const (
+	DownloadJobPending = "pending"
+	DownloadJobRunning = "running"
+	DownloadJobDone    = "done"
+	DownloadJobFailed  = "failed"
+)
+
+const downloadJobColumns = `id, user_id, youtube_id, type, playlist_id, playlist_position, status,
+	attempts, error, item_id, created_at, updated_at`
+
+// DownloadJob is a download queued for the background worker. When PlaylistID
+// is set, the downloaded track is added to that playlist at PlaylistPosition,
+// its index in the file or archive the playlist was made from.
+type DownloadJob struct {
+	ID               string
+	UserID           string
+	YoutubeID        string
+	Type             string
+	PlaylistID       *string
+	PlaylistPosition *int
+	Status           string
+	Attempts         int
+	Error            string
+	ItemID           *string
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+func scanDownloadJob(row pgx.Row, job *DownloadJob) error {
+	return row.Scan(
+		&job.ID,
+		&job.UserID,
+		&job.YoutubeID,
+		&job.Type,
+		&job.PlaylistID,
+		&job.PlaylistPosition,
+		&job.Status,
+		&job.Attempts,
+		&job.Error,
+		&job.ItemID,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+	)
+}
+
+// EnqueueDownload adds a download to the queue
+func (db *DB) EnqueueDownload(ctx context.Context, job *DownloadJob) (*DownloadJob, error) {
+	query := `
+		INSERT INTO download_jobs (user_id, youtube_id, type, playlist_id, playlist_position)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING ` + downloadJobColumns
+
+	queued := &DownloadJob{}
+	err := scanDownloadJob(db.Pool.QueryRow(ctx, query,
+		job.UserID, job.YoutubeID, job.Type, job.PlaylistID, job.PlaylistPosition,
+	), queued)
+	if err != nil {
+		return nil, fmt.Errorf("enqueue download: %w", err)
+	}
+
+	return queued, nil
+}
+
+// ClaimDownloadJob marks the oldest runnable job as running and returns it.
+// SKIP LOCKED lets several workers claim jobs concurrently. Returns
+// ErrNotFound when the queue is empty.
+func (db *DB) ClaimDownloadJob(ctx context.Context) (*DownloadJob, error) {
+	query := `
+		UPDATE download_jobs
+		SET status = 'running', attempts = attempts + 1, updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM download_jobs
+			WHERE status = 'pending' AND run_after <= NOW()
+			ORDER BY run_after, created_at
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING ` + downloadJobColumns
+
+	job := &DownloadJob{}
+	if err := scanDownloadJob(db.Pool.QueryRow(ctx, query), job); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("claim download job: %w", err)
+	}
+
+	return job, nil
+}
+
+// CompleteDownloadJob records the library item a job produced
+func (db *DB) CompleteDownloadJob(ctx context.Context, jobID, itemID string) error {
+	_, err := db.Pool.Exec(ctx, `
+		UPDATE download_jobs
+		SET status = 'done', item_id = $2, error = '', updated_at = NOW()
+		WHERE id = $1
+	`, jobID, itemID)
+	if err != nil {
+		return fmt.Errorf("complete download job: %w", err)
+	}
+	return nil
+}
+
+// FailDownloadJob records a failed attempt. If retryAt is set the job goes
+// back to the queue to run again after it, otherwise it's marked failed.
+func (db *DB) FailDownloadJob(ctx context.Context, jobID, message string, retryAt *time.Time) error {
+	status := DownloadJobFailed
+	if retryAt != nil {
+		status = DownloadJobPending
+	}
+
+	_, err := db.Pool.Exec(ctx, `
+		UPDATE download_jobs
+		SET status = $2, error = $3, run_after = COALESCE($4, run_after), updated_at = NOW()
+		WHERE id = $1
+	`, jobID, status, message, retryAt)
+	if err != nil {
+		return fmt.Errorf("fail download job: %w", err)
+	}
+	return nil
+}
+
+// ResetRunningDownloadJobs requeues jobs left running by a previous process
+func (db *DB) ResetRunningDownloadJobs(ctx context.Context) error {
+	_, err := db.Pool.Exec(ctx, `
+		UPDATE download_jobs
+		SET status = 'pending', updated_at = NOW()
+		WHERE status = 'running'
+	`)
+	if err != nil {
+		return fmt.Errorf("reset running download jobs: %w", err)
+	}
+	return nil
+}
+
+// ListDownloadJobs returns a user's download jobs, most recent first
+func (db *DB) ListDownloadJobs(ctx context.Context, userID string) ([]DownloadJob, error) {
+	query := `
+		SELECT ` + downloadJobColumns + `
+		FROM download_jobs
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`
+
+	rows, err := db.Pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list download jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []DownloadJob
+	for rows.Next() {
+		var job DownloadJob
+		if err := scanDownloadJob(rows, &job); err != nil {
+			return nil, fmt.Errorf("scan download job: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate download jobs: %w", err)
+	}
+
+	return jobs, nil
+}
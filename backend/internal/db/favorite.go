@@ -0,0 +1,119 @@
+package db
+
+import (
+	"context"
+	"fmt"
+)
+
+// FavoritesPlaylistID identifies the virtual playlist of a user's favorite
+// tracks. It isn't a UUID, so it can't collide with a stored playlist.
+const FavoritesPlaylistID = "favorites"
+
+// favoritesPlaylistName is how the favorites playlist is listed
+const favoritesPlaylistName = "Favorites"
+
+// Preferences changes how a user regards a track or video. Nil fields are
+// left unchanged.
+type Preferences struct {
+	Favorite *bool
+	Rating   *int
+}
+
+// preferencesSet is the SET list applying Preferences passed as $3 and $4 to
+// the item aliased a. Favoriting again keeps the original time.
+const preferencesSet = `
+	favorited_at = CASE
+		WHEN $3::boolean IS NULL THEN %[1]s.favorited_at
+		WHEN $3 THEN COALESCE(%[1]s.favorited_at, NOW())
+		ELSE NULL
+	END,
+	rating = COALESCE($4::smallint, %[1]s.rating),
+	updated_at = NOW()`
+
+// SetTrackPreferences favorites, unfavorites or rates a track for a specific user
+func (db *DB) SetTrackPreferences(ctx context.Context, trackID, userID string, prefs *Preferences) (*Track, error) {
+	query := `
+		UPDATE tracks t
+		SET ` + fmt.Sprintf(preferencesSet, "t") + `
+		WHERE t.id = $1 AND t.user_id = $2
+		RETURNING ` + trackColumns
+
+	track := &Track{}
+	err := scanTrack(db.Pool.QueryRow(ctx, query, trackID, userID, prefs.Favorite, prefs.Rating), track)
+	if err != nil {
+		return nil, fmt.Errorf("set track preferences: %w", err)
+	}
+
+	return track, nil
+}
+
+// SetVideoPreferences favorites, unfavorites or rates a video for a specific user
+func (db *DB) SetVideoPreferences(ctx context.Context, videoID, userID string, prefs *Preferences) (*Video, error) {
+	query := `
+		UPDATE videos v
+		SET ` + fmt.Sprintf(preferencesSet, "v") + `
+		WHERE v.id = $1 AND v.user_id = $2
+		RETURNING ` + videoColumns
+
+	video := &Video{}
+	err := scanVideo(db.Pool.QueryRow(ctx, query, videoID, userID, prefs.Favorite, prefs.Rating), video)
+	if err != nil {
+		return nil, fmt.Errorf("set video preferences: %w", err)
+	}
+
+	return video, nil
+}
+
+// GetFavoritesPlaylist returns the virtual playlist of a user's favorite
+// tracks. It dates from the user's sign-up and was last updated when a
+// track was last favorited.
+func (db *DB) GetFavoritesPlaylist(ctx context.Context, userID string) (*Playlist, error) {
+	playlist := &Playlist{ID: FavoritesPlaylistID, UserID: userID, Name: favoritesPlaylistName, Virtual: true}
+	err := db.Pool.QueryRow(ctx, `
+		SELECT u.created_at, COALESCE(MAX(t.favorited_at), u.created_at)
+		FROM users u
+		LEFT JOIN tracks t ON t.user_id = u.id AND t.favorited_at IS NOT NULL
+		WHERE u.id = $1
+		GROUP BY u.id
+	`, userID).Scan(&playlist.CreatedAt, &playlist.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("get favorites playlist: %w", err)
+	}
+
+	return playlist, nil
+}
+
+// getFavoritesPlaylistWithTracks returns the favorites playlist with the
+// most recently favorited tracks first
+func (db *DB) getFavoritesPlaylistWithTracks(ctx context.Context, userID string) (*PlaylistWithTracks, error) {
+	playlist, err := db.GetFavoritesPlaylist(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Pool.Query(ctx, `
+		SELECT `+trackColumns+`
+		FROM tracks t
+		WHERE t.user_id = $1 AND t.favorited_at IS NOT NULL
+		ORDER BY t.favorited_at DESC, t.id
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("get favorite tracks: %w", err)
+	}
+	defer rows.Close()
+
+	var tracks []Track
+	for rows.Next() {
+		var track Track
+		if err := scanTrack(rows, &track); err != nil {
+			return nil, fmt.Errorf("scan track: %w", err)
+		}
+		tracks = append(tracks, track)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate favorite tracks: %w", err)
+	}
+
+	return &PlaylistWithTracks{Playlist: *playlist, Tracks: tracks}, nil
+}
@@ -0,0 +1,203 @@
+package db
+
+import (
+	"context"
+	"fmt"
+)
+
+// TrackFingerprint is a track's stored Chromaprint fingerprint
+type TrackFingerprint struct {
+	TrackID     string
+	Fingerprint []byte
+}
+
+// SetTrackFingerprint stores the fingerprint computed for a track
+func (db *DB) SetTrackFingerprint(ctx context.Context, trackID string, fingerprint []byte) error {
+	result, err := db.Pool.Exec(ctx, `
+		UPDATE tracks SET fingerprint = $2 WHERE id = $1
+	`, trackID, fingerprint)
+	if err != nil {
+		return fmt.Errorf("set track fingerprint: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetTrackFingerprints returns the fingerprints of all fingerprinted tracks for a user
+func (db *DB) GetTrackFingerprints(ctx context.Context, userID string) ([]TrackFingerprint, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT id, fingerprint
+		FROM tracks
+		WHERE user_id = $1 AND fingerprint IS NOT NULL
+		ORDER BY created_at ASC
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("get track fingerprints: %w", err)
+	}
+	defer rows.Close()
+
+	var fingerprints []TrackFingerprint
+	for rows.Next() {
+		var fp TrackFingerprint
+		if err := rows.Scan(&fp.TrackID, &fp.Fingerprint); err != nil {
+			return nil, fmt.Errorf("scan track fingerprint: %w", err)
+		}
+		fingerprints = append(fingerprints, fp)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate track fingerprints: %w", err)
+	}
+
+	return fingerprints, nil
+}
+
+// GetUnfingerprintedTracks returns the tracks, across all users, that have no fingerprint yet
+func (db *DB) GetUnfingerprintedTracks(ctx context.Context) ([]Track, error) {
+	query := `
+		SELECT ` + trackColumns + `
+		FROM tracks t
+		WHERE t.fingerprint IS NULL
+		ORDER BY t.created_at ASC
+	`
+
+	rows, err := db.Pool.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("get unfingerprinted tracks: %w", err)
+	}
+	defer rows.Close()
+
+	var tracks []Track
+	for rows.Next() {
+		var track Track
+		if err := scanTrack(rows, &track); err != nil {
+			return nil, fmt.Errorf("scan track: %w", err)
+		}
+		tracks = append(tracks, track)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate tracks: %w", err)
+	}
+
+	return tracks, nil
+}
+
+// MergeTracks folds duplicate tracks into the track being kept. Playlist
+// entries that point at a merged track are repointed at the kept track; where
+// that would list the kept track twice in one playlist, only its earliest
+// entry survives. The merged tracks are then deleted and the paths of their
+// media files and cover art returned.
+func (db *DB) MergeTracks(ctx context.Context, userID, keepID string, mergeIDs []string) ([]string, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Every track involved must belong to the user
+	var owned int
+	err = tx.QueryRow(ctx, `
+		SELECT COUNT(*) FROM tracks
+		WHERE user_id = $1 AND (id = $2 OR id = ANY($3))
+	`, userID, keepID, mergeIDs).Scan(&owned)
+	if err != nil {
+		return nil, fmt.Errorf("verify track ownership: %w", err)
+	}
+	if owned != len(mergeIDs)+1 {
+		return nil, ErrNotFound
+	}
+
+	// Keep one entry per playlist: the kept track's own entry if it has one,
+	// otherwise the earliest-positioned duplicate
+	_, err = tx.Exec(ctx, `
+		DELETE FROM playlist_tracks pt
+		USING (
+			SELECT id, ROW_NUMBER() OVER (
+				PARTITION BY playlist_id
+				ORDER BY (track_id = $1) DESC, position ASC
+			) AS rank
+			FROM playlist_tracks
+			WHERE track_id = $1 OR track_id = ANY($2)
+		) ranked
+		WHERE pt.id = ranked.id AND ranked.rank > 1
+	`, keepID, mergeIDs)
+	if err != nil {
+		return nil, fmt.Errorf("remove duplicate playlist entries: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE playlist_tracks SET track_id = $1 WHERE track_id = ANY($2)
+	`, keepID, mergeIDs)
+	if err != nil {
+		return nil, fmt.Errorf("repoint playlist entries: %w", err)
+	}
+
+	// The kept track inherits the duplicates' listening history
+	_, err = tx.Exec(ctx, `
+		UPDATE play_history SET track_id = $1 WHERE track_id = ANY($2)
+	`, keepID, mergeIDs)
+	if err != nil {
+		return nil, fmt.Errorf("repoint play history: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE tracks SET play_count = play_count + (
+			SELECT COALESCE(SUM(play_count), 0) FROM tracks WHERE id = ANY($2)
+		)
+		WHERE id = $1
+	`, keepID, mergeIDs)
+	if err != nil {
+		return nil, fmt.Errorf("combine play counts: %w", err)
+	}
+
+	rows, err := tx.Query(ctx, `
+		DELETE FROM tracks WHERE user_id = $1 AND id = ANY($2)
+		RETURNING file_path, cover_path
+	`, userID, mergeIDs)
+	if err != nil {
+		return nil, fmt.Errorf("delete merged tracks: %w", err)
+	}
+
+	var filePaths []string
+	for rows.Next() {
+		var filePath string
+		var coverPath *string
+		if err := rows.Scan(&filePath, &coverPath); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan file paths: %w", err)
+		}
+		filePaths = append(filePaths, filePath)
+		if coverPath != nil {
+			filePaths = append(filePaths, *coverPath)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate deleted tracks: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit transaction: %w", err)
+	}
+
+	return filePaths, nil
+}
+
+// IsFileReferenced reports whether any track, video or trashed item still
+// points at a media or cover art file. Downloads are stored by YouTube ID,
+// so several library items can share one file.
+func (db *DB) IsFileReferenced(ctx context.Context, filePath string) (bool, error) {
+	var referenced bool
+	err := db.Pool.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM tracks WHERE file_path = $1 OR cover_path = $1)
+		    OR EXISTS (SELECT 1 FROM videos WHERE file_path = $1 OR cover_path = $1)
+		    OR EXISTS (SELECT 1 FROM trash WHERE file_path = $1 OR item->>'cover_path' = $1)
+	`, filePath).Scan(&referenced)
+	if err != nil {
+		return false, fmt.Errorf("check file references: %w", err)
+	}
+	return referenced, nil
+}
@@ -0,0 +1,356 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Import job statuses
+const (
+	ImportJobMatching = "matching"
+	ImportJobReview   = "review"
+	ImportJobDone     = "done"
+)
+
+// Import row statuses
+const (
+	ImportRowPending = "pending"
+	ImportRowMatched = "matched"
+	ImportRowReview  = "review"
+	ImportRowSkipped = "skipped"
+)
+
+// importJobQuery selects jobs along with how many of their rows are in each status
+const importJobQuery = `
+	SELECT j.id, j.user_id, j.name, j.playlist_id, j.status, j.created_at, j.updated_at,
+		COUNT(r.position),
+		COUNT(r.position) FILTER (WHERE r.status = 'pending'),
+		COUNT(r.position) FILTER (WHERE r.status = 'matched'),
+		COUNT(r.position) FILTER (WHERE r.status = 'review'),
+		COUNT(r.position) FILTER (WHERE r.status = 'skipped')
+	FROM import_jobs j
+	LEFT JOIN import_rows r ON r.job_id = j.id
+`
+
+const importRowColumns = `job_id, position, title, artist, album, duration_seconds, status,
+	youtube_id, score, candidates, download_job_id, updated_at`
+
+// ImportJob is a playlist exported from another service being matched
+// against YouTube. Matched rows are downloaded into PlaylistID.
+type ImportJob struct {
+	ID         string
+	UserID     string
+	Name       string
+	PlaylistID *string
+	Status     string
+	Total      int
+	Pending    int
+	Matched    int
+	Review     int
+	Skipped    int
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// ImportCandidate is a YouTube video that may match an import row
+type ImportCandidate struct {
+	VideoID         string  `json:"video_id"`
+	Title           string  `json:"title"`
+	Channel         string  `json:"channel"`
+	DurationSeconds int     `json:"duration_seconds"`
+	Score           float64 `json:"score"`
+}
+
+// ImportRow is one track of an import job
+type ImportRow struct {
+	JobID           string
+	Position        int
+	Title           string
+	Artist          string
+	Album           string
+	DurationSeconds int
+	Status          string
+	YoutubeID       *string
+	Score           *float64
+	Candidates      []ImportCandidate
+	DownloadJobID   *string
+	UpdatedAt       time.Time
+}
+
+func scanImportJob(row pgx.Row, job *ImportJob) error {
+	return row.Scan(
+		&job.ID,
+		&job.UserID,
+		&job.Name,
+		&job.PlaylistID,
+		&job.Status,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.Total,
+		&job.Pending,
+		&job.Matched,
+		&job.Review,
+		&job.Skipped,
+	)
+}
+
+func scanImportRow(row pgx.Row, r *ImportRow) error {
+	return row.Scan(
+		&r.JobID,
+		&r.Position,
+		&r.Title,
+		&r.Artist,
+		&r.Album,
+		&r.DurationSeconds,
+		&r.Status,
+		&r.YoutubeID,
+		&r.Score,
+		&r.Candidates,
+		&r.DownloadJobID,
+		&r.UpdatedAt,
+	)
+}
+
+// CreateImportJob creates an import job for the given rows along with the
+// empty playlist its matches are added to
+func (db *DB) CreateImportJob(ctx context.Context, userID, name string, rows []ImportRow) (*ImportJob, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var playlistID string
+	err = tx.QueryRow(ctx, `
+		INSERT INTO playlists (user_id, name)
+		VALUES ($1, $2)
+		RETURNING id
+	`, userID, name).Scan(&playlistID)
+	if err != nil {
+		return nil, fmt.Errorf("create playlist: %w", err)
+	}
+
+	job := &ImportJob{UserID: userID, Name: name, PlaylistID: &playlistID, Status: ImportJobMatching}
+	err = tx.QueryRow(ctx, `
+		INSERT INTO import_jobs (user_id, name, playlist_id)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at, updated_at
+	`, userID, name, playlistID).Scan(&job.ID, &job.CreatedAt, &job.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("create import job: %w", err)
+	}
+
+	titles := make([]string, len(rows))
+	artists := make([]string, len(rows))
+	albums := make([]string, len(rows))
+	durations := make([]int32, len(rows))
+	for i, row := range rows {
+		titles[i], artists[i], albums[i], durations[i] = row.Title, row.Artist, row.Album, int32(row.DurationSeconds)
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO import_rows (job_id, position, title, artist, album, duration_seconds)
+		SELECT $1, r.ord - 1, r.title, r.artist, r.album, r.duration
+		FROM unnest($2::text[], $3::text[], $4::text[], $5::int[])
+			WITH ORDINALITY AS r(title, artist, album, duration, ord)
+	`, job.ID, titles, artists, albums, durations)
+	if err != nil {
+		return nil, fmt.Errorf("create import rows: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit transaction: %w", err)
+	}
+
+	job.Total = len(rows)
+	job.Pending = len(rows)
+	return job, nil
+}
+
+// GetImportJob returns one of a user's import jobs
+func (db *DB) GetImportJob(ctx context.Context, jobID, userID string) (*ImportJob, error) {
+	query := importJobQuery + `
+		WHERE j.id = $1 AND j.user_id = $2
+		GROUP BY j.id
+	`
+
+	job := &ImportJob{}
+	if err := scanImportJob(db.Pool.QueryRow(ctx, query, jobID, userID), job); err != nil {
+		return nil, fmt.Errorf("get import job: %w", err)
+	}
+
+	return job, nil
+}
+
+// ListImportJobs returns a user's import jobs, most recent first
+func (db *DB) ListImportJobs(ctx context.Context, userID string) ([]ImportJob, error) {
+	query := importJobQuery + `
+		WHERE j.user_id = $1
+		GROUP BY j.id
+		ORDER BY j.created_at DESC
+	`
+
+	rows, err := db.Pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list import jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []ImportJob
+	for rows.Next() {
+		var job ImportJob
+		if err := scanImportJob(rows, &job); err != nil {
+			return nil, fmt.Errorf("scan import job: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate import jobs: %w", err)
+	}
+
+	return jobs, nil
+}
+
+// NextMatchingImportJob returns the oldest job that still has rows to match,
+// or ErrNotFound if there is none
+func (db *DB) NextMatchingImportJob(ctx context.Context) (*ImportJob, error) {
+	query := importJobQuery + `
+		WHERE j.status = 'matching'
+		GROUP BY j.id
+		ORDER BY j.created_at
+		LIMIT 1
+	`
+
+	job := &ImportJob{}
+	if err := scanImportJob(db.Pool.QueryRow(ctx, query), job); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("next matching import job: %w", err)
+	}
+
+	return job, nil
+}
+
+// ListImportRows returns a job's rows in their original order
+func (db *DB) ListImportRows(ctx context.Context, jobID string) ([]ImportRow, error) {
+	query := `
+		SELECT ` + importRowColumns + `
+		FROM import_rows
+		WHERE job_id = $1
+		ORDER BY position
+	`
+
+	rows, err := db.Pool.Query(ctx, query, jobID)
+	if err != nil {
+		return nil, fmt.Errorf("list import rows: %w", err)
+	}
+	defer rows.Close()
+
+	var result []ImportRow
+	for rows.Next() {
+		var row ImportRow
+		if err := scanImportRow(rows, &row); err != nil {
+			return nil, fmt.Errorf("scan import row: %w", err)
+		}
+		result = append(result, row)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate import rows: %w", err)
+	}
+
+	return result, nil
+}
+
+// GetImportRow returns one row of a job
+func (db *DB) GetImportRow(ctx context.Context, jobID string, position int) (*ImportRow, error) {
+	query := `
+		SELECT ` + importRowColumns + `
+		FROM import_rows
+		WHERE job_id = $1 AND position = $2
+	`
+
+	row := &ImportRow{}
+	if err := scanImportRow(db.Pool.QueryRow(ctx, query, jobID, position), row); err != nil {
+		return nil, fmt.Errorf("get import row: %w", err)
+	}
+
+	return row, nil
+}
+
+// NextPendingImportRow returns the first row of a job that hasn't been
+// matched yet, or ErrNotFound if there is none
+func (db *DB) NextPendingImportRow(ctx context.Context, jobID string) (*ImportRow, error) {
+	query := `
+		SELECT ` + importRowColumns + `
+		FROM import_rows
+		WHERE job_id = $1 AND status = 'pending'
+		ORDER BY position
+		LIMIT 1
+	`
+
+	row := &ImportRow{}
+	if err := scanImportRow(db.Pool.QueryRow(ctx, query, jobID), row); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("next pending import row: %w", err)
+	}
+
+	return row, nil
+}
+
+// UpdateImportRow saves a row's match. When fromStatuses is given the row is
+// only updated while in one of them, so concurrent resolutions can't both
+// win. Returns ErrNotFound if no row was updated.
+func (db *DB) UpdateImportRow(ctx context.Context, row *ImportRow, fromStatuses ...string) error {
+	candidates := row.Candidates
+	if candidates == nil {
+		candidates = []ImportCandidate{}
+	}
+	// A nil slice would be sent as NULL rather than an empty array
+	if fromStatuses == nil {
+		fromStatuses = []string{}
+	}
+
+	result, err := db.Pool.Exec(ctx, `
+		UPDATE import_rows
+		SET status = $3, youtube_id = $4, score = $5, candidates = $6, download_job_id = $7, updated_at = NOW()
+		WHERE job_id = $1 AND position = $2
+			AND (cardinality($8::text[]) = 0 OR status = ANY($8))
+	`, row.JobID, row.Position, row.Status, row.YoutubeID, row.Score, candidates, row.DownloadJobID, fromStatuses)
+	if err != nil {
+		return fmt.Errorf("update import row: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// RefreshImportJobStatus sets a job's status from its rows: matching while
+// any are pending, review while any need the user, otherwise done
+func (db *DB) RefreshImportJobStatus(ctx context.Context, jobID string) error {
+	_, err := db.Pool.Exec(ctx, `
+		UPDATE import_jobs
+		SET status = CASE
+				WHEN EXISTS (SELECT 1 FROM import_rows WHERE job_id = $1 AND status = 'pending') THEN 'matching'
+				WHEN EXISTS (SELECT 1 FROM import_rows WHERE job_id = $1 AND status = 'review') THEN 'review'
+				ELSE 'done'
+			END,
+			updated_at = NOW()
+		WHERE id = $1
+	`, jobID)
+	if err != nil {
+		return fmt.Errorf("refresh import job status: %w", err)
+	}
+	return nil
+}
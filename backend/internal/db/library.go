@@ -0,0 +1,315 @@
+package db
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Library sort keys
+const (
+	SortAdded    = "added"
+	SortTitle    = "title"
+	SortArtist   = "artist"
+	SortDuration = "duration"
+	SortPlays    = "plays"
+	SortRating   = "rating"
+)
+
+// MaxLibraryPageSize bounds LibraryQuery.Limit
+const MaxLibraryPageSize = 500
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// librarySort is how a sort key orders tracks and videos. The expressions
+// match the indexes in 009_library_indexes.sql.
+type librarySort struct {
+	track   string
+	video   string
+	sqlType string
+}
+
+var librarySorts = map[string]librarySort{
+	SortAdded:    {"t.created_at", "v.created_at", "timestamptz"},
+	SortTitle:    {"lower(t.title)", "lower(v.title)", "text"},
+	SortArtist:   {"lower(COALESCE(t.artist, ''))", "lower(COALESCE(v.channel, ''))", "text"},
+	SortDuration: {"COALESCE(t.duration_seconds, 0)", "COALESCE(v.duration_seconds, 0)", "integer"},
+	SortPlays:    {"t.play_count", "v.play_count", "integer"},
+	SortRating:   {"t.rating", "v.rating", "integer"},
+}
+
+// ValidLibrarySort reports whether sort is a library sort key
+func ValidLibrarySort(sort string) bool {
+	_, ok := librarySorts[sort]
+	return ok
+}
+
+// LibraryQuery selects a page of a user's tracks or videos. Zero values
+// leave a filter off; a zero Limit returns every match.
+type LibraryQuery struct {
+	Sort       string
+	Descending bool
+	Limit      int
+	Cursor     *LibraryCursor
+
+	// Artist matches a track's artist or a video's channel, ignoring case
+	Artist      string
+	AddedAfter  *time.Time
+	AddedBefore *time.Time
+	MinDuration *int
+	MaxDuration *int
+
+	// NotInPlaylist keeps only tracks that are in no playlist
+	NotInPlaylist bool
+
+	// Favorite keeps only favorites when true and only the rest when false
+	Favorite  *bool
+	MinRating *int
+
+	// Tags keeps items carrying any of the named tags, or all of them with
+	// MatchAllTags. Names are compared ignoring case.
+	Tags         []string
+	MatchAllTags bool
+}
+
+// LibraryCursor marks the last item of a page. It records the sort it was
+// made for so it can't be replayed against a different order.
+type LibraryCursor struct {
+	Sort       string `json:"s"`
+	Descending bool   `json:"d"`
+	Value      string `json:"v"`
+	ID         string `json:"i"`
+}
+
+// Encode returns the cursor as an opaque URL-safe string
+func (c *LibraryCursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeLibraryCursor parses a cursor made by Encode. Cursors come back
+// from clients, so the value is checked against the sort's type here rather
+// than left to fail the cast in SQL.
+func DecodeLibraryCursor(s string) (*LibraryCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor LibraryCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || !IsUUID(cursor.ID) {
+		return nil, ErrInvalidCursor
+	}
+	sort, ok := librarySorts[cursor.Sort]
+	if !ok || !validSortValue(sort.sqlType, cursor.Value) {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// sortValueTimeLayouts are the forms Postgres prints a timestamptz in as
+// text, with whole-hour and other offsets
+var sortValueTimeLayouts = []string{
+	"2006-01-02 15:04:05.999999999-07",
+	"2006-01-02 15:04:05.999999999-07:00",
+}
+
+// validSortValue reports whether a cursor's sort value can be cast to the
+// sort's SQL type
+func validSortValue(sqlType, value string) bool {
+	switch sqlType {
+	case "timestamptz":
+		for _, layout := range sortValueTimeLayouts {
+			if _, err := time.Parse(layout, value); err == nil {
+				return true
+			}
+		}
+		return false
+	case "integer":
+		_, err := strconv.ParseInt(value, 10, 32)
+		return err == nil
+	default:
+		// Postgres text can't hold NUL or invalid UTF-8
+		return utf8.ValidString(value) && !strings.ContainsRune(value, 0)
+	}
+}
+
+// libraryQuerySQL builds the SELECT for a library page. The sort value is
+// selected as text after the item's columns so the next cursor can be made
+// from the last row. One row more than the limit is fetched to tell whether
+// another page follows.
+func libraryQuerySQL(video bool, userID string, q *LibraryQuery) (string, []interface{}, error) {
+	sort, ok := librarySorts[q.Sort]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown sort %q", q.Sort)
+	}
+
+	artist := librarySorts[SortArtist]
+	alias, table, columns, sortExpr, artistExpr := "t", "tracks", trackColumns, sort.track, artist.track
+	if video {
+		alias, table, columns, sortExpr, artistExpr = "v", "videos", videoColumns, sort.video, artist.video
+	}
+
+	args := []interface{}{userID}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	where := []string{alias + ".user_id = $1"}
+	if q.Artist != "" {
+		where = append(where, artistExpr+" = lower("+arg(q.Artist)+")")
+	}
+	if q.AddedAfter != nil {
+		where = append(where, alias+".created_at >= "+arg(*q.AddedAfter))
+	}
+	if q.AddedBefore != nil {
+		where = append(where, alias+".created_at < "+arg(*q.AddedBefore))
+	}
+	if q.MinDuration != nil {
+		where = append(where, alias+".duration_seconds >= "+arg(*q.MinDuration))
+	}
+	if q.MaxDuration != nil {
+		where = append(where, alias+".duration_seconds <= "+arg(*q.MaxDuration))
+	}
+	if q.NotInPlaylist && !video {
+		where = append(where, "NOT EXISTS (SELECT 1 FROM playlist_tracks pt WHERE pt.track_id = t.id)")
+	}
+
+	if q.Favorite != nil {
+		if *q.Favorite {
+			where = append(where, alias+".favorited_at IS NOT NULL")
+		} else {
+			where = append(where, alias+".favorited_at IS NULL")
+		}
+	}
+	if q.MinRating != nil {
+		where = append(where, alias+".rating >= "+arg(*q.MinRating))
+	}
+	if len(q.Tags) > 0 {
+		tagTable, itemColumn := "track_tags", "track_id"
+		if video {
+			tagTable, itemColumn = "video_tags", "video_id"
+		}
+		tagged := fmt.Sprintf(`SELECT COUNT(DISTINCT it.tag_id) FROM %s it INNER JOIN tags tg ON tg.id = it.tag_id
+			WHERE it.%s = %s.id AND lower(tg.name) IN (SELECT lower(name) FROM unnest(%s::text[]) AS name)`,
+			tagTable, itemColumn, alias, arg(q.Tags))
+		if q.MatchAllTags {
+			where = append(where, "("+tagged+") = (SELECT COUNT(DISTINCT lower(name)) FROM unnest("+arg(q.Tags)+"::text[]) AS name)")
+		} else {
+			where = append(where, "("+tagged+") > 0")
+		}
+	}
+
+	direction, comparison := "ASC", ">"
+	if q.Descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	if q.Cursor != nil {
+		if q.Cursor.Sort != q.Sort || q.Cursor.Descending != q.Descending {
+			return "", nil, ErrInvalidCursor
+		}
+		where = append(where, fmt.Sprintf("(%s, %s.id) %s (CAST(%s::text AS %s), %s::uuid)",
+			sortExpr, alias, comparison, arg(q.Cursor.Value), sort.sqlType, arg(q.Cursor.ID)))
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s, (%s)::text
+		FROM %s %s
+		WHERE %s
+		ORDER BY %s %s, %s.id %s`,
+		columns, sortExpr, table, alias,
+		strings.Join(where, " AND "),
+		sortExpr, direction, alias, direction,
+	)
+	if q.Limit > 0 {
+		query += "\n\t\tLIMIT " + arg(q.Limit+1)
+	}
+
+	return query, args, nil
+}
+
+// QueryTracks returns a page of a user's tracks and the cursor for the next
+// page, which is nil on the last one
+func (db *DB) QueryTracks(ctx context.Context, userID string, q *LibraryQuery) ([]Track, *LibraryCursor, error) {
+	query, args, err := libraryQuerySQL(false, userID, q)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := db.Pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("query tracks: %w", err)
+	}
+	defer rows.Close()
+
+	var tracks []Track
+	var sortValues []string
+	for rows.Next() {
+		var track Track
+		var sortValue string
+		if err := rows.Scan(append(trackScanTargets(&track), &sortValue)...); err != nil {
+			return nil, nil, fmt.Errorf("scan track: %w", err)
+		}
+		tracks = append(tracks, track)
+		sortValues = append(sortValues, sortValue)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("iterate tracks: %w", err)
+	}
+
+	if q.Limit <= 0 || len(tracks) <= q.Limit {
+		return tracks, nil, nil
+	}
+
+	tracks = tracks[:q.Limit]
+	last := len(tracks) - 1
+	return tracks, &LibraryCursor{Sort: q.Sort, Descending: q.Descending, Value: sortValues[last], ID: tracks[last].ID}, nil
+}
+
+// QueryVideos returns a page of a user's videos and the cursor for the next
+// page, which is nil on the last one. NotInPlaylist doesn't apply to videos.
+func (db *DB) QueryVideos(ctx context.Context, userID string, q *LibraryQuery) ([]Video, *LibraryCursor, error) {
+	query, args, err := libraryQuerySQL(true, userID, q)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := db.Pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("query videos: %w", err)
+	}
+	defer rows.Close()
+
+	var videos []Video
+	var sortValues []string
+	for rows.Next() {
+		var video Video
+		var sortValue string
+		if err := rows.Scan(append(videoScanTargets(&video), &sortValue)...); err != nil {
+			return nil, nil, fmt.Errorf("scan video: %w", err)
+		}
+		videos = append(videos, video)
+		sortValues = append(sortValues, sortValue)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("iterate videos: %w", err)
+	}
+
+	if q.Limit <= 0 || len(videos) <= q.Limit {
+		return videos, nil, nil
+	}
+
+	videos = videos[:q.Limit]
+	last := len(videos) - 1
+	return videos, &LibraryCursor{Sort: q.Sort, Descending: q.Descending, Value: sortValues[last], ID: videos[last].ID}, nil
+}
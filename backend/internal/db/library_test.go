@@ -0,0 +1,142 @@
+package db
+
+import (
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testItemID = "3f2b8c1e-9a4d-4e6f-8b7a-1c2d3e4f5a6b"
+
+func TestLibraryCursorRoundTrip(t *testing.T) {
+	cursors := []LibraryCursor{
+		{Sort: SortAdded, Value: "2026-10-18 12:34:56.123456+00", ID: testItemID},
+		{Sort: SortAdded, Descending: true, Value: "2026-10-18 18:04:56+05:30", ID: testItemID},
+		{Sort: SortTitle, Value: "bohemian rhapsody", ID: testItemID},
+		{Sort: SortArtist, Descending: true, Value: "", ID: testItemID},
+		{Sort: SortDuration, Value: "354", ID: testItemID},
+		{Sort: SortPlays, Value: "0", ID: testItemID},
+		{Sort: SortRating, Value: "5", ID: testItemID},
+	}
+
+	for _, cursor := range cursors {
+		encoded := cursor.Encode()
+		if strings.ContainsAny(encoded, "+/=") {
+			t.Errorf("Encode() = %q, want URL-safe", encoded)
+		}
+		decoded, err := DecodeLibraryCursor(encoded)
+		if err != nil {
+			t.Errorf("DecodeLibraryCursor(%+v) error = %v", cursor, err)
+			continue
+		}
+		if !reflect.DeepEqual(*decoded, cursor) {
+			t.Errorf("DecodeLibraryCursor() = %+v, want %+v", *decoded, cursor)
+		}
+	}
+}
+
+func TestDecodeLibraryCursorRejects(t *testing.T) {
+	encode := func(json string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(json))
+	}
+
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"not base64", "!!!"},
+		{"not JSON", encode("cursor")},
+		{"missing ID", encode(`{"s":"title","v":"a"}`)},
+		{"ID not a UUID", encode(`{"s":"title","v":"a","i":"1 OR 1=1"}`)},
+		{"unknown sort", encode(`{"s":"bpm","v":"120","i":"` + testItemID + `"}`)},
+		{"text for a date", encode(`{"s":"added","v":"abc","i":"` + testItemID + `"}`)},
+		{"date without time", encode(`{"s":"added","v":"2026-10-18","i":"` + testItemID + `"}`)},
+		{"text for an integer", encode(`{"s":"duration","v":"abc","i":"` + testItemID + `"}`)},
+		{"fraction for an integer", encode(`{"s":"plays","v":"1.5","i":"` + testItemID + `"}`)},
+		{"integer out of range", encode(`{"s":"rating","v":"99999999999","i":"` + testItemID + `"}`)},
+		{"NUL in text", encode(`{"s":"title","v":"a\u0000b","i":"` + testItemID + `"}`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeLibraryCursor(tt.input); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("DecodeLibraryCursor() error = %v, want ErrInvalidCursor", err)
+			}
+		})
+	}
+}
+
+func TestLibraryQuerySQLKeyset(t *testing.T) {
+	tests := []struct {
+		name      string
+		video     bool
+		query     LibraryQuery
+		wantWhere string
+		wantOrder string
+		wantArgs  []interface{}
+	}{
+		{
+			name:      "first page",
+			query:     LibraryQuery{Sort: SortAdded, Limit: 50},
+			wantWhere: "WHERE t.user_id = $1",
+			wantOrder: "ORDER BY t.created_at ASC, t.id ASC LIMIT $2",
+			wantArgs:  []interface{}{"user", 51},
+		},
+		{
+			name: "next page ascending",
+			query: LibraryQuery{Sort: SortDuration, Limit: 50,
+				Cursor: &LibraryCursor{Sort: SortDuration, Value: "180", ID: testItemID}},
+			wantWhere: "WHERE t.user_id = $1 AND (COALESCE(t.duration_seconds, 0), t.id) > (CAST($2::text AS integer), $3::uuid)",
+			wantOrder: "ORDER BY COALESCE(t.duration_seconds, 0) ASC, t.id ASC LIMIT $4",
+			wantArgs:  []interface{}{"user", "180", testItemID, 51},
+		},
+		{
+			name:  "next page of videos descending",
+			video: true,
+			query: LibraryQuery{Sort: SortTitle, Descending: true, Limit: 10,
+				Cursor: &LibraryCursor{Sort: SortTitle, Descending: true, Value: "intro", ID: testItemID}},
+			wantWhere: "WHERE v.user_id = $1 AND (lower(v.title), v.id) < (CAST($2::text AS text), $3::uuid)",
+			wantOrder: "ORDER BY lower(v.title) DESC, v.id DESC LIMIT $4",
+			wantArgs:  []interface{}{"user", "intro", testItemID, 11},
+		},
+		{
+			name: "filters come before the cursor",
+			query: LibraryQuery{Sort: SortAdded, Descending: true, Limit: 20, Artist: "Queen",
+				Cursor: &LibraryCursor{Sort: SortAdded, Descending: true, Value: "2026-10-18 12:00:00+00", ID: testItemID}},
+			wantWhere: "WHERE t.user_id = $1 AND lower(COALESCE(t.artist, '')) = lower($2) AND (t.created_at, t.id) < (CAST($3::text AS timestamptz), $4::uuid)",
+			wantOrder: "ORDER BY t.created_at DESC, t.id DESC LIMIT $5",
+			wantArgs:  []interface{}{"user", "Queen", "2026-10-18 12:00:00+00", testItemID, 21},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args, err := libraryQuerySQL(tt.video, "user", &tt.query)
+			if err != nil {
+				t.Fatalf("libraryQuerySQL() error = %v", err)
+			}
+			query = squash(query)
+			if !strings.Contains(query, tt.wantWhere+" "+tt.wantOrder) {
+				t.Errorf("query = %q, want %q followed by %q", query, tt.wantWhere, tt.wantOrder)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("args = %v, want %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestLibraryQuerySQLCursorForOtherOrder(t *testing.T) {
+	cursor := &LibraryCursor{Sort: SortTitle, Value: "intro", ID: testItemID}
+
+	for _, q := range []LibraryQuery{
+		{Sort: SortArtist, Cursor: cursor},
+		{Sort: SortTitle, Descending: true, Cursor: cursor},
+	} {
+		if _, _, err := libraryQuerySQL(false, "user", &q); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("sort %q descending %v: error = %v, want ErrInvalidCursor", q.Sort, q.Descending, err)
+		}
+	}
+}
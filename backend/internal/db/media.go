@@ -3,8 +3,23 @@ package db
 import (
 	"context"
 	"time"
+
+	"github.com/jackc/pgx/v5"
 )
 
+// trackColumns lists the columns scanned by scanTrack, qualified by the alias t
+const trackColumns = `t.id, t.user_id, t.youtube_id, t.title, t.artist, t.duration_seconds, t.thumbnail_url,
+	t.file_path, t.file_size_bytes, t.album, t.release_date, t.mb_recording_id, t.mb_release_id, t.mb_artist_id,
+	t.description, t.play_count, t.favorited_at, t.rating, t.artist_id, t.album_id, t.cover_path, t.created_at, t.updated_at,
+	ARRAY(SELECT tg.name FROM track_tags tt INNER JOIN tags tg ON tg.id = tt.tag_id
+		WHERE tt.track_id = t.id ORDER BY lower(tg.name))`
+
+// videoColumns lists the columns scanned by scanVideo, qualified by the alias v
+const videoColumns = `v.id, v.user_id, v.youtube_id, v.title, v.channel, v.duration_seconds, v.thumbnail_url,
+	v.file_path, v.file_size_bytes, v.quality, v.description, v.play_count, v.favorited_at, v.rating, v.cover_path, v.created_at, v.updated_at,
+	ARRAY(SELECT tg.name FROM video_tags vt INNER JOIN tags tg ON tg.id = vt.tag_id
+		WHERE vt.video_id = v.id ORDER BY lower(tg.name))`
+
 // Track represents a music track in a user's library
 type Track struct {
 	ID              string
@@ -16,8 +31,33 @@ type Track struct {
 	ThumbnailURL    string
 	FilePath        string
 	FileSizeBytes   int64
-	CreatedAt       time.Time
-	UpdatedAt       time.Time
+	Album           string
+	ReleaseDate     string
+	MBRecordingID   *string
+	MBReleaseID     *string
+	MBArtistID      *string
+	Description     string
+	PlayCount       int
+	FavoritedAt     *time.Time
+	Rating          int
+	ArtistID        *string
+	AlbumID         *string
+	// CoverPath is the uploaded cover art file, if any
+	CoverPath *string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Tags      []string
+}
+
+// TrackMetadata is the canonical metadata applied to a track from an external source
+type TrackMetadata struct {
+	Title         string
+	Artist        string
+	Album         string
+	ReleaseDate   string
+	MBRecordingID *string
+	MBReleaseID   *string
+	MBArtistID    *string
 }
 
 // Video represents a video in a user's library
@@ -32,22 +72,96 @@ type Video struct {
 	FilePath        string
 	FileSizeBytes   int64
 	Quality         string
-	CreatedAt       time.Time
-	UpdatedAt       time.Time
+	Description     string
+	PlayCount       int
+	FavoritedAt     *time.Time
+	Rating          int
+	// CoverPath is the uploaded cover art file, if any
+	CoverPath *string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Tags      []string
+}
+
+// scanTrack scans a row selected with trackColumns
+func scanTrack(row pgx.Row, track *Track) error {
+	return row.Scan(trackScanTargets(track)...)
+}
+
+// trackScanTargets returns the destinations for trackColumns, for queries
+// that select further columns after them
+func trackScanTargets(track *Track) []interface{} {
+	return []interface{}{
+		&track.ID,
+		&track.UserID,
+		&track.YoutubeID,
+		&track.Title,
+		&track.Artist,
+		&track.DurationSeconds,
+		&track.ThumbnailURL,
+		&track.FilePath,
+		&track.FileSizeBytes,
+		&track.Album,
+		&track.ReleaseDate,
+		&track.MBRecordingID,
+		&track.MBReleaseID,
+		&track.MBArtistID,
+		&track.Description,
+		&track.PlayCount,
+		&track.FavoritedAt,
+		&track.Rating,
+		&track.ArtistID,
+		&track.AlbumID,
+		&track.CoverPath,
+		&track.CreatedAt,
+		&track.UpdatedAt,
+		&track.Tags,
+	}
+}
+
+// scanVideo scans a row selected with videoColumns
+func scanVideo(row pgx.Row, video *Video) error {
+	return row.Scan(videoScanTargets(video)...)
+}
+
+// videoScanTargets returns the destinations for videoColumns
+func videoScanTargets(video *Video) []interface{} {
+	return []interface{}{
+		&video.ID,
+		&video.UserID,
+		&video.YoutubeID,
+		&video.Title,
+		&video.Channel,
+		&video.DurationSeconds,
+		&video.ThumbnailURL,
+		&video.FilePath,
+		&video.FileSizeBytes,
+		&video.Quality,
+		&video.Description,
+		&video.PlayCount,
+		&video.FavoritedAt,
+		&video.Rating,
+		&video.CoverPath,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Tags,
+	}
 }
 
 // CreateTrack inserts a new track into the database
 func (db *DB) CreateTrack(ctx context.Context, track *Track) (*Track, error) {
 	query := `
-		INSERT INTO tracks (user_id, youtube_id, title, artist, duration_seconds, thumbnail_url, file_path, file_size_bytes)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		INSERT INTO tracks (user_id, youtube_id, title, artist, duration_seconds, thumbnail_url, file_path, file_size_bytes, description)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 		ON CONFLICT (user_id, youtube_id) DO UPDATE SET
 			title = EXCLUDED.title,
 			artist = EXCLUDED.artist,
 			duration_seconds = EXCLUDED.duration_seconds,
-			thumbnail_url = EXCLUDED.thumbnail_url,
+			-- Uploaded cover art outlives re-downloads
+			thumbnail_url = CASE WHEN tracks.cover_path IS NULL THEN EXCLUDED.thumbnail_url ELSE tracks.thumbnail_url END,
 			file_path = EXCLUDED.file_path,
 			file_size_bytes = EXCLUDED.file_size_bytes,
+			description = EXCLUDED.description,
 			updated_at = NOW()
 		RETURNING id, created_at, updated_at
 	`
@@ -61,6 +175,7 @@ func (db *DB) CreateTrack(ctx context.Context, track *Track) (*Track, error) {
 		track.ThumbnailURL,
 		track.FilePath,
 		track.FileSizeBytes,
+		track.Description,
 	).Scan(&track.ID, &track.CreatedAt, &track.UpdatedAt)
 
 	if err != nil {
@@ -73,16 +188,18 @@ func (db *DB) CreateTrack(ctx context.Context, track *Track) (*Track, error) {
 // CreateVideo inserts a new video into the database
 func (db *DB) CreateVideo(ctx context.Context, video *Video) (*Video, error) {
 	query := `
-		INSERT INTO videos (user_id, youtube_id, title, channel, duration_seconds, thumbnail_url, file_path, file_size_bytes, quality)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		INSERT INTO videos (user_id, youtube_id, title, channel, duration_seconds, thumbnail_url, file_path, file_size_bytes, quality, description)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 		ON CONFLICT (user_id, youtube_id) DO UPDATE SET
 			title = EXCLUDED.title,
 			channel = EXCLUDED.channel,
 			duration_seconds = EXCLUDED.duration_seconds,
-			thumbnail_url = EXCLUDED.thumbnail_url,
+			-- Uploaded cover art outlives re-downloads
+			thumbnail_url = CASE WHEN videos.cover_path IS NULL THEN EXCLUDED.thumbnail_url ELSE videos.thumbnail_url END,
 			file_path = EXCLUDED.file_path,
 			file_size_bytes = EXCLUDED.file_size_bytes,
 			quality = EXCLUDED.quality,
+			description = EXCLUDED.description,
 			updated_at = NOW()
 		RETURNING id, created_at, updated_at
 	`
@@ -97,6 +214,7 @@ func (db *DB) CreateVideo(ctx context.Context, video *Video) (*Video, error) {
 		video.FilePath,
 		video.FileSizeBytes,
 		video.Quality,
+		video.Description,
 	).Scan(&video.ID, &video.CreatedAt, &video.UpdatedAt)
 
 	if err != nil {
@@ -109,26 +227,30 @@ func (db *DB) CreateVideo(ctx context.Context, video *Video) (*Video, error) {
 // GetTrackByID retrieves a track by ID for a specific user
 func (db *DB) GetTrackByID(ctx context.Context, trackID, userID string) (*Track, error) {
 	query := `
-		SELECT id, user_id, youtube_id, title, artist, duration_seconds, thumbnail_url, file_path, file_size_bytes, created_at, updated_at
-		FROM tracks
-		WHERE id = $1 AND user_id = $2
+		SELECT ` + trackColumns + `
+		FROM tracks t
+		WHERE t.id = $1 AND t.user_id = $2
 	`
 
 	track := &Track{}
-	err := db.Pool.QueryRow(ctx, query, trackID, userID).Scan(
-		&track.ID,
-		&track.UserID,
-		&track.YoutubeID,
-		&track.Title,
-		&track.Artist,
-		&track.DurationSeconds,
-		&track.ThumbnailURL,
-		&track.FilePath,
-		&track.FileSizeBytes,
-		&track.CreatedAt,
-		&track.UpdatedAt,
-	)
+	err := scanTrack(db.Pool.QueryRow(ctx, query, trackID, userID), track)
+	if err != nil {
+		return nil, err
+	}
+
+	return track, nil
+}
 
+// GetTrackByYoutubeID retrieves a user's track downloaded from a YouTube video
+func (db *DB) GetTrackByYoutubeID(ctx context.Context, userID, youtubeID string) (*Track, error) {
+	query := `
+		SELECT ` + trackColumns + `
+		FROM tracks t
+		WHERE t.user_id = $1 AND t.youtube_id = $2
+	`
+
+	track := &Track{}
+	err := scanTrack(db.Pool.QueryRow(ctx, query, userID, youtubeID), track)
 	if err != nil {
 		return nil, err
 	}
@@ -136,30 +258,33 @@ func (db *DB) GetTrackByID(ctx context.Context, trackID, userID string) (*Track,
 	return track, nil
 }
 
+// GetVideoByYoutubeID retrieves a user's video downloaded from a YouTube video
+func (db *DB) GetVideoByYoutubeID(ctx context.Context, userID, youtubeID string) (*Video, error) {
+	query := `
+		SELECT ` + videoColumns + `
+		FROM videos v
+		WHERE v.user_id = $1 AND v.youtube_id = $2
+	`
+
+	video := &Video{}
+	err := scanVideo(db.Pool.QueryRow(ctx, query, userID, youtubeID), video)
+	if err != nil {
+		return nil, err
+	}
+
+	return video, nil
+}
+
 // GetVideoByID retrieves a video by ID for a specific user
 func (db *DB) GetVideoByID(ctx context.Context, videoID, userID string) (*Video, error) {
 	query := `
-		SELECT id, user_id, youtube_id, title, channel, duration_seconds, thumbnail_url, file_path, file_size_bytes, quality, created_at, updated_at
-		FROM videos
-		WHERE id = $1 AND user_id = $2
+		SELECT ` + videoColumns + `
+		FROM videos v
+		WHERE v.id = $1 AND v.user_id = $2
 	`
 
 	video := &Video{}
-	err := db.Pool.QueryRow(ctx, query, videoID, userID).Scan(
-		&video.ID,
-		&video.UserID,
-		&video.YoutubeID,
-		&video.Title,
-		&video.Channel,
-		&video.DurationSeconds,
-		&video.ThumbnailURL,
-		&video.FilePath,
-		&video.FileSizeBytes,
-		&video.Quality,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-	)
-
+	err := scanVideo(db.Pool.QueryRow(ctx, query, videoID, userID), video)
 	if err != nil {
 		return nil, err
 	}
@@ -170,10 +295,10 @@ func (db *DB) GetVideoByID(ctx context.Context, videoID, userID string) (*Video,
 // GetTracksByUserID retrieves all tracks for a user, ordered by most recent first
 func (db *DB) GetTracksByUserID(ctx context.Context, userID string) ([]Track, error) {
 	query := `
-		SELECT id, user_id, youtube_id, title, artist, duration_seconds, thumbnail_url, file_path, file_size_bytes, created_at, updated_at
-		FROM tracks
-		WHERE user_id = $1
-		ORDER BY created_at DESC
+		SELECT ` + trackColumns + `
+		FROM tracks t
+		WHERE t.user_id = $1
+		ORDER BY t.created_at DESC
 	`
 
 	rows, err := db.Pool.Query(ctx, query, userID)
@@ -185,20 +310,38 @@ func (db *DB) GetTracksByUserID(ctx context.Context, userID string) ([]Track, er
 	var tracks []Track
 	for rows.Next() {
 		var track Track
-		err := rows.Scan(
-			&track.ID,
-			&track.UserID,
-			&track.YoutubeID,
-			&track.Title,
-			&track.Artist,
-			&track.DurationSeconds,
-			&track.ThumbnailURL,
-			&track.FilePath,
-			&track.FileSizeBytes,
-			&track.CreatedAt,
-			&track.UpdatedAt,
-		)
-		if err != nil {
+		if err := scanTrack(rows, &track); err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, track)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tracks, nil
+}
+
+// GetTracksByIDs retrieves the given tracks for a specific user; IDs the user doesn't own are skipped
+func (db *DB) GetTracksByIDs(ctx context.Context, userID string, trackIDs []string) ([]Track, error) {
+	query := `
+		SELECT ` + trackColumns + `
+		FROM tracks t
+		WHERE t.user_id = $1 AND t.id = ANY($2)
+		ORDER BY t.created_at ASC
+	`
+
+	rows, err := db.Pool.Query(ctx, query, userID, trackIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tracks []Track
+	for rows.Next() {
+		var track Track
+		if err := scanTrack(rows, &track); err != nil {
 			return nil, err
 		}
 		tracks = append(tracks, track)
@@ -214,27 +357,56 @@ func (db *DB) GetTracksByUserID(ctx context.Context, userID string) ([]Track, er
 // UpdateTrack updates the title and artist of a track for a specific user
 func (db *DB) UpdateTrack(ctx context.Context, trackID, userID, title, artist string) (*Track, error) {
 	query := `
-		UPDATE tracks
+		UPDATE tracks t
 		SET title = $3, artist = $4, updated_at = NOW()
-		WHERE id = $1 AND user_id = $2
-		RETURNING id, user_id, youtube_id, title, artist, duration_seconds, thumbnail_url, file_path, file_size_bytes, created_at, updated_at
-	`
+		WHERE t.id = $1 AND t.user_id = $2
+		RETURNING ` + trackColumns
 
 	track := &Track{}
-	err := db.Pool.QueryRow(ctx, query, trackID, userID, title, artist).Scan(
-		&track.ID,
-		&track.UserID,
-		&track.YoutubeID,
-		&track.Title,
-		&track.Artist,
-		&track.DurationSeconds,
-		&track.ThumbnailURL,
-		&track.FilePath,
-		&track.FileSizeBytes,
-		&track.CreatedAt,
-		&track.UpdatedAt,
-	)
+	err := scanTrack(db.Pool.QueryRow(ctx, query, trackID, userID, title, artist), track)
+	if err != nil {
+		return nil, err
+	}
 
+	return track, nil
+}
+
+// VideoEdit holds changes to a video's metadata; nil fields are left as they are
+type VideoEdit struct {
+	Title       *string
+	Channel     *string
+	Description *string
+}
+
+// UpdateVideo edits a video's title, channel and description for a specific user
+func (db *DB) UpdateVideo(ctx context.Context, videoID, userID string, edit *VideoEdit) (*Video, error) {
+	query := `
+		UPDATE videos v
+		SET title = COALESCE($3, v.title), channel = COALESCE($4, v.channel),
+		    description = COALESCE($5, v.description), updated_at = NOW()
+		WHERE v.id = $1 AND v.user_id = $2
+		RETURNING ` + videoColumns
+
+	video := &Video{}
+	err := scanVideo(db.Pool.QueryRow(ctx, query, videoID, userID, edit.Title, edit.Channel, edit.Description), video)
+	if err != nil {
+		return nil, err
+	}
+
+	return video, nil
+}
+
+// SetTrackCover records uploaded cover art for a track, pointing its
+// thumbnail at thumbnailURL
+func (db *DB) SetTrackCover(ctx context.Context, trackID, userID, coverPath, thumbnailURL string) (*Track, error) {
+	query := `
+		UPDATE tracks t
+		SET cover_path = $3, thumbnail_url = $4, updated_at = NOW()
+		WHERE t.id = $1 AND t.user_id = $2
+		RETURNING ` + trackColumns
+
+	track := &Track{}
+	err := scanTrack(db.Pool.QueryRow(ctx, query, trackID, userID, coverPath, thumbnailURL), track)
 	if err != nil {
 		return nil, err
 	}
@@ -242,30 +414,58 @@ func (db *DB) UpdateTrack(ctx context.Context, trackID, userID, title, artist st
 	return track, nil
 }
 
-// DeleteTrack deletes a track by ID for a specific user and returns the file path
-func (db *DB) DeleteTrack(ctx context.Context, trackID, userID string) (string, error) {
+// SetVideoCover records uploaded cover art for a video, pointing its
+// thumbnail at thumbnailURL
+func (db *DB) SetVideoCover(ctx context.Context, videoID, userID, coverPath, thumbnailURL string) (*Video, error) {
 	query := `
-		DELETE FROM tracks
-		WHERE id = $1 AND user_id = $2
-		RETURNING file_path
-	`
+		UPDATE videos v
+		SET cover_path = $3, thumbnail_url = $4, updated_at = NOW()
+		WHERE v.id = $1 AND v.user_id = $2
+		RETURNING ` + videoColumns
+
+	video := &Video{}
+	err := scanVideo(db.Pool.QueryRow(ctx, query, videoID, userID, coverPath, thumbnailURL), video)
+	if err != nil {
+		return nil, err
+	}
+
+	return video, nil
+}
 
-	var filePath string
-	err := db.Pool.QueryRow(ctx, query, trackID, userID).Scan(&filePath)
+// ApplyTrackMetadata replaces a track's title, artist, album, release date and
+// MusicBrainz identifiers for a specific user
+func (db *DB) ApplyTrackMetadata(ctx context.Context, trackID, userID string, meta *TrackMetadata) (*Track, error) {
+	query := `
+		UPDATE tracks t
+		SET title = $3, artist = $4, album = $5, release_date = $6,
+		    mb_recording_id = $7, mb_release_id = $8, mb_artist_id = $9, updated_at = NOW()
+		WHERE t.id = $1 AND t.user_id = $2
+		RETURNING ` + trackColumns
+
+	track := &Track{}
+	err := scanTrack(db.Pool.QueryRow(ctx, query, trackID, userID,
+		meta.Title,
+		meta.Artist,
+		meta.Album,
+		meta.ReleaseDate,
+		meta.MBRecordingID,
+		meta.MBReleaseID,
+		meta.MBArtistID,
+	), track)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return filePath, nil
+	return track, nil
 }
 
 // GetVideosByUserID retrieves all videos for a user, ordered by most recent first
 func (db *DB) GetVideosByUserID(ctx context.Context, userID string) ([]Video, error) {
 	query := `
-		SELECT id, user_id, youtube_id, title, channel, duration_seconds, thumbnail_url, file_path, file_size_bytes, quality, created_at, updated_at
-		FROM videos
-		WHERE user_id = $1
-		ORDER BY created_at DESC
+		SELECT ` + videoColumns + `
+		FROM videos v
+		WHERE v.user_id = $1
+		ORDER BY v.created_at DESC
 	`
 
 	rows, err := db.Pool.Query(ctx, query, userID)
@@ -277,21 +477,7 @@ func (db *DB) GetVideosByUserID(ctx context.Context, userID string) ([]Video, er
 	var videos []Video
 	for rows.Next() {
 		var video Video
-		err := rows.Scan(
-			&video.ID,
-			&video.UserID,
-			&video.YoutubeID,
-			&video.Title,
-			&video.Channel,
-			&video.DurationSeconds,
-			&video.ThumbnailURL,
-			&video.FilePath,
-			&video.FileSizeBytes,
-			&video.Quality,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-		)
-		if err != nil {
+		if err := scanVideo(rows, &video); err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
@@ -303,20 +489,3 @@ func (db *DB) GetVideosByUserID(ctx context.Context, userID string) ([]Video, er
 
 	return videos, nil
 }
-
-// DeleteVideo deletes a video by ID for a specific user and returns the file path
-func (db *DB) DeleteVideo(ctx context.Context, videoID, userID string) (string, error) {
-	query := `
-		DELETE FROM videos
-		WHERE id = $1 AND user_id = $2
-		RETURNING file_path
-	`
-
-	var filePath string
-	err := db.Pool.QueryRow(ctx, query, videoID, userID).Scan(&filePath)
-	if err != nil {
-		return "", err
-	}
-
-	return filePath, nil
-}
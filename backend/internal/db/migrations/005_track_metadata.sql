@@ -0,0 +1,6 @@
+-- Canonical metadata for tracks, filled in from MusicBrainz matches
+ALTER TABLE tracks ADD COLUMN album VARCHAR(500) NOT NULL DEFAULT '';
+ALTER TABLE tracks ADD COLUMN release_date VARCHAR(10) NOT NULL DEFAULT '';
+ALTER TABLE tracks ADD COLUMN mb_recording_id UUID;
+ALTER TABLE tracks ADD COLUMN mb_release_id UUID;
+ALTER TABLE tracks ADD COLUMN mb_artist_id UUID;
@@ -0,0 +1,2 @@
+-- Chromaprint fingerprint of each track's audio, used to find duplicate uploads
+ALTER TABLE tracks ADD COLUMN fingerprint BYTEA;
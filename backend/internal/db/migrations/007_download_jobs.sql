@@ -0,0 +1,19 @@
+-- Downloads queued for the background worker, e.g. for imported playlists
+CREATE TABLE IF NOT EXISTS download_jobs (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    youtube_id VARCHAR(20) NOT NULL,
+    type VARCHAR(10) NOT NULL,
+    playlist_id UUID REFERENCES playlists(id) ON DELETE SET NULL,
+    playlist_position INTEGER,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    item_id UUID,
+    run_after TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_download_jobs_pending ON download_jobs(run_after) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_download_jobs_user_id ON download_jobs(user_id, created_at DESC);
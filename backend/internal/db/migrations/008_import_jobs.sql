@@ -0,0 +1,29 @@
+-- Spotify and Apple Music playlist imports, matched row by row against YouTube
+CREATE TABLE IF NOT EXISTS import_jobs (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(500) NOT NULL,
+    playlist_id UUID REFERENCES playlists(id) ON DELETE SET NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'matching',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_import_jobs_user_id ON import_jobs(user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_import_jobs_matching ON import_jobs(created_at) WHERE status = 'matching';
+
+CREATE TABLE IF NOT EXISTS import_rows (
+    job_id UUID NOT NULL REFERENCES import_jobs(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    title TEXT NOT NULL,
+    artist TEXT NOT NULL DEFAULT '',
+    album TEXT NOT NULL DEFAULT '',
+    duration_seconds INTEGER NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    youtube_id VARCHAR(20),
+    score DOUBLE PRECISION,
+    candidates JSONB NOT NULL DEFAULT '[]',
+    download_job_id UUID REFERENCES download_jobs(id) ON DELETE SET NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (job_id, position)
+);
@@ -0,0 +1,17 @@
+-- How often each library item has been played, for sorting the library
+ALTER TABLE tracks ADD COLUMN play_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE videos ADD COLUMN play_count INTEGER NOT NULL DEFAULT 0;
+
+-- Keyset pagination indexes, one per library sort key. Each ends with id,
+-- the tiebreaker that makes cursors unambiguous, and serves both directions.
+CREATE INDEX IF NOT EXISTS idx_tracks_user_added ON tracks(user_id, created_at, id);
+CREATE INDEX IF NOT EXISTS idx_tracks_user_title ON tracks(user_id, lower(title), id);
+CREATE INDEX IF NOT EXISTS idx_tracks_user_artist ON tracks(user_id, lower(COALESCE(artist, '')), id);
+CREATE INDEX IF NOT EXISTS idx_tracks_user_duration ON tracks(user_id, COALESCE(duration_seconds, 0), id);
+CREATE INDEX IF NOT EXISTS idx_tracks_user_plays ON tracks(user_id, play_count, id);
+
+CREATE INDEX IF NOT EXISTS idx_videos_user_added ON videos(user_id, created_at, id);
+CREATE INDEX IF NOT EXISTS idx_videos_user_title ON videos(user_id, lower(title), id);
+CREATE INDEX IF NOT EXISTS idx_videos_user_artist ON videos(user_id, lower(COALESCE(channel, '')), id);
+CREATE INDEX IF NOT EXISTS idx_videos_user_duration ON videos(user_id, COALESCE(duration_seconds, 0), id);
+CREATE INDEX IF NOT EXISTS idx_videos_user_plays ON videos(user_id, play_count, id);
@@ -0,0 +1,35 @@
+-- Full-text and fuzzy search over a user's own library
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+ALTER TABLE tracks ADD COLUMN description TEXT NOT NULL DEFAULT '';
+ALTER TABLE videos ADD COLUMN description TEXT NOT NULL DEFAULT '';
+
+-- The 'simple' configuration doesn't stem, which suits names and titles in
+-- any language. Weights rank title over artist over album over description.
+ALTER TABLE tracks ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', title), 'A') ||
+    setweight(to_tsvector('simple', COALESCE(artist, '')), 'B') ||
+    setweight(to_tsvector('simple', album), 'C') ||
+    setweight(to_tsvector('simple', description), 'D')
+) STORED;
+
+ALTER TABLE videos ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', title), 'A') ||
+    setweight(to_tsvector('simple', COALESCE(channel, '')), 'B') ||
+    setweight(to_tsvector('simple', description), 'D')
+) STORED;
+
+-- Trigram matching on the short fields catches typos the word search misses
+ALTER TABLE tracks ADD COLUMN search_text TEXT GENERATED ALWAYS AS (
+    lower(title || ' ' || COALESCE(artist, '') || ' ' || album)
+) STORED;
+
+ALTER TABLE videos ADD COLUMN search_text TEXT GENERATED ALWAYS AS (
+    lower(title || ' ' || COALESCE(channel, ''))
+) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_tracks_search_vector ON tracks USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS idx_videos_search_vector ON videos USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS idx_tracks_search_text ON tracks USING GIN (search_text gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_videos_search_text ON videos USING GIN (search_text gin_trgm_ops);
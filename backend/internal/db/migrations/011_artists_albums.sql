@@ -0,0 +1,40 @@
+-- Artists and albums as catalog entries that tracks link to. Names are
+-- grouped by a normalized key so differently spelled credits share an entry.
+CREATE TABLE IF NOT EXISTS artists (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(500) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Every key an artist is known by, including its own name's. Merging artists
+-- moves the merged artist's keys here so later downloads link to the survivor.
+CREATE TABLE IF NOT EXISTS artist_aliases (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    normalized_name VARCHAR(500) NOT NULL,
+    artist_id UUID NOT NULL REFERENCES artists(id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, normalized_name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_artist_aliases_artist_id ON artist_aliases(artist_id);
+
+CREATE TABLE IF NOT EXISTS albums (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    artist_id UUID NOT NULL REFERENCES artists(id) ON DELETE CASCADE,
+    title VARCHAR(500) NOT NULL,
+    normalized_title VARCHAR(500) NOT NULL,
+    release_date VARCHAR(10) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, artist_id, normalized_title)
+);
+
+CREATE INDEX IF NOT EXISTS idx_albums_artist_id ON albums(artist_id);
+
+ALTER TABLE tracks ADD COLUMN artist_id UUID REFERENCES artists(id) ON DELETE SET NULL;
+ALTER TABLE tracks ADD COLUMN album_id UUID REFERENCES albums(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_tracks_artist_id ON tracks(artist_id);
+CREATE INDEX IF NOT EXISTS idx_tracks_album_id ON tracks(album_id);
@@ -0,0 +1,29 @@
+-- User-defined labels shared by tracks and videos
+CREATE TABLE IF NOT EXISTS tags (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Tag names are matched ignoring case
+CREATE UNIQUE INDEX IF NOT EXISTS idx_tags_user_name ON tags(user_id, lower(name));
+
+CREATE TABLE IF NOT EXISTS track_tags (
+    tag_id UUID NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    track_id UUID NOT NULL REFERENCES tracks(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (tag_id, track_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_track_tags_track_id ON track_tags(track_id);
+
+CREATE TABLE IF NOT EXISTS video_tags (
+    tag_id UUID NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    video_id UUID NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (tag_id, video_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_video_tags_video_id ON video_tags(video_id);
@@ -0,0 +1,14 @@
+-- Favorites and 0-5 star ratings. A library item is a favorite while
+-- favorited_at is set; 0 means unrated.
+ALTER TABLE tracks ADD COLUMN favorited_at TIMESTAMPTZ;
+ALTER TABLE tracks ADD COLUMN rating SMALLINT NOT NULL DEFAULT 0 CHECK (rating BETWEEN 0 AND 5);
+ALTER TABLE videos ADD COLUMN favorited_at TIMESTAMPTZ;
+ALTER TABLE videos ADD COLUMN rating SMALLINT NOT NULL DEFAULT 0 CHECK (rating BETWEEN 0 AND 5);
+
+-- Keyset pagination indexes for the rating sort, as in 009_library_indexes.sql
+CREATE INDEX IF NOT EXISTS idx_tracks_user_rating ON tracks(user_id, rating, id);
+CREATE INDEX IF NOT EXISTS idx_videos_user_rating ON videos(user_id, rating, id);
+
+-- The favorites playlist lists the most recently favorited first
+CREATE INDEX IF NOT EXISTS idx_tracks_user_favorited ON tracks(user_id, favorited_at) WHERE favorited_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_videos_user_favorited ON videos(user_id, favorited_at) WHERE favorited_at IS NOT NULL;
@@ -0,0 +1,23 @@
+-- Play events uploaded by clients, possibly long after they happened.
+-- Events outlive the library items they refer to so listening totals stay
+-- accurate after deletions.
+CREATE TABLE IF NOT EXISTS play_history (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    track_id UUID REFERENCES tracks(id) ON DELETE SET NULL,
+    video_id UUID REFERENCES videos(id) ON DELETE SET NULL,
+    -- Client-chosen ID that makes re-uploading a batch harmless
+    client_id VARCHAR(100),
+    started_at TIMESTAMPTZ NOT NULL,
+    duration_seconds INTEGER NOT NULL CHECK (duration_seconds >= 0),
+    completed BOOLEAN NOT NULL DEFAULT FALSE,
+    -- Whether the event counts as a play: finished, or listened to for 30s
+    counted BOOLEAN GENERATED ALWAYS AS (completed OR duration_seconds >= 30) STORED,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CHECK (track_id IS NULL OR video_id IS NULL)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_play_history_client_id ON play_history(user_id, client_id);
+CREATE INDEX IF NOT EXISTS idx_play_history_user_started ON play_history(user_id, started_at);
+CREATE INDEX IF NOT EXISTS idx_play_history_track_id ON play_history(track_id);
+CREATE INDEX IF NOT EXISTS idx_play_history_video_id ON play_history(video_id);
@@ -0,0 +1,35 @@
+-- Accounts on ListenBrainz or Last.fm that plays are forwarded to. The
+-- token is a ListenBrainz user token or a Last.fm session key.
+CREATE TABLE IF NOT EXISTS scrobble_accounts (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    service VARCHAR(20) NOT NULL,
+    token TEXT NOT NULL,
+    username VARCHAR(255) NOT NULL DEFAULT '',
+    -- Set when the service rejects the token; submissions wait for a relink
+    disabled_at TIMESTAMPTZ,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, service)
+);
+
+-- Submissions waiting to be sent, kept until the service accepts them
+CREATE TABLE IF NOT EXISTS scrobble_outbox (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL,
+    service VARCHAR(20) NOT NULL,
+    kind VARCHAR(20) NOT NULL,
+    play_id UUID REFERENCES play_history(id) ON DELETE CASCADE,
+    listen JSONB NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    -- Now playing notices are pointless once the track has ended
+    expires_at TIMESTAMPTZ,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (user_id, service) REFERENCES scrobble_accounts(user_id, service) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_scrobble_outbox_play ON scrobble_outbox(play_id, service);
+CREATE INDEX IF NOT EXISTS idx_scrobble_outbox_next_attempt ON scrobble_outbox(next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_scrobble_outbox_account ON scrobble_outbox(user_id, service);
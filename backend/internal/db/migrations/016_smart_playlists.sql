@@ -0,0 +1,3 @@
+-- Smart playlists hold no tracks of their own; their rules choose tracks
+-- from the library each time they're read. NULL for ordinary playlists.
+ALTER TABLE playlists ADD COLUMN rules JSONB;
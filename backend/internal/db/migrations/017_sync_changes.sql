@@ -0,0 +1,111 @@
+-- Change log behind GET /sync. Each track, video and playlist a user has or
+-- had keeps one row recording its latest change; deleted items stay behind
+-- as tombstones. txid is the writing transaction, which sync tokens compare
+-- against so that changes committed out of order aren't skipped.
+CREATE TABLE IF NOT EXISTS sync_changes (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind VARCHAR(20) NOT NULL,
+    item_id UUID NOT NULL,
+    deleted BOOLEAN NOT NULL,
+    txid xid8 NOT NULL DEFAULT pg_current_xact_id(),
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, kind, item_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_sync_changes_user_txid ON sync_changes(user_id, txid);
+
+-- Changes made while a user is being deleted aren't recorded
+CREATE OR REPLACE FUNCTION record_sync_change(change_user UUID, change_kind TEXT, change_item UUID, change_deleted BOOLEAN)
+RETURNS void AS $$
+    INSERT INTO sync_changes (user_id, kind, item_id, deleted)
+    SELECT change_user, change_kind, change_item, change_deleted
+    WHERE EXISTS (SELECT 1 FROM users WHERE id = change_user)
+    ON CONFLICT (user_id, kind, item_id) DO UPDATE SET
+        deleted = EXCLUDED.deleted,
+        txid = EXCLUDED.txid,
+        changed_at = NOW()
+$$ LANGUAGE sql;
+
+-- Records a change to a tracks, videos or playlists row; TG_ARGV[0] is the kind
+CREATE OR REPLACE FUNCTION sync_item_changed() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        PERFORM record_sync_change(OLD.user_id, TG_ARGV[0], OLD.id, TRUE);
+    ELSE
+        PERFORM record_sync_change(NEW.user_id, TG_ARGV[0], NEW.id, FALSE);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Records a change to the playlist a playlist_tracks row belongs to
+CREATE OR REPLACE FUNCTION sync_playlist_tracks_changed() RETURNS trigger AS $$
+DECLARE
+    changed_playlist UUID;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        changed_playlist := OLD.playlist_id;
+    ELSE
+        changed_playlist := NEW.playlist_id;
+    END IF;
+    PERFORM record_sync_change(p.user_id, 'playlist', p.id, FALSE)
+    FROM playlists p WHERE p.id = changed_playlist;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Records a change to the item a track_tags or video_tags row belongs to,
+-- since items carry their tag names; TG_ARGV[0] is the kind
+CREATE OR REPLACE FUNCTION sync_item_tags_changed() RETURNS trigger AS $$
+DECLARE
+    tag_row RECORD;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        tag_row := OLD;
+    ELSE
+        tag_row := NEW;
+    END IF;
+    IF TG_ARGV[0] = 'track' THEN
+        PERFORM record_sync_change(t.user_id, 'track', t.id, FALSE)
+        FROM tracks t WHERE t.id = tag_row.track_id;
+    ELSE
+        PERFORM record_sync_change(v.user_id, 'video', v.id, FALSE)
+        FROM videos v WHERE v.id = tag_row.video_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Renaming a tag changes every item carrying it
+CREATE OR REPLACE FUNCTION sync_tag_renamed() RETURNS trigger AS $$
+BEGIN
+    PERFORM record_sync_change(NEW.user_id, 'track', tt.track_id, FALSE)
+    FROM track_tags tt WHERE tt.tag_id = NEW.id;
+    PERFORM record_sync_change(NEW.user_id, 'video', vt.video_id, FALSE)
+    FROM video_tags vt WHERE vt.tag_id = NEW.id;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER tracks_sync AFTER INSERT OR UPDATE OR DELETE ON tracks
+    FOR EACH ROW EXECUTE FUNCTION sync_item_changed('track');
+CREATE TRIGGER videos_sync AFTER INSERT OR UPDATE OR DELETE ON videos
+    FOR EACH ROW EXECUTE FUNCTION sync_item_changed('video');
+CREATE TRIGGER playlists_sync AFTER INSERT OR UPDATE OR DELETE ON playlists
+    FOR EACH ROW EXECUTE FUNCTION sync_item_changed('playlist');
+CREATE TRIGGER playlist_tracks_sync AFTER INSERT OR UPDATE OR DELETE ON playlist_tracks
+    FOR EACH ROW EXECUTE FUNCTION sync_playlist_tracks_changed();
+CREATE TRIGGER track_tags_sync AFTER INSERT OR DELETE ON track_tags
+    FOR EACH ROW EXECUTE FUNCTION sync_item_tags_changed('track');
+CREATE TRIGGER video_tags_sync AFTER INSERT OR DELETE ON video_tags
+    FOR EACH ROW EXECUTE FUNCTION sync_item_tags_changed('video');
+CREATE TRIGGER tags_sync AFTER UPDATE OF name ON tags
+    FOR EACH ROW EXECUTE FUNCTION sync_tag_renamed();
+
+-- Everything that already exists counts as created now
+INSERT INTO sync_changes (user_id, kind, item_id, deleted)
+SELECT user_id, 'track', id, FALSE FROM tracks
+UNION ALL
+SELECT user_id, 'video', id, FALSE FROM videos
+UNION ALL
+SELECT user_id, 'playlist', id, FALSE FROM playlists;
@@ -0,0 +1,20 @@
+-- Deleted tracks and videos wait here until they're restored or purged.
+-- item holds the deleted row; tag_ids, playlists and play_ids hold the links
+-- that went with it, so restoring can put them back. playlists is a list of
+-- {playlist_id, index} giving each playlist entry's place at deletion. The
+-- media file stays on disk until the entry is purged.
+CREATE TABLE IF NOT EXISTS trash (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind VARCHAR(20) NOT NULL,
+    item JSONB NOT NULL,
+    file_path TEXT NOT NULL,
+    tag_ids UUID[] NOT NULL DEFAULT '{}',
+    playlists JSONB NOT NULL DEFAULT '[]',
+    play_ids UUID[] NOT NULL DEFAULT '{}',
+    deleted_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_trash_user_deleted_at ON trash(user_id, deleted_at DESC);
+CREATE INDEX IF NOT EXISTS idx_trash_deleted_at ON trash(deleted_at);
+CREATE INDEX IF NOT EXISTS idx_trash_file_path ON trash(file_path);
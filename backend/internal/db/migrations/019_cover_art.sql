@@ -0,0 +1,3 @@
+-- Uploaded cover art stored on disk; thumbnail_url points at it while set
+ALTER TABLE tracks ADD COLUMN cover_path TEXT;
+ALTER TABLE videos ADD COLUMN cover_path TEXT;
@@ -0,0 +1,16 @@
+-- Where each user last was in a track or video, shared across their devices.
+-- updated_at is when the position was reached on the device, so the latest
+-- position wins even when an older one is uploaded later.
+CREATE TABLE IF NOT EXISTS playback_positions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    track_id UUID REFERENCES tracks(id) ON DELETE CASCADE,
+    video_id UUID REFERENCES videos(id) ON DELETE CASCADE,
+    position_seconds INTEGER NOT NULL CHECK (position_seconds >= 0),
+    finished BOOLEAN NOT NULL DEFAULT FALSE,
+    updated_at TIMESTAMPTZ NOT NULL,
+    CHECK ((track_id IS NULL) <> (video_id IS NULL))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_playback_positions_item ON playback_positions(user_id, (COALESCE(track_id, video_id)));
+CREATE INDEX IF NOT EXISTS idx_playback_positions_user_updated ON playback_positions(user_id, updated_at DESC);
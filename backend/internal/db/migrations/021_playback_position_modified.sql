@@ -0,0 +1,7 @@
+-- When the server last stored each position. updated_at comes from the
+-- device, so a position uploaded late by an offline device can be older
+-- than what other devices have already synced past; clients fetch changes
+-- by modified_at instead.
+ALTER TABLE playback_positions ADD COLUMN modified_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+CREATE INDEX idx_playback_positions_user_modified ON playback_positions(user_id, modified_at DESC);
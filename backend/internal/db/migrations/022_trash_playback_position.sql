@@ -0,0 +1,3 @@
+-- The playback_positions row of a trashed item, so restoring it keeps the
+-- resume point. NULL when the item had no position.
+ALTER TABLE trash ADD COLUMN playback_position JSONB;
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// PlayEvent is one playback of a track or video reported by a client
+type PlayEvent struct {
+	TrackID         *string
+	VideoID         *string
+	ClientID        *string
+	StartedAt       time.Time
+	DurationSeconds int
+	Completed       bool
+}
+
+// RecordPlays stores a batch of play events and adds the ones that count as
+// plays to their items' play counts. Track plays long enough to scrobble are
+// queued for each of the user's linked scrobbling accounts. Events for items
+// the user doesn't own and events whose client ID was already recorded are
+// skipped. It returns how many events were stored.
+func (db *DB) RecordPlays(ctx context.Context, userID string, events []PlayEvent) (int, error) {
+	trackIDs := make([]*string, len(events))
+	videoIDs := make([]*string, len(events))
+	clientIDs := make([]*string, len(events))
+	startedAt := make([]time.Time, len(events))
+	durations := make([]int32, len(events))
+	completed := make([]bool, len(events))
+	for i, event := range events {
+		trackIDs[i] = event.TrackID
+		videoIDs[i] = event.VideoID
+		clientIDs[i] = event.ClientID
+		startedAt[i] = event.StartedAt
+		durations[i] = int32(event.DurationSeconds)
+		completed[i] = event.Completed
+	}
+
+	var recorded int
+	err := db.Pool.QueryRow(ctx, `
+		WITH inserted AS (
+			INSERT INTO play_history (user_id, track_id, video_id, client_id, started_at, duration_seconds, completed)
+			SELECT $1, e.track_id, e.video_id, e.client_id, e.started_at, e.duration_seconds, e.completed
+			FROM unnest($2::uuid[], $3::uuid[], $4::text[], $5::timestamptz[], $6::integer[], $7::boolean[])
+				AS e(track_id, video_id, client_id, started_at, duration_seconds, completed)
+			WHERE (e.track_id IS NULL OR EXISTS (SELECT 1 FROM tracks WHERE id = e.track_id AND user_id = $1))
+			  AND (e.video_id IS NULL OR EXISTS (SELECT 1 FROM videos WHERE id = e.video_id AND user_id = $1))
+			ON CONFLICT (user_id, client_id) DO NOTHING
+			RETURNING id, track_id, video_id, started_at, duration_seconds, completed, counted
+		), scrobbles AS (
+			-- Scrobbling services want tracks over 30 seconds played to the end,
+			-- for half their length or for four minutes
+			INSERT INTO scrobble_outbox (user_id, service, kind, play_id, listen)
+			SELECT $1, sa.service, 'scrobble', i.id, `+fmt.Sprintf(scrobbleListenJSON, "i.started_at")+`
+			FROM inserted i
+			INNER JOIN tracks t ON t.id = i.track_id
+			INNER JOIN scrobble_accounts sa ON sa.user_id = $1
+			WHERE t.duration_seconds > 30
+			  AND (i.completed OR i.duration_seconds >= LEAST(240, t.duration_seconds / 2))
+		), track_plays AS (
+			UPDATE tracks t SET play_count = t.play_count + p.plays
+			FROM (
+				SELECT track_id, COUNT(*) AS plays FROM inserted
+				WHERE counted AND track_id IS NOT NULL GROUP BY track_id
+			) p
+			WHERE t.id = p.track_id
+		), video_plays AS (
+			UPDATE videos v SET play_count = v.play_count + p.plays
+			FROM (
+				SELECT video_id, COUNT(*) AS plays FROM inserted
+				WHERE counted AND video_id IS NOT NULL GROUP BY video_id
+			) p
+			WHERE v.id = p.video_id
+		)
+		SELECT COUNT(*) FROM inserted
+	`, userID, trackIDs, videoIDs, clientIDs, startedAt, durations, completed).Scan(&recorded)
+	if err != nil {
+		return 0, fmt.Errorf("record plays: %w", err)
+	}
+
+	return recorded, nil
+}
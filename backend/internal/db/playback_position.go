@@ -0,0 +1,230 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// LongTrackSeconds is the length from which a track is resumable like a
+	// video, e.g. an audiobook chapter, podcast or DJ mix
+	LongTrackSeconds = 20 * 60
+
+	// positionFinishedRatio is how far through an item a position marks it
+	// finished
+	positionFinishedRatio = 0.95
+
+	// minResumeSeconds is how far into an item a position must be to count
+	// as partially played
+	minResumeSeconds = 30
+)
+
+// PlaybackPosition is where a user last was in a track or video. Kind is
+// SyncTrack or SyncVideo. UpdatedAt is when the device reached the position
+// and ModifiedAt when the server stored it.
+type PlaybackPosition struct {
+	ItemID          string
+	Kind            string
+	PositionSeconds int
+	DurationSeconds int
+	Finished        bool
+	UpdatedAt       time.Time
+	ModifiedAt      time.Time
+}
+
+// ResumeItem is a partially played track or video with its position.
+// Exactly one of Track and Video is set.
+type ResumeItem struct {
+	Position PlaybackPosition
+	Track    *Track
+	Video    *Video
+}
+
+// positionColumns lists the columns scanned by positionScanTargets,
+// qualified by the alias p
+const positionColumns = `COALESCE(p.track_id, p.video_id),
+	CASE WHEN p.track_id IS NOT NULL THEN 'track' ELSE 'video' END,
+	p.position_seconds,
+	COALESCE((SELECT duration_seconds FROM tracks WHERE id = p.track_id),
+		(SELECT duration_seconds FROM videos WHERE id = p.video_id), 0),
+	p.finished, p.updated_at, p.modified_at`
+
+func positionScanTargets(pos *PlaybackPosition) []interface{} {
+	return []interface{}{
+		&pos.ItemID,
+		&pos.Kind,
+		&pos.PositionSeconds,
+		&pos.DurationSeconds,
+		&pos.Finished,
+		&pos.UpdatedAt,
+		&pos.ModifiedAt,
+	}
+}
+
+// SavePlaybackPosition records where a user is in one of their tracks or
+// videos as of updatedAt, which is capped at the current time. A position
+// reported as of an earlier time than the stored one is ignored, so the
+// latest wins; applied reports whether it was stored. Positions past the
+// end are capped at the item's duration, and positions near the end mark
+// the item finished. Returns ErrNotFound if the user has no such item.
+func (db *DB) SavePlaybackPosition(ctx context.Context, userID, itemID string, positionSeconds int, finished bool, updatedAt time.Time) (pos *PlaybackPosition, applied bool, err error) {
+	pos = &PlaybackPosition{}
+	err = db.Pool.QueryRow(ctx, `
+		WITH item AS (
+			SELECT id AS track_id, NULL::uuid AS video_id, COALESCE(duration_seconds, 0) AS duration_seconds
+			FROM tracks WHERE id = $2 AND user_id = $1
+			UNION ALL
+			SELECT NULL, id, COALESCE(duration_seconds, 0)
+			FROM videos WHERE id = $2 AND user_id = $1
+		), p AS (
+			INSERT INTO playback_positions (user_id, track_id, video_id, position_seconds, finished, updated_at)
+			SELECT $1, item.track_id, item.video_id,
+				CASE WHEN item.duration_seconds > 0 THEN LEAST($3::int, item.duration_seconds) ELSE $3::int END,
+				$4::boolean OR (item.duration_seconds > 0 AND $3::int >= item.duration_seconds * $6::float8),
+				LEAST($5::timestamptz, NOW())
+			FROM item
+			ON CONFLICT (user_id, (COALESCE(track_id, video_id))) DO UPDATE SET
+				position_seconds = EXCLUDED.position_seconds,
+				finished = EXCLUDED.finished,
+				updated_at = EXCLUDED.updated_at,
+				modified_at = NOW()
+			WHERE playback_positions.updated_at <= EXCLUDED.updated_at
+			RETURNING track_id, video_id, position_seconds, finished, updated_at, modified_at
+		)
+		SELECT `+positionColumns+`
+		FROM p
+	`, userID, itemID, positionSeconds, finished, updatedAt, positionFinishedRatio).Scan(positionScanTargets(pos)...)
+	if err == nil {
+		return pos, true, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, false, fmt.Errorf("save playback position: %w", err)
+	}
+
+	// Either the item doesn't exist or a later position is already stored
+	pos, err = db.GetPlaybackPosition(ctx, userID, itemID)
+	if err != nil {
+		return nil, false, err
+	}
+	return pos, false, nil
+}
+
+// GetPlaybackPosition returns where a user is in a track or video. Returns
+// ErrNotFound if no position is stored for it.
+func (db *DB) GetPlaybackPosition(ctx context.Context, userID, itemID string) (*PlaybackPosition, error) {
+	pos := &PlaybackPosition{}
+	err := db.Pool.QueryRow(ctx, `
+		SELECT `+positionColumns+`
+		FROM playback_positions p
+		WHERE p.user_id = $1 AND COALESCE(p.track_id, p.video_id) = $2
+	`, userID, itemID).Scan(positionScanTargets(pos)...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get playback position: %w", err)
+	}
+
+	return pos, nil
+}
+
+// ListPlaybackPositions returns a user's positions stored after since, or
+// all of them if since is nil, most recently stored first. Since is compared
+// with ModifiedAt, so positions uploaded late by an offline device are still
+// returned to devices that synced in the meantime.
+func (db *DB) ListPlaybackPositions(ctx context.Context, userID string, since *time.Time) ([]PlaybackPosition, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT `+positionColumns+`
+		FROM playback_positions p
+		WHERE p.user_id = $1 AND ($2::timestamptz IS NULL OR p.modified_at > $2)
+		ORDER BY p.modified_at DESC
+	`, userID, since)
+	if err != nil {
+		return nil, fmt.Errorf("get playback positions: %w", err)
+	}
+	defer rows.Close()
+
+	var positions []PlaybackPosition
+	for rows.Next() {
+		var pos PlaybackPosition
+		if err := rows.Scan(positionScanTargets(&pos)...); err != nil {
+			return nil, fmt.Errorf("scan playback position: %w", err)
+		}
+		positions = append(positions, pos)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate playback positions: %w", err)
+	}
+
+	return positions, nil
+}
+
+// GetContinueWatching returns up to limit videos and long tracks the user
+// is partway through, most recently played first
+func (db *DB) GetContinueWatching(ctx context.Context, userID string, limit int) ([]ResumeItem, error) {
+	var items []ResumeItem
+
+	rows, err := db.Pool.Query(ctx, `
+		SELECT `+trackColumns+`, `+positionColumns+`
+		FROM playback_positions p
+		INNER JOIN tracks t ON t.id = p.track_id
+		WHERE p.user_id = $1 AND NOT p.finished AND p.position_seconds >= $2 AND t.duration_seconds >= $3
+		ORDER BY p.updated_at DESC
+		LIMIT $4
+	`, userID, minResumeSeconds, LongTrackSeconds, limit)
+	if err != nil {
+		return nil, fmt.Errorf("get tracks to resume: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := ResumeItem{Track: &Track{}}
+		if err := rows.Scan(append(trackScanTargets(item.Track), positionScanTargets(&item.Position)...)...); err != nil {
+			return nil, fmt.Errorf("scan track to resume: %w", err)
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate tracks to resume: %w", err)
+	}
+	rows.Close()
+
+	rows, err = db.Pool.Query(ctx, `
+		SELECT `+videoColumns+`, `+positionColumns+`
+		FROM playback_positions p
+		INNER JOIN videos v ON v.id = p.video_id
+		WHERE p.user_id = $1 AND NOT p.finished AND p.position_seconds >= $2
+		ORDER BY p.updated_at DESC
+		LIMIT $3
+	`, userID, minResumeSeconds, limit)
+	if err != nil {
+		return nil, fmt.Errorf("get videos to resume: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := ResumeItem{Video: &Video{}}
+		if err := rows.Scan(append(videoScanTargets(item.Video), positionScanTargets(&item.Position)...)...); err != nil {
+			return nil, fmt.Errorf("scan video to resume: %w", err)
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate videos to resume: %w", err)
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Position.UpdatedAt.After(items[j].Position.UpdatedAt)
+	})
+	if len(items) > limit {
+		items = items[:limit]
+	}
+
+	return items, nil
+}
@@ -2,7 +2,11 @@ package db
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
+
+	"github.com/jackc/pgx/v5"
 )
 
 // Playlist represents a user's playlist
@@ -12,6 +16,27 @@ type Playlist struct {
 	Name      string
 	CreatedAt time.Time
 	UpdatedAt time.Time
+
+	// Virtual playlists, like favorites, are derived from the library and
+	// can't be edited
+	Virtual bool
+
+	// Rules makes a smart playlist, whose tracks are chosen by its rules
+	// when it's read. Nil for ordinary playlists.
+	Rules *SmartRules
+}
+
+// ReadOnly reports whether the playlist's tracks can't be added, removed or
+// reordered directly
+func (p *Playlist) ReadOnly() bool {
+	return p.Virtual || p.Rules != nil
+}
+
+// playlistColumns lists the columns scanned by playlistScanTargets
+const playlistColumns = `id, user_id, name, rules, created_at, updated_at`
+
+func playlistScanTargets(p *Playlist) []interface{} {
+	return []interface{}{&p.ID, &p.UserID, &p.Name, &p.Rules, &p.CreatedAt, &p.UpdatedAt}
 }
 
 // PlaylistWithTracks represents a playlist with its tracks
@@ -25,17 +50,11 @@ func (db *DB) CreatePlaylist(ctx context.Context, userID, name string) (*Playlis
 	query := `
 		INSERT INTO playlists (user_id, name)
 		VALUES ($1, $2)
-		RETURNING id, user_id, name, created_at, updated_at
+		RETURNING ` + playlistColumns + `
 	`
 
 	playlist := &Playlist{}
-	err := db.Pool.QueryRow(ctx, query, userID, name).Scan(
-		&playlist.ID,
-		&playlist.UserID,
-		&playlist.Name,
-		&playlist.CreatedAt,
-		&playlist.UpdatedAt,
-	)
+	err := db.Pool.QueryRow(ctx, query, userID, name).Scan(playlistScanTargets(playlist)...)
 
 	if err != nil {
 		return nil, err
@@ -44,10 +63,64 @@ func (db *DB) CreatePlaylist(ctx context.Context, userID, name string) (*Playlis
 	return playlist, nil
 }
 
+// PlaylistEntry places a track at a stored position in a playlist
+type PlaylistEntry struct {
+	TrackID  string
+	Position int
+}
+
+// CreatePlaylistWithTracks creates a playlist holding the given entries.
+// Positions may have gaps, so that tracks added later with
+// InsertTrackIntoPlaylist can fill them. Repeated tracks keep their first
+// position.
+func (db *DB) CreatePlaylistWithTracks(ctx context.Context, userID, name string, entries []PlaylistEntry) (*Playlist, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	playlist := &Playlist{}
+	err = tx.QueryRow(ctx, `
+		INSERT INTO playlists (user_id, name)
+		VALUES ($1, $2)
+		RETURNING `+playlistColumns+`
+	`, userID, name).Scan(playlistScanTargets(playlist)...)
+	if err != nil {
+		return nil, fmt.Errorf("create playlist: %w", err)
+	}
+
+	trackIDs := make([]string, len(entries))
+	positions := make([]int32, len(entries))
+	for i, e := range entries {
+		trackIDs[i] = e.TrackID
+		positions[i] = int32(e.Position)
+	}
+
+	// Tracks are checked against the user's library so other users' IDs can't be added
+	_, err = tx.Exec(ctx, `
+		INSERT INTO playlist_tracks (playlist_id, track_id, position)
+		SELECT $1, t.id, e.position
+		FROM unnest($2::uuid[], $3::integer[]) AS e(track_id, position)
+		INNER JOIN tracks t ON t.id = e.track_id AND t.user_id = $4
+		ORDER BY e.position
+		ON CONFLICT (playlist_id, track_id) DO NOTHING
+	`, playlist.ID, trackIDs, positions, userID)
+	if err != nil {
+		return nil, fmt.Errorf("add playlist tracks: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit transaction: %w", err)
+	}
+
+	return playlist, nil
+}
+
 // GetPlaylistsByUserID retrieves all playlists for a user
 func (db *DB) GetPlaylistsByUserID(ctx context.Context, userID string) ([]Playlist, error) {
 	query := `
-		SELECT id, user_id, name, created_at, updated_at
+		SELECT ` + playlistColumns + `
 		FROM playlists
 		WHERE user_id = $1
 		ORDER BY created_at DESC
@@ -62,13 +135,7 @@ func (db *DB) GetPlaylistsByUserID(ctx context.Context, userID string) ([]Playli
 	var playlists []Playlist
 	for rows.Next() {
 		var p Playlist
-		err := rows.Scan(
-			&p.ID,
-			&p.UserID,
-			&p.Name,
-			&p.CreatedAt,
-			&p.UpdatedAt,
-		)
+		err := rows.Scan(playlistScanTargets(&p)...)
 		if err != nil {
 			return nil, err
 		}
@@ -85,19 +152,13 @@ func (db *DB) GetPlaylistsByUserID(ctx context.Context, userID string) ([]Playli
 // GetPlaylistByID retrieves a playlist by ID for a specific user
 func (db *DB) GetPlaylistByID(ctx context.Context, playlistID, userID string) (*Playlist, error) {
 	query := `
-		SELECT id, user_id, name, created_at, updated_at
+		SELECT ` + playlistColumns + `
 		FROM playlists
 		WHERE id = $1 AND user_id = $2
 	`
 
 	playlist := &Playlist{}
-	err := db.Pool.QueryRow(ctx, query, playlistID, userID).Scan(
-		&playlist.ID,
-		&playlist.UserID,
-		&playlist.Name,
-		&playlist.CreatedAt,
-		&playlist.UpdatedAt,
-	)
+	err := db.Pool.QueryRow(ctx, query, playlistID, userID).Scan(playlistScanTargets(playlist)...)
 
 	if err != nil {
 		return nil, err
@@ -106,18 +167,30 @@ func (db *DB) GetPlaylistByID(ctx context.Context, playlistID, userID string) (*
 	return playlist, nil
 }
 
-// GetPlaylistWithTracks retrieves a playlist with all its tracks
+// GetPlaylistWithTracks retrieves a playlist with all its tracks, including
+// the virtual favorites playlist and smart playlists
 func (db *DB) GetPlaylistWithTracks(ctx context.Context, playlistID, userID string) (*PlaylistWithTracks, error) {
+	if playlistID == FavoritesPlaylistID {
+		return db.getFavoritesPlaylistWithTracks(ctx, userID)
+	}
+
 	// First get the playlist
 	playlist, err := db.GetPlaylistByID(ctx, playlistID, userID)
 	if err != nil {
 		return nil, err
 	}
 
+	if playlist.Rules != nil {
+		tracks, err := db.getSmartPlaylistTracks(ctx, playlist)
+		if err != nil {
+			return nil, err
+		}
+		return &PlaylistWithTracks{Playlist: *playlist, Tracks: tracks}, nil
+	}
+
 	// Then get the tracks in order
 	query := `
-		SELECT t.id, t.user_id, t.youtube_id, t.title, t.artist, t.duration_seconds,
-		       t.thumbnail_url, t.file_path, t.file_size_bytes, t.created_at, t.updated_at
+		SELECT ` + trackColumns + `
 		FROM tracks t
 		INNER JOIN playlist_tracks pt ON t.id = pt.track_id
 		WHERE pt.playlist_id = $1
@@ -133,20 +206,7 @@ func (db *DB) GetPlaylistWithTracks(ctx context.Context, playlistID, userID stri
 	var tracks []Track
 	for rows.Next() {
 		var track Track
-		err := rows.Scan(
-			&track.ID,
-			&track.UserID,
-			&track.YoutubeID,
-			&track.Title,
-			&track.Artist,
-			&track.DurationSeconds,
-			&track.ThumbnailURL,
-			&track.FilePath,
-			&track.FileSizeBytes,
-			&track.CreatedAt,
-			&track.UpdatedAt,
-		)
-		if err != nil {
+		if err := scanTrack(rows, &track); err != nil {
 			return nil, err
 		}
 		tracks = append(tracks, track)
@@ -162,23 +222,49 @@ func (db *DB) GetPlaylistWithTracks(ctx context.Context, playlistID, userID stri
 	}, nil
 }
 
-// UpdatePlaylist updates a playlist's name
-func (db *DB) UpdatePlaylist(ctx context.Context, playlistID, userID, name string) (*Playlist, error) {
+// GetPlaylistTrackIDs returns the track IDs of every playlist a user has, in
+// playlist order, keyed by playlist ID
+func (db *DB) GetPlaylistTrackIDs(ctx context.Context, userID string) (map[string][]string, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT pt.playlist_id, pt.track_id
+		FROM playlist_tracks pt
+		INNER JOIN playlists p ON p.id = pt.playlist_id
+		WHERE p.user_id = $1
+		ORDER BY pt.playlist_id, pt.position
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("get playlist track ids: %w", err)
+	}
+	defer rows.Close()
+
+	trackIDs := make(map[string][]string)
+	for rows.Next() {
+		var playlistID, trackID string
+		if err := rows.Scan(&playlistID, &trackID); err != nil {
+			return nil, fmt.Errorf("scan playlist track: %w", err)
+		}
+		trackIDs[playlistID] = append(trackIDs[playlistID], trackID)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate playlist tracks: %w", err)
+	}
+
+	return trackIDs, nil
+}
+
+// UpdatePlaylist updates a playlist's name and, for a smart playlist, its
+// rules. Nil rules are left unchanged.
+func (db *DB) UpdatePlaylist(ctx context.Context, playlistID, userID, name string, rules *SmartRules) (*Playlist, error) {
 	query := `
 		UPDATE playlists
-		SET name = $3, updated_at = NOW()
+		SET name = $3, rules = CASE WHEN rules IS NULL THEN NULL ELSE COALESCE($4, rules) END, updated_at = NOW()
 		WHERE id = $1 AND user_id = $2
-		RETURNING id, user_id, name, created_at, updated_at
+		RETURNING ` + playlistColumns + `
 	`
 
 	playlist := &Playlist{}
-	err := db.Pool.QueryRow(ctx, query, playlistID, userID, name).Scan(
-		&playlist.ID,
-		&playlist.UserID,
-		&playlist.Name,
-		&playlist.CreatedAt,
-		&playlist.UpdatedAt,
-	)
+	err := db.Pool.QueryRow(ctx, query, playlistID, userID, name, rules).Scan(playlistScanTargets(playlist)...)
 
 	if err != nil {
 		return nil, err
@@ -232,6 +318,109 @@ func (db *DB) AddTrackToPlaylist(ctx context.Context, playlistID, trackID string
 	return err
 }
 
+// InsertTrackIntoPlaylist adds a track at a stored position. Callers filling
+// in a playlist made by CreatePlaylistWithTracks pass the track's index in
+// the same source, so it lands between its neighbours however many of them
+// are there yet. An entry already at the position moves back, along with
+// those after it. A track already in the playlist is left where it is.
+func (db *DB) InsertTrackIntoPlaylist(ctx context.Context, playlistID, trackID string, position int) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	exists, err := lockPlaylistForInsert(ctx, tx, playlistID, trackID)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE playlist_tracks SET position = position + 1
+		WHERE playlist_id = $1 AND position >= $2
+			AND EXISTS (SELECT 1 FROM playlist_tracks WHERE playlist_id = $1 AND position = $2)
+	`, playlistID, position)
+	if err != nil {
+		return fmt.Errorf("shift playlist tracks: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO playlist_tracks (playlist_id, track_id, position)
+		VALUES ($1, $2, $3)
+	`, playlistID, trackID, position)
+	if err != nil {
+		return fmt.Errorf("insert playlist track: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
+
+// lockPlaylistForInsert locks a playlist so concurrent inserts don't
+// interleave their shifts, and reports whether it already holds the track
+func lockPlaylistForInsert(ctx context.Context, tx pgx.Tx, playlistID, trackID string) (bool, error) {
+	if _, err := tx.Exec(ctx, `SELECT id FROM playlists WHERE id = $1 FOR UPDATE`, playlistID); err != nil {
+		return false, fmt.Errorf("lock playlist: %w", err)
+	}
+
+	var exists bool
+	err := tx.QueryRow(ctx, `
+		SELECT EXISTS(SELECT 1 FROM playlist_tracks WHERE playlist_id = $1 AND track_id = $2)
+	`, playlistID, trackID).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("check playlist track: %w", err)
+	}
+	return exists, nil
+}
+
+// insertPlaylistTrack inserts a track within tx so that it becomes the
+// playlist's entry at index, shifting later entries back. Indexes past the
+// end append the track. A track already in the playlist is left where it is.
+func insertPlaylistTrack(ctx context.Context, tx pgx.Tx, playlistID, trackID string, index int) error {
+	exists, err := lockPlaylistForInsert(ctx, tx, playlistID, trackID)
+	if err != nil || exists {
+		return err
+	}
+
+	// Positions may have gaps after removals, so find the stored position
+	// of the entry currently at the requested index
+	var at int
+	err = tx.QueryRow(ctx, `
+		SELECT position FROM playlist_tracks
+		WHERE playlist_id = $1
+		ORDER BY position
+		OFFSET $2 LIMIT 1
+	`, playlistID, index).Scan(&at)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		err = tx.QueryRow(ctx, `
+			SELECT COALESCE(MAX(position) + 1, 0) FROM playlist_tracks WHERE playlist_id = $1
+		`, playlistID).Scan(&at)
+		if err != nil {
+			return fmt.Errorf("get next position: %w", err)
+		}
+	case err != nil:
+		return fmt.Errorf("get insert position: %w", err)
+	default:
+		_, err = tx.Exec(ctx, `
+			UPDATE playlist_tracks SET position = position + 1
+			WHERE playlist_id = $1 AND position >= $2
+		`, playlistID, at)
+		if err != nil {
+			return fmt.Errorf("shift playlist tracks: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO playlist_tracks (playlist_id, track_id, position)
+		VALUES ($1, $2, $3)
+	`, playlistID, trackID, at)
+	if err != nil {
+		return fmt.Errorf("insert playlist track: %w", err)
+	}
+
+	return nil
+}
+
 // RemoveTrackFromPlaylist removes a track from a playlist
 func (db *DB) RemoveTrackFromPlaylist(ctx context.Context, playlistID, trackID string) error {
 	query := `
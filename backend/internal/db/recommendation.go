@@ -0,0 +1,141 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// GetCoOccurringTracks returns up to limit of the user's tracks that share
+// playlists with a track, most closely related first. Sharing a small
+// playlist counts for more than sharing a large one.
+func (db *DB) GetCoOccurringTracks(ctx context.Context, userID, trackID string, limit int) ([]Track, error) {
+	rows, err := db.Pool.Query(ctx, `
+		WITH shared AS (
+			SELECT other.track_id, SUM(1.0 / sizes.track_count) AS score
+			FROM playlist_tracks seed
+			INNER JOIN playlists p ON p.id = seed.playlist_id AND p.user_id = $1
+			INNER JOIN LATERAL (
+				SELECT COUNT(*) AS track_count FROM playlist_tracks WHERE playlist_id = seed.playlist_id
+			) sizes ON TRUE
+			INNER JOIN playlist_tracks other ON other.playlist_id = seed.playlist_id AND other.track_id <> seed.track_id
+			WHERE seed.track_id = $2
+			GROUP BY other.track_id
+		)
+		SELECT `+trackColumns+`
+		FROM shared
+		INNER JOIN tracks t ON t.id = shared.track_id
+		WHERE t.user_id = $1
+		ORDER BY shared.score DESC, t.play_count DESC, t.id
+		LIMIT $3
+	`, userID, trackID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("get co-occurring tracks: %w", err)
+	}
+	defer rows.Close()
+
+	var tracks []Track
+	for rows.Next() {
+		var track Track
+		if err := scanTrack(rows, &track); err != nil {
+			return nil, fmt.Errorf("scan co-occurring track: %w", err)
+		}
+		tracks = append(tracks, track)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate co-occurring tracks: %w", err)
+	}
+
+	return tracks, nil
+}
+
+// GetItemsByYoutubeIDs returns the user's tracks and videos downloaded from
+// any of the given YouTube videos, keyed by YouTube ID
+func (db *DB) GetItemsByYoutubeIDs(ctx context.Context, userID string, youtubeIDs []string) (map[string]*Track, map[string]*Video, error) {
+	tracks := make(map[string]*Track)
+	videos := make(map[string]*Video)
+	if len(youtubeIDs) == 0 {
+		return tracks, videos, nil
+	}
+
+	rows, err := db.Pool.Query(ctx, `
+		SELECT `+trackColumns+`
+		FROM tracks t
+		WHERE t.user_id = $1 AND t.youtube_id = ANY($2)
+	`, userID, youtubeIDs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("get tracks by youtube id: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		track := &Track{}
+		if err := scanTrack(rows, track); err != nil {
+			return nil, nil, fmt.Errorf("scan track: %w", err)
+		}
+		tracks[track.YoutubeID] = track
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("iterate tracks: %w", err)
+	}
+	rows.Close()
+
+	rows, err = db.Pool.Query(ctx, `
+		SELECT `+videoColumns+`
+		FROM videos v
+		WHERE v.user_id = $1 AND v.youtube_id = ANY($2)
+	`, userID, youtubeIDs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("get videos by youtube id: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		video := &Video{}
+		if err := scanVideo(rows, video); err != nil {
+			return nil, nil, fmt.Errorf("scan video: %w", err)
+		}
+		videos[video.YoutubeID] = video
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("iterate videos: %w", err)
+	}
+
+	return tracks, videos, nil
+}
+
+// GetRecentlyPlayedYoutubeIDs returns the YouTube IDs of the user's tracks
+// and videos played since the given time
+func (db *DB) GetRecentlyPlayedYoutubeIDs(ctx context.Context, userID string, since time.Time) (map[string]bool, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT t.youtube_id
+		FROM play_history ph
+		INNER JOIN tracks t ON t.id = ph.track_id
+		WHERE ph.user_id = $1 AND ph.started_at >= $2
+		UNION
+		SELECT v.youtube_id
+		FROM play_history ph
+		INNER JOIN videos v ON v.id = ph.video_id
+		WHERE ph.user_id = $1 AND ph.started_at >= $2
+	`, userID, since)
+	if err != nil {
+		return nil, fmt.Errorf("get recently played: %w", err)
+	}
+	defer rows.Close()
+
+	played := make(map[string]bool)
+	for rows.Next() {
+		var youtubeID string
+		if err := rows.Scan(&youtubeID); err != nil {
+			return nil, fmt.Errorf("scan recently played: %w", err)
+		}
+		played[youtubeID] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate recently played: %w", err)
+	}
+
+	return played, nil
+}
@@ -0,0 +1,257 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Scrobble outbox entry kinds
+const (
+	ScrobbleNowPlaying = "now_playing"
+	ScrobbleSubmit     = "scrobble"
+)
+
+// scrobbleLeaseDuration is how long a claimed submission stays hidden from
+// other workers. A worker that dies mid-send releases it when this passes.
+const scrobbleLeaseDuration = 5 * time.Minute
+
+// scrobbleListenJSON builds the listen sent for a track aliased t, played at
+// the time expression %s. Its keys are those of scrobble.Listen.
+const scrobbleListenJSON = `jsonb_build_object(
+	'artist', t.artist,
+	'track', t.title,
+	'album', t.album,
+	'duration_seconds', t.duration_seconds,
+	'listened_at', %s,
+	'mb_recording_id', t.mb_recording_id,
+	'mb_release_id', t.mb_release_id,
+	'mb_artist_id', t.mb_artist_id,
+	'youtube_id', t.youtube_id
+)`
+
+// ScrobbleAccount is a user's linked ListenBrainz or Last.fm account
+type ScrobbleAccount struct {
+	UserID     string
+	Service    string
+	Token      string
+	Username   string
+	DisabledAt *time.Time
+	LastError  string
+	Pending    int
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// ScrobbleJob is a claimed outbox entry with the credentials to send it
+type ScrobbleJob struct {
+	ID        string
+	UserID    string
+	Service   string
+	Kind      string
+	Listen    []byte
+	Attempts  int
+	Token     string
+	ExpiresAt *time.Time
+}
+
+// ListScrobbleAccounts returns a user's linked accounts with how many
+// submissions each has waiting
+func (db *DB) ListScrobbleAccounts(ctx context.Context, userID string) ([]ScrobbleAccount, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT sa.user_id, sa.service, sa.token, sa.username, sa.disabled_at, sa.last_error,
+		       (SELECT COUNT(*) FROM scrobble_outbox o
+		        WHERE o.user_id = sa.user_id AND o.service = sa.service AND o.kind = 'scrobble'),
+		       sa.created_at, sa.updated_at
+		FROM scrobble_accounts sa
+		WHERE sa.user_id = $1
+		ORDER BY sa.service
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list scrobble accounts: %w", err)
+	}
+	defer rows.Close()
+
+	var accounts []ScrobbleAccount
+	for rows.Next() {
+		var a ScrobbleAccount
+		err := rows.Scan(&a.UserID, &a.Service, &a.Token, &a.Username, &a.DisabledAt, &a.LastError,
+			&a.Pending, &a.CreatedAt, &a.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("scan scrobble account: %w", err)
+		}
+		accounts = append(accounts, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate scrobble accounts: %w", err)
+	}
+
+	return accounts, nil
+}
+
+// LinkScrobbleAccount stores a user's token for a service, replacing any
+// earlier one. Submissions held back by a rejected token are sent again.
+func (db *DB) LinkScrobbleAccount(ctx context.Context, userID, service, token, username string) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO scrobble_accounts (user_id, service, token, username)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, service) DO UPDATE SET
+			token = EXCLUDED.token,
+			username = EXCLUDED.username,
+			disabled_at = NULL,
+			last_error = '',
+			updated_at = NOW()
+	`, userID, service, token, username)
+	if err != nil {
+		return fmt.Errorf("link scrobble account: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE scrobble_outbox SET next_attempt_at = NOW()
+		WHERE user_id = $1 AND service = $2
+	`, userID, service)
+	if err != nil {
+		return fmt.Errorf("release held scrobbles: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
+
+// UnlinkScrobbleAccount removes a linked account and drops its unsent
+// submissions
+func (db *DB) UnlinkScrobbleAccount(ctx context.Context, userID, service string) error {
+	result, err := db.Pool.Exec(ctx, `
+		DELETE FROM scrobble_accounts WHERE user_id = $1 AND service = $2
+	`, userID, service)
+	if err != nil {
+		return fmt.Errorf("unlink scrobble account: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// EnqueueNowPlaying queues a now playing notice of a track for each of the
+// user's active accounts, replacing notices not yet sent. It returns how
+// many were queued.
+func (db *DB) EnqueueNowPlaying(ctx context.Context, userID, trackID string, expiresAt time.Time) (int, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		DELETE FROM scrobble_outbox WHERE user_id = $1 AND kind = 'now_playing'
+	`, userID)
+	if err != nil {
+		return 0, fmt.Errorf("drop stale now playing: %w", err)
+	}
+
+	result, err := tx.Exec(ctx, `
+		INSERT INTO scrobble_outbox (user_id, service, kind, listen, expires_at)
+		SELECT $1, sa.service, 'now_playing', `+fmt.Sprintf(scrobbleListenJSON, "NOW()")+`, $3
+		FROM tracks t
+		INNER JOIN scrobble_accounts sa ON sa.user_id = t.user_id AND sa.disabled_at IS NULL
+		WHERE t.id = $2 AND t.user_id = $1
+	`, userID, trackID, expiresAt)
+	if err != nil {
+		return 0, fmt.Errorf("enqueue now playing: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("commit transaction: %w", err)
+	}
+
+	return int(result.RowsAffected()), nil
+}
+
+// ClaimScrobble takes the next due submission of an active account, hiding
+// it from other workers while it's sent. It returns ErrNotFound when none
+// is due. Expired now playing notices are dropped on the way.
+func (db *DB) ClaimScrobble(ctx context.Context) (*ScrobbleJob, error) {
+	_, err := db.Pool.Exec(ctx, `DELETE FROM scrobble_outbox WHERE expires_at < NOW()`)
+	if err != nil {
+		return nil, fmt.Errorf("drop expired scrobbles: %w", err)
+	}
+
+	job := &ScrobbleJob{}
+	err = db.Pool.QueryRow(ctx, `
+		UPDATE scrobble_outbox o
+		SET attempts = o.attempts + 1, next_attempt_at = NOW() + $1::interval
+		FROM scrobble_accounts sa
+		WHERE o.id = (
+			SELECT q.id FROM scrobble_outbox q
+			INNER JOIN scrobble_accounts qa ON qa.user_id = q.user_id AND qa.service = q.service
+			WHERE qa.disabled_at IS NULL AND q.next_attempt_at <= NOW()
+			ORDER BY q.next_attempt_at, q.created_at
+			FOR UPDATE OF q SKIP LOCKED
+			LIMIT 1
+		)
+		AND sa.user_id = o.user_id AND sa.service = o.service
+		RETURNING o.id, o.user_id, o.service, o.kind, o.listen, o.attempts, sa.token, o.expires_at
+	`, scrobbleLeaseDuration.String()).Scan(
+		&job.ID,
+		&job.UserID,
+		&job.Service,
+		&job.Kind,
+		&job.Listen,
+		&job.Attempts,
+		&job.Token,
+		&job.ExpiresAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("claim scrobble: %w", err)
+	}
+
+	return job, nil
+}
+
+// CompleteScrobble removes a sent submission, or one the service refused
+func (db *DB) CompleteScrobble(ctx context.Context, jobID string) error {
+	if _, err := db.Pool.Exec(ctx, `DELETE FROM scrobble_outbox WHERE id = $1`, jobID); err != nil {
+		return fmt.Errorf("complete scrobble: %w", err)
+	}
+	return nil
+}
+
+// RetryScrobble records a failed attempt and schedules the next one
+func (db *DB) RetryScrobble(ctx context.Context, jobID, message string, retryAt time.Time) error {
+	_, err := db.Pool.Exec(ctx, `
+		UPDATE scrobble_outbox SET last_error = $2, next_attempt_at = $3 WHERE id = $1
+	`, jobID, message, retryAt)
+	if err != nil {
+		return fmt.Errorf("retry scrobble: %w", err)
+	}
+	return nil
+}
+
+// DisableScrobbleAccount holds back an account's submissions after the
+// service rejected its token
+func (db *DB) DisableScrobbleAccount(ctx context.Context, userID, service, message string) error {
+	_, err := db.Pool.Exec(ctx, `
+		UPDATE scrobble_accounts
+		SET disabled_at = NOW(), last_error = $3, updated_at = NOW()
+		WHERE user_id = $1 AND service = $2
+	`, userID, service, message)
+	if err != nil {
+		return fmt.Errorf("disable scrobble account: %w", err)
+	}
+	return nil
+}
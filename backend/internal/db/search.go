@@ -0,0 +1,112 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// searchFuzzyWeight scales trigram similarity against the full-text rank so
+// that exact word matches still come first
+const searchFuzzyWeight = 0.5
+
+// TrackSearchResult is a track matching a library search
+type TrackSearchResult struct {
+	Track
+	Score float64
+}
+
+// VideoSearchResult is a video matching a library search
+type VideoSearchResult struct {
+	Video
+	Score float64
+}
+
+// prefixTSQuery turns free text into a tsquery matching every word as a
+// prefix, so results appear while the user is still typing. Punctuation is
+// dropped since it would otherwise be tsquery syntax.
+func prefixTSQuery(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	terms := make([]string, len(words))
+	for i, word := range words {
+		terms[i] = word + ":*"
+	}
+	return strings.Join(terms, " & ")
+}
+
+// searchWhere matches rows whose words start with every word of the query,
+// or whose title, artist and album are close to it by trigram similarity
+const searchWhere = `
+	(%[1]s.search_vector @@ to_tsquery('simple', $2) OR lower($3) <%% %[1]s.search_text)`
+
+// searchScore ranks full-text matches and adds a share of the fuzzy similarity
+const searchScore = `
+	ts_rank_cd(%[1]s.search_vector, to_tsquery('simple', $2)) + %[2]g * word_similarity(lower($3), %[1]s.search_text)`
+
+// SearchTracks returns a user's tracks matching text, best first
+func (db *DB) SearchTracks(ctx context.Context, userID, text string, limit int) ([]TrackSearchResult, error) {
+	query := `
+		SELECT ` + trackColumns + `, ` + fmt.Sprintf(searchScore, "t", searchFuzzyWeight) + ` AS score
+		FROM tracks t
+		WHERE t.user_id = $1 AND ` + fmt.Sprintf(searchWhere, "t") + `
+		ORDER BY score DESC, t.id
+		LIMIT $4
+	`
+
+	rows, err := db.Pool.Query(ctx, query, userID, prefixTSQuery(text), text, limit)
+	if err != nil {
+		return nil, fmt.Errorf("search tracks: %w", err)
+	}
+	defer rows.Close()
+
+	var results []TrackSearchResult
+	for rows.Next() {
+		var result TrackSearchResult
+		if err := rows.Scan(append(trackScanTargets(&result.Track), &result.Score)...); err != nil {
+			return nil, fmt.Errorf("scan track: %w", err)
+		}
+		results = append(results, result)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate tracks: %w", err)
+	}
+
+	return results, nil
+}
+
+// SearchVideos returns a user's videos matching text, best first
+func (db *DB) SearchVideos(ctx context.Context, userID, text string, limit int) ([]VideoSearchResult, error) {
+	query := `
+		SELECT ` + videoColumns + `, ` + fmt.Sprintf(searchScore, "v", searchFuzzyWeight) + ` AS score
+		FROM videos v
+		WHERE v.user_id = $1 AND ` + fmt.Sprintf(searchWhere, "v") + `
+		ORDER BY score DESC, v.id
+		LIMIT $4
+	`
+
+	rows, err := db.Pool.Query(ctx, query, userID, prefixTSQuery(text), text, limit)
+	if err != nil {
+		return nil, fmt.Errorf("search videos: %w", err)
+	}
+	defer rows.Close()
+
+	var results []VideoSearchResult
+	for rows.Next() {
+		var result VideoSearchResult
+		if err := rows.Scan(append(videoScanTargets(&result.Video), &result.Score)...); err != nil {
+			return nil, fmt.Errorf("scan video: %w", err)
+		}
+		results = append(results, result)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate videos: %w", err)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,315 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Smart playlist rule fields
+const (
+	RuleArtist     = "artist"
+	RuleTitle      = "title"
+	RuleAdded      = "added"
+	RuleDuration   = "duration"
+	RuleTag        = "tag"
+	RuleRating     = "rating"
+	RulePlayCount  = "play_count"
+	RuleLastPlayed = "last_played"
+)
+
+// Smart playlist rule groups
+const (
+	MatchAll = "all"
+	MatchAny = "any"
+)
+
+// SortRandom shuffles a smart playlist each time it's read
+const SortRandom = "random"
+
+const (
+	// MaxSmartPlaylistLimit bounds SmartRules.Limit
+	MaxSmartPlaylistLimit = 5000
+
+	maxRuleDepth       = 5
+	maxRuleCount       = 100
+	maxRuleTextLength  = 500
+	maxRuleWithinDays  = 100 * 365
+	maxRuleNumberValue = 1 << 30
+
+	// maxRuleRating matches the CHECK on the rating columns
+	maxRuleRating = 5
+)
+
+var ErrInvalidRules = errors.New("invalid smart playlist rules")
+
+// SmartRule is a node of a smart playlist's rule tree. A group sets Match
+// and combines its Rules; a condition sets Field, Operator and Value.
+type SmartRule struct {
+	Match string      `json:"match,omitempty"`
+	Rules []SmartRule `json:"rules,omitempty"`
+
+	Field    string          `json:"field,omitempty"`
+	Operator string          `json:"operator,omitempty"`
+	Value    json.RawMessage `json:"value,omitempty"`
+}
+
+// SmartRules defines a smart playlist: the root of its rule tree, how the
+// matching tracks are sorted and, unless Limit is 0, how many are kept
+type SmartRules struct {
+	SmartRule
+	Sort       string `json:"sort,omitempty"`
+	Descending bool   `json:"descending,omitempty"`
+	Limit      int    `json:"limit,omitempty"`
+}
+
+// ruleOperators lists the operators each field accepts
+var ruleOperators = map[string][]string{
+	RuleArtist:     {"is", "is_not", "contains", "not_contains"},
+	RuleTitle:      {"is", "is_not", "contains", "not_contains"},
+	RuleTag:        {"has", "has_not"},
+	RuleAdded:      {"within_days", "not_within_days"},
+	RuleLastPlayed: {"within_days", "not_within_days"},
+	RuleDuration:   {"eq", "ne", "gt", "gte", "lt", "lte", "between"},
+	RuleRating:     {"eq", "ne", "gt", "gte", "lt", "lte", "between"},
+	RulePlayCount:  {"eq", "ne", "gt", "gte", "lt", "lte", "between"},
+}
+
+// ruleExpressions are the track columns rules compare, for tracks aliased t
+var ruleExpressions = map[string]string{
+	RuleArtist:    "lower(COALESCE(t.artist, ''))",
+	RuleTitle:     "lower(t.title)",
+	RuleDuration:  "COALESCE(t.duration_seconds, 0)",
+	RuleRating:    "t.rating",
+	RulePlayCount: "t.play_count",
+}
+
+var numericComparisons = map[string]string{
+	"eq": "=", "ne": "<>", "gt": ">", "gte": ">=", "lt": "<", "lte": "<=",
+}
+
+// Validate reports the first problem with the rules, wrapping
+// ErrInvalidRules, or nil if they can be evaluated
+func (r *SmartRules) Validate() error {
+	_, _, err := smartRulesSQL(r, func(interface{}) string { return "NULL" })
+	return err
+}
+
+// smartRulesSQL compiles rules into a WHERE condition and ORDER BY list for
+// tracks aliased t. arg binds a value and returns its placeholder.
+func smartRulesSQL(r *SmartRules, arg func(interface{}) string) (where, orderBy string, err error) {
+	if r.Limit < 0 || r.Limit > MaxSmartPlaylistLimit {
+		return "", "", fmt.Errorf("%w: limit must be between 0 and %d", ErrInvalidRules, MaxSmartPlaylistLimit)
+	}
+
+	direction := "ASC"
+	if r.Descending {
+		direction = "DESC"
+	}
+	switch sort, ok := librarySorts[r.Sort]; {
+	case r.Sort == SortRandom:
+		orderBy = "random()"
+	case r.Sort == "":
+		orderBy = "t.created_at " + direction + ", t.id " + direction
+	case ok:
+		orderBy = sort.track + " " + direction + ", t.id " + direction
+	default:
+		return "", "", fmt.Errorf("%w: unknown sort %q", ErrInvalidRules, r.Sort)
+	}
+
+	count := 0
+	where, err = ruleSQL(&r.SmartRule, "rule", 1, &count, arg)
+	if err != nil {
+		return "", "", err
+	}
+	return where, orderBy, nil
+}
+
+// ruleSQL compiles one node of a rule tree. path names the node in errors.
+func ruleSQL(rule *SmartRule, path string, depth int, count *int, arg func(interface{}) string) (string, error) {
+	invalid := func(format string, a ...interface{}) error {
+		return fmt.Errorf("%w: %s: %s", ErrInvalidRules, path, fmt.Sprintf(format, a...))
+	}
+
+	*count++
+	if *count > maxRuleCount {
+		return "", fmt.Errorf("%w: at most %d rules are allowed", ErrInvalidRules, maxRuleCount)
+	}
+
+	if rule.Match != "" {
+		if rule.Field != "" {
+			return "", invalid("a rule can't have both match and field")
+		}
+		if depth > maxRuleDepth {
+			return "", invalid("rules can be nested at most %d deep", maxRuleDepth)
+		}
+
+		join, empty := " AND ", "TRUE"
+		switch rule.Match {
+		case MatchAll:
+		case MatchAny:
+			join, empty = " OR ", "FALSE"
+		default:
+			return "", invalid("match must be 'all' or 'any'")
+		}
+		if len(rule.Rules) == 0 {
+			return empty, nil
+		}
+
+		conditions := make([]string, 0, len(rule.Rules))
+		for i := range rule.Rules {
+			condition, err := ruleSQL(&rule.Rules[i], path+".rules["+strconv.Itoa(i)+"]", depth+1, count, arg)
+			if err != nil {
+				return "", err
+			}
+			conditions = append(conditions, condition)
+		}
+		return "(" + strings.Join(conditions, join) + ")", nil
+	}
+
+	operators, ok := ruleOperators[rule.Field]
+	if !ok {
+		if rule.Field == "" {
+			return "", invalid("either match or field is required")
+		}
+		return "", invalid("unknown field %q", rule.Field)
+	}
+	known := false
+	for _, op := range operators {
+		known = known || op == rule.Operator
+	}
+	if !known {
+		return "", invalid("operator for %s must be one of %s", rule.Field, strings.Join(operators, ", "))
+	}
+
+	switch rule.Field {
+	case RuleArtist, RuleTitle:
+		var text string
+		if err := json.Unmarshal(rule.Value, &text); err != nil || text == "" || len(text) > maxRuleTextLength {
+			return "", invalid("value must be a string of 1 to %d characters", maxRuleTextLength)
+		}
+		expr := ruleExpressions[rule.Field]
+		switch rule.Operator {
+		case "is":
+			return expr + " = lower(" + arg(text) + ")", nil
+		case "is_not":
+			return expr + " <> lower(" + arg(text) + ")", nil
+		case "contains":
+			return "strpos(" + expr + ", lower(" + arg(text) + ")) > 0", nil
+		default:
+			return "strpos(" + expr + ", lower(" + arg(text) + ")) = 0", nil
+		}
+
+	case RuleTag:
+		var name string
+		if err := json.Unmarshal(rule.Value, &name); err != nil || name == "" || len(name) > maxRuleTextLength {
+			return "", invalid("value must be a tag name")
+		}
+		tagged := `EXISTS (SELECT 1 FROM track_tags tt INNER JOIN tags tg ON tg.id = tt.tag_id
+			WHERE tt.track_id = t.id AND lower(tg.name) = lower(` + arg(name) + `))`
+		if rule.Operator == "has_not" {
+			return "NOT " + tagged, nil
+		}
+		return tagged, nil
+
+	case RuleAdded, RuleLastPlayed:
+		var days int
+		if err := json.Unmarshal(rule.Value, &days); err != nil || days < 1 || days > maxRuleWithinDays {
+			return "", invalid("value must be a number of days between 1 and %d", maxRuleWithinDays)
+		}
+		since := "NOW() - make_interval(days => " + arg(days) + ")"
+		condition := "t.created_at >= " + since
+		if rule.Field == RuleLastPlayed {
+			// Every play event counts, and tracks never played haven't been
+			// played within any period
+			condition = "EXISTS (SELECT 1 FROM play_history ph WHERE ph.track_id = t.id AND ph.started_at >= " + since + ")"
+		}
+		if rule.Operator == "not_within_days" {
+			return "NOT " + condition, nil
+		}
+		return condition, nil
+
+	default:
+		expr := ruleExpressions[rule.Field]
+		maxValue := maxRuleNumberValue
+		if rule.Field == RuleRating {
+			maxValue = maxRuleRating
+		}
+		if rule.Operator == "between" {
+			var bounds []int
+			err := json.Unmarshal(rule.Value, &bounds)
+			if err != nil || len(bounds) != 2 || bounds[0] < 0 || bounds[0] > bounds[1] || bounds[1] > maxValue {
+				return "", invalid("value must be [min, max] with 0 <= min <= max <= %d", maxValue)
+			}
+			return expr + " BETWEEN " + arg(bounds[0]) + " AND " + arg(bounds[1]), nil
+		}
+		var n int
+		if err := json.Unmarshal(rule.Value, &n); err != nil || n < 0 || n > maxValue {
+			return "", invalid("value must be a whole number between 0 and %d", maxValue)
+		}
+		return expr + " " + numericComparisons[rule.Operator] + " " + arg(n), nil
+	}
+}
+
+// CreateSmartPlaylist creates a playlist whose tracks are chosen by rules
+func (db *DB) CreateSmartPlaylist(ctx context.Context, userID, name string, rules *SmartRules) (*Playlist, error) {
+	playlist := &Playlist{}
+	err := db.Pool.QueryRow(ctx, `
+		INSERT INTO playlists (user_id, name, rules)
+		VALUES ($1, $2, $3)
+		RETURNING `+playlistColumns,
+		userID, name, rules).Scan(playlistScanTargets(playlist)...)
+	if err != nil {
+		return nil, fmt.Errorf("create smart playlist: %w", err)
+	}
+
+	return playlist, nil
+}
+
+// getSmartPlaylistTracks evaluates a smart playlist's rules against the
+// owner's library
+func (db *DB) getSmartPlaylistTracks(ctx context.Context, playlist *Playlist) ([]Track, error) {
+	args := []interface{}{playlist.UserID}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	where, orderBy, err := smartRulesSQL(playlist.Rules, arg)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT ` + trackColumns + `
+		FROM tracks t
+		WHERE t.user_id = $1 AND ` + where + `
+		ORDER BY ` + orderBy
+	if playlist.Rules.Limit > 0 {
+		query += "\n\t\tLIMIT " + arg(playlist.Rules.Limit)
+	}
+
+	rows, err := db.Pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("get smart playlist tracks: %w", err)
+	}
+	defer rows.Close()
+
+	var tracks []Track
+	for rows.Next() {
+		var track Track
+		if err := scanTrack(rows, &track); err != nil {
+			return nil, fmt.Errorf("scan track: %w", err)
+		}
+		tracks = append(tracks, track)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate smart playlist tracks: %w", err)
+	}
+
+	return tracks, nil
+}
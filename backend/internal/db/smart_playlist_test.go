@@ -0,0 +1,243 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// compileRules runs smartRulesSQL with numbered placeholders, returning the
+// bound arguments alongside the SQL
+func compileRules(r *SmartRules) (where, orderBy string, args []interface{}, err error) {
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	where, orderBy, err = smartRulesSQL(r, arg)
+	return where, orderBy, args, err
+}
+
+func condition(field, operator, value string) SmartRule {
+	return SmartRule{Field: field, Operator: operator, Value: json.RawMessage(value)}
+}
+
+// squash collapses whitespace so multi-line SQL compares on one line
+func squash(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func TestRuleSQLConditions(t *testing.T) {
+	tests := []struct {
+		rule     SmartRule
+		wantSQL  string
+		wantArgs []interface{}
+	}{
+		{condition(RuleArtist, "is", `"Queen"`), "lower(COALESCE(t.artist, '')) = lower($1)", []interface{}{"Queen"}},
+		{condition(RuleArtist, "is_not", `"Queen"`), "lower(COALESCE(t.artist, '')) <> lower($1)", []interface{}{"Queen"}},
+		{condition(RuleArtist, "contains", `"que"`), "strpos(lower(COALESCE(t.artist, '')), lower($1)) > 0", []interface{}{"que"}},
+		{condition(RuleArtist, "not_contains", `"que"`), "strpos(lower(COALESCE(t.artist, '')), lower($1)) = 0", []interface{}{"que"}},
+		{condition(RuleTitle, "is", `"Intro"`), "lower(t.title) = lower($1)", []interface{}{"Intro"}},
+		{condition(RuleTitle, "is_not", `"Intro"`), "lower(t.title) <> lower($1)", []interface{}{"Intro"}},
+		{condition(RuleTitle, "contains", `"live"`), "strpos(lower(t.title), lower($1)) > 0", []interface{}{"live"}},
+		{condition(RuleTitle, "not_contains", `"live"`), "strpos(lower(t.title), lower($1)) = 0", []interface{}{"live"}},
+		{
+			condition(RuleTag, "has", `"chill"`),
+			"EXISTS (SELECT 1 FROM track_tags tt INNER JOIN tags tg ON tg.id = tt.tag_id WHERE tt.track_id = t.id AND lower(tg.name) = lower($1))",
+			[]interface{}{"chill"},
+		},
+		{
+			condition(RuleTag, "has_not", `"chill"`),
+			"NOT EXISTS (SELECT 1 FROM track_tags tt INNER JOIN tags tg ON tg.id = tt.tag_id WHERE tt.track_id = t.id AND lower(tg.name) = lower($1))",
+			[]interface{}{"chill"},
+		},
+		{condition(RuleAdded, "within_days", `30`), "t.created_at >= NOW() - make_interval(days => $1)", []interface{}{30}},
+		{condition(RuleAdded, "not_within_days", `30`), "NOT t.created_at >= NOW() - make_interval(days => $1)", []interface{}{30}},
+		{
+			condition(RuleLastPlayed, "within_days", `7`),
+			"EXISTS (SELECT 1 FROM play_history ph WHERE ph.track_id = t.id AND ph.started_at >= NOW() - make_interval(days => $1))",
+			[]interface{}{7},
+		},
+		{
+			condition(RuleLastPlayed, "not_within_days", `7`),
+			"NOT EXISTS (SELECT 1 FROM play_history ph WHERE ph.track_id = t.id AND ph.started_at >= NOW() - make_interval(days => $1))",
+			[]interface{}{7},
+		},
+		{condition(RuleDuration, "eq", `180`), "COALESCE(t.duration_seconds, 0) = $1", []interface{}{180}},
+		{condition(RuleDuration, "ne", `180`), "COALESCE(t.duration_seconds, 0) <> $1", []interface{}{180}},
+		{condition(RuleDuration, "gt", `180`), "COALESCE(t.duration_seconds, 0) > $1", []interface{}{180}},
+		{condition(RuleDuration, "gte", `180`), "COALESCE(t.duration_seconds, 0) >= $1", []interface{}{180}},
+		{condition(RuleDuration, "lt", `180`), "COALESCE(t.duration_seconds, 0) < $1", []interface{}{180}},
+		{condition(RuleDuration, "lte", `180`), "COALESCE(t.duration_seconds, 0) <= $1", []interface{}{180}},
+		{condition(RuleDuration, "between", `[60, 300]`), "COALESCE(t.duration_seconds, 0) BETWEEN $1 AND $2", []interface{}{60, 300}},
+		{condition(RuleRating, "gte", `4`), "t.rating >= $1", []interface{}{4}},
+		{condition(RuleRating, "between", `[1, 5]`), "t.rating BETWEEN $1 AND $2", []interface{}{1, 5}},
+		{condition(RulePlayCount, "eq", `0`), "t.play_count = $1", []interface{}{0}},
+		{condition(RulePlayCount, "lt", `10`), "t.play_count < $1", []interface{}{10}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rule.Field+" "+tt.rule.Operator, func(t *testing.T) {
+			where, _, args, err := compileRules(&SmartRules{SmartRule: tt.rule})
+			if err != nil {
+				t.Fatalf("smartRulesSQL() error = %v", err)
+			}
+			if squash(where) != tt.wantSQL {
+				t.Errorf("where = %q, want %q", squash(where), tt.wantSQL)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("args = %v, want %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestRuleSQLGroups(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     SmartRule
+		wantSQL  string
+		wantArgs []interface{}
+	}{
+		{"empty all matches everything", SmartRule{Match: MatchAll}, "TRUE", nil},
+		{"empty any matches nothing", SmartRule{Match: MatchAny}, "FALSE", nil},
+		{
+			name: "all joins with AND",
+			rule: SmartRule{Match: MatchAll, Rules: []SmartRule{
+				condition(RuleArtist, "is", `"Queen"`),
+				condition(RuleRating, "gte", `4`),
+			}},
+			wantSQL:  "(lower(COALESCE(t.artist, '')) = lower($1) AND t.rating >= $2)",
+			wantArgs: []interface{}{"Queen", 4},
+		},
+		{
+			name: "nested any joins with OR",
+			rule: SmartRule{Match: MatchAll, Rules: []SmartRule{
+				condition(RulePlayCount, "eq", `0`),
+				{Match: MatchAny, Rules: []SmartRule{
+					condition(RuleTitle, "contains", `"live"`),
+					condition(RuleDuration, "gt", `600`),
+				}},
+			}},
+			wantSQL:  "(t.play_count = $1 AND (strpos(lower(t.title), lower($2)) > 0 OR COALESCE(t.duration_seconds, 0) > $3))",
+			wantArgs: []interface{}{0, "live", 600},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			where, _, args, err := compileRules(&SmartRules{SmartRule: tt.rule})
+			if err != nil {
+				t.Fatalf("smartRulesSQL() error = %v", err)
+			}
+			if where != tt.wantSQL {
+				t.Errorf("where = %q, want %q", where, tt.wantSQL)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("args = %v, want %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestSmartRulesSQLOrder(t *testing.T) {
+	tests := []struct {
+		sort       string
+		descending bool
+		want       string
+	}{
+		{"", false, "t.created_at ASC, t.id ASC"},
+		{"", true, "t.created_at DESC, t.id DESC"},
+		{SortRandom, false, "random()"},
+		{SortTitle, false, "lower(t.title) ASC, t.id ASC"},
+		{SortArtist, true, "lower(COALESCE(t.artist, '')) DESC, t.id DESC"},
+		{SortDuration, false, "COALESCE(t.duration_seconds, 0) ASC, t.id ASC"},
+		{SortPlays, true, "t.play_count DESC, t.id DESC"},
+		{SortRating, true, "t.rating DESC, t.id DESC"},
+		{SortAdded, false, "t.created_at ASC, t.id ASC"},
+	}
+
+	for _, tt := range tests {
+		rules := &SmartRules{SmartRule: SmartRule{Match: MatchAll}, Sort: tt.sort, Descending: tt.descending}
+		_, orderBy, _, err := compileRules(rules)
+		if err != nil {
+			t.Errorf("sort %q: error = %v", tt.sort, err)
+			continue
+		}
+		if orderBy != tt.want {
+			t.Errorf("sort %q descending %v: orderBy = %q, want %q", tt.sort, tt.descending, orderBy, tt.want)
+		}
+	}
+}
+
+// nest returns a rule tree of groups depth deep around a single condition
+func nest(depth int) SmartRule {
+	rule := condition(RuleRating, "gte", `1`)
+	for i := 0; i < depth; i++ {
+		rule = SmartRule{Match: MatchAll, Rules: []SmartRule{rule}}
+	}
+	return rule
+}
+
+func TestSmartRulesSQLRejects(t *testing.T) {
+	manyRules := make([]SmartRule, maxRuleCount)
+	for i := range manyRules {
+		manyRules[i] = condition(RuleRating, "gte", `1`)
+	}
+
+	tests := []struct {
+		name  string
+		rules SmartRules
+	}{
+		{"unknown field", SmartRules{SmartRule: condition("genre", "is", `"rock"`)}},
+		{"missing field and match", SmartRules{SmartRule: SmartRule{Operator: "is", Value: json.RawMessage(`"x"`)}}},
+		{"unknown operator", SmartRules{SmartRule: condition(RuleArtist, "like", `"Queen"`)}},
+		{"operator of another field", SmartRules{SmartRule: condition(RuleTag, "contains", `"chill"`)}},
+		{"unknown match", SmartRules{SmartRule: SmartRule{Match: "none"}}},
+		{"match and field", SmartRules{SmartRule: SmartRule{Match: MatchAll, Field: RuleArtist}}},
+		{"empty text", SmartRules{SmartRule: condition(RuleTitle, "is", `""`)}},
+		{"text too long", SmartRules{SmartRule: condition(RuleTitle, "is", `"`+strings.Repeat("a", maxRuleTextLength+1)+`"`)}},
+		{"number for text", SmartRules{SmartRule: condition(RuleArtist, "is", `5`)}},
+		{"zero days", SmartRules{SmartRule: condition(RuleAdded, "within_days", `0`)}},
+		{"too many days", SmartRules{SmartRule: condition(RuleLastPlayed, "within_days", strconv.Itoa(maxRuleWithinDays+1))}},
+		{"negative number", SmartRules{SmartRule: condition(RulePlayCount, "gt", `-1`)}},
+		{"rating above 5", SmartRules{SmartRule: condition(RuleRating, "eq", `6`)}},
+		{"fractional number", SmartRules{SmartRule: condition(RuleDuration, "gt", `1.5`)}},
+		{"between reversed", SmartRules{SmartRule: condition(RuleDuration, "between", `[300, 60]`)}},
+		{"between one bound", SmartRules{SmartRule: condition(RuleDuration, "between", `[60]`)}},
+		{"too deep", SmartRules{SmartRule: nest(maxRuleDepth + 1)}},
+		{"too many rules", SmartRules{SmartRule: SmartRule{Match: MatchAll, Rules: manyRules}}},
+		{"unknown sort", SmartRules{SmartRule: SmartRule{Match: MatchAll}, Sort: "bpm"}},
+		{"negative limit", SmartRules{SmartRule: SmartRule{Match: MatchAll}, Limit: -1}},
+		{"limit too large", SmartRules{SmartRule: SmartRule{Match: MatchAll}, Limit: MaxSmartPlaylistLimit + 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, _, err := compileRules(&tt.rules); !errors.Is(err, ErrInvalidRules) {
+				t.Errorf("smartRulesSQL() error = %v, want ErrInvalidRules", err)
+			}
+		})
+	}
+}
+
+func TestSmartRulesSQLLimits(t *testing.T) {
+	if _, _, _, err := compileRules(&SmartRules{SmartRule: nest(maxRuleDepth)}); err != nil {
+		t.Errorf("rules %d deep: error = %v", maxRuleDepth, err)
+	}
+
+	// The root group counts towards the limit
+	rules := make([]SmartRule, maxRuleCount-1)
+	for i := range rules {
+		rules[i] = condition(RuleRating, "gte", `1`)
+	}
+	if _, _, _, err := compileRules(&SmartRules{SmartRule: SmartRule{Match: MatchAll, Rules: rules}}); err != nil {
+		t.Errorf("%d rules: error = %v", maxRuleCount, err)
+	}
+
+	if err := (&SmartRules{SmartRule: SmartRule{Match: MatchAll}, Limit: MaxSmartPlaylistLimit}).Validate(); err != nil {
+		t.Errorf("Validate() with the largest limit: error = %v", err)
+	}
+}
@@ -0,0 +1,161 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ListeningSummary totals a user's listening over a period. Seconds include
+// every event; Plays counts only those that count as plays.
+type ListeningSummary struct {
+	TotalSeconds int64
+	Plays        int
+	Tracks       int
+	Videos       int
+}
+
+// TopTrack is a track with how often it was played in a period
+type TopTrack struct {
+	Track
+	Plays   int
+	Seconds int64
+}
+
+// TopArtist is an artist with how often their tracks were played in a period
+type TopArtist struct {
+	ID         string
+	Name       string
+	ArtworkURL string
+	Plays      int
+	Seconds    int64
+}
+
+// ListeningHeatmap holds seconds listened by ISO weekday (Monday first) and
+// hour of day
+type ListeningHeatmap [7][24]int64
+
+// sinceCondition limits play_history rows aliased ph to those started at or
+// after $2, or all of them when $2 is NULL
+const sinceCondition = `($2::timestamptz IS NULL OR ph.started_at >= $2)`
+
+// GetListeningSummary totals a user's play events since a time, or over all
+// time when since is nil
+func (db *DB) GetListeningSummary(ctx context.Context, userID string, since *time.Time) (*ListeningSummary, error) {
+	summary := &ListeningSummary{}
+	err := db.Pool.QueryRow(ctx, `
+		SELECT COALESCE(SUM(ph.duration_seconds), 0),
+		       COUNT(*) FILTER (WHERE ph.counted),
+		       COUNT(DISTINCT ph.track_id),
+		       COUNT(DISTINCT ph.video_id)
+		FROM play_history ph
+		WHERE ph.user_id = $1 AND `+sinceCondition,
+		userID, since).Scan(&summary.TotalSeconds, &summary.Plays, &summary.Tracks, &summary.Videos)
+	if err != nil {
+		return nil, fmt.Errorf("get listening summary: %w", err)
+	}
+
+	return summary, nil
+}
+
+// GetTopTracks returns a user's most played tracks since a time. Plays of
+// tracks since deleted aren't included.
+func (db *DB) GetTopTracks(ctx context.Context, userID string, since *time.Time, limit int) ([]TopTrack, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT `+trackColumns+`, COUNT(*) AS plays, SUM(ph.duration_seconds)
+		FROM play_history ph
+		INNER JOIN tracks t ON t.id = ph.track_id
+		WHERE ph.user_id = $1 AND ph.counted AND `+sinceCondition+`
+		GROUP BY t.id
+		ORDER BY plays DESC, SUM(ph.duration_seconds) DESC, t.id
+		LIMIT $3
+	`, userID, since, limit)
+	if err != nil {
+		return nil, fmt.Errorf("get top tracks: %w", err)
+	}
+	defer rows.Close()
+
+	var tracks []TopTrack
+	for rows.Next() {
+		var track TopTrack
+		if err := rows.Scan(append(trackScanTargets(&track.Track), &track.Plays, &track.Seconds)...); err != nil {
+			return nil, fmt.Errorf("scan top track: %w", err)
+		}
+		tracks = append(tracks, track)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate top tracks: %w", err)
+	}
+
+	return tracks, nil
+}
+
+// GetTopArtists returns the artists whose tracks a user played most since a
+// time, going by the artist catalog
+func (db *DB) GetTopArtists(ctx context.Context, userID string, since *time.Time, limit int) ([]TopArtist, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT ar.id, ar.name,
+		       COALESCE((ARRAY_AGG(t.thumbnail_url ORDER BY t.created_at DESC) FILTER (WHERE t.thumbnail_url <> ''))[1], ''),
+		       COUNT(*) AS plays, SUM(ph.duration_seconds)
+		FROM play_history ph
+		INNER JOIN tracks t ON t.id = ph.track_id
+		INNER JOIN artists ar ON ar.id = t.artist_id
+		WHERE ph.user_id = $1 AND ph.counted AND `+sinceCondition+`
+		GROUP BY ar.id
+		ORDER BY plays DESC, SUM(ph.duration_seconds) DESC, ar.id
+		LIMIT $3
+	`, userID, since, limit)
+	if err != nil {
+		return nil, fmt.Errorf("get top artists: %w", err)
+	}
+	defer rows.Close()
+
+	var artists []TopArtist
+	for rows.Next() {
+		var artist TopArtist
+		if err := rows.Scan(&artist.ID, &artist.Name, &artist.ArtworkURL, &artist.Plays, &artist.Seconds); err != nil {
+			return nil, fmt.Errorf("scan top artist: %w", err)
+		}
+		artists = append(artists, artist)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate top artists: %w", err)
+	}
+
+	return artists, nil
+}
+
+// GetListeningHeatmap totals a user's listening since a time by weekday and
+// hour in the given IANA time zone. A play is placed by when it started.
+func (db *DB) GetListeningHeatmap(ctx context.Context, userID string, since *time.Time, timezone string) (*ListeningHeatmap, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT EXTRACT(ISODOW FROM ph.started_at AT TIME ZONE $3)::integer,
+		       EXTRACT(HOUR FROM ph.started_at AT TIME ZONE $3)::integer,
+		       SUM(ph.duration_seconds)
+		FROM play_history ph
+		WHERE ph.user_id = $1 AND `+sinceCondition+`
+		GROUP BY 1, 2
+	`, userID, since, timezone)
+	if err != nil {
+		return nil, fmt.Errorf("get listening heatmap: %w", err)
+	}
+	defer rows.Close()
+
+	heatmap := &ListeningHeatmap{}
+	for rows.Next() {
+		var weekday, hour int
+		var seconds int64
+		if err := rows.Scan(&weekday, &hour, &seconds); err != nil {
+			return nil, fmt.Errorf("scan heatmap cell: %w", err)
+		}
+		heatmap[weekday-1][hour] = seconds
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate heatmap: %w", err)
+	}
+
+	return heatmap, nil
+}
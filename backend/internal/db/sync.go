@@ -0,0 +1,233 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Kinds of items in the sync change log
+const (
+	SyncTrack    = "track"
+	SyncVideo    = "video"
+	SyncPlaylist = "playlist"
+)
+
+var ErrInvalidSyncToken = errors.New("invalid sync token")
+
+// SyncDelta is everything in a user's library that changed since a sync
+// token, along with the token to pass next time
+type SyncDelta struct {
+	Token     string
+	Tracks    []Track
+	Videos    []Video
+	Playlists []Playlist
+	// PlaylistTrackIDs holds the tracks of each changed playlist in order.
+	// Smart playlists have none.
+	PlaylistTrackIDs map[string][]string
+
+	DeletedTracks    []string
+	DeletedVideos    []string
+	DeletedPlaylists []string
+}
+
+// GetSyncDelta returns the tracks, videos and playlists created, changed or
+// deleted since token. An empty token returns the whole library without
+// deletions.
+//
+// Tokens are transaction ID watermarks rather than counters, so changes
+// committed after a later transaction's aren't missed. The price is that a
+// change may be returned again by the next call.
+func (db *DB) GetSyncDelta(ctx context.Context, userID, token string) (*SyncDelta, error) {
+	var since *string
+	if token != "" {
+		if _, err := strconv.ParseUint(token, 10, 64); err != nil {
+			return nil, ErrInvalidSyncToken
+		}
+		since = &token
+	}
+
+	// One snapshot keeps the changes, the items and the next token consistent
+	tx, err := db.Pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	delta := &SyncDelta{PlaylistTrackIDs: make(map[string][]string)}
+
+	// Every transaction not visible in this snapshot has an ID of at least
+	// its xmin, so that's where the next sync picks up
+	err = tx.QueryRow(ctx, `SELECT pg_snapshot_xmin(pg_current_snapshot())::text`).Scan(&delta.Token)
+	if err != nil {
+		return nil, fmt.Errorf("get sync token: %w", err)
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT kind, item_id, deleted
+		FROM sync_changes
+		WHERE user_id = $1 AND ($2::text IS NULL OR txid >= $2::text::xid8)
+		  AND ($2::text IS NOT NULL OR NOT deleted)
+	`, userID, since)
+	if err != nil {
+		return nil, fmt.Errorf("get sync changes: %w", err)
+	}
+	defer rows.Close()
+
+	changed := map[string][]string{}
+	for rows.Next() {
+		var kind, itemID string
+		var deleted bool
+		if err := rows.Scan(&kind, &itemID, &deleted); err != nil {
+			return nil, fmt.Errorf("scan sync change: %w", err)
+		}
+		switch {
+		case !deleted:
+			changed[kind] = append(changed[kind], itemID)
+		case kind == SyncTrack:
+			delta.DeletedTracks = append(delta.DeletedTracks, itemID)
+		case kind == SyncVideo:
+			delta.DeletedVideos = append(delta.DeletedVideos, itemID)
+		case kind == SyncPlaylist:
+			delta.DeletedPlaylists = append(delta.DeletedPlaylists, itemID)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate sync changes: %w", err)
+	}
+
+	if ids := changed[SyncTrack]; len(ids) > 0 {
+		if delta.Tracks, err = syncTracks(ctx, tx, userID, ids); err != nil {
+			return nil, err
+		}
+	}
+	if ids := changed[SyncVideo]; len(ids) > 0 {
+		if delta.Videos, err = syncVideos(ctx, tx, userID, ids); err != nil {
+			return nil, err
+		}
+	}
+	if ids := changed[SyncPlaylist]; len(ids) > 0 {
+		if delta.Playlists, err = syncPlaylists(ctx, tx, userID, ids); err != nil {
+			return nil, err
+		}
+		if err := syncPlaylistTrackIDs(ctx, tx, ids, delta.PlaylistTrackIDs); err != nil {
+			return nil, err
+		}
+	}
+
+	return delta, nil
+}
+
+func syncTracks(ctx context.Context, tx pgx.Tx, userID string, ids []string) ([]Track, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT `+trackColumns+`
+		FROM tracks t
+		WHERE t.user_id = $1 AND t.id = ANY($2::uuid[])
+		ORDER BY t.created_at, t.id
+	`, userID, ids)
+	if err != nil {
+		return nil, fmt.Errorf("get changed tracks: %w", err)
+	}
+	defer rows.Close()
+
+	var tracks []Track
+	for rows.Next() {
+		var track Track
+		if err := scanTrack(rows, &track); err != nil {
+			return nil, fmt.Errorf("scan track: %w", err)
+		}
+		tracks = append(tracks, track)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate changed tracks: %w", err)
+	}
+
+	return tracks, nil
+}
+
+func syncVideos(ctx context.Context, tx pgx.Tx, userID string, ids []string) ([]Video, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT `+videoColumns+`
+		FROM videos v
+		WHERE v.user_id = $1 AND v.id = ANY($2::uuid[])
+		ORDER BY v.created_at, v.id
+	`, userID, ids)
+	if err != nil {
+		return nil, fmt.Errorf("get changed videos: %w", err)
+	}
+	defer rows.Close()
+
+	var videos []Video
+	for rows.Next() {
+		var video Video
+		if err := scanVideo(rows, &video); err != nil {
+			return nil, fmt.Errorf("scan video: %w", err)
+		}
+		videos = append(videos, video)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate changed videos: %w", err)
+	}
+
+	return videos, nil
+}
+
+func syncPlaylists(ctx context.Context, tx pgx.Tx, userID string, ids []string) ([]Playlist, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT `+playlistColumns+`
+		FROM playlists
+		WHERE user_id = $1 AND id = ANY($2::uuid[])
+		ORDER BY created_at, id
+	`, userID, ids)
+	if err != nil {
+		return nil, fmt.Errorf("get changed playlists: %w", err)
+	}
+	defer rows.Close()
+
+	var playlists []Playlist
+	for rows.Next() {
+		var p Playlist
+		if err := rows.Scan(playlistScanTargets(&p)...); err != nil {
+			return nil, fmt.Errorf("scan playlist: %w", err)
+		}
+		playlists = append(playlists, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate changed playlists: %w", err)
+	}
+
+	return playlists, nil
+}
+
+func syncPlaylistTrackIDs(ctx context.Context, tx pgx.Tx, playlistIDs []string, trackIDs map[string][]string) error {
+	rows, err := tx.Query(ctx, `
+		SELECT playlist_id, track_id
+		FROM playlist_tracks
+		WHERE playlist_id = ANY($1::uuid[])
+		ORDER BY playlist_id, position
+	`, playlistIDs)
+	if err != nil {
+		return fmt.Errorf("get changed playlist tracks: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var playlistID, trackID string
+		if err := rows.Scan(&playlistID, &trackID); err != nil {
+			return fmt.Errorf("scan playlist track: %w", err)
+		}
+		trackIDs[playlistID] = append(trackIDs[playlistID], trackID)
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate changed playlist tracks: %w", err)
+	}
+
+	return nil
+}
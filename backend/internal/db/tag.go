@@ -0,0 +1,217 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// tagColumns lists the columns scanned by scanTag, qualified by the alias tg
+const tagColumns = `tg.id, tg.user_id, tg.name,
+	(SELECT COUNT(*) FROM track_tags tt WHERE tt.tag_id = tg.id),
+	(SELECT COUNT(*) FROM video_tags vt WHERE vt.tag_id = tg.id),
+	tg.created_at, tg.updated_at`
+
+var ErrTagExists = errors.New("tag with this name already exists")
+
+// Tag is a user-defined label on tracks and videos, with how many of each
+// carry it
+type Tag struct {
+	ID         string
+	UserID     string
+	Name       string
+	TrackCount int
+	VideoCount int
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// TagChanges adds and removes tags on a set of tracks and videos. Tags are
+// named ignoring case, and added names that don't exist yet are created.
+type TagChanges struct {
+	Add      []string
+	Remove   []string
+	TrackIDs []string
+	VideoIDs []string
+}
+
+func scanTag(row pgx.Row, tag *Tag) error {
+	return row.Scan(
+		&tag.ID,
+		&tag.UserID,
+		&tag.Name,
+		&tag.TrackCount,
+		&tag.VideoCount,
+		&tag.CreatedAt,
+		&tag.UpdatedAt,
+	)
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// ListTags returns a user's tags ordered by name
+func (db *DB) ListTags(ctx context.Context, userID string) ([]Tag, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT `+tagColumns+`
+		FROM tags tg
+		WHERE tg.user_id = $1
+		ORDER BY lower(tg.name)
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list tags: %w", err)
+	}
+	defer rows.Close()
+
+	var tags []Tag
+	for rows.Next() {
+		var tag Tag
+		if err := scanTag(rows, &tag); err != nil {
+			return nil, fmt.Errorf("scan tag: %w", err)
+		}
+		tags = append(tags, tag)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate tags: %w", err)
+	}
+
+	return tags, nil
+}
+
+// CreateTag creates a tag, returning ErrTagExists if the user has one with
+// the same name
+func (db *DB) CreateTag(ctx context.Context, userID, name string) (*Tag, error) {
+	tag := &Tag{}
+	err := scanTag(db.Pool.QueryRow(ctx, `
+		INSERT INTO tags AS tg (user_id, name)
+		VALUES ($1, $2)
+		RETURNING `+tagColumns,
+		userID, name), tag)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrTagExists
+		}
+		return nil, fmt.Errorf("create tag: %w", err)
+	}
+
+	return tag, nil
+}
+
+// RenameTag renames a tag, returning ErrTagExists if another of the user's
+// tags has the new name
+func (db *DB) RenameTag(ctx context.Context, tagID, userID, name string) (*Tag, error) {
+	tag := &Tag{}
+	err := scanTag(db.Pool.QueryRow(ctx, `
+		UPDATE tags tg
+		SET name = $3, updated_at = NOW()
+		WHERE tg.id = $1 AND tg.user_id = $2
+		RETURNING `+tagColumns,
+		tagID, userID, name), tag)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrTagExists
+		}
+		return nil, fmt.Errorf("rename tag: %w", err)
+	}
+
+	return tag, nil
+}
+
+// DeleteTag deletes a tag and removes it from every item
+func (db *DB) DeleteTag(ctx context.Context, tagID, userID string) error {
+	result, err := db.Pool.Exec(ctx, `DELETE FROM tags WHERE id = $1 AND user_id = $2`, tagID, userID)
+	if err != nil {
+		return fmt.Errorf("delete tag: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// ApplyTagChanges adds and removes tags on tracks and videos in one
+// transaction. Items the user doesn't own are skipped.
+func (db *DB) ApplyTagChanges(ctx context.Context, userID string, changes *TagChanges) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := applyTagChanges(ctx, tx, userID, changes); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// applyTagChanges runs ApplyTagChanges within tx
+func applyTagChanges(ctx context.Context, tx pgx.Tx, userID string, changes *TagChanges) error {
+	if len(changes.Add) > 0 {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO tags (user_id, name)
+			SELECT $1, name FROM unnest($2::text[]) AS name
+			ON CONFLICT (user_id, lower(name)) DO NOTHING
+		`, userID, changes.Add)
+		if err != nil {
+			return fmt.Errorf("create tags: %w", err)
+		}
+	}
+
+	for _, items := range []struct {
+		table     string
+		column    string
+		itemTable string
+		ids       []string
+	}{
+		{"track_tags", "track_id", "tracks", changes.TrackIDs},
+		{"video_tags", "video_id", "videos", changes.VideoIDs},
+	} {
+		if len(items.ids) == 0 {
+			continue
+		}
+
+		if len(changes.Add) > 0 {
+			_, err := tx.Exec(ctx, fmt.Sprintf(`
+				INSERT INTO %s (tag_id, %s)
+				SELECT tg.id, item.id
+				FROM tags tg
+				CROSS JOIN %s item
+				WHERE tg.user_id = $1 AND lower(tg.name) IN (SELECT lower(name) FROM unnest($2::text[]) AS name)
+				  AND item.user_id = $1 AND item.id = ANY($3)
+				ON CONFLICT DO NOTHING
+			`, items.table, items.column, items.itemTable), userID, changes.Add, items.ids)
+			if err != nil {
+				return fmt.Errorf("add %s: %w", items.table, err)
+			}
+		}
+
+		if len(changes.Remove) > 0 {
+			_, err := tx.Exec(ctx, fmt.Sprintf(`
+				DELETE FROM %s x
+				USING tags tg
+				WHERE x.tag_id = tg.id AND tg.user_id = $1
+				  AND lower(tg.name) IN (SELECT lower(name) FROM unnest($2::text[]) AS name)
+				  AND x.%s = ANY($3)
+			`, items.table, items.column), userID, changes.Remove, items.ids)
+			if err != nil {
+				return fmt.Errorf("remove %s: %w", items.table, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,391 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrRestoreConflict is returned when a trashed item can't be restored
+// because the same YouTube video is back in the library
+var ErrRestoreConflict = errors.New("item is already in the library")
+
+// trashKind describes how an item kind is stored and linked
+type trashKind struct {
+	table    string
+	tagTable string
+	// idColumn names the item in its tag table and in play_history
+	idColumn string
+	// columns lists every stored column, which is everything a restore
+	// copies back. Generated columns are left out.
+	columns string
+}
+
+var trashKinds = map[string]trashKind{
+	SyncTrack: {
+		table:    "tracks",
+		tagTable: "track_tags",
+		idColumn: "track_id",
+		columns: `id, user_id, youtube_id, title, artist, duration_seconds, thumbnail_url, file_path,
+			file_size_bytes, album, release_date, mb_recording_id, mb_release_id, mb_artist_id, fingerprint,
+			description, play_count, favorited_at, rating, artist_id, album_id, cover_path, created_at, updated_at`,
+	},
+	SyncVideo: {
+		table:    "videos",
+		tagTable: "video_tags",
+		idColumn: "video_id",
+		columns: `id, user_id, youtube_id, title, channel, duration_seconds, thumbnail_url, file_path,
+			file_size_bytes, quality, description, play_count, favorited_at, rating, cover_path, created_at, updated_at`,
+	},
+}
+
+// TrashItem is a deleted track or video awaiting restore or purge. Exactly
+// one of Track and Video is set, matching Kind.
+type TrashItem struct {
+	ID        string
+	Kind      string
+	Track     *Track
+	Video     *Video
+	DeletedAt time.Time
+}
+
+// MoveToTrash deletes one of the user's tracks or videos, keeping it in the
+// trash along with its tags, playlist entries, play history links and
+// playback position.
+// Returns ErrNotFound if the user has no such item.
+func (db *DB) MoveToTrash(ctx context.Context, userID, itemID string) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := moveToTrash(ctx, tx, userID, itemID); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func moveToTrash(ctx context.Context, tx pgx.Tx, userID, itemID string) error {
+	for _, kind := range []string{SyncTrack, SyncVideo} {
+		k := trashKinds[kind]
+
+		// Tracks remember where they sat in each playlist as an index, since
+		// stored positions can have gaps
+		playlists := `'[]'::jsonb`
+		if kind == SyncTrack {
+			playlists = `COALESCE((
+				SELECT jsonb_agg(jsonb_build_object('playlist_id', pt.playlist_id, 'index', (
+					SELECT COUNT(*) FROM playlist_tracks other
+					WHERE other.playlist_id = pt.playlist_id AND other.position < pt.position
+				)))
+				FROM playlist_tracks pt WHERE pt.track_id = x.id
+			), '[]'::jsonb)`
+		}
+
+		result, err := tx.Exec(ctx, fmt.Sprintf(`
+			INSERT INTO trash (id, user_id, kind, item, file_path, tag_ids, playlists, play_ids, playback_position)
+			SELECT x.id, x.user_id, $3, to_jsonb(x) - 'search_vector' - 'search_text', x.file_path,
+				ARRAY(SELECT tag_id FROM %[2]s WHERE %[3]s = x.id),
+				%[4]s,
+				ARRAY(SELECT id FROM play_history WHERE %[3]s = x.id),
+				(SELECT to_jsonb(pp) FROM playback_positions pp WHERE pp.%[3]s = x.id)
+			FROM %[1]s x
+			WHERE x.id = $1 AND x.user_id = $2
+		`, k.table, k.tagTable, k.idColumn, playlists), itemID, userID, kind)
+		if err != nil {
+			return fmt.Errorf("move %s to trash: %w", kind, err)
+		}
+		if result.RowsAffected() == 0 {
+			continue
+		}
+
+		_, err = tx.Exec(ctx, fmt.Sprintf(`DELETE FROM %s WHERE id = $1`, k.table), itemID)
+		if err != nil {
+			return fmt.Errorf("delete %s: %w", kind, err)
+		}
+		return nil
+	}
+
+	return ErrNotFound
+}
+
+// ListTrash returns the items in a user's trash, most recently deleted first
+func (db *DB) ListTrash(ctx context.Context, userID string) ([]TrashItem, error) {
+	var items []TrashItem
+
+	rows, err := db.Pool.Query(ctx, `
+		SELECT `+trackColumns+`, tr.deleted_at,
+			ARRAY(SELECT tg.name FROM tags tg WHERE tg.id = ANY(tr.tag_ids) ORDER BY lower(tg.name))
+		FROM trash tr
+		CROSS JOIN LATERAL jsonb_populate_record(NULL::tracks, tr.item) t
+		WHERE tr.user_id = $1 AND tr.kind = $2
+	`, userID, SyncTrack)
+	if err != nil {
+		return nil, fmt.Errorf("get trashed tracks: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := TrashItem{Kind: SyncTrack, Track: &Track{}}
+		// The trashed item's tags went with it, so they're scanned from the
+		// IDs kept in the trash instead
+		var tags []string
+		if err := rows.Scan(append(trackScanTargets(item.Track), &item.DeletedAt, &tags)...); err != nil {
+			return nil, fmt.Errorf("scan trashed track: %w", err)
+		}
+		item.ID, item.Track.Tags = item.Track.ID, tags
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate trashed tracks: %w", err)
+	}
+	rows.Close()
+
+	rows, err = db.Pool.Query(ctx, `
+		SELECT `+videoColumns+`, tr.deleted_at,
+			ARRAY(SELECT tg.name FROM tags tg WHERE tg.id = ANY(tr.tag_ids) ORDER BY lower(tg.name))
+		FROM trash tr
+		CROSS JOIN LATERAL jsonb_populate_record(NULL::videos, tr.item) v
+		WHERE tr.user_id = $1 AND tr.kind = $2
+	`, userID, SyncVideo)
+	if err != nil {
+		return nil, fmt.Errorf("get trashed videos: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := TrashItem{Kind: SyncVideo, Video: &Video{}}
+		var tags []string
+		if err := rows.Scan(append(videoScanTargets(item.Video), &item.DeletedAt, &tags)...); err != nil {
+			return nil, fmt.Errorf("scan trashed video: %w", err)
+		}
+		item.ID, item.Video.Tags = item.Video.ID, tags
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate trashed videos: %w", err)
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].DeletedAt.After(items[j].DeletedAt)
+	})
+
+	return items, nil
+}
+
+// RestoreFromTrash puts a trashed item back in the user's library with its
+// tags, play history, playlist entries and playback position. Tags and playlists deleted in
+// the meantime are skipped. Returns ErrNotFound if the item isn't in the
+// user's trash, or ErrRestoreConflict if the same YouTube video has been
+// added to the library again.
+func (db *DB) RestoreFromTrash(ctx context.Context, userID, itemID string) (*TrashItem, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var kind string
+	var tagIDs, playIDs []string
+	err = tx.QueryRow(ctx, `
+		SELECT kind, tag_ids, play_ids FROM trash WHERE id = $1 AND user_id = $2 FOR UPDATE
+	`, itemID, userID).Scan(&kind, &tagIDs, &playIDs)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get trashed item: %w", err)
+	}
+	k, known := trashKinds[kind]
+	if !known {
+		return nil, fmt.Errorf("unknown trash kind %q", kind)
+	}
+
+	if kind == SyncTrack {
+		// The track's artist or album may have been merged away meanwhile;
+		// the catalog links it again after the restore
+		_, err = tx.Exec(ctx, `
+			UPDATE trash SET item = item || jsonb_build_object(
+				'artist_id', (SELECT id FROM artists WHERE id = (item->>'artist_id')::uuid),
+				'album_id', (SELECT id FROM albums WHERE id = (item->>'album_id')::uuid))
+			WHERE id = $1
+		`, itemID)
+		if err != nil {
+			return nil, fmt.Errorf("check catalog links: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(ctx, fmt.Sprintf(`
+		INSERT INTO %[1]s (%[2]s)
+		SELECT %[2]s FROM jsonb_populate_record(NULL::%[1]s, (SELECT item FROM trash WHERE id = $1))
+	`, k.table, k.columns), itemID)
+	if isUniqueViolation(err) {
+		return nil, ErrRestoreConflict
+	}
+	if err != nil {
+		return nil, fmt.Errorf("restore %s: %w", kind, err)
+	}
+
+	_, err = tx.Exec(ctx, fmt.Sprintf(`
+		INSERT INTO %s (tag_id, %s)
+		SELECT id, $1 FROM tags WHERE user_id = $2 AND id = ANY($3::uuid[])
+	`, k.tagTable, k.idColumn), itemID, userID, tagIDs)
+	if err != nil {
+		return nil, fmt.Errorf("restore tags: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, fmt.Sprintf(`
+		UPDATE play_history SET %s = $1 WHERE id = ANY($2::uuid[]) AND %[1]s IS NULL
+	`, k.idColumn), itemID, playIDs)
+	if err != nil {
+		return nil, fmt.Errorf("restore play history: %w", err)
+	}
+
+	// The position is stored again now, so devices that synced positions
+	// while the item was in the trash fetch it
+	_, err = tx.Exec(ctx, fmt.Sprintf(`
+		INSERT INTO playback_positions (user_id, %s, position_seconds, finished, updated_at)
+		SELECT $2, $1, p.position_seconds, p.finished, p.updated_at
+		FROM trash tr
+		CROSS JOIN LATERAL jsonb_populate_record(NULL::playback_positions, tr.playback_position) p
+		WHERE tr.id = $1 AND tr.playback_position IS NOT NULL
+		ON CONFLICT DO NOTHING
+	`, k.idColumn), itemID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("restore playback position: %w", err)
+	}
+
+	if kind == SyncTrack {
+		if err := restorePlaylistEntries(ctx, tx, userID, itemID); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM trash WHERE id = $1`, itemID); err != nil {
+		return nil, fmt.Errorf("remove from trash: %w", err)
+	}
+
+	item := &TrashItem{ID: itemID, Kind: kind}
+	if kind == SyncTrack {
+		item.Track = &Track{}
+		err = scanTrack(tx.QueryRow(ctx, `SELECT `+trackColumns+` FROM tracks t WHERE t.id = $1`, itemID), item.Track)
+	} else {
+		item.Video = &Video{}
+		err = scanVideo(tx.QueryRow(ctx, `SELECT `+videoColumns+` FROM videos v WHERE v.id = $1`, itemID), item.Video)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get restored %s: %w", kind, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit transaction: %w", err)
+	}
+
+	return item, nil
+}
+
+// restorePlaylistEntries puts a restored track back at its old index in
+// each playlist it was in that still exists
+func restorePlaylistEntries(ctx context.Context, tx pgx.Tx, userID, trackID string) error {
+	type entry struct {
+		playlistID string
+		index      int
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT e.playlist_id, e.index
+		FROM trash tr
+		CROSS JOIN LATERAL jsonb_to_recordset(tr.playlists) AS e(playlist_id UUID, index INTEGER)
+		INNER JOIN playlists p ON p.id = e.playlist_id
+		WHERE tr.id = $1 AND p.user_id = $2 AND p.rules IS NULL
+		ORDER BY e.index
+	`, trackID, userID)
+	if err != nil {
+		return fmt.Errorf("get trashed playlist entries: %w", err)
+	}
+
+	var entries []entry
+	for rows.Next() {
+		var e entry
+		if err := rows.Scan(&e.playlistID, &e.index); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan trashed playlist entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate trashed playlist entries: %w", err)
+	}
+
+	for _, e := range entries {
+		if err := insertPlaylistTrack(ctx, tx, e.playlistID, trackID, e.index); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// trashFiles selects the media file and any cover art of a trash entry
+const trashFiles = `file_path, item->>'cover_path'`
+
+// DeleteFromTrash permanently removes an item from the user's trash and
+// returns the paths of its media file and cover art. Returns ErrNotFound if
+// it isn't there.
+func (db *DB) DeleteFromTrash(ctx context.Context, userID, itemID string) ([]string, error) {
+	filePaths, err := db.deleteTrash(ctx, `DELETE FROM trash WHERE id = $1 AND user_id = $2 RETURNING `+trashFiles, itemID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(filePaths) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return filePaths, nil
+}
+
+// EmptyTrash permanently removes everything in the user's trash and returns
+// the paths of the removed items' media files and cover art
+func (db *DB) EmptyTrash(ctx context.Context, userID string) ([]string, error) {
+	return db.deleteTrash(ctx, `DELETE FROM trash WHERE user_id = $1 RETURNING `+trashFiles, userID)
+}
+
+// PurgeTrash permanently removes items deleted before cutoff from every
+// user's trash and returns the paths of their media files and cover art
+func (db *DB) PurgeTrash(ctx context.Context, cutoff time.Time) ([]string, error) {
+	return db.deleteTrash(ctx, `DELETE FROM trash WHERE deleted_at < $1 RETURNING `+trashFiles, cutoff)
+}
+
+func (db *DB) deleteTrash(ctx context.Context, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.Pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("delete trash: %w", err)
+	}
+	defer rows.Close()
+
+	var filePaths []string
+	for rows.Next() {
+		var filePath string
+		var coverPath *string
+		if err := rows.Scan(&filePath, &coverPath); err != nil {
+			return nil, fmt.Errorf("scan file paths: %w", err)
+		}
+		filePaths = append(filePaths, filePath)
+		if coverPath != nil {
+			filePaths = append(filePaths, *coverPath)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate deleted trash: %w", err)
+	}
+
+	return filePaths, nil
+}
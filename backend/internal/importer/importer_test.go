@@ -0,0 +1,153 @@
+package importer
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseCSV(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []Row
+	}{
+		{
+			name: "exportify",
+			data: bom + "Track URI,Track Name,Artist Name(s),Album Name,Duration (ms)\n" +
+				"spotify:track:1,Hey Jude - Remastered 2015,The Beatles,1,431333\n" +
+				"spotify:track:2,\"Under Pressure\",\"Queen, David Bowie\",Hot Space,248440\n",
+			want: []Row{
+				{Title: "Hey Jude - Remastered 2015", Artist: "The Beatles", Album: "1", DurationSeconds: 431},
+				{Title: "Under Pressure", Artist: "Queen, David Bowie", Album: "Hot Space", DurationSeconds: 248},
+			},
+		},
+		{
+			name: "spreadsheet with m:ss times",
+			data: "Title,Artist,Time\nSo What,Miles Davis,9:22\n,Nobody,1:00\nBlue in Green,Miles Davis,\n",
+			want: []Row{
+				{Title: "So What", Artist: "Miles Davis", DurationSeconds: 562},
+				{Title: "Blue in Green", Artist: "Miles Davis"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(FormatCSV, []byte(tt.data))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCSVWithoutTitle(t *testing.T) {
+	_, err := Parse(FormatCSV, []byte("foo,bar\n1,2\n"))
+	if !errors.Is(err, ErrNoTitleColumn) {
+		t.Errorf("Parse() error = %v, want %v", err, ErrNoTitleColumn)
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []Row
+	}{
+		{
+			name: "spotify account data",
+			data: `{"tracks": [{"artist": "Daft Punk", "album": "Discovery", "track": "One More Time", "uri": "spotify:track:x"}]}`,
+			want: []Row{{Title: "One More Time", Artist: "Daft Punk", Album: "Discovery"}},
+		},
+		{
+			name: "apple music library",
+			data: `[{"Title": "Hurt", "Artist": "Johnny Cash", "Album": "American IV", "Track Duration": 218000}]`,
+			want: []Row{{Title: "Hurt", Artist: "Johnny Cash", Album: "American IV", DurationSeconds: 218}},
+		},
+		{
+			name: "artist list",
+			data: `[{"name": "Get Lucky", "artists": [{"name": "Daft Punk"}, {"name": "Pharrell Williams"}], "duration_ms": 369626}]`,
+			want: []Row{{Title: "Get Lucky", Artist: "Daft Punk, Pharrell Williams", DurationSeconds: 370}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(Detect([]byte(tt.data)), []byte(tt.data))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int
+	}{
+		{"3:45", 225},
+		{"1:02:03", 3723},
+		{"225", 225},
+		{"225000", 225},
+		{"", 0},
+		{"abc", 0},
+	}
+
+	for _, tt := range tests {
+		if got := parseDuration(tt.input); got != tt.want {
+			t.Errorf("parseDuration(%q) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestSearchQuery(t *testing.T) {
+	tests := []struct {
+		row  Row
+		want string
+	}{
+		{Row{Title: "Hey Jude - Remastered 2015", Artist: "The Beatles"}, "The Beatles - Hey Jude"},
+		{Row{Title: "Under Pressure", Artist: "Queen, David Bowie"}, "Queen - Under Pressure"},
+		{Row{Title: "Untitled"}, "Untitled"},
+	}
+
+	for _, tt := range tests {
+		if got := SearchQuery(tt.row); got != tt.want {
+			t.Errorf("SearchQuery(%+v) = %q, want %q", tt.row, got, tt.want)
+		}
+	}
+}
+
+func TestRank(t *testing.T) {
+	row := Row{Title: "Under Pressure - Remastered 2011", Artist: "Queen, David Bowie", DurationSeconds: 248}
+	candidates := []Candidate{
+		{VideoID: "live", Title: "Queen - Under Pressure (Live at Wembley)", Channel: "Queen Official", DurationSeconds: 260},
+		{VideoID: "topic", Title: "Under Pressure (Remastered 2011)", Channel: "Queen - Topic", DurationSeconds: 249},
+		{VideoID: "label", Title: "Queen & David Bowie - Under Pressure (Official Video)", Channel: "Universal Music", DurationSeconds: 245},
+		{VideoID: "other", Title: "Ice Ice Baby", Channel: "Vanilla Ice", DurationSeconds: 271},
+	}
+
+	ranked := Rank(row, candidates)
+	if ranked[0].VideoID != "topic" {
+		t.Errorf("Rank()[0] = %q, want %q", ranked[0].VideoID, "topic")
+	}
+	if ranked[0].Score < AutoAcceptScore {
+		t.Errorf("Rank()[0].Score = %v, want >= %v", ranked[0].Score, AutoAcceptScore)
+	}
+	if ranked[1].VideoID != "label" || ranked[1].Score < AutoAcceptScore {
+		t.Errorf("Rank()[1] = %+v, want label above %v", ranked[1], AutoAcceptScore)
+	}
+	if ranked[2].VideoID != "live" || ranked[2].Score >= AutoAcceptScore {
+		t.Errorf("Rank()[2] = %+v, want live below %v", ranked[2], AutoAcceptScore)
+	}
+	if ranked[3].Score >= 0.5 {
+		t.Errorf("Rank()[3].Score = %v, want < 0.5", ranked[3].Score)
+	}
+}
@@ -0,0 +1,123 @@
+package importer
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/wpinrui/dovora2/backend/internal/textmatch"
+)
+
+const (
+	// AutoAcceptScore is the score at which the best candidate is accepted
+	// without asking the user
+	AutoAcceptScore = 0.8
+
+	// MaxCandidates is how many ranked candidates are kept for manual review
+	MaxCandidates = 5
+
+	// versionPenalty scales the score of a candidate that is a different
+	// version of the song, e.g. a live recording when the studio one was wanted
+	versionPenalty = 0.7
+)
+
+// Candidate is a YouTube video that may be the recording a row refers to
+type Candidate struct {
+	VideoID         string
+	Title           string
+	Channel         string
+	DurationSeconds int
+	Score           float64
+}
+
+// versionWords mark uploads that aren't the original recording
+var versionWords = []string{
+	"live", "cover", "remix", "karaoke", "instrumental", "acoustic",
+	"nightcore", "slowed", "sped", "reverb", "8d", "reaction", "tutorial",
+}
+
+// remasterNote matches " - Remastered 2011" and "(2011 Remaster)" style notes
+// that streaming services and uploaders add to titles inconsistently
+var remasterNote = regexp.MustCompile(`(?i)(\s+[-–]\s+[^-–]*|\s*[\(\[][^\)\]]*)\b(remaster(ed)?|mono|stereo|single version|radio edit)\b([^-–]*$|[^\)\]]*[\)\]])`)
+
+// CleanTitle drops remaster and edition notes that don't help a search
+func CleanTitle(title string) string {
+	return strings.TrimSpace(remasterNote.ReplaceAllString(title, ""))
+}
+
+// SearchQuery returns the YouTube search query for a row
+func SearchQuery(row Row) string {
+	title := CleanTitle(row.Title)
+	if artist := textmatch.PrimaryArtist(row.Artist); artist != "" {
+		return artist + " - " + title
+	}
+	return title
+}
+
+// Score rates how likely a candidate is to be the row's recording, from 0 to 1
+func Score(row Row, candidate Candidate) float64 {
+	want := textmatch.Track{
+		Title:           CleanTitle(row.Title),
+		Artist:          row.Artist,
+		DurationSeconds: row.DurationSeconds,
+	}
+
+	got := textmatch.Track{
+		Title:           CleanTitle(candidate.Title),
+		Artist:          textmatch.StripChannelSuffix(candidate.Channel),
+		DurationSeconds: candidate.DurationSeconds,
+	}
+	// Labels and fan channels put the artist in the title instead
+	if prefix, rest, ok := strings.Cut(got.Title, " - "); ok &&
+		textmatch.Similarity(prefix, want.Artist) > textmatch.Similarity(got.Artist, want.Artist) {
+		got.Title, got.Artist = rest, prefix
+	}
+
+	score := textmatch.TrackScore(got, want)
+	// Several credited artists only partly overlap with one channel name
+	if primary := textmatch.PrimaryArtist(row.Artist); primary != row.Artist {
+		want.Artist = primary
+		score = max(score, textmatch.TrackScore(got, want))
+	}
+
+	if otherVersion(candidate.Title, row.Title) {
+		score *= versionPenalty
+	}
+
+	return score
+}
+
+// Rank scores candidates against a row and returns the best MaxCandidates,
+// highest score first
+func Rank(row Row, candidates []Candidate) []Candidate {
+	ranked := make([]Candidate, len(candidates))
+	for i, candidate := range candidates {
+		candidate.Score = Score(row, candidate)
+		ranked[i] = candidate
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Score > ranked[j].Score
+	})
+
+	if len(ranked) > MaxCandidates {
+		ranked = ranked[:MaxCandidates]
+	}
+	return ranked
+}
+
+// otherVersion reports whether a candidate title names a version of the song
+// that the wanted title doesn't
+func otherVersion(candidate, want string) bool {
+	candidateWords := strings.Fields(textmatch.Normalize(candidate))
+	wantText := " " + textmatch.Normalize(want) + " "
+
+	for _, word := range candidateWords {
+		for _, version := range versionWords {
+			if word == version && !strings.Contains(wantText, " "+version+" ") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,284 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// bom is the UTF-8 byte order mark spreadsheet exports often start with
+const bom = "\ufeff"
+
+// Supported export formats
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported import format")
+	ErrNoTitleColumn     = errors.New("no track title column found")
+)
+
+// Row is one track from an exported playlist
+type Row struct {
+	Title           string
+	Artist          string
+	Album           string
+	DurationSeconds int
+}
+
+type field int
+
+const (
+	fieldNone field = iota
+	fieldTitle
+	fieldArtist
+	fieldAlbum
+	fieldDurationMillis
+	fieldDuration
+)
+
+// columnAliases maps normalized column names used by Spotify exporters
+// (Exportify, account data), Apple Music library exports and hand-made
+// spreadsheets to the field they hold
+var columnAliases = map[string]field{
+	"track":         fieldTitle,
+	"trackname":     fieldTitle,
+	"title":         fieldTitle,
+	"name":          fieldTitle,
+	"song":          fieldTitle,
+	"songname":      fieldTitle,
+	"artist":        fieldArtist,
+	"artists":       fieldArtist,
+	"artistname":    fieldArtist,
+	"artistnames":   fieldArtist,
+	"albumartist":   fieldArtist,
+	"album":         fieldAlbum,
+	"albumname":     fieldAlbum,
+	"durationms":    fieldDurationMillis,
+	"trackduration": fieldDurationMillis,
+	"duration":      fieldDuration,
+	"length":        fieldDuration,
+	"time":          fieldDuration,
+}
+
+// normalizeColumn lowercases a column name and drops everything but letters
+// and digits, so "Artist Name(s)" and "artist_name" compare equal
+func normalizeColumn(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Detect guesses whether data is JSON or CSV
+func Detect(data []byte) string {
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(data, []byte(bom)))
+	if len(trimmed) > 0 && (trimmed[0] == '[' || trimmed[0] == '{') {
+		return FormatJSON
+	}
+	return FormatCSV
+}
+
+// Parse reads the rows of an exported playlist. Rows without a title are skipped.
+func Parse(format string, data []byte) ([]Row, error) {
+	data = bytes.TrimPrefix(data, []byte(bom))
+
+	switch format {
+	case FormatCSV:
+		return parseCSV(data)
+	case FormatJSON:
+		return parseJSON(data)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedFormat, format)
+	}
+}
+
+func parseCSV(data []byte) ([]Row, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading csv header: %w", err)
+	}
+
+	fields := make([]field, len(header))
+	for i, name := range header {
+		fields[i] = columnAliases[normalizeColumn(name)]
+	}
+
+	var rows []Row
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading csv: %w", err)
+		}
+
+		values := make(map[field]string)
+		for i, value := range record {
+			if i < len(fields) && fields[i] != fieldNone && values[fields[i]] == "" {
+				values[fields[i]] = value
+			}
+		}
+		if row, ok := newRow(values); ok {
+			rows = append(rows, row)
+		}
+	}
+
+	if !hasField(fields, fieldTitle) {
+		return nil, ErrNoTitleColumn
+	}
+
+	return rows, nil
+}
+
+func parseJSON(data []byte) ([]Row, error) {
+	var items []map[string]interface{}
+	if err := json.Unmarshal(data, &items); err != nil {
+		// Spotify's account data wraps the list: {"tracks": [...]}
+		var wrapped map[string]json.RawMessage
+		if json.Unmarshal(data, &wrapped) != nil {
+			return nil, fmt.Errorf("parsing json: %w", err)
+		}
+		list, ok := firstList(wrapped, "tracks", "items", "songs")
+		if !ok {
+			return nil, fmt.Errorf("parsing json: no list of tracks found")
+		}
+		if err := json.Unmarshal(list, &items); err != nil {
+			return nil, fmt.Errorf("parsing json: %w", err)
+		}
+	}
+
+	var rows []Row
+	sawTitle := false
+	for _, item := range items {
+		values := make(map[field]string)
+		for key, raw := range item {
+			f := columnAliases[normalizeColumn(key)]
+			if f == fieldNone || values[f] != "" {
+				continue
+			}
+			values[f] = jsonString(raw)
+		}
+		sawTitle = sawTitle || values[fieldTitle] != ""
+		if row, ok := newRow(values); ok {
+			rows = append(rows, row)
+		}
+	}
+
+	if len(items) > 0 && !sawTitle {
+		return nil, ErrNoTitleColumn
+	}
+
+	return rows, nil
+}
+
+func firstList(obj map[string]json.RawMessage, keys ...string) (json.RawMessage, bool) {
+	for key, value := range obj {
+		for _, want := range keys {
+			if strings.EqualFold(key, want) {
+				return value, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// jsonString renders a decoded JSON value as the text a CSV cell would hold.
+// Lists, such as an array of artists, are joined with commas.
+func jsonString(v interface{}) string {
+	switch value := v.(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case []interface{}:
+		parts := make([]string, 0, len(value))
+		for _, item := range value {
+			if s := jsonString(item); s != "" {
+				parts = append(parts, s)
+			}
+		}
+		return strings.Join(parts, ", ")
+	case map[string]interface{}:
+		// e.g. {"name": "Artist"}
+		return jsonString(value["name"])
+	default:
+		return ""
+	}
+}
+
+func newRow(values map[field]string) (Row, bool) {
+	row := Row{
+		Title:  strings.TrimSpace(values[fieldTitle]),
+		Artist: strings.TrimSpace(values[fieldArtist]),
+		Album:  strings.TrimSpace(values[fieldAlbum]),
+	}
+	if row.Title == "" {
+		return row, false
+	}
+
+	if ms := strings.TrimSpace(values[fieldDurationMillis]); ms != "" {
+		if n, err := strconv.ParseFloat(ms, 64); err == nil && n > 0 {
+			row.DurationSeconds = int(n/1000 + 0.5)
+		}
+	} else {
+		row.DurationSeconds = parseDuration(values[fieldDuration])
+	}
+
+	return row, true
+}
+
+// parseDuration reads "m:ss", "h:mm:ss" or a bare number. Bare numbers above
+// an hour are taken to be milliseconds, since no column name said otherwise.
+func parseDuration(s string) int {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0
+	}
+
+	if strings.Contains(s, ":") {
+		total := 0
+		for _, part := range strings.Split(s, ":") {
+			n, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil || n < 0 {
+				return 0
+			}
+			total = total*60 + n
+		}
+		return total
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n <= 0 {
+		return 0
+	}
+	if n > 3600 {
+		return int(n/1000 + 0.5)
+	}
+	return int(n + 0.5)
+}
+
+func hasField(fields []field, want field) bool {
+	for _, f := range fields {
+		if f == want {
+			return true
+		}
+	}
+	return false
+}
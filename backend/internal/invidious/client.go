@@ -86,3 +86,56 @@ func (c *Client) Search(ctx context.Context, query string, searchType string) ([
 
 	return filtered, nil
 }
+
+// Video is a video's details, with the videos YouTube recommends alongside
+// it
+type Video struct {
+	VideoID           string             `json:"videoId"`
+	Title             string             `json:"title"`
+	Author            string             `json:"author"`
+	AuthorID          string             `json:"authorId"`
+	LengthSeconds     int                `json:"lengthSeconds"`
+	VideoThumbnails   []VideoThumbnail   `json:"videoThumbnails,omitempty"`
+	RecommendedVideos []RecommendedVideo `json:"recommendedVideos"`
+}
+
+// RecommendedVideo is a video related to another, in YouTube's order
+type RecommendedVideo struct {
+	VideoID         string           `json:"videoId"`
+	Title           string           `json:"title"`
+	Author          string           `json:"author"`
+	AuthorID        string           `json:"authorId"`
+	LengthSeconds   int              `json:"lengthSeconds"`
+	ViewCount       int64            `json:"viewCount,omitempty"`
+	VideoThumbnails []VideoThumbnail `json:"videoThumbnails,omitempty"`
+}
+
+// GetVideo fetches a video's details, including its related videos
+func (c *Client) GetVideo(ctx context.Context, videoID string) (*Video, error) {
+	endpoint := fmt.Sprintf("%s/api/v1/videos/%s?fields=videoId,title,author,authorId,lengthSeconds,videoThumbnails,recommendedVideos",
+		c.baseURL,
+		url.PathEscape(videoID),
+	)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("executing request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("invidious returned status %d", resp.StatusCode)
+	}
+
+	var video Video
+	if err := json.NewDecoder(resp.Body).Decode(&video); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+
+	return &video, nil
+}
@@ -0,0 +1,246 @@
+package media
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strconv"
+)
+
+const (
+	// fingerprintSeconds limits how much audio fpcalc analyses
+	fingerprintSeconds = 120
+
+	// minOverlapFrames is the shortest alignment worth comparing (~20s of audio)
+	minOverlapFrames = 160
+
+	// maxOffsetCandidates is how many of the most common alignments are scored
+	maxOffsetCandidates = 3
+)
+
+// Fingerprint is a raw Chromaprint fingerprint as produced by fpcalc -raw
+type Fingerprint []uint32
+
+// fpcalcOutput is the JSON structure printed by fpcalc -json -raw
+type fpcalcOutput struct {
+	Duration    float64       `json:"duration"`
+	Fingerprint []json.Number `json:"fingerprint"`
+}
+
+// Fingerprint computes the Chromaprint fingerprint of an audio file
+func (p *Processor) Fingerprint(ctx context.Context, filePath string) (Fingerprint, error) {
+	if filePath == "" {
+		return nil, errors.New("filePath is required")
+	}
+
+	output, err := p.runner.Run(ctx, p.fpcalcPath,
+		"-raw",
+		"-json",
+		"-length", strconv.Itoa(fingerprintSeconds),
+		filePath,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseFpcalcJSON(output)
+}
+
+// parseFpcalcJSON parses fpcalc JSON output. Older fpcalc releases print
+// the raw values as signed integers, so both signs are accepted.
+func parseFpcalcJSON(data []byte) (Fingerprint, error) {
+	var out fpcalcOutput
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("parsing fpcalc JSON: %w", err)
+	}
+
+	if len(out.Fingerprint) == 0 {
+		return nil, errors.New("fpcalc returned an empty fingerprint")
+	}
+
+	fp := make(Fingerprint, len(out.Fingerprint))
+	for i, n := range out.Fingerprint {
+		v, err := strconv.ParseInt(n.String(), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parsing fingerprint value %q: %w", n, err)
+		}
+		fp[i] = uint32(v)
+	}
+
+	return fp, nil
+}
+
+// Bytes encodes the fingerprint as little-endian 32-bit values for storage
+func (f Fingerprint) Bytes() []byte {
+	buf := make([]byte, 4*len(f))
+	for i, v := range f {
+		binary.LittleEndian.PutUint32(buf[4*i:], v)
+	}
+	return buf
+}
+
+// FingerprintFromBytes decodes a fingerprint encoded with Bytes
+func FingerprintFromBytes(data []byte) (Fingerprint, error) {
+	if len(data)%4 != 0 {
+		return nil, fmt.Errorf("fingerprint length %d is not a multiple of 4", len(data))
+	}
+
+	fp := make(Fingerprint, len(data)/4)
+	for i := range fp {
+		fp[i] = binary.LittleEndian.Uint32(data[4*i:])
+	}
+	return fp, nil
+}
+
+// Similarity compares two fingerprints and returns a score between 0 and 1,
+// where 1 means the audio is identical. Unrelated audio scores around 0.5.
+//
+// The fingerprints may be offset from one another (a music video with an
+// intro, for example), so likely alignments are found by counting exactly
+// matching frames at each offset, and the best of those is scored by the
+// fraction of matching bits over the overlapping frames.
+func (f Fingerprint) Similarity(other Fingerprint) float64 {
+	offsets := candidateOffsets(f, other)
+
+	best := 0.0
+	for _, offset := range offsets {
+		best = max(best, bitSimilarity(f, other, offset))
+	}
+	return best
+}
+
+// candidateOffsets returns the alignments (index in f minus index in other)
+// with the most exactly matching frames, always including zero
+func candidateOffsets(a, b Fingerprint) []int {
+	positions := make(map[uint32][]int, len(b))
+	for j, v := range b {
+		positions[v] = append(positions[v], j)
+	}
+
+	counts := make(map[int]int)
+	for i, v := range a {
+		for _, j := range positions[v] {
+			counts[i-j]++
+		}
+	}
+
+	offsets := []int{0}
+	for len(offsets) <= maxOffsetCandidates && len(counts) > 0 {
+		bestOffset, bestCount := 0, 0
+		for offset, count := range counts {
+			if count > bestCount || (count == bestCount && offset < bestOffset) {
+				bestOffset, bestCount = offset, count
+			}
+		}
+		delete(counts, bestOffset)
+		if bestOffset != 0 {
+			offsets = append(offsets, bestOffset)
+		}
+	}
+
+	return offsets
+}
+
+// bitSimilarity returns the fraction of equal bits when a[i] is aligned with b[i-offset]
+func bitSimilarity(a, b Fingerprint, offset int) float64 {
+	start := max(0, offset)
+	end := min(len(a), len(b)+offset)
+	if end-start < minOverlapFrames {
+		return 0
+	}
+
+	errorBits := 0
+	for i := start; i < end; i++ {
+		errorBits += bits.OnesCount32(a[i] ^ b[i-offset])
+	}
+
+	return 1 - float64(errorBits)/float64(32*(end-start))
+}
+
+// posting records where a fingerprint value occurs
+type posting struct {
+	fp  int
+	pos int
+}
+
+// alignment identifies a pair of fingerprints at a given offset
+type alignment struct {
+	a, b   int
+	offset int
+}
+
+const (
+	// maxPostings skips values shared by too many frames, such as silence,
+	// which would otherwise link every track to every other
+	maxPostings = 64
+
+	// minExactMatches is how many identical frames an alignment needs before
+	// it is worth scoring
+	minExactMatches = 8
+)
+
+// DuplicateGroups clusters fingerprints that match each other with at least
+// the given similarity. It returns groups of indices into fps; only groups
+// with two or more members are included.
+//
+// Rather than comparing every pair, an inverted index of frame values finds
+// the pairs and offsets that share exact frames, so unrelated tracks cost
+// almost nothing.
+func DuplicateGroups(fps []Fingerprint, threshold float64) [][]int {
+	index := make(map[uint32][]posting)
+	matches := make(map[alignment]int)
+
+	for i, fp := range fps {
+		for pos, v := range fp {
+			postings := index[v]
+			if len(postings) >= maxPostings {
+				continue
+			}
+			for _, p := range postings {
+				if p.fp != i {
+					matches[alignment{a: p.fp, b: i, offset: p.pos - pos}]++
+				}
+			}
+			index[v] = append(postings, posting{fp: i, pos: pos})
+		}
+	}
+
+	parent := make([]int, len(fps))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	for al, count := range matches {
+		if count < minExactMatches || find(al.a) == find(al.b) {
+			continue
+		}
+		if bitSimilarity(fps[al.a], fps[al.b], al.offset) >= threshold {
+			parent[find(al.a)] = find(al.b)
+		}
+	}
+
+	members := make(map[int][]int)
+	for i := range fps {
+		root := find(i)
+		members[root] = append(members[root], i)
+	}
+
+	var groups [][]int
+	for i := range fps {
+		if group := members[i]; len(group) > 1 {
+			groups = append(groups, group)
+		}
+	}
+
+	return groups
+}
@@ -0,0 +1,207 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// mockRunner is a test implementation of CommandRunner
+type mockRunner struct {
+	output []byte
+	err    error
+	calls  []mockCall
+}
+
+type mockCall struct {
+	name string
+	args []string
+}
+
+func (m *mockRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	m.calls = append(m.calls, mockCall{name: name, args: args})
+	return m.output, m.err
+}
+
+func (m *mockRunner) Stream(ctx context.Context, w io.Writer, name string, args ...string) error {
+	m.calls = append(m.calls, mockCall{name: name, args: args})
+	if m.err != nil {
+		return m.err
+	}
+	_, err := w.Write(m.output)
+	return err
+}
+
+func newTestProcessor(t *testing.T, opts ...Option) *Processor {
+	t.Helper()
+	p, err := New(t.TempDir(), opts...)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return p
+}
+
+func randomFingerprint(seed int64, length int) Fingerprint {
+	rng := rand.New(rand.NewSource(seed))
+	fp := make(Fingerprint, length)
+	for i := range fp {
+		fp[i] = rng.Uint32()
+	}
+	return fp
+}
+
+func TestProcessorFingerprint(t *testing.T) {
+	t.Run("runs fpcalc and parses output", func(t *testing.T) {
+		runner := &mockRunner{output: []byte(`{"duration": 210.5, "fingerprint": [1, 4294967295, 3]}`)}
+		p := newTestProcessor(t, WithCommandRunner(runner), WithFpcalcPath("/custom/fpcalc"))
+
+		fp, err := p.Fingerprint(context.Background(), "/media/song.m4a")
+		if err != nil {
+			t.Fatalf("Fingerprint() error = %v", err)
+		}
+
+		want := Fingerprint{1, 4294967295, 3}
+		if !reflect.DeepEqual(fp, want) {
+			t.Errorf("Fingerprint() = %v, want %v", fp, want)
+		}
+
+		if len(runner.calls) != 1 {
+			t.Fatalf("expected 1 call, got %d", len(runner.calls))
+		}
+		call := runner.calls[0]
+		if call.name != "/custom/fpcalc" {
+			t.Errorf("command = %v, want /custom/fpcalc", call.name)
+		}
+		wantArgs := []string{"-raw", "-json", "-length", "120", "/media/song.m4a"}
+		if !reflect.DeepEqual(call.args, wantArgs) {
+			t.Errorf("args = %v, want %v", call.args, wantArgs)
+		}
+	})
+
+	t.Run("returns runner error", func(t *testing.T) {
+		runner := &mockRunner{err: errors.New("fpcalc not found")}
+		p := newTestProcessor(t, WithCommandRunner(runner))
+
+		if _, err := p.Fingerprint(context.Background(), "/media/song.m4a"); err == nil {
+			t.Error("Fingerprint() expected error")
+		}
+	})
+
+	t.Run("requires file path", func(t *testing.T) {
+		p := newTestProcessor(t, WithCommandRunner(&mockRunner{}))
+
+		if _, err := p.Fingerprint(context.Background(), ""); err == nil {
+			t.Error("Fingerprint() expected error for empty path")
+		}
+	})
+}
+
+func TestParseFpcalcJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    Fingerprint
+		wantErr bool
+	}{
+		{"unsigned values", `{"fingerprint": [0, 4294967295]}`, Fingerprint{0, 4294967295}, false},
+		{"signed values", `{"fingerprint": [-1, 5]}`, Fingerprint{4294967295, 5}, false},
+		{"empty fingerprint", `{"fingerprint": []}`, nil, true},
+		{"invalid JSON", `not json`, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseFpcalcJSON([]byte(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFpcalcJSON() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseFpcalcJSON() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFingerprintBytes(t *testing.T) {
+	fp := randomFingerprint(1, 50)
+
+	decoded, err := FingerprintFromBytes(fp.Bytes())
+	if err != nil {
+		t.Fatalf("FingerprintFromBytes() error = %v", err)
+	}
+	if !reflect.DeepEqual(decoded, fp) {
+		t.Error("round trip through Bytes() changed the fingerprint")
+	}
+
+	if _, err := FingerprintFromBytes([]byte{1, 2, 3}); err == nil {
+		t.Error("FingerprintFromBytes() expected error for truncated data")
+	}
+}
+
+func TestFingerprintSimilarity(t *testing.T) {
+	base := randomFingerprint(1, 900)
+
+	t.Run("identical", func(t *testing.T) {
+		if got := base.Similarity(base); got != 1 {
+			t.Errorf("Similarity() = %v, want 1", got)
+		}
+	})
+
+	t.Run("offset copy", func(t *testing.T) {
+		// Simulate a video with an 80-frame intro before the same audio
+		shifted := append(randomFingerprint(2, 80), base[:820]...)
+		if got := base.Similarity(shifted); got != 1 {
+			t.Errorf("Similarity() = %v, want 1", got)
+		}
+		if got := shifted.Similarity(base); got != 1 {
+			t.Errorf("reverse Similarity() = %v, want 1", got)
+		}
+	})
+
+	t.Run("noisy copy", func(t *testing.T) {
+		noisy := make(Fingerprint, len(base))
+		copy(noisy, base)
+		for i := range noisy {
+			if i%3 == 0 {
+				noisy[i] ^= 0x00000F0F // flip 8 bits on a third of frames
+			}
+		}
+		if got := base.Similarity(noisy); got < 0.9 {
+			t.Errorf("Similarity() = %v, want >= 0.9", got)
+		}
+	})
+
+	t.Run("unrelated", func(t *testing.T) {
+		other := randomFingerprint(3, 900)
+		if got := base.Similarity(other); got > 0.6 {
+			t.Errorf("Similarity() = %v, want <= 0.6", got)
+		}
+	})
+
+	t.Run("too short to compare", func(t *testing.T) {
+		short := base[:minOverlapFrames-1]
+		if got := base.Similarity(short); got != 0 {
+			t.Errorf("Similarity() = %v, want 0", got)
+		}
+	})
+}
+
+func TestDuplicateGroups(t *testing.T) {
+	song := randomFingerprint(1, 900)
+	lyricVideo := append(randomFingerprint(2, 120), song[:780]...)
+	other := randomFingerprint(3, 900)
+	silence := make(Fingerprint, 900)
+	moreSilence := make(Fingerprint, 900)
+
+	fps := []Fingerprint{other, song, silence, lyricVideo, moreSilence}
+
+	groups := DuplicateGroups(fps, 0.85)
+
+	want := [][]int{{1, 3}}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("DuplicateGroups() = %v, want %v", groups, want)
+	}
+}
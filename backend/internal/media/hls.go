@@ -0,0 +1,291 @@
+package media
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// HLSMasterPlaylist is the file name of the master playlist in an HLS directory
+	HLSMasterPlaylist = "master.m3u8"
+
+	// hlsSegmentSeconds is the target duration of each HLS segment
+	hlsSegmentSeconds = 6
+
+	// hlsTimeout bounds a background HLS job; long 1080p videos take a while
+	hlsTimeout = 2 * time.Hour
+)
+
+// Rendition describes one HLS variant stream
+type Rendition struct {
+	Name         string
+	Height       int
+	VideoBitrate int // kbps, zero means the source video is copied
+	AudioBitrate int // kbps
+}
+
+// hlsRenditions are the downscaled variants offered below the source rendition
+var hlsRenditions = []Rendition{
+	{Name: "360p", Height: 360, VideoBitrate: 800, AudioBitrate: 96},
+	{Name: "720p", Height: 720, VideoBitrate: 2800, AudioBitrate: 128},
+}
+
+// sourceAudioBitrate is used for the source rendition, whose video is copied as-is
+const sourceAudioBitrate = 192
+
+// probeOutput is the JSON structure printed by ffprobe -of json
+type probeOutput struct {
+	Streams []struct {
+		Width  int `json:"width"`
+		Height int `json:"height"`
+	} `json:"streams"`
+	Format struct {
+		BitRate string `json:"bit_rate"`
+	} `json:"format"`
+}
+
+// videoInfo is what HLS planning needs to know about a source video
+type videoInfo struct {
+	Width   int
+	Height  int
+	BitRate int // bits per second
+}
+
+// HLSDir returns the directory holding the HLS renditions of a source video
+func (p *Processor) HLSDir(source string) string {
+	return p.cachePath("hls", source, "")
+}
+
+// HLSReady reports whether up-to-date HLS renditions exist for a source video
+func (p *Processor) HLSReady(source string) bool {
+	return isFresh(filepath.Join(p.HLSDir(source), HLSMasterPlaylist), source)
+}
+
+// EnsureHLS reports whether HLS renditions for a source video are ready. If
+// they aren't, the source is queued for generation unless it already is.
+// Jobs run one at a time since each one saturates the CPU.
+func (p *Processor) EnsureHLS(source string) bool {
+	if p.HLSReady(source) {
+		return true
+	}
+
+	p.hlsMu.Lock()
+	defer p.hlsMu.Unlock()
+
+	if p.hlsJobs[source] {
+		return false
+	}
+	p.hlsJobs[source] = true
+	p.hlsQueue = append(p.hlsQueue, source)
+
+	if !p.hlsRunning {
+		p.hlsRunning = true
+		go p.runHLSJobs()
+	}
+
+	return false
+}
+
+// runHLSJobs generates HLS for queued sources until the queue is empty
+func (p *Processor) runHLSJobs() {
+	for {
+		p.hlsMu.Lock()
+		if len(p.hlsQueue) == 0 {
+			p.hlsRunning = false
+			p.hlsMu.Unlock()
+			return
+		}
+		source := p.hlsQueue[0]
+		p.hlsQueue = p.hlsQueue[1:]
+		p.hlsMu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), hlsTimeout)
+		if err := p.GenerateHLS(ctx, source); err != nil {
+			log.Printf("Failed to generate HLS for %s: %v", source, err)
+		}
+		cancel()
+
+		p.hlsMu.Lock()
+		delete(p.hlsJobs, source)
+		p.hlsMu.Unlock()
+	}
+}
+
+// GenerateHLS transcodes a source video into fMP4 HLS renditions and writes a
+// master playlist referencing them. Renditions are built in a temporary
+// directory that replaces the previous output only once everything succeeded.
+func (p *Processor) GenerateHLS(ctx context.Context, source string) error {
+	info, err := p.probeVideo(ctx, source)
+	if err != nil {
+		return err
+	}
+
+	finalDir := p.HLSDir(source)
+	if err := os.MkdirAll(filepath.Dir(finalDir), dirPermission); err != nil {
+		return fmt.Errorf("creating HLS directory: %w", err)
+	}
+
+	workDir, err := os.MkdirTemp(filepath.Dir(finalDir), ".tmp-"+filepath.Base(finalDir)+"-*")
+	if err != nil {
+		return fmt.Errorf("creating HLS work directory: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	renditions := planRenditions(info.Height)
+	for _, rendition := range renditions {
+		renditionDir := filepath.Join(workDir, rendition.Name)
+		if err := os.MkdirAll(renditionDir, dirPermission); err != nil {
+			return fmt.Errorf("creating rendition directory: %w", err)
+		}
+
+		if _, err := p.runner.Run(ctx, p.ffmpegPath, hlsArgs(source, renditionDir, rendition)...); err != nil {
+			return fmt.Errorf("encoding %s rendition: %w", rendition.Name, err)
+		}
+	}
+
+	master := masterPlaylist(renditions, info)
+	if err := os.WriteFile(filepath.Join(workDir, HLSMasterPlaylist), []byte(master), 0644); err != nil {
+		return fmt.Errorf("writing master playlist: %w", err)
+	}
+
+	if err := os.RemoveAll(finalDir); err != nil {
+		return fmt.Errorf("removing previous HLS output: %w", err)
+	}
+	if err := os.Rename(workDir, finalDir); err != nil {
+		return fmt.Errorf("moving HLS output into place: %w", err)
+	}
+
+	return nil
+}
+
+func (p *Processor) probeVideo(ctx context.Context, source string) (*videoInfo, error) {
+	output, err := p.runner.Run(ctx, p.ffprobePath,
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=width,height:format=bit_rate",
+		"-of", "json",
+		source,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseProbeJSON(output)
+}
+
+func parseProbeJSON(data []byte) (*videoInfo, error) {
+	var out probeOutput
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("parsing ffprobe JSON: %w", err)
+	}
+
+	if len(out.Streams) == 0 || out.Streams[0].Height <= 0 {
+		return nil, errors.New("source has no video stream")
+	}
+
+	info := &videoInfo{
+		Width:  out.Streams[0].Width,
+		Height: out.Streams[0].Height,
+	}
+	// A missing bit rate only affects the advertised bandwidth
+	info.BitRate, _ = strconv.Atoi(out.Format.BitRate)
+
+	return info, nil
+}
+
+// planRenditions returns the downscaled renditions smaller than the source,
+// followed by the source itself
+func planRenditions(sourceHeight int) []Rendition {
+	var renditions []Rendition
+	for _, r := range hlsRenditions {
+		if r.Height < sourceHeight {
+			renditions = append(renditions, r)
+		}
+	}
+
+	return append(renditions, Rendition{
+		Name:         "source",
+		Height:       sourceHeight,
+		AudioBitrate: sourceAudioBitrate,
+	})
+}
+
+// hlsArgs builds the ffmpeg arguments that encode one rendition into dir
+func hlsArgs(source, dir string, r Rendition) []string {
+	args := []string{
+		"-v", "error",
+		"-y",
+		"-i", source,
+		"-map", "0:v:0",
+		"-map", "0:a:0?",
+	}
+
+	if r.VideoBitrate == 0 {
+		args = append(args, "-c:v", "copy")
+	} else {
+		// Fixed GOPs keep segment boundaries aligned across renditions
+		args = append(args,
+			"-vf", fmt.Sprintf("scale=-2:%d", r.Height),
+			"-c:v", "libx264",
+			"-preset", "veryfast",
+			"-b:v", fmt.Sprintf("%dk", r.VideoBitrate),
+			"-maxrate", fmt.Sprintf("%dk", r.VideoBitrate*107/100),
+			"-bufsize", fmt.Sprintf("%dk", r.VideoBitrate*3/2),
+			"-g", "48",
+			"-keyint_min", "48",
+			"-sc_threshold", "0",
+		)
+	}
+
+	return append(args,
+		"-c:a", "aac",
+		"-b:a", fmt.Sprintf("%dk", r.AudioBitrate),
+		"-ac", "2",
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(hlsSegmentSeconds),
+		"-hls_playlist_type", "vod",
+		"-hls_segment_type", "fmp4",
+		"-hls_fmp4_init_filename", "init.mp4",
+		"-hls_segment_filename", filepath.Join(dir, "seg_%05d.m4s"),
+		filepath.Join(dir, "index.m3u8"),
+	)
+}
+
+// masterPlaylist renders the master playlist for the given renditions
+func masterPlaylist(renditions []Rendition, source *videoInfo) string {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-INDEPENDENT-SEGMENTS\n")
+
+	for _, r := range renditions {
+		bandwidth := (r.VideoBitrate + r.AudioBitrate) * 1000
+		if r.VideoBitrate == 0 {
+			bandwidth = max(source.BitRate, bandwidth)
+		}
+		// Peak bandwidth runs above the average bitrate
+		bandwidth = bandwidth * 11 / 10
+
+		width := source.Width
+		if r.Height != source.Height {
+			width = evenRound(float64(source.Width) * float64(r.Height) / float64(source.Height))
+		}
+
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d,NAME=\"%s\"\n",
+			bandwidth, width, r.Height, r.Name)
+		fmt.Fprintf(&b, "%s/index.m3u8\n", r.Name)
+	}
+
+	return b.String()
+}
+
+// evenRound rounds to the nearest even integer, matching ffmpeg's scale=-2
+func evenRound(v float64) int {
+	return int(v/2+0.5) * 2
+}
@@ -0,0 +1,228 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testProbeJSON = `{"streams": [{"width": 1920, "height": 1080}], "format": {"bit_rate": "5000000"}}`
+
+func TestParseProbeJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    *videoInfo
+		wantErr bool
+	}{
+		{
+			name:  "full output",
+			input: testProbeJSON,
+			want:  &videoInfo{Width: 1920, Height: 1080, BitRate: 5000000},
+		},
+		{
+			name:  "missing bit rate",
+			input: `{"streams": [{"width": 640, "height": 360}], "format": {}}`,
+			want:  &videoInfo{Width: 640, Height: 360},
+		},
+		{
+			name:    "no video stream",
+			input:   `{"streams": [], "format": {"bit_rate": "128000"}}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid JSON",
+			input:   `not json`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseProbeJSON([]byte(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseProbeJSON() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseProbeJSON() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanRenditions(t *testing.T) {
+	tests := []struct {
+		name         string
+		sourceHeight int
+		want         []string
+	}{
+		{"1080p source", 1080, []string{"360p", "720p", "source"}},
+		{"720p source", 720, []string{"360p", "source"}},
+		{"480p source", 480, []string{"360p", "source"}},
+		{"240p source", 240, []string{"source"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, r := range planRenditions(tt.sourceHeight) {
+				got = append(got, r.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("planRenditions(%d) = %v, want %v", tt.sourceHeight, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMasterPlaylist(t *testing.T) {
+	source := &videoInfo{Width: 1920, Height: 1080, BitRate: 5000000}
+	got := masterPlaylist(planRenditions(source.Height), source)
+
+	want := "#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-INDEPENDENT-SEGMENTS\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=985600,RESOLUTION=640x360,NAME=\"360p\"\n360p/index.m3u8\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=3220800,RESOLUTION=1280x720,NAME=\"720p\"\n720p/index.m3u8\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=5500000,RESOLUTION=1920x1080,NAME=\"source\"\nsource/index.m3u8\n"
+	if got != want {
+		t.Errorf("masterPlaylist() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestProcessorGenerateHLS(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "video.mp4")
+	if err := os.WriteFile(source, []byte("video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("encodes renditions and writes master playlist", func(t *testing.T) {
+		runner := &mockRunner{output: []byte(testProbeJSON)}
+		p := newTestProcessor(t, WithCommandRunner(runner), WithFfprobePath("/custom/ffprobe"))
+
+		if p.HLSReady(source) {
+			t.Fatal("HLSReady() = true before generation")
+		}
+
+		if err := p.GenerateHLS(context.Background(), source); err != nil {
+			t.Fatalf("GenerateHLS() error = %v", err)
+		}
+
+		// One probe plus one encode per rendition
+		if len(runner.calls) != 4 {
+			t.Fatalf("expected 4 calls, got %d", len(runner.calls))
+		}
+		if runner.calls[0].name != "/custom/ffprobe" {
+			t.Errorf("probe command = %v, want /custom/ffprobe", runner.calls[0].name)
+		}
+		for _, call := range runner.calls[1:] {
+			if call.name != "ffmpeg" {
+				t.Errorf("encode command = %v, want ffmpeg", call.name)
+			}
+		}
+		if !strings.Contains(strings.Join(runner.calls[3].args, " "), "-c:v copy") {
+			t.Errorf("source rendition args = %v, want video copied", runner.calls[3].args)
+		}
+
+		if !p.HLSReady(source) {
+			t.Error("HLSReady() = false after generation")
+		}
+		for _, name := range []string{"360p", "720p", "source"} {
+			if _, err := os.Stat(filepath.Join(p.HLSDir(source), name)); err != nil {
+				t.Errorf("rendition directory %s missing: %v", name, err)
+			}
+		}
+	})
+
+	t.Run("returns runner error and keeps no output", func(t *testing.T) {
+		runner := &mockRunner{err: errors.New("ffprobe not found")}
+		p := newTestProcessor(t, WithCommandRunner(runner))
+
+		if err := p.GenerateHLS(context.Background(), source); err == nil {
+			t.Error("GenerateHLS() expected error")
+		}
+		if p.HLSReady(source) {
+			t.Error("HLSReady() = true after failed generation")
+		}
+	})
+}
+
+// countingRunner answers every command like mockRunner and records how many
+// run at once
+type countingRunner struct {
+	output []byte
+
+	mu      sync.Mutex
+	running int
+	most    int
+	calls   int
+}
+
+func (c *countingRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	c.mu.Lock()
+	c.running++
+	c.calls++
+	c.most = max(c.most, c.running)
+	c.mu.Unlock()
+
+	time.Sleep(time.Millisecond)
+
+	c.mu.Lock()
+	c.running--
+	c.mu.Unlock()
+	return c.output, nil
+}
+
+func (c *countingRunner) Stream(ctx context.Context, w io.Writer, name string, args ...string) error {
+	output, err := c.Run(ctx, name, args...)
+	w.Write(output)
+	return err
+}
+
+func TestProcessorEnsureHLS(t *testing.T) {
+	dir := t.TempDir()
+	var sources []string
+	for _, name := range []string{"a.mp4", "b.mp4", "c.mp4"} {
+		source := filepath.Join(dir, name)
+		if err := os.WriteFile(source, []byte("video"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		sources = append(sources, source)
+	}
+
+	runner := &countingRunner{output: []byte(testProbeJSON)}
+	p := newTestProcessor(t, WithCommandRunner(runner))
+
+	for _, source := range sources {
+		if p.EnsureHLS(source) {
+			t.Fatalf("EnsureHLS(%s) = true before generation", source)
+		}
+		// Asking again doesn't queue the source twice
+		p.EnsureHLS(source)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for _, source := range sources {
+		for !p.EnsureHLS(source) {
+			if time.Now().After(deadline) {
+				t.Fatalf("HLS for %s not generated", source)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	runner.mu.Lock()
+	defer runner.mu.Unlock()
+	// One probe plus three renditions per source
+	if runner.calls != 12 {
+		t.Errorf("runner called %d times, want 12", runner.calls)
+	}
+	if runner.most != 1 {
+		t.Errorf("%d commands ran at once, want 1", runner.most)
+	}
+}
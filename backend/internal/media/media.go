@@ -0,0 +1,208 @@
+package media
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const dirPermission = 0755
+
+// CommandRunner executes commands and returns their output
+type CommandRunner interface {
+	Run(ctx context.Context, name string, args ...string) ([]byte, error)
+
+	// Stream executes a command, writing its output to w as it's produced
+	Stream(ctx context.Context, w io.Writer, name string, args ...string) error
+}
+
+// execRunner is the default CommandRunner using os/exec
+type execRunner struct{}
+
+func (r *execRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	output, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("command failed: %s", string(exitErr.Stderr))
+		}
+		return nil, fmt.Errorf("executing command: %w", err)
+	}
+	return output, nil
+}
+
+func (r *execRunner) Stream(ctx context.Context, w io.Writer, name string, args ...string) error {
+	var stderr strings.Builder
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return fmt.Errorf("command failed: %s", stderr.String())
+		}
+		return fmt.Errorf("executing command: %w", err)
+	}
+	return nil
+}
+
+// Processor derives data from downloaded media files using external tools
+// and caches the results under its cache directory
+type Processor struct {
+	cacheDir    string
+	ffmpegPath  string
+	ffprobePath string
+	fpcalcPath  string
+	runner      CommandRunner
+
+	// transcodeCacheBytes caps the transcode cache; least recently used
+	// entries are evicted beyond it
+	transcodeMu         sync.Mutex
+	transcodeCacheBytes int64
+
+	// waveformSlot limits how many waveforms are generated at once
+	waveformSlot chan struct{}
+
+	// hlsJobs tracks sources queued or underway for HLS generation; a
+	// single worker, running while hlsQueue has sources, takes them in turn
+	hlsMu      sync.Mutex
+	hlsJobs    map[string]bool
+	hlsQueue   []string
+	hlsRunning bool
+}
+
+// Option configures the Processor
+type Option func(*Processor)
+
+// WithCommandRunner sets a custom command runner (for testing)
+func WithCommandRunner(runner CommandRunner) Option {
+	return func(p *Processor) {
+		p.runner = runner
+	}
+}
+
+// WithFfmpegPath sets a custom path to the ffmpeg executable
+func WithFfmpegPath(path string) Option {
+	return func(p *Processor) {
+		p.ffmpegPath = path
+	}
+}
+
+// WithFfprobePath sets a custom path to the ffprobe executable
+func WithFfprobePath(path string) Option {
+	return func(p *Processor) {
+		p.ffprobePath = path
+	}
+}
+
+// WithFpcalcPath sets a custom path to the Chromaprint fpcalc executable
+func WithFpcalcPath(path string) Option {
+	return func(p *Processor) {
+		p.fpcalcPath = path
+	}
+}
+
+// WithTranscodeCacheSize sets the maximum total size of cached transcodes in bytes
+func WithTranscodeCacheSize(bytes int64) Option {
+	return func(p *Processor) {
+		p.transcodeCacheBytes = bytes
+	}
+}
+
+// New creates a new Processor that caches derived files under cacheDir
+func New(cacheDir string, opts ...Option) (*Processor, error) {
+	if err := os.MkdirAll(cacheDir, dirPermission); err != nil {
+		return nil, fmt.Errorf("creating cache directory: %w", err)
+	}
+
+	p := &Processor{
+		cacheDir:            cacheDir,
+		ffmpegPath:          "ffmpeg",
+		ffprobePath:         "ffprobe",
+		fpcalcPath:          "fpcalc",
+		runner:              &execRunner{},
+		transcodeCacheBytes: defaultTranscodeCacheBytes,
+		waveformSlot:        make(chan struct{}, maxWaveformJobs),
+		hlsJobs:             make(map[string]bool),
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p, nil
+}
+
+// RemoveCached deletes everything derived from a source file
+func (p *Processor) RemoveCached(source string) error {
+	paths := []string{
+		p.cachePath("waveforms", source, ".peaks"),
+		p.HLSDir(source),
+	}
+	transcodes, _ := filepath.Glob(p.cachePath("transcodes", source, "-*"))
+	paths = append(paths, transcodes...)
+
+	var errs []error
+	for _, path := range paths {
+		if err := os.RemoveAll(path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// cachePath returns where a file derived from source is cached: a file in
+// the kind subdirectory with the given extension, named after the source
+// file and a hash of its path. Sources sharing a name, such as a track and a
+// video of the same YouTube ID, get separate entries.
+func (p *Processor) cachePath(kind, source, ext string) string {
+	base := strings.TrimSuffix(filepath.Base(source), filepath.Ext(source))
+	sum := sha256.Sum256([]byte(filepath.Clean(source)))
+	return filepath.Join(p.cacheDir, kind, base+"-"+hex.EncodeToString(sum[:4])+ext)
+}
+
+// isFresh reports whether a cached file exists and is newer than its source
+func isFresh(cached, source string) bool {
+	cachedInfo, err := os.Stat(cached)
+	if err != nil {
+		return false
+	}
+	sourceInfo, err := os.Stat(source)
+	if err != nil {
+		return false
+	}
+	return !cachedInfo.ModTime().Before(sourceInfo.ModTime())
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into place,
+// so concurrent readers never see a partially written cache entry
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), dirPermission); err != nil {
+		return fmt.Errorf("creating cache subdirectory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("creating temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("closing temp file: %w", err)
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
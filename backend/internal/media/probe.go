@@ -0,0 +1,56 @@
+package media
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrNotMedia means a file isn't audio or video that ffprobe can read, or
+// lacks the streams its kind needs
+var ErrNotMedia = errors.New("not a playable media file")
+
+// streamsOutput is the JSON structure printed by ffprobe for stream types
+type streamsOutput struct {
+	Streams []struct {
+		CodecType string `json:"codec_type"`
+	} `json:"streams"`
+}
+
+// CheckMedia verifies with ffprobe that a file holds an audio stream and,
+// if video is set, a video stream too. Returns an error wrapping ErrNotMedia
+// for files that don't.
+func (p *Processor) CheckMedia(ctx context.Context, path string, video bool) error {
+	output, err := p.runner.Run(ctx, p.ffprobePath,
+		"-v", "error",
+		"-show_entries", "stream=codec_type",
+		"-of", "json",
+		path,
+	)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNotMedia, err)
+	}
+
+	return checkStreams(output, video)
+}
+
+func checkStreams(data []byte, video bool) error {
+	var out streamsOutput
+	if err := json.Unmarshal(data, &out); err != nil {
+		return fmt.Errorf("parsing ffprobe JSON: %w", err)
+	}
+
+	types := make(map[string]bool)
+	for _, stream := range out.Streams {
+		types[stream.CodecType] = true
+	}
+
+	if !types["audio"] {
+		return fmt.Errorf("%w: no audio stream", ErrNotMedia)
+	}
+	if video && !types["video"] {
+		return fmt.Errorf("%w: no video stream", ErrNotMedia)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCheckStreams(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		video   bool
+		wantErr bool
+	}{
+		{"audio file", `{"streams": [{"codec_type": "audio"}]}`, false, false},
+		{"audio with cover art", `{"streams": [{"codec_type": "audio"}, {"codec_type": "video"}]}`, false, false},
+		{"video file", `{"streams": [{"codec_type": "video"}, {"codec_type": "audio"}]}`, true, false},
+		{"video without audio", `{"streams": [{"codec_type": "video"}]}`, true, true},
+		{"audio expected as video", `{"streams": [{"codec_type": "audio"}]}`, true, true},
+		{"no streams", `{"streams": []}`, false, true},
+		{"invalid JSON", `not json`, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkStreams([]byte(tt.input), tt.video)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkStreams() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProcessorCheckMedia(t *testing.T) {
+	t.Run("probes the file", func(t *testing.T) {
+		runner := &mockRunner{output: []byte(`{"streams": [{"codec_type": "audio"}]}`)}
+		p := newTestProcessor(t, WithCommandRunner(runner), WithFfprobePath("/custom/ffprobe"))
+
+		if err := p.CheckMedia(context.Background(), "/media/song.m4a", false); err != nil {
+			t.Fatalf("CheckMedia() error = %v", err)
+		}
+		if len(runner.calls) != 1 || runner.calls[0].name != "/custom/ffprobe" {
+			t.Fatalf("calls = %+v, want one ffprobe call", runner.calls)
+		}
+		args := runner.calls[0].args
+		if args[len(args)-1] != "/media/song.m4a" {
+			t.Errorf("probed %v, want /media/song.m4a", args[len(args)-1])
+		}
+	})
+
+	t.Run("unreadable file is not media", func(t *testing.T) {
+		runner := &mockRunner{err: errors.New("exit status 1")}
+		p := newTestProcessor(t, WithCommandRunner(runner))
+
+		err := p.CheckMedia(context.Background(), "/media/junk.m4a", false)
+		if !errors.Is(err, ErrNotMedia) {
+			t.Errorf("CheckMedia() error = %v, want ErrNotMedia", err)
+		}
+	})
+}
@@ -0,0 +1,212 @@
+package media
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultTranscodeCacheBytes is the transcode cache limit when none is configured
+	defaultTranscodeCacheBytes = 1 << 30
+
+	// MinTranscodeBitrate and MaxTranscodeBitrate bound requested bitrates in kbps
+	MinTranscodeBitrate = 32
+	MaxTranscodeBitrate = 320
+)
+
+// TranscodeFormat is an audio format tracks can be transcoded to. Each uses a
+// container that can be written to a pipe, so output streams as it's produced.
+type TranscodeFormat struct {
+	Name           string
+	ContentType    string
+	Ext            string
+	codec          string
+	muxer          string
+	defaultBitrate int
+}
+
+var transcodeFormats = map[string]TranscodeFormat{
+	"mp3": {
+		Name:           "mp3",
+		ContentType:    "audio/mpeg",
+		Ext:            ".mp3",
+		codec:          "libmp3lame",
+		muxer:          "mp3",
+		defaultBitrate: 192,
+	},
+	"opus": {
+		Name:           "opus",
+		ContentType:    "audio/ogg",
+		Ext:            ".opus",
+		codec:          "libopus",
+		muxer:          "ogg",
+		defaultBitrate: 128,
+	},
+	"aac": {
+		Name:           "aac",
+		ContentType:    "audio/aac",
+		Ext:            ".aac",
+		codec:          "aac",
+		muxer:          "adts",
+		defaultBitrate: 160,
+	},
+}
+
+// LookupTranscodeFormat returns the transcode format with the given name
+func LookupTranscodeFormat(name string) (TranscodeFormat, bool) {
+	format, ok := transcodeFormats[strings.ToLower(name)]
+	return format, ok
+}
+
+// TranscodeBitrate returns the bitrate in kbps to encode at: the format's
+// default, lowered to maxBitrate if given, and clamped to the supported range
+func (f TranscodeFormat) TranscodeBitrate(maxBitrate int) int {
+	bitrate := f.defaultBitrate
+	if maxBitrate > 0 && maxBitrate < bitrate {
+		bitrate = maxBitrate
+	}
+	return min(max(bitrate, MinTranscodeBitrate), MaxTranscodeBitrate)
+}
+
+// CachedTranscode returns the path of a cached transcode of source, if one
+// exists. A hit marks the entry as recently used.
+func (p *Processor) CachedTranscode(source string, format TranscodeFormat, bitrate int) (string, bool) {
+	path, err := p.transcodePath(source, format, bitrate)
+	if err != nil {
+		return "", false
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		return "", false
+	}
+
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		log.Printf("Failed to touch cached transcode %s: %v", path, err)
+	}
+
+	return path, true
+}
+
+// Transcode encodes source to the given format and bitrate, writing the
+// output to w as ffmpeg produces it. A complete transcode is also saved to
+// the cache, after which the least recently used entries are evicted to keep
+// the cache within its size limit.
+func (p *Processor) Transcode(ctx context.Context, w io.Writer, source string, format TranscodeFormat, bitrate int) error {
+	path, err := p.transcodePath(source, format, bitrate)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, dirPermission); err != nil {
+		return fmt.Errorf("creating cache subdirectory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("creating temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	args := []string{
+		"-v", "error",
+		"-i", source,
+		"-vn",
+		"-map_metadata", "-1",
+		"-c:a", format.codec,
+		"-b:a", fmt.Sprintf("%dk", bitrate),
+		"-f", format.muxer,
+		"-",
+	}
+
+	if err := p.runner.Stream(ctx, io.MultiWriter(w, tmp), p.ffmpegPath, args...); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("closing temp file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("caching transcode: %w", err)
+	}
+
+	p.evictTranscodes()
+	return nil
+}
+
+// transcodePath returns the cache path for a transcode. The key covers the
+// source's modification time, so re-downloading a file invalidates its entries.
+func (p *Processor) transcodePath(source string, format TranscodeFormat, bitrate int) (string, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return "", fmt.Errorf("reading source file: %w", err)
+	}
+
+	key := fmt.Sprintf("%s|%d|%s|%d", source, info.ModTime().UnixNano(), format.Name, bitrate)
+	sum := sha256.Sum256([]byte(key))
+	return p.cachePath("transcodes", source, "-"+hex.EncodeToString(sum[:8])+format.Ext), nil
+}
+
+// evictTranscodes deletes the least recently used transcodes until the cache
+// fits within its size limit
+func (p *Processor) evictTranscodes() {
+	p.transcodeMu.Lock()
+	defer p.transcodeMu.Unlock()
+
+	dir := filepath.Join(p.cacheDir, "transcodes")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Printf("Failed to read transcode cache: %v", err)
+		return
+	}
+
+	type cacheEntry struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+
+	var files []cacheEntry
+	var total int64
+	for _, entry := range entries {
+		// Skip in-progress transcodes
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".tmp-") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, cacheEntry{
+			path:    filepath.Join(dir, entry.Name()),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+		total += info.Size()
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+
+	for _, file := range files {
+		if total <= p.transcodeCacheBytes {
+			break
+		}
+		if err := os.Remove(file.path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to evict cached transcode %s: %v", file.path, err)
+			continue
+		}
+		total -= file.size
+	}
+}
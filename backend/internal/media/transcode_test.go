@@ -0,0 +1,180 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTranscodeBitrate(t *testing.T) {
+	mp3, _ := LookupTranscodeFormat("mp3")
+
+	tests := []struct {
+		name       string
+		maxBitrate int
+		want       int
+	}{
+		{"no limit uses default", 0, 192},
+		{"lower limit", 96, 96},
+		{"higher limit keeps default", 256, 192},
+		{"clamped to minimum", 8, MinTranscodeBitrate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mp3.TranscodeBitrate(tt.maxBitrate); got != tt.want {
+				t.Errorf("TranscodeBitrate(%d) = %d, want %d", tt.maxBitrate, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookupTranscodeFormat(t *testing.T) {
+	for _, name := range []string{"mp3", "opus", "aac", "MP3"} {
+		if _, ok := LookupTranscodeFormat(name); !ok {
+			t.Errorf("LookupTranscodeFormat(%q) not found", name)
+		}
+	}
+	if _, ok := LookupTranscodeFormat("flac"); ok {
+		t.Error("LookupTranscodeFormat(\"flac\") found, want unsupported")
+	}
+}
+
+func writeSource(t *testing.T, name string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte("audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestProcessorTranscode(t *testing.T) {
+	opus, _ := LookupTranscodeFormat("opus")
+
+	t.Run("streams output and caches it", func(t *testing.T) {
+		source := writeSource(t, "song.m4a")
+		runner := &mockRunner{output: []byte("encoded")}
+		p := newTestProcessor(t, WithCommandRunner(runner))
+
+		if _, ok := p.CachedTranscode(source, opus, 96); ok {
+			t.Fatal("CachedTranscode() hit before transcoding")
+		}
+
+		var buf bytes.Buffer
+		if err := p.Transcode(context.Background(), &buf, source, opus, 96); err != nil {
+			t.Fatalf("Transcode() error = %v", err)
+		}
+		if buf.String() != "encoded" {
+			t.Errorf("Transcode() wrote %q, want %q", buf.String(), "encoded")
+		}
+
+		cached, ok := p.CachedTranscode(source, opus, 96)
+		if !ok {
+			t.Fatal("CachedTranscode() missed after transcoding")
+		}
+		data, err := os.ReadFile(cached)
+		if err != nil || string(data) != "encoded" {
+			t.Errorf("cached transcode = %q, %v, want %q", data, err, "encoded")
+		}
+
+		if _, ok := p.CachedTranscode(source, opus, 128); ok {
+			t.Error("CachedTranscode() hit for a different bitrate")
+		}
+
+		if err := p.RemoveCached(source); err != nil {
+			t.Fatalf("RemoveCached() error = %v", err)
+		}
+		if _, ok := p.CachedTranscode(source, opus, 96); ok {
+			t.Error("CachedTranscode() hit after RemoveCached()")
+		}
+	})
+
+	t.Run("keeps sources sharing a name apart", func(t *testing.T) {
+		dir := t.TempDir()
+		var sources []string
+		for _, sub := range []string{"audio", "video"} {
+			os.MkdirAll(filepath.Join(dir, sub), 0755)
+			source := filepath.Join(dir, sub, "abc123.m4a")
+			if err := os.WriteFile(source, []byte(sub), 0644); err != nil {
+				t.Fatal(err)
+			}
+			sources = append(sources, source)
+		}
+
+		runner := &mockRunner{output: []byte("encoded")}
+		p := newTestProcessor(t, WithCommandRunner(runner))
+		for _, source := range sources {
+			if err := p.Transcode(context.Background(), &bytes.Buffer{}, source, opus, 96); err != nil {
+				t.Fatalf("Transcode() error = %v", err)
+			}
+		}
+		if p.HLSDir(sources[0]) == p.HLSDir(sources[1]) {
+			t.Errorf("HLSDir() = %q for both sources", p.HLSDir(sources[0]))
+		}
+
+		if err := p.RemoveCached(sources[0]); err != nil {
+			t.Fatalf("RemoveCached() error = %v", err)
+		}
+		if _, ok := p.CachedTranscode(sources[0], opus, 96); ok {
+			t.Error("CachedTranscode() hit for the removed source")
+		}
+		if _, ok := p.CachedTranscode(sources[1], opus, 96); !ok {
+			t.Error("RemoveCached() removed the other source's transcode")
+		}
+	})
+
+	t.Run("does not cache failed transcodes", func(t *testing.T) {
+		source := writeSource(t, "song.m4a")
+		runner := &mockRunner{err: errors.New("ffmpeg failed")}
+		p := newTestProcessor(t, WithCommandRunner(runner))
+
+		var buf bytes.Buffer
+		if err := p.Transcode(context.Background(), &buf, source, opus, 96); err == nil {
+			t.Fatal("Transcode() expected error")
+		}
+		if _, ok := p.CachedTranscode(source, opus, 96); ok {
+			t.Error("CachedTranscode() hit after failed transcode")
+		}
+	})
+
+	t.Run("evicts least recently used entries", func(t *testing.T) {
+		runner := &mockRunner{output: bytes.Repeat([]byte("x"), 100)}
+		p := newTestProcessor(t, WithCommandRunner(runner), WithTranscodeCacheSize(250))
+
+		sources := []string{writeSource(t, "a.m4a"), writeSource(t, "b.m4a")}
+		for _, source := range sources {
+			if err := p.Transcode(context.Background(), &bytes.Buffer{}, source, opus, 96); err != nil {
+				t.Fatalf("Transcode() error = %v", err)
+			}
+		}
+
+		// Age both entries, then use a so b becomes the least recently used
+		past := time.Now().Add(-time.Hour)
+		for _, source := range sources {
+			path, _ := p.transcodePath(source, opus, 96)
+			os.Chtimes(path, past, past)
+		}
+		if _, ok := p.CachedTranscode(sources[0], opus, 96); !ok {
+			t.Fatal("CachedTranscode() missed for a")
+		}
+
+		third := writeSource(t, "c.m4a")
+		if err := p.Transcode(context.Background(), &bytes.Buffer{}, third, opus, 96); err != nil {
+			t.Fatalf("Transcode() error = %v", err)
+		}
+
+		if _, ok := p.CachedTranscode(sources[1], opus, 96); ok {
+			t.Error("least recently used entry was not evicted")
+		}
+		for _, source := range []string{sources[0], third} {
+			if _, ok := p.CachedTranscode(source, opus, 96); !ok {
+				t.Errorf("entry for %s was evicted", filepath.Base(source))
+			}
+		}
+	})
+}
@@ -0,0 +1,161 @@
+package media
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+const (
+	// WaveformBuckets is the number of peaks in every generated waveform
+	WaveformBuckets = 1000
+
+	// waveformSampleRate is the rate audio is decoded at; peaks need far less
+	// detail than playback does
+	waveformSampleRate = 8000
+
+	// maxWaveformJobs is how many waveforms can be generated at once
+	maxWaveformJobs = 2
+)
+
+// Waveform returns the peak amplitude of each of WaveformBuckets equal slices
+// of an audio file, scaled so the loudest peak is 255. Results are cached on
+// disk and regenerated if the source file changes.
+func (p *Processor) Waveform(ctx context.Context, filePath string) ([]byte, error) {
+	if filePath == "" {
+		return nil, errors.New("filePath is required")
+	}
+
+	cached := p.cachePath("waveforms", filePath, ".peaks")
+	if isFresh(cached, filePath) {
+		peaks, err := os.ReadFile(cached)
+		if err == nil && len(peaks) == WaveformBuckets {
+			return peaks, nil
+		}
+	}
+
+	// Only a few decodes run at once; each saturates a CPU
+	select {
+	case p.waveformSlot <- struct{}{}:
+		defer func() { <-p.waveformSlot }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	// Decode to mono 16-bit PCM on stdout, folding it into peaks as it comes
+	var peaks peakWriter
+	err := p.runner.Stream(ctx, &peaks, p.ffmpegPath,
+		"-v", "error",
+		"-i", filePath,
+		"-vn",
+		"-ac", "1",
+		"-ar", strconv.Itoa(waveformSampleRate),
+		"-f", "s16le",
+		"-",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	result := peaks.Peaks(WaveformBuckets)
+
+	if err := writeFileAtomic(cached, result); err != nil {
+		return nil, fmt.Errorf("caching waveform: %w", err)
+	}
+
+	return result, nil
+}
+
+// peakWriter takes little-endian 16-bit PCM and keeps the absolute peak of
+// each block of samples. When it holds maxPeakBlocks blocks, neighbouring
+// blocks are merged and the block size doubles, so memory stays the same
+// however long the audio is.
+type peakWriter struct {
+	blocks    []int
+	blockSize int // samples per block; zero until the first sample
+	filled    int // samples in the last block
+	loudest   int
+
+	// odd holds the first byte of a sample split across writes
+	odd    byte
+	hasOdd bool
+}
+
+// maxPeakBlocks bounds the blocks a peakWriter holds. With at least eight
+// blocks to a bucket, merging moves bucket edges by under an eighth of one.
+const maxPeakBlocks = 16 * WaveformBuckets
+
+func (w *peakWriter) Write(data []byte) (int, error) {
+	n := len(data)
+	if w.hasOdd && len(data) > 0 {
+		w.add(int(int16(uint16(w.odd) | uint16(data[0])<<8)))
+		data = data[1:]
+		w.hasOdd = false
+	}
+	for len(data) >= 2 {
+		w.add(int(int16(binary.LittleEndian.Uint16(data))))
+		data = data[2:]
+	}
+	if len(data) == 1 {
+		w.odd, w.hasOdd = data[0], true
+	}
+	return n, nil
+}
+
+func (w *peakWriter) add(sample int) {
+	if sample < 0 {
+		sample = -sample
+	}
+	w.loudest = max(w.loudest, sample)
+
+	if w.filled == 0 {
+		if w.blockSize == 0 {
+			w.blockSize = 1
+		}
+		if len(w.blocks) == maxPeakBlocks {
+			for i := 0; i < len(w.blocks)/2; i++ {
+				w.blocks[i] = max(w.blocks[2*i], w.blocks[2*i+1])
+			}
+			w.blocks = w.blocks[:len(w.blocks)/2]
+			w.blockSize *= 2
+		}
+		w.blocks = append(w.blocks, 0)
+	}
+
+	last := len(w.blocks) - 1
+	w.blocks[last] = max(w.blocks[last], sample)
+	w.filled++
+	if w.filled == w.blockSize {
+		w.filled = 0
+	}
+}
+
+// Peaks splits the blocks seen so far into buckets and returns the peak of
+// each, normalized so the loudest bucket is 255
+func (w *peakWriter) Peaks(buckets int) []byte {
+	raw := make([]int, buckets)
+	for i, peak := range w.blocks {
+		bucket := i * buckets / len(w.blocks)
+		raw[bucket] = max(raw[bucket], peak)
+	}
+
+	peaks := make([]byte, buckets)
+	if w.loudest == 0 {
+		return peaks
+	}
+	for i, peak := range raw {
+		peaks[i] = byte(peak * 255 / w.loudest)
+	}
+	return peaks
+}
+
+// computePeaks splits little-endian 16-bit PCM into buckets and returns the
+// absolute peak of each, normalized so the loudest bucket is 255
+func computePeaks(pcm []byte, buckets int) []byte {
+	var w peakWriter
+	w.Write(pcm)
+	return w.Peaks(buckets)
+}
@@ -0,0 +1,156 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// pcm encodes samples as little-endian 16-bit PCM
+func pcm(samples ...int16) []byte {
+	buf := make([]byte, 2*len(samples))
+	for i, s := range samples {
+		binary.LittleEndian.PutUint16(buf[2*i:], uint16(s))
+	}
+	return buf
+}
+
+func TestComputePeaks(t *testing.T) {
+	t.Run("normalizes to loudest peak", func(t *testing.T) {
+		peaks := computePeaks(pcm(100, -200, 50, 25, -1000, 500, 0, 0), 4)
+		want := []byte{51, 12, 255, 0}
+		for i := range want {
+			if peaks[i] != want[i] {
+				t.Errorf("peaks = %v, want %v", peaks, want)
+				break
+			}
+		}
+	})
+
+	t.Run("handles most negative sample", func(t *testing.T) {
+		peaks := computePeaks(pcm(-32768, 16384), 2)
+		if peaks[0] != 255 || peaks[1] != 127 {
+			t.Errorf("peaks = %v, want [255 127]", peaks)
+		}
+	})
+
+	t.Run("fewer samples than buckets", func(t *testing.T) {
+		peaks := computePeaks(pcm(1000), 4)
+		if len(peaks) != 4 || peaks[0] != 255 {
+			t.Errorf("peaks = %v, want 255 followed by zeros", peaks)
+		}
+	})
+
+	t.Run("silence", func(t *testing.T) {
+		peaks := computePeaks(pcm(0, 0, 0), 3)
+		for _, p := range peaks {
+			if p != 0 {
+				t.Errorf("peaks = %v, want all zeros", peaks)
+				break
+			}
+		}
+	})
+
+	t.Run("empty input", func(t *testing.T) {
+		if peaks := computePeaks(nil, 10); len(peaks) != 10 {
+			t.Errorf("len(peaks) = %d, want 10", len(peaks))
+		}
+	})
+}
+
+func TestPeakWriter(t *testing.T) {
+	t.Run("samples split across writes", func(t *testing.T) {
+		data := pcm(100, -200, 50, 25, -1000, 500, 0, 0)
+		var w peakWriter
+		for _, b := range data {
+			w.Write([]byte{b})
+		}
+		if got, want := w.Peaks(4), computePeaks(data, 4); !bytes.Equal(got, want) {
+			t.Errorf("Peaks() = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("long audio stays bounded", func(t *testing.T) {
+		// Two hours at the decode rate, silent but for one spike in the
+		// middle of the bucket three quarters of the way in
+		samples := 2 * 60 * 60 * waveformSampleRate
+		spike := samples*3/4 + samples/WaveformBuckets/2
+		var w peakWriter
+		chunk := make([]byte, 2*waveformSampleRate)
+		for written := 0; written < samples; written += waveformSampleRate {
+			clear(chunk)
+			if spike >= written && spike < written+waveformSampleRate {
+				binary.LittleEndian.PutUint16(chunk[2*(spike-written):], uint16(20000))
+			}
+			w.Write(chunk)
+		}
+
+		if len(w.blocks) > maxPeakBlocks {
+			t.Errorf("holding %d blocks, want at most %d", len(w.blocks), maxPeakBlocks)
+		}
+		peaks := w.Peaks(WaveformBuckets)
+		for i, peak := range peaks {
+			want := byte(0)
+			if i == WaveformBuckets*3/4 {
+				want = 255
+			}
+			if peak != want {
+				t.Errorf("peaks[%d] = %d, want %d", i, peak, want)
+			}
+		}
+	})
+}
+
+func TestProcessorWaveform(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "abc123.m4a")
+	if err := os.WriteFile(source, []byte("audio"), 0644); err != nil {
+		t.Fatalf("writing source: %v", err)
+	}
+
+	runner := &mockRunner{output: pcm(100, 200, 300, 400)}
+	p := newTestProcessor(t, WithCommandRunner(runner), WithFfmpegPath("/custom/ffmpeg"))
+
+	t.Run("generates and caches", func(t *testing.T) {
+		peaks, err := p.Waveform(context.Background(), source)
+		if err != nil {
+			t.Fatalf("Waveform() error = %v", err)
+		}
+		if len(peaks) != WaveformBuckets {
+			t.Errorf("len(peaks) = %d, want %d", len(peaks), WaveformBuckets)
+		}
+		if len(runner.calls) != 1 || runner.calls[0].name != "/custom/ffmpeg" {
+			t.Fatalf("expected one ffmpeg call, got %v", runner.calls)
+		}
+
+		cached := p.cachePath("waveforms", source, ".peaks")
+		if _, err := os.Stat(cached); err != nil {
+			t.Errorf("waveform not cached: %v", err)
+		}
+	})
+
+	t.Run("serves from cache", func(t *testing.T) {
+		if _, err := p.Waveform(context.Background(), source); err != nil {
+			t.Fatalf("Waveform() error = %v", err)
+		}
+		if len(runner.calls) != 1 {
+			t.Errorf("expected cached result, ffmpeg called %d times", len(runner.calls))
+		}
+	})
+
+	t.Run("regenerates when source changes", func(t *testing.T) {
+		future := time.Now().Add(time.Hour)
+		if err := os.Chtimes(source, future, future); err != nil {
+			t.Fatalf("touching source: %v", err)
+		}
+		if _, err := p.Waveform(context.Background(), source); err != nil {
+			t.Fatalf("Waveform() error = %v", err)
+		}
+		if len(runner.calls) != 2 {
+			t.Errorf("expected regeneration, ffmpeg called %d times", len(runner.calls))
+		}
+	})
+}
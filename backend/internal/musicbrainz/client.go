@@ -0,0 +1,173 @@
+package musicbrainz
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const userAgent = "Dovora/1.0 ( https://github.com/wpinrui/dovora2 )"
+
+// ErrNotFound is returned when MusicBrainz has no entity with the requested ID
+var ErrNotFound = errors.New("not found")
+
+// Client queries a MusicBrainz-compatible web service
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	limiter    *rate.Limiter
+}
+
+// NewClient creates a client for the MusicBrainz API at baseURL.
+// Requests are throttled to one per second, as MusicBrainz requires.
+func NewClient(baseURL string) *Client {
+	return &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+		limiter: rate.NewLimiter(rate.Every(time.Second), 1),
+	}
+}
+
+type recordingSearchResponse struct {
+	Recordings []Recording `json:"recordings"`
+}
+
+// Recording is a MusicBrainz recording with its credited artists and releases
+type Recording struct {
+	ID           string         `json:"id"`
+	Title        string         `json:"title"`
+	LengthMillis int            `json:"length"`
+	ArtistCredit []ArtistCredit `json:"artist-credit"`
+	Releases     []Release      `json:"releases"`
+}
+
+type ArtistCredit struct {
+	Name       string `json:"name"`
+	JoinPhrase string `json:"joinphrase"`
+	Artist     Artist `json:"artist"`
+}
+
+type Artist struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type Release struct {
+	ID           string       `json:"id"`
+	Title        string       `json:"title"`
+	Date         string       `json:"date"`
+	Status       string       `json:"status"`
+	ReleaseGroup ReleaseGroup `json:"release-group"`
+}
+
+type ReleaseGroup struct {
+	ID          string `json:"id"`
+	PrimaryType string `json:"primary-type"`
+}
+
+// ArtistName joins the credited artists the way MusicBrainz displays them
+func (r *Recording) ArtistName() string {
+	var b strings.Builder
+	for _, credit := range r.ArtistCredit {
+		b.WriteString(credit.Name)
+		b.WriteString(credit.JoinPhrase)
+	}
+	return b.String()
+}
+
+// PrimaryArtistID returns the MBID of the first credited artist
+func (r *Recording) PrimaryArtistID() string {
+	if len(r.ArtistCredit) == 0 {
+		return ""
+	}
+	return r.ArtistCredit[0].Artist.ID
+}
+
+// DurationSeconds returns the recording length rounded to whole seconds
+func (r *Recording) DurationSeconds() int {
+	return (r.LengthMillis + 500) / 1000
+}
+
+// SearchRecordings searches for recordings matching a title and optional artist
+func (c *Client) SearchRecordings(ctx context.Context, title, artist string, limit int) ([]Recording, error) {
+	query := fmt.Sprintf(`recording:"%s"`, escapeLucene(title))
+	if artist != "" {
+		query += fmt.Sprintf(` AND artist:"%s"`, escapeLucene(artist))
+	}
+
+	endpoint := fmt.Sprintf("%s/ws/2/recording?query=%s&limit=%d&fmt=json",
+		c.baseURL,
+		url.QueryEscape(query),
+		limit,
+	)
+
+	var resp recordingSearchResponse
+	if err := c.get(ctx, endpoint, &resp); err != nil {
+		return nil, err
+	}
+
+	return resp.Recordings, nil
+}
+
+// GetRecording looks up a single recording with its artists and releases
+func (c *Client) GetRecording(ctx context.Context, recordingID string) (*Recording, error) {
+	endpoint := fmt.Sprintf("%s/ws/2/recording/%s?inc=artists+releases+release-groups&fmt=json",
+		c.baseURL,
+		url.PathEscape(recordingID),
+	)
+
+	var recording Recording
+	if err := c.get(ctx, endpoint, &recording); err != nil {
+		return nil, err
+	}
+
+	return &recording, nil
+}
+
+func (c *Client) get(ctx context.Context, endpoint string, target any) error {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return fmt.Errorf("waiting for rate limiter: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("executing request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("musicbrainz returned status %d", resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(target); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+
+	return nil
+}
+
+// escapeLucene escapes characters that would break a quoted Lucene phrase
+func escapeLucene(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return replacer.Replace(s)
+}
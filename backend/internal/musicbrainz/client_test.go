@@ -0,0 +1,162 @@
+package musicbrainz
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const searchFixture = `{
+	"recordings": [
+		{
+			"id": "rec-live",
+			"title": "Bohemian Rhapsody (live)",
+			"length": 345000,
+			"artist-credit": [{"name": "Queen", "joinphrase": "", "artist": {"id": "artist-queen", "name": "Queen"}}],
+			"releases": []
+		},
+		{
+			"id": "rec-studio",
+			"title": "Bohemian Rhapsody",
+			"length": 354000,
+			"artist-credit": [{"name": "Queen", "joinphrase": "", "artist": {"id": "artist-queen", "name": "Queen"}}],
+			"releases": [
+				{"id": "rel-single", "title": "Bohemian Rhapsody", "date": "1975-10-31", "status": "Official", "release-group": {"primary-type": "Single"}},
+				{"id": "rel-album", "title": "A Night at the Opera", "date": "1975-11-21", "status": "Official", "release-group": {"primary-type": "Album"}}
+			]
+		}
+	]
+}`
+
+func TestSearchRecordings(t *testing.T) {
+	var gotQuery, gotUserAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ws/2/recording" {
+			t.Errorf("path = %v, want /ws/2/recording", r.URL.Path)
+		}
+		gotQuery = r.URL.Query().Get("query")
+		gotUserAgent = r.Header.Get("User-Agent")
+		w.Write([]byte(searchFixture))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL + "/")
+	recordings, err := client.SearchRecordings(context.Background(), `Say "Hi"`, "Queen", 5)
+	if err != nil {
+		t.Fatalf("SearchRecordings() error = %v", err)
+	}
+
+	if len(recordings) != 2 {
+		t.Fatalf("len(recordings) = %d, want 2", len(recordings))
+	}
+
+	wantQuery := `recording:"Say \"Hi\"" AND artist:"Queen"`
+	if gotQuery != wantQuery {
+		t.Errorf("query = %v, want %v", gotQuery, wantQuery)
+	}
+	if gotUserAgent != userAgent {
+		t.Errorf("User-Agent = %v, want %v", gotUserAgent, userAgent)
+	}
+}
+
+func TestGetRecording(t *testing.T) {
+	t.Run("returns recording", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/ws/2/recording/rec-studio" {
+				t.Errorf("path = %v, want /ws/2/recording/rec-studio", r.URL.Path)
+			}
+			w.Write([]byte(`{"id": "rec-studio", "title": "Bohemian Rhapsody", "length": 354000}`))
+		}))
+		defer server.Close()
+
+		recording, err := NewClient(server.URL).GetRecording(context.Background(), "rec-studio")
+		if err != nil {
+			t.Fatalf("GetRecording() error = %v", err)
+		}
+		if recording.DurationSeconds() != 354 {
+			t.Errorf("DurationSeconds() = %d, want 354", recording.DurationSeconds())
+		}
+	})
+
+	t.Run("maps 404 to ErrNotFound", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer server.Close()
+
+		_, err := NewClient(server.URL).GetRecording(context.Background(), "missing")
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetRecording() error = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestRank(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(searchFixture))
+	}))
+	defer server.Close()
+
+	recordings, err := NewClient(server.URL).SearchRecordings(context.Background(), "Bohemian Rhapsody", "Queen", 5)
+	if err != nil {
+		t.Fatalf("SearchRecordings() error = %v", err)
+	}
+
+	candidates := Rank(recordings, "Queen - Bohemian Rhapsody (Official Video)", "Queen", 355)
+	if candidates[0].Recording.ID != "rec-studio" {
+		t.Errorf("best candidate = %v, want rec-studio", candidates[0].Recording.ID)
+	}
+	if candidates[0].Score <= candidates[1].Score {
+		t.Errorf("candidates not sorted by score: %v <= %v", candidates[0].Score, candidates[1].Score)
+	}
+	if candidates[0].Release == nil || candidates[0].Release.ID != "rel-album" {
+		t.Errorf("best release = %v, want rel-album", candidates[0].Release)
+	}
+	if candidates[1].Release != nil {
+		t.Errorf("release for recording without releases = %v, want nil", candidates[1].Release)
+	}
+}
+
+func TestScore(t *testing.T) {
+	recording := &Recording{
+		Title:        "Bohemian Rhapsody",
+		LengthMillis: 354000,
+		ArtistCredit: []ArtistCredit{{Name: "Queen"}},
+	}
+
+	t.Run("perfect match", func(t *testing.T) {
+		if got := Score(recording, "Bohemian Rhapsody", "Queen", 354); got != 1 {
+			t.Errorf("Score() = %v, want 1", got)
+		}
+	})
+
+	t.Run("unknown duration is not penalised", func(t *testing.T) {
+		if got := Score(recording, "Bohemian Rhapsody", "Queen", 0); got != 1 {
+			t.Errorf("Score() = %v, want 1", got)
+		}
+	})
+
+	t.Run("duration mismatch lowers score", func(t *testing.T) {
+		if got := Score(recording, "Bohemian Rhapsody", "Queen", 200); got >= 1 {
+			t.Errorf("Score() = %v, want < 1", got)
+		}
+	})
+}
+
+func TestBestRelease(t *testing.T) {
+	releases := []Release{
+		{ID: "bootleg", Date: "1970", Status: "Bootleg", ReleaseGroup: ReleaseGroup{PrimaryType: "Album"}},
+		{ID: "compilation-late", Date: "1990", Status: "Official", ReleaseGroup: ReleaseGroup{PrimaryType: "Album"}},
+		{ID: "album-undated", Status: "Official", ReleaseGroup: ReleaseGroup{PrimaryType: "Album"}},
+		{ID: "album-early", Date: "1975", Status: "Official", ReleaseGroup: ReleaseGroup{PrimaryType: "Album"}},
+	}
+
+	if got := BestRelease(releases); got.ID != "album-early" {
+		t.Errorf("BestRelease() = %v, want album-early", got.ID)
+	}
+	if got := BestRelease(nil); got != nil {
+		t.Errorf("BestRelease(nil) = %v, want nil", got)
+	}
+}
@@ -0,0 +1,82 @@
+package musicbrainz
+
+import (
+	"sort"
+
+	"github.com/wpinrui/dovora2/backend/internal/textmatch"
+)
+
+// Candidate is a scored recording together with the release it would be filed under
+type Candidate struct {
+	Recording Recording
+	Release   *Release
+	Score     float64
+}
+
+// Score rates how well a recording matches a track's title, artist and
+// duration, between 0 and 1
+func Score(recording *Recording, title, artist string, durationSeconds int) float64 {
+	return textmatch.TrackScore(
+		textmatch.Track{
+			Title:           recording.Title,
+			Artist:          recording.ArtistName(),
+			DurationSeconds: recording.DurationSeconds(),
+		},
+		textmatch.Track{Title: title, Artist: artist, DurationSeconds: durationSeconds},
+	)
+}
+
+// Rank scores recordings against a track and returns them best first
+func Rank(recordings []Recording, title, artist string, durationSeconds int) []Candidate {
+	candidates := make([]Candidate, 0, len(recordings))
+	for i := range recordings {
+		candidates = append(candidates, Candidate{
+			Recording: recordings[i],
+			Release:   BestRelease(recordings[i].Releases),
+			Score:     Score(&recordings[i], title, artist, durationSeconds),
+		})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score > candidates[j].Score
+	})
+
+	return candidates
+}
+
+// BestRelease picks the release a recording is most likely known by:
+// official releases first, then albums over singles and compilations,
+// then the earliest date.
+func BestRelease(releases []Release) *Release {
+	var best *Release
+	for i := range releases {
+		r := &releases[i]
+		if best == nil || releaseRank(r) < releaseRank(best) ||
+			(releaseRank(r) == releaseRank(best) && earlierDate(r.Date, best.Date)) {
+			best = r
+		}
+	}
+	return best
+}
+
+func releaseRank(r *Release) int {
+	rank := 0
+	if r.Status != "Official" {
+		rank += 2
+	}
+	if r.ReleaseGroup.PrimaryType != "Album" {
+		rank++
+	}
+	return rank
+}
+
+// earlierDate reports whether date a precedes b, treating missing dates as latest
+func earlierDate(a, b string) bool {
+	if a == "" {
+		return false
+	}
+	if b == "" {
+		return true
+	}
+	return a < b
+}
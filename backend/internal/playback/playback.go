@@ -0,0 +1,405 @@
+// Package playback keeps each user's playback queue in sync across their
+// connected devices and relays remote control commands between them
+package playback
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Kinds of event sent to devices
+const (
+	// EventState carries the user's current playback state
+	EventState = "state"
+
+	// EventDevices lists the user's connected devices
+	EventDevices = "devices"
+
+	// EventCommand asks the receiving device to change its playback
+	EventCommand = "command"
+
+	// EventError reports a message from the device that couldn't be handled
+	EventError = "error"
+
+	// EventPong answers a device's ping
+	EventPong = "pong"
+)
+
+// Commands one device can send another
+const (
+	CommandPlay    = "play"
+	CommandPause   = "pause"
+	CommandSkip    = "skip"
+	CommandSeek    = "seek"
+	CommandEnqueue = "enqueue"
+)
+
+const (
+	// MaxQueueLength bounds the queue a device can report
+	MaxQueueLength = 5000
+
+	// MaxEnqueueItems bounds the items a single enqueue command can add
+	MaxEnqueueItems = 100
+
+	// eventBuffer is how many events can wait for a device before it's
+	// considered too slow and disconnected
+	eventBuffer = 32
+)
+
+var (
+	ErrDeviceNotConnected = errors.New("device not connected")
+	ErrInvalidCommand     = errors.New("invalid command")
+	ErrInvalidState       = errors.New("invalid state")
+)
+
+// QueueItem is a track or video in a queue. Type is "audio" or "video".
+type QueueItem struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+}
+
+// State is a user's playback as last reported by the device playing it.
+// PositionSeconds is the position as of UpdatedAt; while Playing, clients
+// add the time since.
+type State struct {
+	Queue           []QueueItem `json:"queue"`
+	Index           int         `json:"index"`
+	PositionSeconds float64     `json:"position_seconds"`
+	Playing         bool        `json:"playing"`
+	DeviceID        string      `json:"device_id"`
+	UpdatedAt       time.Time   `json:"updated_at"`
+}
+
+// Command asks a device to change its playback. PositionSeconds is set for
+// seek and Items for enqueue.
+type Command struct {
+	Command         string      `json:"command"`
+	PositionSeconds *float64    `json:"position_seconds,omitempty"`
+	Items           []QueueItem `json:"items,omitempty"`
+}
+
+// DeviceInfo describes a connected device
+type DeviceInfo struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+// Event is a message sent to a device. From is the device a command came
+// from.
+type Event struct {
+	Type    string       `json:"type"`
+	State   *State       `json:"state,omitempty"`
+	Devices []DeviceInfo `json:"devices,omitempty"`
+	From    string       `json:"from,omitempty"`
+	Command *Command     `json:"command,omitempty"`
+	Error   string       `json:"error,omitempty"`
+}
+
+func validateItems(items []QueueItem) error {
+	for _, item := range items {
+		if item.ID == "" {
+			return errors.New("queue items need an id")
+		}
+		if item.Type != "audio" && item.Type != "video" {
+			return fmt.Errorf("queue item type must be audio or video, got %q", item.Type)
+		}
+	}
+	return nil
+}
+
+// Validate checks a state reported by a device
+func (s *State) Validate() error {
+	if len(s.Queue) > MaxQueueLength {
+		return fmt.Errorf("%w: queue has more than %d items", ErrInvalidState, MaxQueueLength)
+	}
+	if err := validateItems(s.Queue); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidState, err)
+	}
+	if s.Index < 0 || (s.Index >= len(s.Queue) && !(s.Index == 0 && len(s.Queue) == 0)) {
+		return fmt.Errorf("%w: index out of range", ErrInvalidState)
+	}
+	if s.PositionSeconds < 0 {
+		return fmt.Errorf("%w: position_seconds must not be negative", ErrInvalidState)
+	}
+	return nil
+}
+
+// Validate checks a command before it's relayed
+func (c *Command) Validate() error {
+	switch c.Command {
+	case CommandPlay, CommandPause, CommandSkip:
+		return nil
+	case CommandSeek:
+		if c.PositionSeconds == nil || *c.PositionSeconds < 0 {
+			return fmt.Errorf("%w: seek needs a position_seconds of at least 0", ErrInvalidCommand)
+		}
+		return nil
+	case CommandEnqueue:
+		if len(c.Items) == 0 || len(c.Items) > MaxEnqueueItems {
+			return fmt.Errorf("%w: enqueue needs between 1 and %d items", ErrInvalidCommand, MaxEnqueueItems)
+		}
+		if err := validateItems(c.Items); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidCommand, err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown command %q", ErrInvalidCommand, c.Command)
+	}
+}
+
+// Device is one connected client of a user. Events for it are read from
+// Events until the channel is closed, which happens when it disconnects,
+// is replaced by a new connection with the same ID, or falls too far
+// behind.
+type Device struct {
+	info DeviceInfo
+
+	mu     sync.Mutex
+	closed bool
+	events chan Event
+}
+
+func newDevice(id, name string, now time.Time) *Device {
+	return &Device{
+		info:   DeviceInfo{ID: id, Name: name, ConnectedAt: now},
+		events: make(chan Event, eventBuffer),
+	}
+}
+
+// ID returns the device's ID
+func (d *Device) ID() string {
+	return d.info.ID
+}
+
+// Events returns the channel of events for the device
+func (d *Device) Events() <-chan Event {
+	return d.events
+}
+
+// Send queues an event for the device without waiting. A device whose
+// buffer is full is closed, and Send reports false.
+func (d *Device) Send(event Event) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return false
+	}
+	select {
+	case d.events <- event:
+		return true
+	default:
+		d.closed = true
+		close(d.events)
+		return false
+	}
+}
+
+func (d *Device) close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.closed {
+		d.closed = true
+		close(d.events)
+	}
+}
+
+// session is one user's playback state and connected devices
+type session struct {
+	state   *State
+	devices map[string]*Device
+}
+
+// Hub holds every user's playback session. Sessions live in memory, so
+// state is lost when the server restarts; devices report it again as they
+// reconnect.
+type Hub struct {
+	mu       sync.Mutex
+	sessions map[string]*session
+	now      func() time.Time
+}
+
+func NewHub() *Hub {
+	return &Hub{sessions: make(map[string]*session), now: time.Now}
+}
+
+// Connect registers a device for a user and queues the current state and
+// device list for it. A device already connected with the same ID is
+// closed and replaced.
+func (h *Hub) Connect(userID, deviceID, name string) *Device {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.sessions[userID]
+	if s == nil {
+		s = &session{devices: make(map[string]*Device)}
+		h.sessions[userID] = s
+	}
+
+	if old := s.devices[deviceID]; old != nil {
+		old.close()
+	}
+	device := newDevice(deviceID, name, h.now())
+	s.devices[deviceID] = device
+
+	if s.state != nil {
+		device.Send(Event{Type: EventState, State: s.state})
+	}
+	h.broadcastDevices(userID, s)
+
+	return device
+}
+
+// Disconnect removes a device and closes it. If it was playing, the state
+// is marked paused, since nothing is known to be playing any more.
+func (h *Hub) Disconnect(userID string, device *Device) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	device.close()
+
+	s := h.sessions[userID]
+	if s == nil {
+		return
+	}
+	if s.devices[device.ID()] == device {
+		delete(s.devices, device.ID())
+	}
+
+	// A connection that replaced this one under the same ID carries on
+	// playing
+	if s.devices[device.ID()] == nil && s.state != nil && s.state.Playing && s.state.DeviceID == device.ID() {
+		state := *s.state
+		state.PositionSeconds += h.now().Sub(state.UpdatedAt).Seconds()
+		state.Playing = false
+		state.UpdatedAt = h.now()
+		s.state = &state
+		h.broadcast(s, Event{Type: EventState, State: s.state})
+	}
+	h.broadcastDevices(userID, s)
+}
+
+// UpdateState stores the state reported by a device, making it the device
+// playing, and sends it to all of the user's devices
+func (h *Hub) UpdateState(userID string, device *Device, state State) error {
+	if err := state.Validate(); err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.sessions[userID]
+	if s == nil || s.devices[device.ID()] != device {
+		return ErrDeviceNotConnected
+	}
+
+	if state.Queue == nil {
+		state.Queue = []QueueItem{}
+	}
+	state.DeviceID = device.ID()
+	state.UpdatedAt = h.now()
+	s.state = &state
+	h.broadcast(s, Event{Type: EventState, State: s.state})
+
+	return nil
+}
+
+// SendCommand relays a command from one of a user's devices to another.
+// Returns ErrDeviceNotConnected if the target isn't connected.
+func (h *Hub) SendCommand(userID string, from *Device, targetID string, cmd Command) error {
+	if err := cmd.Validate(); err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.sessions[userID]
+	if s == nil || s.devices[from.ID()] != from {
+		return ErrDeviceNotConnected
+	}
+	target := s.devices[targetID]
+	if target == nil {
+		return ErrDeviceNotConnected
+	}
+
+	if !target.Send(Event{Type: EventCommand, From: from.ID(), Command: &cmd}) {
+		h.drop(userID, s, target)
+		return ErrDeviceNotConnected
+	}
+
+	return nil
+}
+
+// Close disconnects every device, for server shutdown
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for userID, s := range h.sessions {
+		for _, device := range s.devices {
+			device.close()
+		}
+		delete(h.sessions, userID)
+	}
+}
+
+// broadcast sends an event to each of a session's devices, dropping those
+// too far behind to take it
+func (h *Hub) broadcast(s *session, event Event) {
+	var dropped bool
+	for id, device := range s.devices {
+		if !device.Send(event) {
+			delete(s.devices, id)
+			dropped = true
+		}
+	}
+	if dropped {
+		// The remaining devices learn of the drop from the next device list
+		h.sendDevices(s)
+	}
+}
+
+func (h *Hub) broadcastDevices(userID string, s *session) {
+	h.sendDevices(s)
+	if len(s.devices) == 0 && s.state == nil {
+		delete(h.sessions, userID)
+	}
+}
+
+// sendDevices sends the device list to each device. Devices dropped along
+// the way are removed and the list sent again.
+func (h *Hub) sendDevices(s *session) {
+	for {
+		devices := make([]DeviceInfo, 0, len(s.devices))
+		for _, device := range s.devices {
+			devices = append(devices, device.info)
+		}
+		sort.Slice(devices, func(i, j int) bool {
+			return devices[i].ConnectedAt.Before(devices[j].ConnectedAt)
+		})
+
+		var dropped bool
+		for id, device := range s.devices {
+			if !device.Send(Event{Type: EventDevices, Devices: devices}) {
+				delete(s.devices, id)
+				dropped = true
+			}
+		}
+		if !dropped {
+			return
+		}
+	}
+}
+
+// drop removes a device that fell too far behind and tells the others
+func (h *Hub) drop(userID string, s *session, device *Device) {
+	delete(s.devices, device.ID())
+	h.broadcastDevices(userID, s)
+}
@@ -0,0 +1,205 @@
+package playback
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// next returns the device's next queued event, failing if there is none
+func next(t *testing.T, d *Device) Event {
+	t.Helper()
+	select {
+	case event, ok := <-d.Events():
+		if !ok {
+			t.Fatalf("device %s closed", d.ID())
+		}
+		return event
+	default:
+		t.Fatalf("no event for device %s", d.ID())
+		return Event{}
+	}
+}
+
+// drain discards the device's queued events
+func drain(d *Device) {
+	for {
+		select {
+		case _, ok := <-d.Events():
+			if !ok {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+func newTestHub() (*Hub, *time.Time) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	hub := NewHub()
+	hub.now = func() time.Time { return now }
+	return hub, &now
+}
+
+func TestConnectSendsStateAndDevices(t *testing.T) {
+	hub, _ := newTestHub()
+
+	phone := hub.Connect("u1", "phone", "Phone")
+	if event := next(t, phone); event.Type != EventDevices || len(event.Devices) != 1 {
+		t.Fatalf("first event = %+v, want devices with one entry", event)
+	}
+
+	state := State{Queue: []QueueItem{{ID: "t1", Type: "audio"}}, PositionSeconds: 12, Playing: true}
+	if err := hub.UpdateState("u1", phone, state); err != nil {
+		t.Fatalf("UpdateState: %v", err)
+	}
+	drain(phone)
+
+	laptop := hub.Connect("u1", "laptop", "Laptop")
+	event := next(t, laptop)
+	if event.Type != EventState || event.State.DeviceID != "phone" || event.State.PositionSeconds != 12 {
+		t.Errorf("first event = %+v, want the phone's state", event)
+	}
+	if event := next(t, laptop); event.Type != EventDevices || len(event.Devices) != 2 {
+		t.Errorf("second event = %+v, want devices with two entries", event)
+	}
+	if event := next(t, phone); event.Type != EventDevices || len(event.Devices) != 2 {
+		t.Errorf("phone event = %+v, want devices with two entries", event)
+	}
+
+	// Other users see nothing
+	other := hub.Connect("u2", "phone", "Phone")
+	if event := next(t, other); event.Type != EventDevices || len(event.Devices) != 1 {
+		t.Errorf("other user's event = %+v, want only their device", event)
+	}
+}
+
+func TestUpdateStateBroadcasts(t *testing.T) {
+	hub, _ := newTestHub()
+	phone := hub.Connect("u1", "phone", "Phone")
+	laptop := hub.Connect("u1", "laptop", "Laptop")
+	drain(phone)
+	drain(laptop)
+
+	if err := hub.UpdateState("u1", phone, State{Index: 3}); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("out of range index: err = %v, want ErrInvalidState", err)
+	}
+
+	state := State{Queue: []QueueItem{{ID: "t1", Type: "audio"}, {ID: "v1", Type: "video"}}, Index: 1}
+	if err := hub.UpdateState("u1", phone, state); err != nil {
+		t.Fatalf("UpdateState: %v", err)
+	}
+	for _, d := range []*Device{phone, laptop} {
+		event := next(t, d)
+		if event.Type != EventState || event.State.Index != 1 || event.State.DeviceID != "phone" {
+			t.Errorf("%s event = %+v, want the new state", d.ID(), event)
+		}
+	}
+}
+
+func TestSendCommand(t *testing.T) {
+	hub, _ := newTestHub()
+	phone := hub.Connect("u1", "phone", "Phone")
+	laptop := hub.Connect("u1", "laptop", "Laptop")
+	drain(phone)
+	drain(laptop)
+
+	position := 90.0
+	if err := hub.SendCommand("u1", laptop, "phone", Command{Command: CommandSeek, PositionSeconds: &position}); err != nil {
+		t.Fatalf("SendCommand: %v", err)
+	}
+	event := next(t, phone)
+	if event.Type != EventCommand || event.From != "laptop" || *event.Command.PositionSeconds != 90 {
+		t.Errorf("phone event = %+v, want seek from laptop", event)
+	}
+
+	if err := hub.SendCommand("u1", laptop, "tv", Command{Command: CommandPlay}); !errors.Is(err, ErrDeviceNotConnected) {
+		t.Errorf("unknown target: err = %v, want ErrDeviceNotConnected", err)
+	}
+	if err := hub.SendCommand("u1", laptop, "phone", Command{Command: CommandSeek}); !errors.Is(err, ErrInvalidCommand) {
+		t.Errorf("seek without position: err = %v, want ErrInvalidCommand", err)
+	}
+	if err := hub.SendCommand("u1", laptop, "phone", Command{Command: CommandEnqueue}); !errors.Is(err, ErrInvalidCommand) {
+		t.Errorf("empty enqueue: err = %v, want ErrInvalidCommand", err)
+	}
+	if err := hub.SendCommand("u1", laptop, "phone", Command{Command: "rewind"}); !errors.Is(err, ErrInvalidCommand) {
+		t.Errorf("unknown command: err = %v, want ErrInvalidCommand", err)
+	}
+
+	// Devices of another user can't be reached
+	stranger := hub.Connect("u2", "laptop", "Laptop")
+	if err := hub.SendCommand("u2", stranger, "phone", Command{Command: CommandPause}); !errors.Is(err, ErrDeviceNotConnected) {
+		t.Errorf("other user's device: err = %v, want ErrDeviceNotConnected", err)
+	}
+}
+
+func TestDisconnectPausesPlayingDevice(t *testing.T) {
+	hub, now := newTestHub()
+	phone := hub.Connect("u1", "phone", "Phone")
+	laptop := hub.Connect("u1", "laptop", "Laptop")
+
+	state := State{Queue: []QueueItem{{ID: "t1", Type: "audio"}}, PositionSeconds: 10, Playing: true}
+	if err := hub.UpdateState("u1", phone, state); err != nil {
+		t.Fatalf("UpdateState: %v", err)
+	}
+	drain(laptop)
+
+	*now = now.Add(5 * time.Second)
+	hub.Disconnect("u1", phone)
+
+	event := next(t, laptop)
+	if event.Type != EventState || event.State.Playing || event.State.PositionSeconds != 15 {
+		t.Errorf("laptop event = %+v, want paused at 15 seconds", event)
+	}
+	if event := next(t, laptop); event.Type != EventDevices || len(event.Devices) != 1 {
+		t.Errorf("laptop event = %+v, want devices with one entry", event)
+	}
+}
+
+func TestReconnectReplacesDevice(t *testing.T) {
+	hub, _ := newTestHub()
+	old := hub.Connect("u1", "phone", "Phone")
+	if err := hub.UpdateState("u1", old, State{Playing: true}); err != nil {
+		t.Fatalf("UpdateState: %v", err)
+	}
+
+	replacement := hub.Connect("u1", "phone", "Phone")
+	drain(old)
+	if _, ok := <-old.Events(); ok {
+		t.Fatal("old connection still open")
+	}
+
+	// The old connection going away leaves the new one playing
+	hub.Disconnect("u1", old)
+	drain(replacement)
+	if err := hub.SendCommand("u1", replacement, "phone", Command{Command: CommandPause}); err != nil {
+		t.Errorf("SendCommand to replacement: %v", err)
+	}
+	if !hub.sessions["u1"].state.Playing {
+		t.Error("state paused by the replaced connection")
+	}
+}
+
+func TestSlowDeviceDropped(t *testing.T) {
+	hub, _ := newTestHub()
+	phone := hub.Connect("u1", "phone", "Phone")
+	laptop := hub.Connect("u1", "laptop", "Laptop")
+
+	for i := 0; i <= eventBuffer; i++ {
+		if err := hub.UpdateState("u1", phone, State{}); err != nil {
+			t.Fatalf("UpdateState: %v", err)
+		}
+		drain(phone)
+	}
+
+	if err := hub.SendCommand("u1", phone, "laptop", Command{Command: CommandPlay}); !errors.Is(err, ErrDeviceNotConnected) {
+		t.Errorf("command to dropped device: err = %v, want ErrDeviceNotConnected", err)
+	}
+	// The laptop's buffered events are still readable before the close
+	for {
+		if _, ok := <-laptop.Events(); !ok {
+			break
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package playlistfmt
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// jspfDocument is the JSON rendering of XSPF, as used by ListenBrainz
+type jspfDocument struct {
+	Playlist jspfPlaylist `json:"playlist"`
+}
+
+type jspfPlaylist struct {
+	Title  string      `json:"title,omitempty"`
+	Tracks []jspfTrack `json:"track"`
+}
+
+type jspfTrack struct {
+	Locations   stringList `json:"location,omitempty"`
+	Identifiers stringList `json:"identifier,omitempty"`
+	Title       string     `json:"title,omitempty"`
+	Creator     string     `json:"creator,omitempty"`
+	Album       string     `json:"album,omitempty"`
+	Duration    int        `json:"duration,omitempty"` // milliseconds
+}
+
+// stringList accepts either a JSON array of strings or a single string,
+// since JSPF writers disagree on whether location and identifier are lists
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*l = stringList{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// ParseJSPF decodes a JSPF playlist
+func ParseJSPF(data []byte) (*Playlist, error) {
+	var doc jspfDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parsing jspf: %w", err)
+	}
+
+	playlist := &Playlist{Title: strings.TrimSpace(doc.Playlist.Title)}
+	for _, track := range doc.Playlist.Tracks {
+		playlist.Entries = append(playlist.Entries, Entry{
+			Title:           strings.TrimSpace(track.Title),
+			Artist:          strings.TrimSpace(track.Creator),
+			Album:           strings.TrimSpace(track.Album),
+			DurationSeconds: millisToSeconds(track.Duration),
+			Locations:       trimAll(append(track.Locations, track.Identifiers...)),
+		})
+	}
+
+	return playlist, nil
+}
+
+// EncodeJSPF writes a JSPF playlist
+func EncodeJSPF(playlist *Playlist) ([]byte, error) {
+	doc := jspfDocument{Playlist: jspfPlaylist{
+		Title:  playlist.Title,
+		Tracks: make([]jspfTrack, 0, len(playlist.Entries)),
+	}}
+	for _, entry := range playlist.Entries {
+		doc.Playlist.Tracks = append(doc.Playlist.Tracks, jspfTrack{
+			Locations: entry.Locations,
+			Title:     entry.Title,
+			Creator:   entry.Artist,
+			Album:     entry.Album,
+			Duration:  entry.DurationSeconds * 1000,
+		})
+	}
+
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("encoding jspf: %w", err)
+	}
+	return append(out, '\n'), nil
+}
@@ -0,0 +1,115 @@
+package playlistfmt
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseM3U8 decodes an extended M3U playlist. Artist and title come from
+// #EXTINF's "Artist - Title" display text, overridden by #EXTART and #EXTALB.
+func ParseM3U8(data []byte) (*Playlist, error) {
+	playlist := &Playlist{}
+	var pending Entry
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXTINF:"):
+			pending.DurationSeconds, pending.Artist, pending.Title = parseExtInf(strings.TrimPrefix(line, "#EXTINF:"))
+		case strings.HasPrefix(line, "#EXTART:"):
+			pending.Artist = strings.TrimSpace(strings.TrimPrefix(line, "#EXTART:"))
+		case strings.HasPrefix(line, "#EXTALB:"):
+			pending.Album = strings.TrimSpace(strings.TrimPrefix(line, "#EXTALB:"))
+		case strings.HasPrefix(line, "#PLAYLIST:"):
+			playlist.Title = strings.TrimSpace(strings.TrimPrefix(line, "#PLAYLIST:"))
+		case strings.HasPrefix(line, "#"):
+			// Other directives and comments
+		default:
+			pending.Locations = []string{line}
+			if pending.Title == "" {
+				pending.Title = titleFromLocation(line)
+			}
+			playlist.Entries = append(playlist.Entries, pending)
+			pending = Entry{}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading m3u8: %w", err)
+	}
+
+	return playlist, nil
+}
+
+// parseExtInf splits "#EXTINF:duration [attributes],Artist - Title"
+func parseExtInf(value string) (int, string, string) {
+	info, display, _ := strings.Cut(value, ",")
+
+	// Attributes such as tvg-id="..." may follow the duration
+	durationField, _, _ := strings.Cut(strings.TrimSpace(info), " ")
+	duration := 0
+	if seconds, err := strconv.ParseFloat(durationField, 64); err == nil && seconds > 0 {
+		duration = int(seconds + 0.5)
+	}
+
+	display = strings.TrimSpace(display)
+	if artist, title, ok := strings.Cut(display, " - "); ok {
+		return duration, strings.TrimSpace(artist), strings.TrimSpace(title)
+	}
+	return duration, "", display
+}
+
+// titleFromLocation uses a file name without its extension as a fallback title
+func titleFromLocation(location string) string {
+	if YouTubeID(location) != "" {
+		return ""
+	}
+	name := location[strings.LastIndexAny(location, `/\`)+1:]
+	if dot := strings.LastIndex(name, "."); dot > 0 {
+		name = name[:dot]
+	}
+	return name
+}
+
+// EncodeM3U8 writes an extended M3U playlist
+func EncodeM3U8(playlist *Playlist) []byte {
+	var b bytes.Buffer
+	b.WriteString("#EXTM3U\n")
+	if playlist.Title != "" {
+		fmt.Fprintf(&b, "#PLAYLIST:%s\n", oneLine(playlist.Title))
+	}
+
+	for _, entry := range playlist.Entries {
+		if len(entry.Locations) == 0 {
+			continue
+		}
+
+		duration := entry.DurationSeconds
+		if duration <= 0 {
+			duration = -1
+		}
+		display := oneLine(entry.Title)
+		if entry.Artist != "" {
+			display = oneLine(entry.Artist) + " - " + display
+		}
+
+		fmt.Fprintf(&b, "#EXTINF:%d,%s\n", duration, display)
+		if entry.Album != "" {
+			fmt.Fprintf(&b, "#EXTALB:%s\n", oneLine(entry.Album))
+		}
+		fmt.Fprintf(&b, "%s\n", entry.Locations[0])
+	}
+
+	return b.Bytes()
+}
+
+// oneLine keeps a value from breaking the line-based format
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
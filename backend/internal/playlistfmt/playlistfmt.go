@@ -0,0 +1,151 @@
+package playlistfmt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Supported playlist formats
+const (
+	FormatM3U8 = "m3u8"
+	FormatXSPF = "xspf"
+	FormatJSPF = "jspf"
+)
+
+// bom is the UTF-8 byte order mark some editors prepend
+const bom = "\ufeff"
+
+var ErrUnsupportedFormat = errors.New("unsupported playlist format")
+
+// Playlist is a format-neutral playlist
+type Playlist struct {
+	Title   string
+	Entries []Entry
+}
+
+// Entry is one item of a playlist. Locations are URLs or file paths; any
+// field other than one location may be missing.
+type Entry struct {
+	Title           string
+	Artist          string
+	Album           string
+	DurationSeconds int
+	Locations       []string
+}
+
+// YouTubeID returns the YouTube video ID referenced by any of the entry's
+// locations, or "" if there is none
+func (e *Entry) YouTubeID() string {
+	for _, location := range e.Locations {
+		if id := YouTubeID(location); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// YouTubeURL returns the canonical watch URL for a video ID
+func YouTubeURL(videoID string) string {
+	return "https://www.youtube.com/watch?v=" + videoID
+}
+
+var videoIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+
+// IsVideoID reports whether id has the shape of a YouTube video ID
+func IsVideoID(id string) bool {
+	return videoIDPattern.MatchString(id)
+}
+
+// YouTubeID extracts the video ID from a YouTube, YouTube Music or youtu.be
+// URL, returning "" for anything else
+func YouTubeID(location string) string {
+	u, err := url.Parse(strings.TrimSpace(location))
+	if err != nil {
+		return ""
+	}
+
+	var id string
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	switch host {
+	case "youtu.be":
+		id = strings.Trim(u.Path, "/")
+	case "youtube.com", "m.youtube.com", "music.youtube.com", "youtube-nocookie.com":
+		if u.Path == "/watch" {
+			id = u.Query().Get("v")
+			break
+		}
+		for _, prefix := range []string{"/shorts/", "/embed/", "/live/", "/v/"} {
+			if strings.HasPrefix(u.Path, prefix) {
+				id = strings.Trim(strings.TrimPrefix(u.Path, prefix), "/")
+				break
+			}
+		}
+	}
+
+	if !IsVideoID(id) {
+		return ""
+	}
+	return id
+}
+
+// Detect guesses the format of playlist data from its first character
+func Detect(data []byte) string {
+	data = bytes.TrimPrefix(data, []byte(bom))
+	data = bytes.TrimSpace(data)
+	switch {
+	case bytes.HasPrefix(data, []byte("<")):
+		return FormatXSPF
+	case bytes.HasPrefix(data, []byte("{")):
+		return FormatJSPF
+	default:
+		return FormatM3U8
+	}
+}
+
+// Parse decodes playlist data in the given format
+func Parse(format string, data []byte) (*Playlist, error) {
+	data = bytes.TrimPrefix(data, []byte(bom))
+
+	switch format {
+	case FormatM3U8:
+		return ParseM3U8(data)
+	case FormatXSPF:
+		return ParseXSPF(data)
+	case FormatJSPF:
+		return ParseJSPF(data)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedFormat, format)
+	}
+}
+
+// Encode writes a playlist in the given format
+func Encode(format string, playlist *Playlist) ([]byte, error) {
+	switch format {
+	case FormatM3U8:
+		return EncodeM3U8(playlist), nil
+	case FormatXSPF:
+		return EncodeXSPF(playlist)
+	case FormatJSPF:
+		return EncodeJSPF(playlist)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedFormat, format)
+	}
+}
+
+// ContentType returns the MIME type of a playlist format
+func ContentType(format string) string {
+	switch format {
+	case FormatM3U8:
+		return "audio/x-mpegurl; charset=utf-8"
+	case FormatXSPF:
+		return "application/xspf+xml"
+	case FormatJSPF:
+		return "application/jspf+json"
+	default:
+		return "application/octet-stream"
+	}
+}
@@ -0,0 +1,200 @@
+package playlistfmt
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestYouTubeID(t *testing.T) {
+	tests := []struct {
+		location string
+		want     string
+	}{
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ", "dQw4w9WgXcQ"},
+		{"https://youtube.com/watch?v=dQw4w9WgXcQ&list=PL123&t=42", "dQw4w9WgXcQ"},
+		{"https://music.youtube.com/watch?v=dQw4w9WgXcQ", "dQw4w9WgXcQ"},
+		{"https://m.youtube.com/watch?v=dQw4w9WgXcQ", "dQw4w9WgXcQ"},
+		{"https://youtu.be/dQw4w9WgXcQ?si=abc", "dQw4w9WgXcQ"},
+		{"https://www.youtube.com/shorts/dQw4w9WgXcQ", "dQw4w9WgXcQ"},
+		{"https://www.youtube.com/embed/dQw4w9WgXcQ", "dQw4w9WgXcQ"},
+		{"https://www.youtube.com/watch?v=short", ""},
+		{"https://example.com/watch?v=dQw4w9WgXcQ", ""},
+		{"/music/Artist - Song.mp3", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.location, func(t *testing.T) {
+			if got := YouTubeID(tt.location); got != tt.want {
+				t.Errorf("YouTubeID(%q) = %q, want %q", tt.location, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"#EXTM3U\n", FormatM3U8},
+		{"\ufeff  <?xml version=\"1.0\"?><playlist/>", FormatXSPF},
+		{"\n{\"playlist\": {}}", FormatJSPF},
+		{"song.mp3\n", FormatM3U8},
+	}
+
+	for _, tt := range tests {
+		if got := Detect([]byte(tt.data)); got != tt.want {
+			t.Errorf("Detect(%q) = %v, want %v", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestParseM3U8(t *testing.T) {
+	data := "\ufeff#EXTM3U\r\n" +
+		"#PLAYLIST:Road Trip\r\n" +
+		"#EXTINF:213,Daft Punk - One More Time\r\n" +
+		"https://youtu.be/FGBhQbmPwH8\r\n" +
+		"\r\n" +
+		"#EXTINF:-1 tvg-id=\"x\",Untitled Stream\r\n" +
+		"#EXTALB:Live\r\n" +
+		"http://example.com/stream\r\n" +
+		"/music/Some Artist - Local Song.mp3\r\n"
+
+	got, err := Parse(FormatM3U8, []byte(data))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	want := &Playlist{
+		Title: "Road Trip",
+		Entries: []Entry{
+			{Title: "One More Time", Artist: "Daft Punk", DurationSeconds: 213, Locations: []string{"https://youtu.be/FGBhQbmPwH8"}},
+			{Title: "Untitled Stream", Album: "Live", Locations: []string{"http://example.com/stream"}},
+			{Title: "Some Artist - Local Song", Locations: []string{"/music/Some Artist - Local Song.mp3"}},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse() = %+v, want %+v", got, want)
+	}
+
+	if id := got.Entries[0].YouTubeID(); id != "FGBhQbmPwH8" {
+		t.Errorf("YouTubeID() = %q, want FGBhQbmPwH8", id)
+	}
+}
+
+func TestParseXSPF(t *testing.T) {
+	data := `<?xml version="1.0" encoding="UTF-8"?>
+<playlist version="1" xmlns="http://xspf.org/ns/0/">
+  <title>Favourites</title>
+  <trackList>
+    <track>
+      <location>file:///music/song.flac</location>
+      <identifier>https://www.youtube.com/watch?v=FGBhQbmPwH8</identifier>
+      <title>One More Time</title>
+      <creator>Daft Punk</creator>
+      <album>Discovery</album>
+      <duration>320357</duration>
+    </track>
+  </trackList>
+</playlist>`
+
+	got, err := Parse(FormatXSPF, []byte(data))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	want := &Playlist{
+		Title: "Favourites",
+		Entries: []Entry{{
+			Title:           "One More Time",
+			Artist:          "Daft Punk",
+			Album:           "Discovery",
+			DurationSeconds: 320,
+			Locations:       []string{"file:///music/song.flac", "https://www.youtube.com/watch?v=FGBhQbmPwH8"},
+		}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse() = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseJSPF(t *testing.T) {
+	data := `{"playlist": {"title": "Mix", "track": [
+		{"location": ["https://music.youtube.com/watch?v=FGBhQbmPwH8"], "title": "One More Time", "creator": "Daft Punk", "duration": 320000},
+		{"location": "https://example.com/a.mp3", "identifier": ["https://musicbrainz.org/recording/abc"], "title": "Other"}
+	]}}`
+
+	got, err := Parse(FormatJSPF, []byte(data))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	want := &Playlist{
+		Title: "Mix",
+		Entries: []Entry{
+			{Title: "One More Time", Artist: "Daft Punk", DurationSeconds: 320, Locations: []string{"https://music.youtube.com/watch?v=FGBhQbmPwH8"}},
+			{Title: "Other", Locations: []string{"https://example.com/a.mp3", "https://musicbrainz.org/recording/abc"}},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse() = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	if _, err := Parse(FormatXSPF, []byte("<playlist>")); err == nil {
+		t.Error("Parse(xspf) expected error for truncated XML")
+	}
+	if _, err := Parse(FormatJSPF, []byte("{")); err == nil {
+		t.Error("Parse(jspf) expected error for truncated JSON")
+	}
+	if _, err := Parse("pls", nil); err == nil {
+		t.Error("Parse(pls) expected unsupported format error")
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	playlist := &Playlist{
+		Title: "Road <Trip> & More",
+		Entries: []Entry{
+			{Title: "One More Time", Artist: "Daft Punk", Album: "Discovery", DurationSeconds: 320, Locations: []string{YouTubeURL("FGBhQbmPwH8")}},
+			{Title: "Line\nBreak", DurationSeconds: 0, Locations: []string{YouTubeURL("dQw4w9WgXcQ")}},
+		},
+	}
+
+	for _, format := range []string{FormatM3U8, FormatXSPF, FormatJSPF} {
+		t.Run(format, func(t *testing.T) {
+			data, err := Encode(format, playlist)
+			if err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			if Detect(data) != format {
+				t.Errorf("Detect() = %v, want %v", Detect(data), format)
+			}
+
+			got, err := Parse(format, data)
+			if err != nil {
+				t.Fatalf("Parse() error = %v\n%s", err, data)
+			}
+			if got.Title != playlist.Title {
+				t.Errorf("Title = %q, want %q", got.Title, playlist.Title)
+			}
+			if len(got.Entries) != len(playlist.Entries) {
+				t.Fatalf("got %d entries, want %d", len(got.Entries), len(playlist.Entries))
+			}
+
+			first := got.Entries[0]
+			if first.Title != "One More Time" || first.Artist != "Daft Punk" || first.Album != "Discovery" || first.DurationSeconds != 320 {
+				t.Errorf("first entry = %+v", first)
+			}
+			if first.YouTubeID() != "FGBhQbmPwH8" {
+				t.Errorf("first entry YouTubeID() = %q", first.YouTubeID())
+			}
+			if title := got.Entries[1].Title; strings.Contains(title, "\n") && format == FormatM3U8 {
+				t.Errorf("m3u8 title kept a line break: %q", title)
+			}
+		})
+	}
+}
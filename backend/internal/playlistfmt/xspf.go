@@ -0,0 +1,90 @@
+package playlistfmt
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+const xspfNamespace = "http://xspf.org/ns/0/"
+
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"playlist"`
+	Version string      `xml:"version,attr"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	Title   string      `xml:"title,omitempty"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Locations   []string `xml:"location"`
+	Identifiers []string `xml:"identifier"`
+	Title       string   `xml:"title,omitempty"`
+	Creator     string   `xml:"creator,omitempty"`
+	Album       string   `xml:"album,omitempty"`
+	Duration    int      `xml:"duration,omitempty"` // milliseconds
+}
+
+// ParseXSPF decodes an XSPF (XML Shareable Playlist Format) playlist
+func ParseXSPF(data []byte) (*Playlist, error) {
+	var doc xspfPlaylist
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parsing xspf: %w", err)
+	}
+
+	playlist := &Playlist{Title: strings.TrimSpace(doc.Title)}
+	for _, track := range doc.Tracks {
+		playlist.Entries = append(playlist.Entries, Entry{
+			Title:           strings.TrimSpace(track.Title),
+			Artist:          strings.TrimSpace(track.Creator),
+			Album:           strings.TrimSpace(track.Album),
+			DurationSeconds: millisToSeconds(track.Duration),
+			Locations:       trimAll(append(track.Locations, track.Identifiers...)),
+		})
+	}
+
+	return playlist, nil
+}
+
+// EncodeXSPF writes an XSPF playlist
+func EncodeXSPF(playlist *Playlist) ([]byte, error) {
+	doc := xspfPlaylist{
+		Version: "1",
+		Xmlns:   xspfNamespace,
+		Title:   playlist.Title,
+		Tracks:  make([]xspfTrack, 0, len(playlist.Entries)),
+	}
+	for _, entry := range playlist.Entries {
+		doc.Tracks = append(doc.Tracks, xspfTrack{
+			Locations: entry.Locations,
+			Title:     entry.Title,
+			Creator:   entry.Artist,
+			Album:     entry.Album,
+			Duration:  entry.DurationSeconds * 1000,
+		})
+	}
+
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("encoding xspf: %w", err)
+	}
+	return append([]byte(xml.Header), append(out, '\n')...), nil
+}
+
+func millisToSeconds(ms int) int {
+	if ms <= 0 {
+		return 0
+	}
+	return (ms + 500) / 1000
+}
+
+// trimAll trims whitespace from each value and drops empty ones
+func trimAll(values []string) []string {
+	var out []string
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,49 @@
+// Package recommend blends ranked lists of recommendations from several
+// sources into one
+package recommend
+
+import "sort"
+
+// rankOffset dampens the lead of the top few places in each list, so an
+// item ranked well by two sources beats one ranked first by only one. 60
+// is the usual choice for reciprocal rank fusion.
+const rankOffset = 60
+
+// Blend merges ranked lists of keys, best first, into a single ranking of
+// at most limit keys. Each key scores 1/(rankOffset+rank) for every list it
+// appears in, so keys found by several sources rise to the top. Ties keep
+// the order keys were first seen in. Keys in exclude are left out.
+func Blend(lists [][]string, exclude map[string]bool, limit int) []string {
+	scores := make(map[string]float64)
+	var keys []string
+
+	for _, list := range lists {
+		seen := make(map[string]bool, len(list))
+		rank := 0
+		for _, key := range list {
+			// Only a key's best place in a list counts
+			if key == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			rank++
+
+			if exclude[key] {
+				continue
+			}
+			if _, ok := scores[key]; !ok {
+				keys = append(keys, key)
+			}
+			scores[key] += 1 / float64(rankOffset+rank)
+		}
+	}
+
+	sort.SliceStable(keys, func(i, j int) bool {
+		return scores[keys[i]] > scores[keys[j]]
+	})
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+
+	return keys
+}
@@ -0,0 +1,68 @@
+package recommend
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBlend(t *testing.T) {
+	tests := []struct {
+		name    string
+		lists   [][]string
+		exclude map[string]bool
+		limit   int
+		want    []string
+	}{
+		{
+			name:  "single list keeps its order",
+			lists: [][]string{{"a", "b", "c"}},
+			limit: 10,
+			want:  []string{"a", "b", "c"},
+		},
+		{
+			name:  "keys in both lists rise",
+			lists: [][]string{{"a", "b", "c"}, {"x", "c"}},
+			limit: 10,
+			want:  []string{"c", "a", "x", "b"},
+		},
+		{
+			name:  "ties keep first seen order",
+			lists: [][]string{{"a", "b"}, {"x", "y"}},
+			limit: 10,
+			want:  []string{"a", "x", "b", "y"},
+		},
+		{
+			name:    "excluded keys dropped without promoting later ones",
+			lists:   [][]string{{"a", "b", "c"}, {"c", "b"}},
+			exclude: map[string]bool{"b": true},
+			limit:   10,
+			want:    []string{"c", "a"},
+		},
+		{
+			name:  "duplicates and empty keys ignored",
+			lists: [][]string{{"a", "", "a", "b"}},
+			limit: 10,
+			want:  []string{"a", "b"},
+		},
+		{
+			name:  "limited",
+			lists: [][]string{{"a", "b", "c"}},
+			limit: 2,
+			want:  []string{"a", "b"},
+		},
+		{
+			name:  "no lists",
+			limit: 10,
+			want:  nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Blend(tt.lists, tt.exclude, tt.limit)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Blend() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package scrobble
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Last.fm error codes that mean the session key is no good
+var lastFMAuthErrors = map[int]bool{
+	4: true, // authentication failed
+	9: true, // invalid session key
+}
+
+// Last.fm error codes worth retrying. Others reject the request.
+var lastFMTemporaryErrors = map[int]bool{
+	8:  true, // operation failed
+	11: true, // service offline
+	16: true, // temporarily unavailable
+	29: true, // rate limit exceeded
+}
+
+// LastFM submits listens to a Last.fm-compatible server using a session key
+// obtained through Last.fm's authentication flow for this server's API
+// account
+type LastFM struct {
+	baseURL    string
+	apiKey     string
+	secret     string
+	httpClient *http.Client
+}
+
+// NewLastFM creates a client for the Last.fm API at baseURL that signs
+// requests with the given API account
+func NewLastFM(baseURL, apiKey, secret string) *LastFM {
+	return &LastFM{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		apiKey:  apiKey,
+		secret:  secret,
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+type lastFMError struct {
+	Code    int    `json:"error"`
+	Message string `json:"message"`
+}
+
+type lastFMUserInfo struct {
+	User struct {
+		Name string `json:"name"`
+	} `json:"user"`
+}
+
+func (c *LastFM) ValidateToken(ctx context.Context, sessionKey string) (string, error) {
+	var info lastFMUserInfo
+	if err := c.call(ctx, "user.getInfo", sessionKey, url.Values{}, &info); err != nil {
+		return "", err
+	}
+	return info.User.Name, nil
+}
+
+func (c *LastFM) NowPlaying(ctx context.Context, sessionKey string, listen *Listen) error {
+	return c.call(ctx, "track.updateNowPlaying", sessionKey, trackParams(listen), nil)
+}
+
+func (c *LastFM) Scrobble(ctx context.Context, sessionKey string, listen *Listen) error {
+	params := trackParams(listen)
+	params.Set("timestamp", strconv.FormatInt(listen.ListenedAt.Unix(), 10))
+	return c.call(ctx, "track.scrobble", sessionKey, params, nil)
+}
+
+func trackParams(listen *Listen) url.Values {
+	params := url.Values{}
+	params.Set("artist", listen.Artist)
+	params.Set("track", listen.Track)
+	if listen.Album != "" {
+		params.Set("album", listen.Album)
+	}
+	if listen.DurationSeconds > 0 {
+		params.Set("duration", strconv.Itoa(listen.DurationSeconds))
+	}
+	if listen.MBRecordingID != "" {
+		params.Set("mbid", listen.MBRecordingID)
+	}
+	return params
+}
+
+// sign returns the api_sig of a request: the MD5 of every parameter name
+// and value in name order, followed by the API secret
+func (c *LastFM) sign(params url.Values) string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		if name != "format" && name != "callback" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name)
+		b.WriteString(params.Get(name))
+	}
+	b.WriteString(c.secret)
+
+	sum := md5.Sum([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *LastFM) call(ctx context.Context, method, sessionKey string, params url.Values, target any) error {
+	params.Set("method", method)
+	params.Set("api_key", c.apiKey)
+	params.Set("sk", sessionKey)
+	params.Set("api_sig", c.sign(params))
+	params.Set("format", "json")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/2.0/", strings.NewReader(params.Encode()))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("executing request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("last.fm returned status %d", resp.StatusCode)
+		}
+		return fmt.Errorf("decoding response: %w", err)
+	}
+
+	// Errors come back as a JSON body, with or without an error status
+	var apiErr lastFMError
+	if json.Unmarshal(body, &apiErr) == nil && apiErr.Code != 0 {
+		switch {
+		case lastFMAuthErrors[apiErr.Code]:
+			return ErrUnauthorized
+		case lastFMTemporaryErrors[apiErr.Code]:
+			return fmt.Errorf("last.fm error %d: %s", apiErr.Code, apiErr.Message)
+		default:
+			return fmt.Errorf("%w: last.fm error %d: %s", ErrRejected, apiErr.Code, apiErr.Message)
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("last.fm returned status %d", resp.StatusCode)
+	}
+
+	if target != nil {
+		if err := json.Unmarshal(body, target); err != nil {
+			return fmt.Errorf("decoding response: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package scrobble
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ListenBrainz submits listens to a ListenBrainz-compatible server using a
+// user token from the user's ListenBrainz settings
+type ListenBrainz struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewListenBrainz creates a client for the ListenBrainz API at baseURL
+func NewListenBrainz(baseURL string) *ListenBrainz {
+	return &ListenBrainz{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+type listenBrainzSubmission struct {
+	ListenType string               `json:"listen_type"`
+	Payload    []listenBrainzListen `json:"payload"`
+}
+
+type listenBrainzListen struct {
+	ListenedAt    int64                     `json:"listened_at,omitempty"`
+	TrackMetadata listenBrainzTrackMetadata `json:"track_metadata"`
+}
+
+type listenBrainzTrackMetadata struct {
+	ArtistName     string                 `json:"artist_name"`
+	TrackName      string                 `json:"track_name"`
+	ReleaseName    string                 `json:"release_name,omitempty"`
+	AdditionalInfo map[string]interface{} `json:"additional_info"`
+}
+
+type listenBrainzValidation struct {
+	Valid    bool   `json:"valid"`
+	UserName string `json:"user_name"`
+}
+
+func (c *ListenBrainz) ValidateToken(ctx context.Context, token string) (string, error) {
+	var validation listenBrainzValidation
+	if err := c.do(ctx, http.MethodGet, "/1/validate-token", token, nil, &validation); err != nil {
+		return "", err
+	}
+	if !validation.Valid {
+		return "", ErrUnauthorized
+	}
+	return validation.UserName, nil
+}
+
+func (c *ListenBrainz) NowPlaying(ctx context.Context, token string, listen *Listen) error {
+	return c.submit(ctx, token, "playing_now", listen)
+}
+
+func (c *ListenBrainz) Scrobble(ctx context.Context, token string, listen *Listen) error {
+	return c.submit(ctx, token, "single", listen)
+}
+
+func (c *ListenBrainz) submit(ctx context.Context, token, listenType string, listen *Listen) error {
+	info := map[string]interface{}{
+		"media_player":      clientName,
+		"submission_client": clientName,
+	}
+	if listen.DurationSeconds > 0 {
+		info["duration_ms"] = listen.DurationSeconds * 1000
+	}
+	if listen.MBRecordingID != "" {
+		info["recording_mbid"] = listen.MBRecordingID
+	}
+	if listen.MBReleaseID != "" {
+		info["release_mbid"] = listen.MBReleaseID
+	}
+	if listen.MBArtistID != "" {
+		info["artist_mbids"] = []string{listen.MBArtistID}
+	}
+	if url := originURL(listen.YoutubeID); url != "" {
+		info["origin_url"] = url
+	}
+
+	payload := listenBrainzListen{
+		TrackMetadata: listenBrainzTrackMetadata{
+			ArtistName:     listen.Artist,
+			TrackName:      listen.Track,
+			ReleaseName:    listen.Album,
+			AdditionalInfo: info,
+		},
+	}
+	// Playing-now submissions must not carry a time
+	if listenType != "playing_now" {
+		payload.ListenedAt = listen.ListenedAt.Unix()
+	}
+
+	body, err := json.Marshal(listenBrainzSubmission{ListenType: listenType, Payload: []listenBrainzListen{payload}})
+	if err != nil {
+		return fmt.Errorf("encoding listen: %w", err)
+	}
+
+	return c.do(ctx, http.MethodPost, "/1/submit-listens", token, body, nil)
+}
+
+func (c *ListenBrainz) do(ctx context.Context, method, path, token string, body []byte, target any) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Authorization", "Token "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("executing request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case resp.StatusCode == http.StatusBadRequest:
+		return fmt.Errorf("%w: listenbrainz returned status %d", ErrRejected, resp.StatusCode)
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("listenbrainz returned status %d", resp.StatusCode)
+	}
+
+	if target != nil {
+		if err := json.NewDecoder(resp.Body).Decode(target); err != nil {
+			return fmt.Errorf("decoding response: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+// Package scrobble forwards listens to ListenBrainz and Last.fm
+package scrobble
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Services a user can link
+const (
+	ServiceListenBrainz = "listenbrainz"
+	ServiceLastFM       = "lastfm"
+)
+
+const (
+	userAgent = "Dovora/1.0 ( https://github.com/wpinrui/dovora2 )"
+
+	// clientName identifies Dovora as the player and submitter of listens
+	clientName = "Dovora"
+)
+
+var (
+	// ErrUnauthorized means the service rejected the user's token or session
+	// key. Nothing more can be sent for the user until they link again.
+	ErrUnauthorized = errors.New("token rejected")
+
+	// ErrRejected means the service refused a listen itself, so sending it
+	// again won't help
+	ErrRejected = errors.New("listen rejected")
+)
+
+// Listen is a track being played or played to the end
+type Listen struct {
+	Artist          string    `json:"artist"`
+	Track           string    `json:"track"`
+	Album           string    `json:"album,omitempty"`
+	DurationSeconds int       `json:"duration_seconds,omitempty"`
+	ListenedAt      time.Time `json:"listened_at"`
+	MBRecordingID   string    `json:"mb_recording_id,omitempty"`
+	MBReleaseID     string    `json:"mb_release_id,omitempty"`
+	MBArtistID      string    `json:"mb_artist_id,omitempty"`
+	YoutubeID       string    `json:"youtube_id,omitempty"`
+}
+
+// Service submits listens to one scrobbling service on behalf of a user
+// identified by token. Errors other than ErrUnauthorized and ErrRejected are
+// worth retrying.
+type Service interface {
+	// ValidateToken checks a token and returns the account's user name
+	ValidateToken(ctx context.Context, token string) (string, error)
+	NowPlaying(ctx context.Context, token string, listen *Listen) error
+	Scrobble(ctx context.Context, token string, listen *Listen) error
+}
+
+func originURL(youtubeID string) string {
+	if youtubeID == "" {
+		return ""
+	}
+	return "https://www.youtube.com/watch?v=" + youtubeID
+}
@@ -0,0 +1,184 @@
+package scrobble
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+var testListen = &Listen{
+	Artist:          "Queen",
+	Track:           "Bohemian Rhapsody",
+	Album:           "A Night at the Opera",
+	DurationSeconds: 354,
+	ListenedAt:      time.Unix(1700000000, 0),
+	MBRecordingID:   "rec-studio",
+	YoutubeID:       "fJ9rUzIMcZQ",
+}
+
+func TestListenBrainzSubmit(t *testing.T) {
+	var submissions []listenBrainzSubmission
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/1/submit-listens" {
+			t.Errorf("path = %v, want /1/submit-listens", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Token secret" {
+			t.Errorf("Authorization = %v, want Token secret", got)
+		}
+		var submission listenBrainzSubmission
+		if err := json.NewDecoder(r.Body).Decode(&submission); err != nil {
+			t.Fatalf("decoding submission: %v", err)
+		}
+		submissions = append(submissions, submission)
+		w.Write([]byte(`{"status": "ok"}`))
+	}))
+	defer server.Close()
+
+	client := NewListenBrainz(server.URL + "/")
+	if err := client.NowPlaying(context.Background(), "secret", testListen); err != nil {
+		t.Fatalf("NowPlaying() error = %v", err)
+	}
+	if err := client.Scrobble(context.Background(), "secret", testListen); err != nil {
+		t.Fatalf("Scrobble() error = %v", err)
+	}
+
+	if len(submissions) != 2 {
+		t.Fatalf("len(submissions) = %d, want 2", len(submissions))
+	}
+
+	nowPlaying, single := submissions[0], submissions[1]
+	if nowPlaying.ListenType != "playing_now" || nowPlaying.Payload[0].ListenedAt != 0 {
+		t.Errorf("now playing = %+v, want playing_now without listened_at", nowPlaying)
+	}
+	if single.ListenType != "single" || single.Payload[0].ListenedAt != 1700000000 {
+		t.Errorf("scrobble = %+v, want single listened at 1700000000", single)
+	}
+
+	info := single.Payload[0].TrackMetadata.AdditionalInfo
+	if info["recording_mbid"] != "rec-studio" {
+		t.Errorf("recording_mbid = %v, want rec-studio", info["recording_mbid"])
+	}
+	if info["origin_url"] != "https://www.youtube.com/watch?v=fJ9rUzIMcZQ" {
+		t.Errorf("origin_url = %v", info["origin_url"])
+	}
+}
+
+func TestListenBrainzErrors(t *testing.T) {
+	tests := []struct {
+		status int
+		want   error
+	}{
+		{http.StatusUnauthorized, ErrUnauthorized},
+		{http.StatusBadRequest, ErrRejected},
+		{http.StatusServiceUnavailable, nil},
+	}
+
+	for _, tt := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+		}))
+
+		err := NewListenBrainz(server.URL).Scrobble(context.Background(), "secret", testListen)
+		server.Close()
+
+		if err == nil {
+			t.Errorf("status %d: Scrobble() error = nil", tt.status)
+			continue
+		}
+		retryable := !errors.Is(err, ErrUnauthorized) && !errors.Is(err, ErrRejected)
+		if tt.want == nil && !retryable || tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("status %d: Scrobble() error = %v, want %v", tt.status, err, tt.want)
+		}
+	}
+}
+
+func TestLastFMSign(t *testing.T) {
+	client := NewLastFM("", "key", "secret")
+	params := url.Values{}
+	params.Set("method", "track.scrobble")
+	params.Set("api_key", "key")
+	params.Set("artist", "Queen")
+	params.Set("format", "json")
+
+	// md5("api_keykeyartistQueenmethodtrack.scrobblesecret")
+	want := "8ad6ded2223992806d3180e4730012b1"
+	if got := client.sign(params); got != want {
+		t.Errorf("sign() = %v, want %v", got, want)
+	}
+}
+
+func TestLastFMScrobble(t *testing.T) {
+	var form url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/2.0/" {
+			t.Errorf("path = %v, want /2.0/", r.URL.Path)
+		}
+		r.ParseForm()
+		form = r.PostForm
+		w.Write([]byte(`{"scrobbles": {"@attr": {"accepted": 1, "ignored": 0}}}`))
+	}))
+	defer server.Close()
+
+	client := NewLastFM(server.URL, "key", "secret")
+	if err := client.Scrobble(context.Background(), "session", testListen); err != nil {
+		t.Fatalf("Scrobble() error = %v", err)
+	}
+
+	for name, want := range map[string]string{
+		"method":    "track.scrobble",
+		"sk":        "session",
+		"artist":    "Queen",
+		"timestamp": "1700000000",
+		"duration":  "354",
+		"format":    "json",
+	} {
+		if got := form.Get(name); got != want {
+			t.Errorf("%s = %v, want %v", name, got, want)
+		}
+	}
+
+	signed := url.Values{}
+	for name, values := range form {
+		if name != "api_sig" {
+			signed[name] = values
+		}
+	}
+	if got, want := form.Get("api_sig"), client.sign(signed); got != want {
+		t.Errorf("api_sig = %v, want %v", got, want)
+	}
+}
+
+func TestLastFMErrors(t *testing.T) {
+	tests := []struct {
+		body string
+		want error
+	}{
+		{`{"error": 9, "message": "Invalid session key"}`, ErrUnauthorized},
+		{`{"error": 6, "message": "Invalid parameters"}`, ErrRejected},
+		{`{"error": 29, "message": "Rate limit exceeded"}`, nil},
+	}
+
+	for _, tt := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(tt.body))
+		}))
+
+		err := NewLastFM(server.URL, "key", "secret").Scrobble(context.Background(), "session", testListen)
+		server.Close()
+
+		if err == nil {
+			t.Errorf("%s: Scrobble() error = nil", tt.body)
+			continue
+		}
+		retryable := !errors.Is(err, ErrUnauthorized) && !errors.Is(err, ErrRejected)
+		if tt.want == nil && !retryable || tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("%s: Scrobble() error = %v, want %v", tt.body, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+// Package takeout defines the account archive users export from one Dovora
+// instance and import into another. An archive is a ZIP holding account.json,
+// which follows the JSON Schema in schema.json, and optionally the media
+// files it refers to.
+package takeout
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// Format identifies account.json files
+	Format = "dovora-takeout"
+
+	// Version is the schema version written by this server. Imports accept
+	// any version up to it.
+	Version = 1
+
+	// DataFile and SchemaFile are the names of the JSON entries in an archive
+	DataFile   = "account.json"
+	SchemaFile = "schema.json"
+
+	// MaxDataFileBytes bounds the DataFile an import reads, far above what
+	// the largest libraries export
+	MaxDataFileBytes = 128 << 20
+
+	// mediaDir holds media files inside an archive
+	mediaDir = "media"
+)
+
+// Schema is the JSON Schema describing DataFile
+//
+//go:embed schema.json
+var Schema []byte
+
+var (
+	ErrUnknownFormat      = errors.New("not a Dovora takeout archive")
+	ErrUnsupportedVersion = errors.New("unsupported takeout version")
+	ErrTooLarge           = errors.New("takeout data file too large")
+)
+
+// Account is the content of DataFile
+type Account struct {
+	Format    string     `json:"format"`
+	Version   int        `json:"version"`
+	Profile   Profile    `json:"profile"`
+	Tracks    []Track    `json:"tracks"`
+	Videos    []Video    `json:"videos"`
+	Playlists []Playlist `json:"playlists"`
+	Invites   []Invite   `json:"invites"`
+}
+
+// Profile is the exported user. Credentials are never exported.
+type Profile struct {
+	Email     string    `json:"email"`
+	IsAdmin   bool      `json:"is_admin"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Track is a music track. ID is only meaningful within the archive, where
+// playlists refer to it. MediaFile is the entry holding the audio, if any.
+type Track struct {
+	ID              string    `json:"id"`
+	YoutubeID       string    `json:"youtube_id"`
+	Title           string    `json:"title"`
+	Artist          string    `json:"artist"`
+	Album           string    `json:"album,omitempty"`
+	ReleaseDate     string    `json:"release_date,omitempty"`
+	DurationSeconds int       `json:"duration_seconds"`
+	ThumbnailURL    string    `json:"thumbnail_url,omitempty"`
+	Description     string    `json:"description,omitempty"`
+	MBRecordingID   string    `json:"mb_recording_id,omitempty"`
+	MBReleaseID     string    `json:"mb_release_id,omitempty"`
+	MBArtistID      string    `json:"mb_artist_id,omitempty"`
+	FileSizeBytes   int64     `json:"file_size_bytes"`
+	MediaFile       string    `json:"media_file,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// Video is a video. MediaFile is the entry holding the video, if any.
+type Video struct {
+	ID              string    `json:"id"`
+	YoutubeID       string    `json:"youtube_id"`
+	Title           string    `json:"title"`
+	Channel         string    `json:"channel"`
+	DurationSeconds int       `json:"duration_seconds"`
+	ThumbnailURL    string    `json:"thumbnail_url,omitempty"`
+	Description     string    `json:"description,omitempty"`
+	Quality         string    `json:"quality,omitempty"`
+	FileSizeBytes   int64     `json:"file_size_bytes"`
+	MediaFile       string    `json:"media_file,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// Playlist lists track IDs in playlist order
+type Playlist struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	TrackIDs  []string  `json:"track_ids"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Invite is an invite code the user created
+type Invite struct {
+	Code      string     `json:"code"`
+	CreatedAt time.Time  `json:"created_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// New returns an empty account in the current format
+func New() *Account {
+	return &Account{
+		Format:    Format,
+		Version:   Version,
+		Tracks:    []Track{},
+		Videos:    []Video{},
+		Playlists: []Playlist{},
+		Invites:   []Invite{},
+	}
+}
+
+// MediaFile returns the archive entry name for an item's media file
+func MediaFile(kind, id, ext string) string {
+	return path.Join(mediaDir, kind, id+ext)
+}
+
+// Encode writes an account as indented JSON
+func Encode(account *Account) ([]byte, error) {
+	data, err := json.MarshalIndent(account, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("encoding account: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// Decode reads DataFile and checks it can be imported. Media file references
+// must stay inside the archive's media directory. Returns ErrTooLarge for
+// files over MaxDataFileBytes.
+func Decode(r io.Reader) (*Account, error) {
+	limited := &io.LimitedReader{R: r, N: MaxDataFileBytes + 1}
+
+	var account Account
+	err := json.NewDecoder(limited).Decode(&account)
+	if limited.N == 0 {
+		return nil, ErrTooLarge
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decoding account: %w", err)
+	}
+
+	if account.Format != Format {
+		return nil, ErrUnknownFormat
+	}
+	if account.Version < 1 || account.Version > Version {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, account.Version)
+	}
+
+	for i := range account.Tracks {
+		if err := checkMediaFile(account.Tracks[i].MediaFile); err != nil {
+			return nil, fmt.Errorf("track %s: %w", account.Tracks[i].ID, err)
+		}
+	}
+	for i := range account.Videos {
+		if err := checkMediaFile(account.Videos[i].MediaFile); err != nil {
+			return nil, fmt.Errorf("video %s: %w", account.Videos[i].ID, err)
+		}
+	}
+
+	return &account, nil
+}
+
+func checkMediaFile(name string) error {
+	if name == "" {
+		return nil
+	}
+	if path.Clean(name) != name || !strings.HasPrefix(name, mediaDir+"/") {
+		return fmt.Errorf("invalid media file %q", name)
+	}
+	return nil
+}
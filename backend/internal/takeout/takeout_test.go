@@ -0,0 +1,161 @@
+package takeout
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sampleAccount() *Account {
+	created := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	used := created.Add(time.Hour)
+
+	account := New()
+	account.Profile = Profile{Email: "user@example.com", CreatedAt: created}
+	account.Tracks = append(account.Tracks, Track{
+		ID:              "t1",
+		YoutubeID:       "dQw4w9WgXcQ",
+		Title:           "Never Gonna Give You Up",
+		Artist:          "Rick Astley",
+		Album:           "Whenever You Need Somebody",
+		ReleaseDate:     "1987-07-27",
+		DurationSeconds: 213,
+		MBRecordingID:   "0b2d9c4e-1b8a-4a5e-9f0b-1c2d3e4f5a6b",
+		FileSizeBytes:   3400000,
+		MediaFile:       MediaFile("audio", "t1", ".m4a"),
+		CreatedAt:       created,
+	})
+	account.Videos = append(account.Videos, Video{
+		ID:              "v1",
+		YoutubeID:       "FGBhQbmPwH8",
+		Title:           "One More Time",
+		Channel:         "Daft Punk",
+		DurationSeconds: 320,
+		Description:     "Official video",
+		Quality:         "1080p",
+		CreatedAt:       created,
+	})
+	account.Playlists = append(account.Playlists, Playlist{
+		ID:        "p1",
+		Name:      "Favourites",
+		TrackIDs:  []string{"t1"},
+		CreatedAt: created,
+		UpdatedAt: created,
+	})
+	account.Invites = append(account.Invites, Invite{Code: "abc", CreatedAt: created, UsedAt: &used})
+	return account
+}
+
+func TestEncodeDecode(t *testing.T) {
+	account := sampleAccount()
+
+	data, err := Encode(account)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+
+	got, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, account) {
+		t.Errorf("Decode() = %+v, want %+v", got, account)
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Account)
+		want   error
+	}{
+		{"other format", func(a *Account) { a.Format = "something-else" }, ErrUnknownFormat},
+		{"newer version", func(a *Account) { a.Version = Version + 1 }, ErrUnsupportedVersion},
+		{"escaping media file", func(a *Account) { a.Tracks[0].MediaFile = "media/../../etc/passwd" }, nil},
+		{"media file outside media", func(a *Account) { a.Videos[0].MediaFile = "account.json" }, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			account := sampleAccount()
+			tt.modify(account)
+			data, err := Encode(account)
+			if err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+
+			_, err = Decode(bytes.NewReader(data))
+			if err == nil {
+				t.Fatal("Decode() error = nil, want an error")
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("Decode() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeTooLarge(t *testing.T) {
+	// Valid JSON so far, but longer than any data file may be
+	r := io.MultiReader(strings.NewReader(`{"format": "`), neverEnding('a'))
+	if _, err := Decode(r); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Decode() error = %v, want ErrTooLarge", err)
+	}
+}
+
+// neverEnding is an endless stream of one byte
+type neverEnding byte
+
+func (b neverEnding) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(b)
+	}
+	return len(p), nil
+}
+
+// TestSchemaCoversAccount keeps schema.json in step with the Go types: every
+// field written to account.json must be described by the schema
+func TestSchemaCoversAccount(t *testing.T) {
+	var schema map[string]interface{}
+	if err := json.Unmarshal(Schema, &schema); err != nil {
+		t.Fatalf("schema.json is not valid JSON: %v", err)
+	}
+
+	data, err := Encode(sampleAccount())
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	var account map[string]interface{}
+	if err := json.Unmarshal(data, &account); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	checkCovered(t, "", account, schema)
+}
+
+func checkCovered(t *testing.T, at string, value interface{}, schema map[string]interface{}) {
+	t.Helper()
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		properties, _ := schema["properties"].(map[string]interface{})
+		for key, child := range v {
+			childSchema, ok := properties[key].(map[string]interface{})
+			if !ok {
+				t.Errorf("schema.json does not describe %s.%s", at, key)
+				continue
+			}
+			checkCovered(t, at+"."+key, child, childSchema)
+		}
+	case []interface{}:
+		items, _ := schema["items"].(map[string]interface{})
+		for _, child := range v {
+			checkCovered(t, at+"[]", child, items)
+		}
+	}
+}
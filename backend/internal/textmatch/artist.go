@@ -0,0 +1,66 @@
+package textmatch
+
+import "strings"
+
+// channelSuffixes are appended to artist names by YouTube channels
+var channelSuffixes = []string{" - Topic", "VEVO", " Official", "Official"}
+
+// StripChannelSuffix turns a YouTube channel name such as "QueenVEVO" or
+// "Queen - Topic" into the artist's name
+func StripChannelSuffix(channel string) string {
+	name := strings.TrimSpace(channel)
+	for _, suffix := range channelSuffixes {
+		if len(name) > len(suffix) && strings.EqualFold(name[len(name)-len(suffix):], suffix) {
+			name = strings.TrimSpace(name[:len(name)-len(suffix)])
+		}
+	}
+	return name
+}
+
+// PrimaryArtist returns the first of several credited artists, as listed by
+// streaming services and YouTube titles: "A, B", "A; B", "A & B", "A feat. B"
+func PrimaryArtist(artist string) string {
+	for _, sep := range []string{";", ", ", " & ", " feat. ", " ft. "} {
+		if first, _, ok := strings.Cut(artist, sep); ok {
+			artist = first
+		}
+	}
+	return strings.TrimSpace(artist)
+}
+
+// featuredSeparators introduce guest credits that aren't part of the
+// performing artist's name. Separators such as "&" and "," are left alone
+// since they are just as often part of a band's name.
+var featuredSeparators = []string{";", " feat. ", " feat ", " ft. ", " featuring "}
+
+// ArtistName returns the name an artist's catalog entry is listed under:
+// the credited artist without guest features or YouTube channel suffixes
+func ArtistName(artist string) string {
+	for _, sep := range featuredSeparators {
+		if i := indexFold(artist, sep); i > 0 {
+			artist = artist[:i]
+		}
+	}
+	return StripChannelSuffix(artist)
+}
+
+// indexFold returns the byte offset in s of the first case-insensitive match
+// of sep, or -1. Matching against s itself rather than a lowercased copy
+// keeps the offset valid, since lowercasing can change a rune's length.
+func indexFold(s, sep string) int {
+	for i := range s {
+		if len(s)-i < len(sep) {
+			break
+		}
+		if strings.EqualFold(s[i:i+len(sep)], sep) {
+			return i
+		}
+	}
+	return -1
+}
+
+// ArtistKey returns the key under which spellings of one artist's name are
+// grouped, so "Queen", "queen" and "QueenVEVO" are the same artist
+func ArtistKey(artist string) string {
+	return Normalize(ArtistName(artist))
+}
@@ -0,0 +1,78 @@
+package textmatch
+
+import "testing"
+
+func TestStripChannelSuffix(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"QueenVEVO", "Queen"},
+		{"Queen - Topic", "Queen"},
+		{"Queen Official", "Queen"},
+		{"queenvevo", "queen"},
+		{"Queen", "Queen"},
+		{"VEVO", "VEVO"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := StripChannelSuffix(tt.input); got != tt.want {
+			t.Errorf("StripChannelSuffix(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestPrimaryArtist(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"Queen, David Bowie", "Queen"},
+		{"Daft Punk; Pharrell Williams", "Daft Punk"},
+		{"Simon & Garfunkel", "Simon"},
+		{"Calvin Harris feat. Rihanna", "Calvin Harris"},
+		{"Queen", "Queen"},
+	}
+
+	for _, tt := range tests {
+		if got := PrimaryArtist(tt.input); got != tt.want {
+			t.Errorf("PrimaryArtist(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestArtistName(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"Calvin Harris feat. Rihanna", "Calvin Harris"},
+		{"Calvin Harris Featuring Rihanna", "Calvin Harris"},
+		{"Daft Punk; Pharrell Williams", "Daft Punk"},
+		{"Simon & Garfunkel", "Simon & Garfunkel"},
+		{"Earth, Wind & Fire", "Earth, Wind & Fire"},
+		{"QueenVEVO", "Queen"},
+		// Lowercasing these changes their length in bytes
+		{"İbrahim Tatlıses feat. X", "İbrahim Tatlıses"},
+		{"ȺȺȺȺȺȺȺȺȺȺ FEAT. X", "ȺȺȺȺȺȺȺȺȺȺ"},
+		{"ȺȺȺȺȺȺȺȺȺȺ", "ȺȺȺȺȺȺȺȺȺȺ"},
+		{"Ⱥ ft. Ⱥ", "Ⱥ"},
+		{"Björk Featuring Thom Yorke", "Björk"},
+		{"Sigur Rós", "Sigur Rós"},
+	}
+
+	for _, tt := range tests {
+		if got := ArtistName(tt.input); got != tt.want {
+			t.Errorf("ArtistName(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestArtistKey(t *testing.T) {
+	for _, name := range []string{"Queen", "queen", "QueenVEVO", "Queen - Topic", "QUEEN feat. David Bowie"} {
+		if got := ArtistKey(name); got != "queen" {
+			t.Errorf("ArtistKey(%q) = %q, want %q", name, got, "queen")
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package textmatch
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// bracketedRegex matches a bracketed or parenthesized segment of a title
+var bracketedRegex = regexp.MustCompile(`[\(\[][^\)\]]*[\)\]]`)
+
+// noiseWords mark bracketed segments that describe the upload rather than the song
+var noiseWords = []string{
+	"official", "video", "audio", "lyric", "visualizer", "visualiser",
+	"hd", "4k", "mv", "m/v", "explicit", "clean",
+}
+
+// Normalize lowercases s, drops bracketed upload noise such as
+// "(Official Video)" and collapses punctuation and whitespace.
+func Normalize(s string) string {
+	s = strings.ToLower(s)
+	s = bracketedRegex.ReplaceAllStringFunc(s, func(segment string) string {
+		for _, word := range noiseWords {
+			if strings.Contains(segment, word) {
+				return " "
+			}
+		}
+		return segment
+	})
+
+	var b strings.Builder
+	space := true
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			space = false
+			continue
+		}
+		if !space {
+			b.WriteRune(' ')
+			space = true
+		}
+	}
+
+	return strings.TrimSpace(b.String())
+}
+
+// StripArtistPrefix removes a leading "Artist - " from a title, which is the
+// usual shape of YouTube video titles for music uploads.
+func StripArtistPrefix(title, artist string) string {
+	if artist == "" {
+		return title
+	}
+	for _, sep := range []string{" - ", " – ", " — "} {
+		prefix, rest, ok := strings.Cut(title, sep)
+		if ok && Normalize(prefix) == Normalize(artist) {
+			return strings.TrimSpace(rest)
+		}
+	}
+	return title
+}
+
+// Similarity returns a score between 0 and 1 for how alike two strings are
+// after normalization. It takes the better of an edit-distance ratio and a
+// token overlap coefficient so that reordered or extra words still score well.
+func Similarity(a, b string) float64 {
+	a, b = Normalize(a), Normalize(b)
+	if a == "" || b == "" {
+		return 0
+	}
+	if a == b {
+		return 1
+	}
+
+	return max(levenshteinRatio(a, b), tokenDice(a, b))
+}
+
+// DurationScore returns 1 when two durations match, falling linearly to 0
+// once they differ by tolerance seconds or more.
+func DurationScore(a, b, tolerance int) float64 {
+	if a <= 0 || b <= 0 || tolerance <= 0 {
+		return 0
+	}
+	delta := a - b
+	if delta < 0 {
+		delta = -delta
+	}
+	if delta >= tolerance {
+		return 0
+	}
+	return 1 - float64(delta)/float64(tolerance)
+}
+
+func levenshteinRatio(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := max(len(ra), len(rb))
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
+
+func tokenDice(a, b string) float64 {
+	ta, tb := strings.Fields(a), strings.Fields(b)
+	counts := make(map[string]int, len(ta))
+	for _, t := range ta {
+		counts[t]++
+	}
+
+	shared := 0
+	for _, t := range tb {
+		if counts[t] > 0 {
+			counts[t]--
+			shared++
+		}
+	}
+
+	return 2 * float64(shared) / float64(len(ta)+len(tb))
+}
@@ -0,0 +1,151 @@
+package textmatch
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"Bohemian Rhapsody", "bohemian rhapsody"},
+		{"Bohemian Rhapsody (Official Video Remastered)", "bohemian rhapsody"},
+		{"Song [HD]", "song"},
+		{"Song (Remix)", "song remix"},
+		{"  Don't   Stop -- Me Now!  ", "don t stop me now"},
+		{"Ünïcödé Sóng", "ünïcödé sóng"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if got := Normalize(tt.input); got != tt.want {
+				t.Errorf("Normalize(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripArtistPrefix(t *testing.T) {
+	tests := []struct {
+		name   string
+		title  string
+		artist string
+		want   string
+	}{
+		{"matching prefix", "Queen - Bohemian Rhapsody", "Queen", "Bohemian Rhapsody"},
+		{"case insensitive", "QUEEN - Bohemian Rhapsody", "queen", "Bohemian Rhapsody"},
+		{"en dash", "Queen – Bohemian Rhapsody", "Queen", "Bohemian Rhapsody"},
+		{"different artist", "Queen - Bohemian Rhapsody", "ABBA", "Queen - Bohemian Rhapsody"},
+		{"no artist", "Queen - Bohemian Rhapsody", "", "Queen - Bohemian Rhapsody"},
+		{"no separator", "Bohemian Rhapsody", "Queen", "Bohemian Rhapsody"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := StripArtistPrefix(tt.title, tt.artist); got != tt.want {
+				t.Errorf("StripArtistPrefix() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	t.Run("identical after normalization", func(t *testing.T) {
+		if got := Similarity("Bohemian Rhapsody", "bohemian rhapsody (Official Video)"); got != 1 {
+			t.Errorf("Similarity() = %v, want 1", got)
+		}
+	})
+
+	t.Run("empty input", func(t *testing.T) {
+		if got := Similarity("", "anything"); got != 0 {
+			t.Errorf("Similarity() = %v, want 0", got)
+		}
+	})
+
+	t.Run("extra words still score well", func(t *testing.T) {
+		got := Similarity("Queen Bohemian Rhapsody", "Bohemian Rhapsody")
+		if got < 0.75 {
+			t.Errorf("Similarity() = %v, want >= 0.75", got)
+		}
+	})
+
+	t.Run("typo scores higher than unrelated", func(t *testing.T) {
+		typo := Similarity("Bohemian Rhapsody", "Bohemian Rapsody")
+		unrelated := Similarity("Bohemian Rhapsody", "Dancing Queen")
+		if typo <= unrelated {
+			t.Errorf("typo score %v should exceed unrelated score %v", typo, unrelated)
+		}
+		if unrelated > 0.5 {
+			t.Errorf("unrelated score = %v, want <= 0.5", unrelated)
+		}
+	})
+}
+
+func TestDurationScore(t *testing.T) {
+	tests := []struct {
+		name      string
+		a, b      int
+		tolerance int
+		want      float64
+	}{
+		{"exact", 200, 200, 10, 1},
+		{"half way", 200, 205, 10, 0.5},
+		{"symmetric", 205, 200, 10, 0.5},
+		{"outside tolerance", 200, 230, 10, 0},
+		{"unknown duration", 0, 200, 10, 0},
+		{"zero tolerance", 200, 200, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DurationScore(tt.a, tt.b, tt.tolerance); got != tt.want {
+				t.Errorf("DurationScore() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrackScore(t *testing.T) {
+	want := Track{Title: "Daft Punk - One More Time (Official Video)", Artist: "Daft Punk", DurationSeconds: 320}
+
+	tests := []struct {
+		name      string
+		candidate Track
+		wantMin   float64
+		wantMax   float64
+	}{
+		{
+			name:      "exact match",
+			candidate: Track{Title: "One More Time", Artist: "Daft Punk", DurationSeconds: 320},
+			wantMin:   0.99,
+			wantMax:   1,
+		},
+		{
+			name:      "unknown duration",
+			candidate: Track{Title: "One More Time", Artist: "Daft Punk"},
+			wantMin:   0.99,
+			wantMax:   1,
+		},
+		{
+			name:      "wrong duration",
+			candidate: Track{Title: "One More Time", Artist: "Daft Punk", DurationSeconds: 600},
+			wantMin:   0.75,
+			wantMax:   0.85,
+		},
+		{
+			name:      "different song",
+			candidate: Track{Title: "Around the World", Artist: "Daft Punk", DurationSeconds: 429},
+			wantMin:   0,
+			wantMax:   0.6,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := TrackScore(tt.candidate, want)
+			if got < tt.wantMin || got > tt.wantMax {
+				t.Errorf("TrackScore() = %v, want between %v and %v", got, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package textmatch
+
+const (
+	titleWeight    = 0.5
+	artistWeight   = 0.3
+	durationWeight = 0.2
+
+	// durationTolerance is how far apart two lengths may be before they stop
+	// contributing to the score
+	durationTolerance = 15
+)
+
+// Track is what's known about a recording when matching it against another
+type Track struct {
+	Title           string
+	Artist          string
+	DurationSeconds int
+}
+
+// TrackScore rates how well a candidate matches the wanted track by title,
+// artist and duration. The result is between 0 and 1. When an artist or
+// duration is unknown on either side the remaining weights are rescaled so
+// the score stays comparable.
+func TrackScore(candidate, want Track) float64 {
+	score := titleWeight * Similarity(
+		StripArtistPrefix(candidate.Title, candidate.Artist),
+		StripArtistPrefix(want.Title, want.Artist),
+	)
+	total := titleWeight
+
+	if candidate.Artist != "" && want.Artist != "" {
+		score += artistWeight * Similarity(candidate.Artist, want.Artist)
+		total += artistWeight
+	}
+
+	if candidate.DurationSeconds > 0 && want.DurationSeconds > 0 {
+		score += durationWeight * DurationScore(candidate.DurationSeconds, want.DurationSeconds, durationTolerance)
+		total += durationWeight
+	}
+
+	return score / total
+}
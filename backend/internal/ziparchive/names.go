@@ -0,0 +1,88 @@
+package ziparchive
+
+import (
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultNameTemplate names entries after their artist and title
+const DefaultNameTemplate = "{artist} - {title}.{ext}"
+
+// maxNameBytes keeps names within the 255 byte limit of common filesystems
+const maxNameBytes = 200
+
+// placeholderPattern matches template placeholders left without a value
+var placeholderPattern = regexp.MustCompile(`\{[a-z_]+\}`)
+
+var nameReplacer = strings.NewReplacer(
+	"/", "_",
+	"\\", "_",
+	":", "_",
+	"*", "_",
+	"?", "_",
+	"\"", "'",
+	"<", "_",
+	">", "_",
+	"|", "_",
+)
+
+// RenderName fills a template's {field} placeholders and returns a name safe
+// to extract on any platform. Separators left dangling by empty fields, as in
+// " - Title.m4a" for a track without an artist, are trimmed.
+func RenderName(template string, fields map[string]string) string {
+	pairs := make([]string, 0, len(fields)*2)
+	for field, value := range fields {
+		pairs = append(pairs, "{"+field+"}", nameReplacer.Replace(value))
+	}
+	name := strings.NewReplacer(pairs...).Replace(template)
+	name = placeholderPattern.ReplaceAllString(name, "")
+
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	base = strings.Trim(base, " -_")
+	if base == "" {
+		base = "untitled"
+	}
+
+	return SanitizeName(truncate(base, maxNameBytes-len(ext)) + ext)
+}
+
+// SanitizeName replaces characters that are invalid in file names
+func SanitizeName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+	s = nameReplacer.Replace(s)
+	// Windows rejects names ending in a dot or space
+	return strings.TrimRight(strings.TrimSpace(s), ".")
+}
+
+// UniqueName returns name, or name with a numbered suffix if it's already in
+// used, and records the result in used
+func UniqueName(name string, used map[string]bool) string {
+	unique := name
+	ext := path.Ext(name)
+	for i := 2; used[strings.ToLower(unique)]; i++ {
+		unique = strings.TrimSuffix(name, ext) + " (" + strconv.Itoa(i) + ")" + ext
+	}
+	used[strings.ToLower(unique)] = true
+	return unique
+}
+
+// truncate shortens s to at most n bytes without splitting a UTF-8 sequence
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
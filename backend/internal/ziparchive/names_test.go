@@ -0,0 +1,74 @@
+package ziparchive
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderName(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		fields   map[string]string
+		want     string
+	}{
+		{
+			name:     "default template",
+			template: DefaultNameTemplate,
+			fields:   map[string]string{"artist": "Daft Punk", "title": "One More Time", "ext": "m4a"},
+			want:     "Daft Punk - One More Time.m4a",
+		},
+		{
+			name:     "missing artist",
+			template: DefaultNameTemplate,
+			fields:   map[string]string{"artist": "", "title": "One More Time", "ext": "m4a"},
+			want:     "One More Time.m4a",
+		},
+		{
+			name:     "unsafe characters",
+			template: DefaultNameTemplate,
+			fields:   map[string]string{"artist": "AC/DC", "title": "What? \"Why\"", "ext": "m4a"},
+			want:     "AC_DC - What_ 'Why'.m4a",
+		},
+		{
+			name:     "field cannot add directories",
+			template: "{album}/{title}.{ext}",
+			fields:   map[string]string{"album": "../..", "title": "x", "ext": "m4a"},
+			want:     ".._.._x.m4a",
+		},
+		{
+			name:     "empty result",
+			template: DefaultNameTemplate,
+			fields:   map[string]string{"ext": "m4a"},
+			want:     "untitled.m4a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RenderName(tt.template, tt.fields); got != tt.want {
+				t.Errorf("RenderName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderNameTruncates(t *testing.T) {
+	got := RenderName("{title}.{ext}", map[string]string{"title": strings.Repeat("é", 300), "ext": "m4a"})
+	if len(got) > maxNameBytes {
+		t.Errorf("RenderName() length = %d, want at most %d", len(got), maxNameBytes)
+	}
+	if !strings.HasSuffix(got, "é.m4a") {
+		t.Errorf("RenderName() = %q, want whole runes and extension kept", got)
+	}
+}
+
+func TestUniqueName(t *testing.T) {
+	used := map[string]bool{}
+	want := []string{"Song.m4a", "Song (2).m4a", "song (3).m4a"}
+	for i, name := range []string{"Song.m4a", "Song.m4a", "song.m4a"} {
+		if got := UniqueName(name, used); got != want[i] {
+			t.Errorf("UniqueName(%q) = %q, want %q", name, got, want[i])
+		}
+	}
+}
@@ -0,0 +1,519 @@
+package ziparchive
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// ZIP format constants, see PKWARE's APPNOTE.TXT
+const (
+	localHeaderSignature   = 0x04034b50
+	centralHeaderSignature = 0x02014b50
+	zip64EndSignature      = 0x06064b50
+	zip64LocatorSignature  = 0x07064b50
+	endSignature           = 0x06054b50
+	localHeaderLen         = 30
+	centralHeaderLen       = 46
+	zip64EndLen            = 56
+	zip64LocatorLen        = 20
+	endLen                 = 22
+	zip64ExtraID           = 0x0001
+	flagUTF8               = 0x0800
+	methodStore            = 0
+	versionDefault         = 20
+	versionZip64           = 45
+	creatorUnix            = 3 << 8
+	uint16Max              = 0xffff
+	uint32Max              = 0xffffffff
+)
+
+// regularFileMode is the Unix mode recorded for every entry
+const regularFileMode uint32 = 0100644
+
+// File is one entry in an archive. Its content comes from the file at Path,
+// or from Data and ModTime when Path is empty.
+type File struct {
+	Name    string
+	Path    string
+	Data    []byte
+	ModTime time.Time
+}
+
+type entry struct {
+	File
+	size    int64
+	modTime time.Time
+
+	// offset is where the entry's local header starts
+	offset    int64
+	headerLen int64
+
+	crc      uint32
+	crcKnown bool
+}
+
+// zip64 reports whether the entry needs ZIP64 size fields
+func (e *entry) zip64() bool {
+	return e.size >= uint32Max
+}
+
+// Archive is an uncompressed ZIP archive assembled on demand from files on
+// disk. Its layout is computed up front, so the total size is known and any
+// byte range can be read without producing the bytes before it, which lets
+// it be served with HTTP range requests. Media files are already compressed,
+// so entries are stored rather than deflated.
+type Archive struct {
+	mu      sync.Mutex
+	entries []*entry
+	size    int64
+	etag    string
+
+	centralOffset int64
+	centralLen    int64
+	zip64         bool
+
+	// trailer is the central directory and end records, rendered on first read
+	trailer []byte
+
+	// open is the file last read from, kept open for sequential reads
+	open      *os.File
+	openEntry *entry
+}
+
+// New lays out an archive of the given files. Files on disk are not read
+// until the archive is.
+func New(files []File) (*Archive, error) {
+	a := &Archive{}
+	etag := sha256.New()
+
+	var offset int64
+	for _, f := range files {
+		e := &entry{File: f, offset: offset}
+
+		if f.Path != "" {
+			info, err := os.Stat(f.Path)
+			if err != nil {
+				return nil, fmt.Errorf("reading %s: %w", f.Path, err)
+			}
+			e.size = info.Size()
+			e.modTime = info.ModTime()
+		} else {
+			e.size = int64(len(f.Data))
+			e.modTime = f.ModTime
+			e.crc = crc32.ChecksumIEEE(f.Data)
+			e.crcKnown = true
+		}
+
+		e.headerLen = localHeaderLen + int64(len(e.Name))
+		if e.zip64() {
+			e.headerLen += 20
+		}
+
+		fmt.Fprintf(etag, "%s\x00%d\x00%d\x00%d\n", e.Name, e.size, e.modTime.UnixNano(), e.crc)
+
+		a.entries = append(a.entries, e)
+		offset += e.headerLen + e.size
+	}
+
+	a.centralOffset = offset
+	for _, e := range a.entries {
+		a.centralLen += centralHeaderLen + int64(len(e.Name)) + int64(len(centralExtra(e)))
+	}
+
+	a.zip64 = len(a.entries) >= uint16Max || a.centralOffset >= uint32Max || a.centralLen >= uint32Max
+	a.size = a.centralOffset + a.centralLen + endLen
+	if a.zip64 {
+		a.size += zip64EndLen + zip64LocatorLen
+	}
+
+	a.etag = `"` + hex.EncodeToString(etag.Sum(nil)[:16]) + `"`
+	return a, nil
+}
+
+// Size returns the length of the archive in bytes
+func (a *Archive) Size() int64 {
+	return a.size
+}
+
+// ETag returns a strong entity tag that changes whenever any entry does, so
+// resumed downloads can't splice together two different archives
+func (a *Archive) ETag() string {
+	return a.etag
+}
+
+// Close releases the file handle kept open between reads
+func (a *Archive) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.closeOpen()
+}
+
+// ReadAt implements io.ReaderAt
+func (a *Archive) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	total := 0
+	for len(p) > 0 && off < a.size {
+		n, err := a.readPart(p, off)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+		off += int64(n)
+	}
+
+	if len(p) > 0 {
+		return total, io.EOF
+	}
+	return total, nil
+}
+
+// readPart reads from the single part of the archive containing off
+func (a *Archive) readPart(p []byte, off int64) (int, error) {
+	if off >= a.centralOffset {
+		if a.trailer == nil {
+			trailer, err := a.renderTrailer()
+			if err != nil {
+				return 0, err
+			}
+			a.trailer = trailer
+		}
+		return copy(p, a.trailer[off-a.centralOffset:]), nil
+	}
+
+	e := a.entryAt(off)
+	rel := off - e.offset
+	if rel < e.headerLen {
+		header, err := a.localHeader(e)
+		if err != nil {
+			return 0, err
+		}
+		return copy(p, header[rel:]), nil
+	}
+
+	rel -= e.headerLen
+	p = p[:min(int64(len(p)), e.size-rel)]
+	if e.Path == "" {
+		return copy(p, e.Data[rel:]), nil
+	}
+
+	return a.readFile(e, p, rel)
+}
+
+// entryAt finds the entry whose header or data contains off
+func (a *Archive) entryAt(off int64) *entry {
+	lo, hi := 0, len(a.entries)-1
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if a.entries[mid].offset <= off {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return a.entries[lo]
+}
+
+func (a *Archive) readFile(e *entry, p []byte, off int64) (int, error) {
+	if a.openEntry != e {
+		if err := a.closeOpen(); err != nil {
+			return 0, err
+		}
+		f, err := os.Open(e.Path)
+		if err != nil {
+			return 0, fmt.Errorf("opening %s: %w", e.Path, err)
+		}
+		a.open, a.openEntry = f, e
+	}
+
+	n, err := a.open.ReadAt(p, off)
+	if n < len(p) {
+		// The file shrank since the archive was laid out
+		return n, fmt.Errorf("reading %s: %w", e.Path, io.ErrUnexpectedEOF)
+	}
+	if err != nil && err != io.EOF {
+		return n, err
+	}
+	return n, nil
+}
+
+func (a *Archive) closeOpen() error {
+	if a.open == nil {
+		return nil
+	}
+	err := a.open.Close()
+	a.open, a.openEntry = nil, nil
+	return err
+}
+
+// ensureCRC computes an entry's checksum if it isn't known yet
+func (a *Archive) ensureCRC(e *entry) error {
+	if e.crcKnown {
+		return nil
+	}
+	crc, err := fileCRC(e.Path, e.size, e.modTime)
+	if err != nil {
+		return err
+	}
+	e.crc, e.crcKnown = crc, true
+	return nil
+}
+
+func (a *Archive) localHeader(e *entry) ([]byte, error) {
+	if err := a.ensureCRC(e); err != nil {
+		return nil, err
+	}
+
+	version, size32 := uint16(versionDefault), uint32(e.size)
+	if e.zip64() {
+		version, size32 = versionZip64, uint32Max
+	}
+	modTime, modDate := dosTime(e.modTime)
+
+	b := make([]byte, 0, e.headerLen)
+	b = binary.LittleEndian.AppendUint32(b, localHeaderSignature)
+	b = binary.LittleEndian.AppendUint16(b, version)
+	b = binary.LittleEndian.AppendUint16(b, flagUTF8)
+	b = binary.LittleEndian.AppendUint16(b, methodStore)
+	b = binary.LittleEndian.AppendUint16(b, modTime)
+	b = binary.LittleEndian.AppendUint16(b, modDate)
+	b = binary.LittleEndian.AppendUint32(b, e.crc)
+	b = binary.LittleEndian.AppendUint32(b, size32)
+	b = binary.LittleEndian.AppendUint32(b, size32)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(e.Name)))
+	if e.zip64() {
+		b = binary.LittleEndian.AppendUint16(b, 20)
+		b = append(b, e.Name...)
+		b = binary.LittleEndian.AppendUint16(b, zip64ExtraID)
+		b = binary.LittleEndian.AppendUint16(b, 16)
+		b = binary.LittleEndian.AppendUint64(b, uint64(e.size))
+		b = binary.LittleEndian.AppendUint64(b, uint64(e.size))
+	} else {
+		b = binary.LittleEndian.AppendUint16(b, 0)
+		b = append(b, e.Name...)
+	}
+
+	return b, nil
+}
+
+// centralExtra returns the ZIP64 extra field of an entry's central directory
+// header, holding whichever of its fields overflow 32 bits
+func centralExtra(e *entry) []byte {
+	var fields []byte
+	if e.zip64() {
+		fields = binary.LittleEndian.AppendUint64(fields, uint64(e.size))
+		fields = binary.LittleEndian.AppendUint64(fields, uint64(e.size))
+	}
+	if e.offset >= uint32Max {
+		fields = binary.LittleEndian.AppendUint64(fields, uint64(e.offset))
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	extra := binary.LittleEndian.AppendUint16(nil, zip64ExtraID)
+	extra = binary.LittleEndian.AppendUint16(extra, uint16(len(fields)))
+	return append(extra, fields...)
+}
+
+// renderTrailer renders the central directory and end records. They hold
+// every entry's checksum, so any not computed while streaming are computed now.
+func (a *Archive) renderTrailer() ([]byte, error) {
+	b := make([]byte, 0, a.size-a.centralOffset)
+
+	for _, e := range a.entries {
+		if err := a.ensureCRC(e); err != nil {
+			return nil, err
+		}
+
+		version, size32, offset32 := uint16(versionDefault), uint32(e.size), uint32(e.offset)
+		if e.zip64() {
+			version, size32 = versionZip64, uint32Max
+		}
+		if e.offset >= uint32Max {
+			version, offset32 = versionZip64, uint32Max
+		}
+		modTime, modDate := dosTime(e.modTime)
+		extra := centralExtra(e)
+
+		b = binary.LittleEndian.AppendUint32(b, centralHeaderSignature)
+		b = binary.LittleEndian.AppendUint16(b, creatorUnix|version)
+		b = binary.LittleEndian.AppendUint16(b, version)
+		b = binary.LittleEndian.AppendUint16(b, flagUTF8)
+		b = binary.LittleEndian.AppendUint16(b, methodStore)
+		b = binary.LittleEndian.AppendUint16(b, modTime)
+		b = binary.LittleEndian.AppendUint16(b, modDate)
+		b = binary.LittleEndian.AppendUint32(b, e.crc)
+		b = binary.LittleEndian.AppendUint32(b, size32)
+		b = binary.LittleEndian.AppendUint32(b, size32)
+		b = binary.LittleEndian.AppendUint16(b, uint16(len(e.Name)))
+		b = binary.LittleEndian.AppendUint16(b, uint16(len(extra)))
+		b = binary.LittleEndian.AppendUint16(b, 0) // comment length
+		b = binary.LittleEndian.AppendUint16(b, 0) // disk number
+		b = binary.LittleEndian.AppendUint16(b, 0) // internal attributes
+		b = binary.LittleEndian.AppendUint32(b, regularFileMode<<16)
+		b = binary.LittleEndian.AppendUint32(b, offset32)
+		b = append(b, e.Name...)
+		b = append(b, extra...)
+	}
+
+	count16, centralLen32, centralOffset32 := uint16(len(a.entries)), uint32(a.centralLen), uint32(a.centralOffset)
+	if a.zip64 {
+		zip64EndOffset := a.centralOffset + a.centralLen
+
+		b = binary.LittleEndian.AppendUint32(b, zip64EndSignature)
+		b = binary.LittleEndian.AppendUint64(b, zip64EndLen-12)
+		b = binary.LittleEndian.AppendUint16(b, creatorUnix|versionZip64)
+		b = binary.LittleEndian.AppendUint16(b, versionZip64)
+		b = binary.LittleEndian.AppendUint32(b, 0) // this disk
+		b = binary.LittleEndian.AppendUint32(b, 0) // central directory disk
+		b = binary.LittleEndian.AppendUint64(b, uint64(len(a.entries)))
+		b = binary.LittleEndian.AppendUint64(b, uint64(len(a.entries)))
+		b = binary.LittleEndian.AppendUint64(b, uint64(a.centralLen))
+		b = binary.LittleEndian.AppendUint64(b, uint64(a.centralOffset))
+
+		b = binary.LittleEndian.AppendUint32(b, zip64LocatorSignature)
+		b = binary.LittleEndian.AppendUint32(b, 0)
+		b = binary.LittleEndian.AppendUint64(b, uint64(zip64EndOffset))
+		b = binary.LittleEndian.AppendUint32(b, 1) // total disks
+
+		count16, centralLen32, centralOffset32 = uint16Max, uint32Max, uint32Max
+	}
+
+	b = binary.LittleEndian.AppendUint32(b, endSignature)
+	b = binary.LittleEndian.AppendUint16(b, 0) // this disk
+	b = binary.LittleEndian.AppendUint16(b, 0) // central directory disk
+	b = binary.LittleEndian.AppendUint16(b, count16)
+	b = binary.LittleEndian.AppendUint16(b, count16)
+	b = binary.LittleEndian.AppendUint32(b, centralLen32)
+	b = binary.LittleEndian.AppendUint32(b, centralOffset32)
+	b = binary.LittleEndian.AppendUint16(b, 0) // comment length
+
+	return b, nil
+}
+
+// dosTime converts t to MS-DOS time and date fields, which have two second
+// precision and can't represent years before 1980
+func dosTime(t time.Time) (uint16, uint16) {
+	t = t.UTC()
+	if t.Year() < 1980 {
+		t = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	dosTime := uint16(t.Hour()<<11 | t.Minute()<<5 | t.Second()>>1)
+	dosDate := uint16((t.Year()-1980)<<9 | int(t.Month())<<5 | t.Day())
+	return dosTime, dosDate
+}
+
+// crcCacheSize bounds how many file checksums crcCache keeps
+const crcCacheSize = 10000
+
+// crcCache remembers file checksums across archives, so resuming a download
+// doesn't reread every file before the resume point
+var crcCache = newCRCCache(crcCacheSize)
+
+// crcEntry is the checksum of a file as of its size and modification time
+type crcEntry struct {
+	path    string
+	size    int64
+	modTime time.Time
+	crc     uint32
+}
+
+// crcLRU holds one checksum per path, evicting the least recently used
+// beyond its limit
+type crcLRU struct {
+	mu      sync.Mutex
+	limit   int
+	order   *list.List // of *crcEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+func newCRCCache(limit int) *crcLRU {
+	return &crcLRU{limit: limit, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// get returns the cached checksum of a file if it hasn't changed since
+func (c *crcLRU) get(path string, size int64, modTime time.Time) (uint32, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[path]
+	if !ok {
+		return 0, false
+	}
+	e := el.Value.(*crcEntry)
+	if e.size != size || !e.modTime.Equal(modTime) {
+		return 0, false
+	}
+	c.order.MoveToFront(el)
+	return e.crc, true
+}
+
+// put caches a checksum, replacing any for an earlier version of the file
+func (c *crcLRU) put(e *crcEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[e.path]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[e.path] = c.order.PushFront(e)
+	for c.order.Len() > c.limit {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*crcEntry).path)
+	}
+}
+
+// reset empties the cache
+func (c *crcLRU) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	clear(c.entries)
+}
+
+// fileCRC returns the CRC-32 of a file, cached by path, size and modification time
+func fileCRC(path string, size int64, modTime time.Time) (uint32, error) {
+	if crc, ok := crcCache.get(path, size, modTime); ok {
+		return crc, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("opening %s: %w", path, err)
+	}
+	defer f.Close()
+
+	h := crc32.NewIEEE()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, fmt.Errorf("reading %s: %w", path, err)
+	}
+	if n != size {
+		return 0, fmt.Errorf("reading %s: size changed from %d to %d bytes", path, size, n)
+	}
+
+	crc := h.Sum32()
+	crcCache.put(&crcEntry{path: path, size: size, modTime: modTime, crc: crc})
+	return crc, nil
+}
@@ -0,0 +1,214 @@
+package ziparchive
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func testFiles(t *testing.T) ([]File, map[string][]byte) {
+	t.Helper()
+	rng := rand.New(rand.NewSource(1))
+
+	big := make([]byte, 300_000)
+	rng.Read(big)
+	small := []byte("hello")
+
+	want := map[string][]byte{
+		"01 - Artist - Söng.m4a": big,
+		"02 - Other - Empty.m4a": {},
+		"03 - Other - Small.m4a": small,
+		"Playlist.m3u8":          []byte("#EXTM3U\n"),
+	}
+
+	files := []File{
+		{Name: "01 - Artist - Söng.m4a", Path: writeTestFile(t, "a.m4a", big)},
+		{Name: "02 - Other - Empty.m4a", Path: writeTestFile(t, "b.m4a", nil)},
+		{Name: "03 - Other - Small.m4a", Path: writeTestFile(t, "c.m4a", small)},
+		{Name: "Playlist.m3u8", Data: []byte("#EXTM3U\n"), ModTime: time.Date(2024, 5, 6, 7, 8, 10, 0, time.UTC)},
+	}
+
+	return files, want
+}
+
+func newTestArchive(t *testing.T, files []File) *Archive {
+	t.Helper()
+	a, err := New(files)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	t.Cleanup(func() { a.Close() })
+	return a
+}
+
+func readAll(t *testing.T, a *Archive) []byte {
+	t.Helper()
+	data, err := io.ReadAll(io.NewSectionReader(a, 0, a.Size()))
+	if err != nil {
+		t.Fatalf("reading archive: %v", err)
+	}
+	if int64(len(data)) != a.Size() {
+		t.Fatalf("read %d bytes, Size() = %d", len(data), a.Size())
+	}
+	return data
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	files, want := testFiles(t)
+	data := readAll(t, newTestArchive(t, files))
+
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("zip.NewReader() error = %v", err)
+	}
+
+	if len(r.File) != len(want) {
+		t.Fatalf("archive has %d files, want %d", len(r.File), len(want))
+	}
+
+	for _, f := range r.File {
+		expected, ok := want[f.Name]
+		if !ok {
+			t.Errorf("unexpected file %q", f.Name)
+			continue
+		}
+		if f.Method != zip.Store {
+			t.Errorf("%s: method = %d, want Store", f.Name, f.Method)
+		}
+		if f.NonUTF8 {
+			t.Errorf("%s: name not marked UTF-8", f.Name)
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("%s: Open() error = %v", f.Name, err)
+		}
+		// Reading to EOF also verifies the CRC
+		got, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("%s: read error = %v", f.Name, err)
+		}
+		if !bytes.Equal(got, expected) {
+			t.Errorf("%s: content mismatch", f.Name)
+		}
+	}
+
+	if got := r.File[3].Modified.UTC(); !got.Equal(time.Date(2024, 5, 6, 7, 8, 10, 0, time.UTC)) {
+		t.Errorf("Modified = %v, want 2024-05-06 07:08:10", got)
+	}
+}
+
+func TestArchiveReadAt(t *testing.T) {
+	files, _ := testFiles(t)
+	full := readAll(t, newTestArchive(t, files))
+
+	// A fresh archive must produce identical bytes for any range, including
+	// ones starting past entries whose checksums haven't been computed yet
+	crcCache.reset()
+	b := newTestArchive(t, files)
+	rng := rand.New(rand.NewSource(2))
+	for i := 0; i < 50; i++ {
+		off := rng.Int63n(b.Size())
+		n := rng.Intn(70_000) + 1
+
+		buf := make([]byte, n)
+		got, err := b.ReadAt(buf, off)
+		if err != nil && err != io.EOF {
+			t.Fatalf("ReadAt(%d, %d) error = %v", n, off, err)
+		}
+		if want := full[off:min(off+int64(n), int64(len(full)))]; !bytes.Equal(buf[:got], want) {
+			t.Fatalf("ReadAt(%d, %d) returned different bytes", n, off)
+		}
+	}
+
+	if _, err := b.ReadAt(make([]byte, 1), b.Size()); err != io.EOF {
+		t.Errorf("ReadAt() at end error = %v, want io.EOF", err)
+	}
+}
+
+func TestArchiveETag(t *testing.T) {
+	files, _ := testFiles(t)
+	a := newTestArchive(t, files)
+	b := newTestArchive(t, files)
+	if a.ETag() != b.ETag() {
+		t.Error("ETag() differs for identical archives")
+	}
+
+	c, err := New([]File{{Name: "Playlist.m3u8", Data: []byte("#EXTM3U\nother\n")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ETag() == a.ETag() {
+		t.Error("ETag() matches for different archives")
+	}
+}
+
+func TestArchiveFileChanged(t *testing.T) {
+	path := writeTestFile(t, "a.m4a", []byte("original content"))
+	a, err := New([]File{{Name: "a.m4a", Path: path}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	if err := os.WriteFile(path, []byte("short"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(io.NewSectionReader(a, 0, a.Size())); err == nil {
+		t.Error("reading archive after file shrank expected error")
+	}
+}
+
+func TestCRCCache(t *testing.T) {
+	c := newCRCCache(2)
+	now := time.Now()
+
+	c.put(&crcEntry{path: "a", size: 1, modTime: now, crc: 1})
+	c.put(&crcEntry{path: "b", size: 1, modTime: now, crc: 2})
+	if crc, ok := c.get("a", 1, now); !ok || crc != 1 {
+		t.Errorf("get(a) = %d, %v, want 1, true", crc, ok)
+	}
+
+	// b is now the least recently used
+	c.put(&crcEntry{path: "c", size: 1, modTime: now, crc: 3})
+	if _, ok := c.get("b", 1, now); ok {
+		t.Error("get(b) hit after eviction")
+	}
+	if _, ok := c.get("a", 1, now); !ok {
+		t.Error("get(a) missed, want it kept")
+	}
+
+	// A changed file misses, and its new checksum replaces the old entry
+	later := now.Add(time.Second)
+	if _, ok := c.get("a", 1, later); ok {
+		t.Error("get(a) hit for a newer modification time")
+	}
+	c.put(&crcEntry{path: "a", size: 2, modTime: later, crc: 4})
+	if crc, ok := c.get("a", 2, later); !ok || crc != 4 {
+		t.Errorf("get(a) = %d, %v, want 4, true", crc, ok)
+	}
+	if c.order.Len() != 2 {
+		t.Errorf("cache holds %d entries, want 2", c.order.Len())
+	}
+}
+
+func TestNewMissingFile(t *testing.T) {
+	if _, err := New([]File{{Name: "a.m4a", Path: filepath.Join(t.TempDir(), "missing.m4a")}}); err == nil {
+		t.Error("New() with missing file expected error")
+	}
+}